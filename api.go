@@ -0,0 +1,1047 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/calendar"
+	"lucb31/booking-go/ical"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Error object returned by every failing /api/v1 request
+type ApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Validation messages by JSON field name
+	FieldErrors map[string]string `json:"fieldErrors,omitempty"`
+	// Ids of the bookings holding the requested slot
+	ConflictingBookingIds []int64 `json:"conflictingBookingIds,omitempty"`
+}
+
+type ApiLoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ApiLoginResponse struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"refreshTokenExpiresAt"`
+}
+
+type ApiRefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type ApiPasswordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+type ApiRoom struct {
+	Id            int64    `json:"id"`
+	Title         string   `json:"title"`
+	TimeZone      string   `json:"timeZone"`
+	Capacity      int      `json:"capacity"`
+	Building      string   `json:"building"`
+	Floor         string   `json:"floor"`
+	Description   string   `json:"description"`
+	Equipment     []string `json:"equipment"`
+	Accessibility []string `json:"accessibility"`
+	ImageUrl      string   `json:"imageUrl,omitempty"`
+	// Set once the room has been deleted
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+}
+
+type ApiRoomRequest struct {
+	Title string `json:"title" binding:"required"`
+	// Defaults to UTC
+	TimeZone string `json:"timeZone"`
+	// 0 if unknown
+	Capacity      int      `json:"capacity" binding:"min=0"`
+	Building      string   `json:"building"`
+	Floor         string   `json:"floor"`
+	Description   string   `json:"description"`
+	Equipment     []string `json:"equipment"`
+	Accessibility []string `json:"accessibility"`
+	ImageUrl      string   `json:"imageUrl"`
+}
+
+type ApiUser struct {
+	Id       int64  `json:"id"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	TimeZone string `json:"timeZone"`
+}
+
+type ApiTimeZoneRequest struct {
+	TimeZone string `json:"timeZone" binding:"required"`
+}
+
+type ApiFeed struct {
+	Id        int64     `json:"id"`
+	RoomId    int64     `json:"roomId,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Only set on creation, as the token is not stored
+	Url string `json:"url,omitempty"`
+}
+
+type ApiFeedRequest struct {
+	// Omitted for a feed of the user's own bookings
+	RoomId int64 `json:"roomId"`
+}
+
+type ApiRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type ApiBooking struct {
+	Id          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	RoomId      int64     `json:"roomId"`
+	UserId      int64     `json:"userId"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Attendees   int       `json:"attendees"`
+	// RRULE of recurring series, e.g. "FREQ=WEEKLY;BYDAY=MO"
+	Recurrence    string     `json:"recurrence,omitempty"`
+	SeriesId      int64      `json:"seriesId,omitempty"`
+	OriginalStart *time.Time `json:"originalStart,omitempty"`
+}
+
+type ApiBookingRequest struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	RoomId      int64     `json:"roomId" binding:"required"`
+	UserId      int64     `json:"userId" binding:"required"`
+	StartTime   time.Time `json:"startTime" binding:"required"`
+	EndTime     time.Time `json:"endTime" binding:"required,gtfield=StartTime"`
+	// Rejected if it exceeds the capacity of the room
+	Attendees  int    `json:"attendees" binding:"min=0"`
+	Recurrence string `json:"recurrence"`
+}
+
+type ApiImportEntry struct {
+	Uid string `json:"uid"`
+	// Start of the replaced series occurrence, if the event is an exception
+	RecurrenceId          *time.Time `json:"recurrenceId,omitempty"`
+	Summary               string     `json:"summary"`
+	Start                 *time.Time `json:"start,omitempty"`
+	Status                string     `json:"status"`
+	Reason                string     `json:"reason,omitempty"`
+	BookingId             int64      `json:"bookingId,omitempty"`
+	ConflictingBookingIds []int64    `json:"conflictingBookingIds,omitempty"`
+}
+
+type ApiImportReport struct {
+	DryRun    bool             `json:"dryRun"`
+	Created   int              `json:"created"`
+	Skipped   int              `json:"skipped"`
+	Conflicts int              `json:"conflicts"`
+	Entries   []ApiImportEntry `json:"entries"`
+}
+
+type ApiCalendarEvent struct {
+	StartRow int        `json:"startRow"`
+	EndRow   int        `json:"endRow"`
+	Lane     int        `json:"lane"`
+	Lanes    int        `json:"lanes"`
+	Booking  ApiBooking `json:"booking"`
+}
+
+type ApiCalendarDay struct {
+	DayNum    int                `json:"dayNum"`
+	DayString string             `json:"dayString"`
+	Events    []ApiCalendarEvent `json:"events"`
+}
+
+type ApiCalendarWeek struct {
+	Year int `json:"year"`
+	Week int `json:"week"`
+	// Time zone of the days & working hours, i.e. the time zone of the user
+	TimeZone string `json:"timeZone"`
+	// Working hours as "15:04"
+	Start       string           `json:"start"`
+	End         string           `json:"end"`
+	SlotMinutes int              `json:"slotMinutes"`
+	TimeMarkers []string         `json:"timeMarkers"`
+	Days        []ApiCalendarDay `json:"days"`
+}
+
+type ApiAvailableSlot struct {
+	RoomId int64     `json:"roomId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Free interval within the working hours of the room the slot lies in
+	FreeFrom  time.Time `json:"freeFrom"`
+	FreeUntil time.Time `json:"freeUntil"`
+}
+
+type ApiInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type ApiRoomBusy struct {
+	RoomId int64         `json:"roomId"`
+	Busy   []ApiInterval `json:"busy"`
+}
+
+func registerApiRoutes(r *gin.Engine) error {
+	// Report validation errors by their JSON field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			return strings.Split(field.Tag.Get("json"), ",")[0]
+		})
+	}
+	doc, err := loadOpenApiSpec()
+	if err != nil {
+		return err
+	}
+	validationMiddleware, err := OpenApiValidationMiddleware(doc)
+	if err != nil {
+		return err
+	}
+
+	r.GET("/api/docs", handleApiDocsRequest)
+	r.GET("/api/docs/openapi.yaml", handleApiSpecRequest)
+
+	api := r.Group("/api/v1")
+	api.Use(validationMiddleware)
+	api.POST("/login", handleApiLoginRequest)
+	api.POST("/token/refresh", handleApiRefreshRequest)
+
+	authenticated := api.Group("/")
+	authenticated.Use(ApiAuthMiddleware())
+	{
+		authenticated.POST("/logout", handleApiLogoutRequest)
+		authenticated.POST("/account/password", handleApiChangePasswordRequest)
+		authenticated.POST("/account/time-zone", handleApiChangeTimeZoneRequest)
+		roomEndpoints := authenticated.Group("/rooms")
+		{
+			roomEndpoints.GET("", handleApiGetRoomsRequest)
+			roomEndpoints.POST("", requireApiRole(booking.Role.CanManageRooms), handleApiAddRoomRequest)
+			roomEndpoints.GET("/:id", handleApiGetRoomRequest)
+			roomEndpoints.PUT("/:id", requireApiRole(booking.Role.CanManageRooms), handleApiUpdateRoomRequest)
+			roomEndpoints.DELETE("/:id", requireApiRole(booking.Role.CanManageRooms), handleApiDeleteRoomRequest)
+		}
+		userEndpoints := authenticated.Group("/users")
+		{
+			userEndpoints.GET("", handleApiGetUsersRequest)
+			userEndpoints.GET("/:id", handleApiGetUserRequest)
+			userEndpoints.PUT("/:id/role", requireApiRole(booking.Role.CanManageUsers), handleApiUpdateUserRoleRequest)
+		}
+		bookingEndpoints := authenticated.Group("/bookings")
+		{
+			bookingEndpoints.GET("", handleApiGetBookingsRequest)
+			bookingEndpoints.POST("", requireApiRole(booking.Role.CanBook), handleApiAddBookingRequest)
+			bookingEndpoints.POST("/import", requireApiRole(booking.Role.CanManageAllBookings), handleApiImportBookingsRequest)
+			bookingEndpoints.GET("/:id", handleApiGetBookingRequest)
+			bookingEndpoints.PUT("/:id", requireApiRole(booking.Role.CanBook), handleApiUpdateBookingRequest)
+			bookingEndpoints.DELETE("/:id", requireApiRole(booking.Role.CanBook), handleApiDeleteBookingRequest)
+		}
+		authenticated.GET("/calendar", handleApiGetCalendarRequest)
+		authenticated.GET("/availability", handleApiFindRoomsRequest)
+		authenticated.GET("/freebusy", handleApiFreeBusyRequest)
+		feedEndpoints := authenticated.Group("/feeds")
+		{
+			feedEndpoints.GET("", handleApiGetFeedsRequest)
+			feedEndpoints.POST("", handleApiAddFeedRequest)
+			feedEndpoints.DELETE("/:id", handleApiRevokeFeedRequest)
+		}
+	}
+	return nil
+}
+
+// Writes the error object matching err and aborts the request
+func abortWithApiError(c *gin.Context, err error) {
+	var validationErrors validator.ValidationErrors
+	var conflict *booking.ErrBookingConflict
+	var inUse *booking.ErrRoomInUse
+	switch {
+	case errors.As(err, &validationErrors):
+		fieldErrors := map[string]string{}
+		for _, fe := range validationErrors {
+			fieldErrors[fe.Field()] = validationMessage(fe)
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid request body", FieldErrors: fieldErrors})
+	case errors.As(err, &conflict):
+		c.AbortWithStatusJSON(http.StatusConflict, ApiError{Code: "conflict", Message: err.Error(), ConflictingBookingIds: conflict.Ids()})
+	case errors.As(err, &inUse):
+		c.AbortWithStatusJSON(http.StatusConflict, ApiError{Code: "conflict", Message: err.Error(), ConflictingBookingIds: inUse.Ids()})
+	case errors.Is(err, ErrForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, ApiError{Code: "forbidden", Message: err.Error()})
+	case errors.Is(err, booking.ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, ApiError{Code: "not_found", Message: err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, ApiError{Code: "invalid_request", Message: err.Error()})
+	}
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "Field is required"
+	case "gtfield":
+		return "Has to be after " + fe.Param()
+	}
+	return "Failed validation '" + fe.Tag() + "'"
+}
+
+// Middleware rejecting requests without a valid "Authorization: Bearer <jwt>" header
+func ApiAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ApiError{Code: "unauthorized", Message: "Missing bearer token"})
+			return
+		}
+		token, user, err := authenticate(tokenString)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ApiError{Code: "unauthorized", Message: err.Error()})
+			return
+		}
+		c.Set("token", token)
+		c.Set("userId", token.UserId)
+		c.Set("user", user)
+	}
+}
+
+func apiIdParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "invalid_request", Message: "Invalid id", FieldErrors: map[string]string{"id": err.Error()}})
+		return 0, false
+	}
+	return id, true
+}
+
+func handleApiLoginRequest(c *gin.Context) {
+	var req ApiLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	tokens, err := LoginRequest(req.Username, req.Password)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ApiError{Code: "unauthorized", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ApiLoginResponse{tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt})
+}
+
+func handleApiRefreshRequest(c *gin.Context) {
+	var req ApiRefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	tokens, err := RefreshRequest(req.RefreshToken)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ApiError{Code: "unauthorized", Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ApiLoginResponse{tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt})
+}
+
+func handleApiLogoutRequest(c *gin.Context) {
+	if err := LogoutRequest(c.MustGet("token").(*JwtClaims).SessionId); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleApiChangePasswordRequest(c *gin.Context) {
+	var req ApiPasswordChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := ChangePasswordRequest(currentUserId(c), currentSessionId(c), req.CurrentPassword, req.NewPassword); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Sets the time zone the calendar of the authenticated user is displayed in
+func handleApiChangeTimeZoneRequest(c *gin.Context) {
+	var req ApiTimeZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := userRepo.UpdateTimeZone(currentUserId(c), req.TimeZone); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func apiRoomFromRoom(r *booking.Room) ApiRoom {
+	res := ApiRoom{
+		Id:            r.Id,
+		Title:         r.Title,
+		TimeZone:      r.TimeZone,
+		Capacity:      r.Capacity,
+		Building:      r.Building,
+		Floor:         r.Floor,
+		Description:   r.Description,
+		Equipment:     r.Equipment,
+		Accessibility: r.Accessibility,
+		ImageUrl:      r.ImageUrl,
+	}
+	if !r.ArchivedAt.IsZero() {
+		res.ArchivedAt = &r.ArchivedAt
+	}
+	return res
+}
+
+func roomFromApiRequest(req *ApiRoomRequest) booking.Room {
+	return booking.Room{
+		Title:         req.Title,
+		TimeZone:      req.TimeZone,
+		Capacity:      req.Capacity,
+		Building:      req.Building,
+		Floor:         req.Floor,
+		Description:   req.Description,
+		Equipment:     req.Equipment,
+		Accessibility: req.Accessibility,
+		ImageUrl:      req.ImageUrl,
+	}
+}
+
+func handleApiGetRoomsRequest(c *gin.Context) {
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := make([]ApiRoom, len(rooms))
+	for idx, room := range rooms {
+		res[idx] = apiRoomFromRoom(room)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func handleApiGetRoomRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	room, err := roomRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiRoomFromRoom(room))
+}
+
+func handleApiAddRoomRequest(c *gin.Context) {
+	var req ApiRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	room, err := roomRepo.Create(roomFromApiRequest(&req))
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, apiRoomFromRoom(room))
+}
+
+func handleApiUpdateRoomRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	var req ApiRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	room := roomFromApiRequest(&req)
+	room.Id = id
+	updated, err := roomRepo.Update(room)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiRoomFromRoom(updated))
+}
+
+func handleApiDeleteRoomRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	if err := roomRepo.Delete(id); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func apiUserFromUser(u *booking.User) ApiUser {
+	return ApiUser{Id: u.Id, Name: u.Name, Role: string(u.Role), TimeZone: u.TimeZone}
+}
+
+func handleApiGetUsersRequest(c *gin.Context) {
+	users, err := userRepo.GetAll()
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := make([]ApiUser, len(users))
+	for idx, user := range users {
+		res[idx] = apiUserFromUser(user)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func handleApiGetUserRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	user, err := userRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiUserFromUser(user))
+}
+
+func handleApiUpdateUserRoleRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	var req ApiRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	role, err := booking.ParseRole(req.Role)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := userRepo.UpdateRole(id, role); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	user, err := userRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiUserFromUser(user))
+}
+
+func apiBookingFromBooking(b *booking.Booking) ApiBooking {
+	res := ApiBooking{
+		Id:          b.Id,
+		Title:       b.Title,
+		Description: b.Description,
+		RoomId:      b.Room.Id,
+		UserId:      b.User.Id,
+		StartTime:   b.StartTime,
+		EndTime:     b.EndTime,
+		Attendees:   b.Attendees,
+		SeriesId:    b.SeriesId,
+	}
+	if b.Recurrence != nil {
+		res.Recurrence = b.Recurrence.String()
+	}
+	if !b.OriginalStart.IsZero() {
+		res.OriginalStart = &b.OriginalStart
+	}
+	return res
+}
+
+func apiBookingsFromBookings(bookings []*booking.Booking) []ApiBooking {
+	res := make([]ApiBooking, len(bookings))
+	for idx, b := range bookings {
+		res[idx] = apiBookingFromBooking(b)
+	}
+	return res
+}
+
+// Applies a validated request body to the given booking
+func bookingFromApiRequest(req *ApiBookingRequest, b *booking.Booking) error {
+	b.Title = req.Title
+	b.Description = req.Description
+	b.Room = booking.Room{Id: req.RoomId}
+	b.User = booking.User{Id: req.UserId}
+	b.StartTime = req.StartTime
+	b.EndTime = req.EndTime
+	b.Attendees = req.Attendees
+	b.Recurrence = nil
+	if len(req.Recurrence) > 0 {
+		recurrence, err := booking.ParseRecurrence(req.Recurrence)
+		if err != nil {
+			return err
+		}
+		b.Recurrence = recurrence
+	}
+	return nil
+}
+
+// Upper limit of the interval, that recurring series are expanded in by the bookings API
+const maxApiBookingsInterval = 366 * 24 * time.Hour
+
+// Lists the bookings & expanded occurrences within [from, to), if both are given. Otherwise lists
+// a page of bookings without expanding series. Bookings can be filtered by rooms & users either way
+func handleApiGetBookingsRequest(c *gin.Context) {
+	from, to := c.Query("from"), c.Query("to")
+	if len(from) == 0 && len(to) == 0 {
+		handleApiQueryBookingsRequest(c)
+		return
+	}
+	fieldErrors := map[string]string{}
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		fieldErrors["from"] = "Expected RFC 3339 timestamp"
+	}
+	end, err := time.Parse(time.RFC3339, to)
+	if err != nil {
+		fieldErrors["to"] = "Expected RFC 3339 timestamp"
+	} else if len(fieldErrors) == 0 && !end.After(start) {
+		fieldErrors["to"] = "Has to be after from"
+	} else if len(fieldErrors) == 0 && end.Sub(start) > maxApiBookingsInterval {
+		fieldErrors["to"] = fmt.Sprintf("The interval cannot exceed %d days", int(maxApiBookingsInterval.Hours()/24))
+	}
+	filter := apiBookingFilter(c, fieldErrors)
+	if len(fieldErrors) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid time range", FieldErrors: fieldErrors})
+		return
+	}
+	bookings, err := bookingRepo.FindWithinTimeIntervalByFilter(&start, &end, filter)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiBookingsFromBookings(bookings))
+}
+
+// Lists a page of bookings, selected by the "after", "before", "q", "status", "sort", "cursor" &
+// "limit" query parameters. The Link header points to the next page, if there is one
+func handleApiQueryBookingsRequest(c *gin.Context) {
+	fieldErrors := map[string]string{}
+	query := booking.BookingQuery{BookingFilter: apiBookingFilter(c, fieldErrors), Search: c.Query("q"), Cursor: c.Query("cursor")}
+	for name, t := range map[string]*time.Time{"after": &query.From, "before": &query.To} {
+		if value := c.Query(name); len(value) > 0 {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				fieldErrors[name] = "Expected RFC 3339 timestamp"
+			}
+		}
+	}
+	var err error
+	if query.Status, err = booking.ParseBookingStatus(c.Query("status")); err != nil {
+		fieldErrors["status"] = "Expected upcoming or past"
+	}
+	if query.Sort, err = booking.ParseBookingSort(c.Query("sort")); err != nil {
+		fieldErrors["sort"] = "Expected startTime, -startTime, title or -title"
+	}
+	if limit := c.Query("limit"); len(limit) > 0 {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > booking.MaxPageSize {
+			fieldErrors["limit"] = fmt.Sprintf("Expected number between 1 & %d", booking.MaxPageSize)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid query", FieldErrors: fieldErrors})
+		return
+	}
+	page, err := bookingRepo.Query(query)
+	if errors.Is(err, booking.ErrInvalidCursor) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid query", FieldErrors: map[string]string{"cursor": err.Error()}})
+		return
+	}
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if len(page.NextCursor) > 0 {
+		next := c.Request.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		c.Header("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, c.Request.URL.Path, next.Encode()))
+	}
+	c.JSON(http.StatusOK, apiBookingsFromBookings(page.Bookings))
+}
+
+func handleApiGetBookingRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	b, err := bookingRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiBookingFromBooking(b))
+}
+
+func handleApiAddBookingRequest(c *gin.Context) {
+	var req ApiBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	var b booking.Booking
+	if err := bookingFromApiRequest(&req, &b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := authorizeBookingChange(c, &b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	created, err := bookingRepo.Create(b)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, apiBookingFromBooking(created))
+}
+
+// Upper limit of imported iCalendar files
+const maxImportSize = 10 << 20
+
+func apiImportReportFromReport(report *ical.ImportReport) ApiImportReport {
+	res := ApiImportReport{
+		DryRun:    report.DryRun,
+		Created:   report.Count(ical.ImportCreated),
+		Skipped:   report.Count(ical.ImportSkipped),
+		Conflicts: report.Count(ical.ImportConflict),
+		Entries:   make([]ApiImportEntry, len(report.Entries)),
+	}
+	for idx, entry := range report.Entries {
+		res.Entries[idx] = ApiImportEntry{
+			Uid:                   entry.Uid,
+			Summary:               entry.Summary,
+			Status:                string(entry.Status),
+			Reason:                entry.Reason,
+			BookingId:             entry.BookingId,
+			ConflictingBookingIds: entry.ConflictingBookingIds,
+		}
+		if !entry.RecurrenceId.IsZero() {
+			res.Entries[idx].RecurrenceId = &entry.RecurrenceId
+		}
+		if !entry.Start.IsZero() {
+			res.Entries[idx].Start = &entry.Start
+		}
+	}
+	return res
+}
+
+func handleApiImportBookingsRequest(c *gin.Context) {
+	dryRun := false
+	if dryRunParam := c.Query("dryRun"); len(dryRunParam) > 0 {
+		var err error
+		if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid dry run flag", FieldErrors: map[string]string{"dryRun": "Expected true or false"}})
+			return
+		}
+	}
+	report, err := ImportBookingsRequest(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize), dryRun)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, apiImportReportFromReport(report))
+}
+
+func handleApiUpdateBookingRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	var req ApiBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	b, err := bookingRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	// Members may neither change bookings of others, nor hand their bookings over
+	if err := authorizeBookingChange(c, b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := bookingFromApiRequest(&req, b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := authorizeBookingChange(c, b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	updated, err := bookingRepo.Update(*b)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiBookingFromBooking(updated))
+}
+
+func handleApiDeleteBookingRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	b, err := bookingRepo.GetById(id)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := authorizeBookingChange(c, b); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	if err := bookingRepo.Delete(id); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Returns the calendar data of an ISO week. Defaults to the current week in the time zone of the user
+func handleApiGetCalendarRequest(c *gin.Context) {
+	loc := currentUser(c).Location()
+	year, week := time.Now().In(loc).ISOWeek()
+	fieldErrors := map[string]string{}
+	if yearParam := c.Query("year"); len(yearParam) > 0 {
+		var err error
+		if year, err = strconv.Atoi(yearParam); err != nil || year < minCalendarYear || year > maxCalendarYear {
+			fieldErrors["year"] = fmt.Sprintf("Expected number between %d and %d", minCalendarYear, maxCalendarYear)
+		}
+	}
+	if weekParam := c.Query("week"); len(weekParam) > 0 {
+		var err error
+		// Only some years have a 53rd week
+		if week, err = strconv.Atoi(weekParam); err != nil || week < 1 || week > calendar.WeeksInYear(year) {
+			fieldErrors["week"] = fmt.Sprintf("Expected number between 1 and %d", calendar.WeeksInYear(year))
+		}
+	}
+	filter := apiBookingFilter(c, fieldErrors)
+	if len(fieldErrors) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid calendar week", FieldErrors: fieldErrors})
+		return
+	}
+
+	var service calendar.CalendarService = calendar.NewService(bookingRepo, roomRepo, calendarConfig).WithLocation(loc)
+	dayData, err := service.GetCalendarDayData(year, week, filter)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	days := make([]ApiCalendarDay, len(dayData))
+	for idx, day := range dayData {
+		events := make([]ApiCalendarEvent, len(day.Events))
+		for eventIdx, event := range day.Events {
+			events[eventIdx] = ApiCalendarEvent{event.StartRow, event.EndRow, event.Lane, event.Lanes, apiBookingFromBooking(event.Booking)}
+		}
+		days[idx] = ApiCalendarDay{day.DayNum, day.DayString, events}
+	}
+	hours := service.WorkingHours(filter)
+	c.JSON(http.StatusOK, ApiCalendarWeek{
+		Year:        year,
+		Week:        week,
+		TimeZone:    loc.String(),
+		Start:       formatTimeOfDay(hours.Start),
+		End:         formatTimeOfDay(hours.End),
+		SlotMinutes: hours.SlotMinutes,
+		TimeMarkers: service.GenerateTimeMarkers(filter),
+		Days:        days,
+	})
+}
+
+// Parses the repeatable "room" & "user" query parameters
+func apiBookingFilter(c *gin.Context, fieldErrors map[string]string) booking.BookingFilter {
+	parse := func(name string) []int64 {
+		ids := []int64{}
+		for _, value := range c.QueryArray(name) {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id < 1 {
+				fieldErrors[name] = fmt.Sprintf("Expected %s id", name)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	return booking.BookingFilter{RoomIds: parse("room"), UserIds: parse("user"), Rooms: apiRoomFilter(c, fieldErrors)}
+}
+
+// Parses the "capacity", "building" & repeatable "equipment" & "accessibility" query parameters
+func apiRoomFilter(c *gin.Context, fieldErrors map[string]string) booking.RoomFilter {
+	filter := booking.RoomFilter{
+		Building:      c.Query("building"),
+		Equipment:     c.QueryArray("equipment"),
+		Accessibility: c.QueryArray("accessibility"),
+	}
+	if capacity := c.Query("capacity"); len(capacity) > 0 {
+		var err error
+		if filter.MinCapacity, err = strconv.Atoi(capacity); err != nil || filter.MinCapacity < 1 {
+			fieldErrors["capacity"] = "Expected positive number"
+		}
+	}
+	return filter
+}
+
+// Parses the required "from" & "to" query parameters
+func apiTimeRange(c *gin.Context, fieldErrors map[string]string) (time.Time, time.Time) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		fieldErrors["from"] = "Expected RFC 3339 timestamp"
+	}
+	to, err := time.Parse(time.RFC3339, c.Query("to"))
+	if err != nil {
+		fieldErrors["to"] = "Expected RFC 3339 timestamp"
+	}
+	return from, to
+}
+
+// Returns free slots of ?duration= minutes within [from, to), earliest first
+func handleApiFindRoomsRequest(c *gin.Context) {
+	fieldErrors := map[string]string{}
+	query := calendar.AvailabilityQuery{}
+	query.From, query.To = apiTimeRange(c, fieldErrors)
+	if minutes, err := strconv.Atoi(c.Query("duration")); err != nil || minutes < 1 {
+		fieldErrors["duration"] = "Expected number of minutes"
+	} else {
+		query.Duration = time.Duration(minutes) * time.Minute
+	}
+	if limitParam := c.Query("limit"); len(limitParam) > 0 {
+		var err error
+		if query.Limit, err = strconv.Atoi(limitParam); err != nil || query.Limit < 1 {
+			fieldErrors["limit"] = "Expected positive number"
+		}
+	}
+	filter := apiBookingFilter(c, fieldErrors)
+	query.RoomSelection = calendar.RoomSelection{RoomIds: filter.RoomIds, Rooms: filter.Rooms}
+	if len(fieldErrors) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid availability query", FieldErrors: fieldErrors})
+		return
+	}
+	var service calendar.AvailabilityService = calendar.NewAvailabilityService(bookingRepo, roomRepo, calendarConfig)
+	candidates, err := service.FindRooms(query)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := make([]ApiAvailableSlot, len(candidates))
+	for idx, candidate := range candidates {
+		res[idx] = ApiAvailableSlot{candidate.Room.Id, candidate.Start, candidate.End, candidate.FreeFrom, candidate.FreeUntil}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// Returns the busy intervals of the rooms within [from, to) without revealing the bookings
+func handleApiFreeBusyRequest(c *gin.Context) {
+	fieldErrors := map[string]string{}
+	from, to := apiTimeRange(c, fieldErrors)
+	filter := apiBookingFilter(c, fieldErrors)
+	if len(fieldErrors) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, ApiError{Code: "validation_failed", Message: "Invalid time range", FieldErrors: fieldErrors})
+		return
+	}
+	var service calendar.AvailabilityService = calendar.NewAvailabilityService(bookingRepo, roomRepo, calendarConfig)
+	rooms, err := service.FreeBusy(from, to, calendar.RoomSelection{RoomIds: filter.RoomIds, Rooms: filter.Rooms})
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := make([]ApiRoomBusy, len(rooms))
+	for idx, room := range rooms {
+		busy := make([]ApiInterval, len(room.Busy))
+		for intervalIdx, interval := range room.Busy {
+			busy[intervalIdx] = ApiInterval{interval.Start, interval.End}
+		}
+		res[idx] = ApiRoomBusy{room.Room.Id, busy}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func apiFeedFromFeed(f *booking.Feed, rooms []*booking.Room) ApiFeed {
+	return ApiFeed{Id: f.Id, RoomId: f.RoomId, Name: feedName(f, rooms), CreatedAt: f.CreatedAt}
+}
+
+func handleApiGetFeedsRequest(c *gin.Context) {
+	feeds, err := feedRepo.GetAllByUser(currentUserId(c))
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := make([]ApiFeed, len(feeds))
+	for idx, feed := range feeds {
+		res[idx] = apiFeedFromFeed(feed, rooms)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func handleApiAddFeedRequest(c *gin.Context) {
+	var req ApiFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	feed, token, err := CreateFeedRequest(currentUserId(c), req.RoomId)
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	res := apiFeedFromFeed(feed, rooms)
+	res.Url = feedUrl(c, token)
+	c.JSON(http.StatusCreated, res)
+}
+
+func handleApiRevokeFeedRequest(c *gin.Context) {
+	id, ok := apiIdParam(c)
+	if !ok {
+		return
+	}
+	if err := RevokeFeedRequest(currentUserId(c), id); err != nil {
+		abortWithApiError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
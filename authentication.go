@@ -1,80 +1,310 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"lucb31/booking-go/booking"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type JwtClaims struct {
-	Foo string `json:"foo"`
+	UserId int64 `json:"uid"`
+	// Session the token was issued for. Tokens of revoked sessions are rejected
+	SessionId int64 `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// Todo Should put this key somewhere else
-var jwtSecretKey = []byte("generateMeSecretlyAndStoreInEnv")
+// Short-lived access token & the refresh token to renew it with
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// Expiry of the refresh token, i.e. of the session
+	ExpiresAt time.Time
+}
+
+const accessTokenLifetime = 10 * time.Minute
+const refreshTokenLifetime = 7 * 24 * time.Hour
+
+var ErrSessionExpired = errors.New("Session expired")
+
+// Accounts are locked for lockoutDuration after maxFailedLogins failed attempts in a row
+const maxFailedLogins = 5
+const lockoutDuration = 15 * time.Minute
+
+const minPasswordLength = 8
+
+var ErrInvalidCredentials = errors.New("Invalid credentials")
+
+// Compared against for unknown users, so response times do not reveal which users exist
+var dummyUser = func() booking.User {
+	u := booking.User{}
+	u.SetPassword("dummy-password")
+	return u
+}()
+
+func LoginRequest(username string, password string) (*TokenPair, error) {
+	user, err := checkCredentials(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return StartSession(user)
+}
+
+// Returns the user of the given credentials. Failed attempts count towards locking the account
+func checkCredentials(username string, password string) (*booking.User, error) {
+	user, err := userRepo.GetByName(username)
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			dummyUser.CheckPassword(password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if err := checkPassword(user, password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Verifies the password of the user. Failed attempts count towards locking the account, a
+// successful one resets them
+func checkPassword(user *booking.User, password string) error {
+	now := time.Now()
+	if user.Locked(now) {
+		return fmt.Errorf("Account locked until %s", user.LockedUntil.Local().Format(time.Kitchen))
+	}
+	if !user.CheckPassword(password) {
+		if err := userRepo.RecordFailedLogin(user.Id, maxFailedLogins, now.Add(lockoutDuration)); err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+	if user.FailedLogins > 0 {
+		return userRepo.ResetFailedLogins(user.Id)
+	}
+	return nil
+}
 
-func LoginRequest(username string, password string) (string, error) {
-	if username != "root" || password != "root" {
-		return "", errors.New("Invalid credentials")
+// Opens a new session of the user
+func StartSession(user *booking.User) (*TokenPair, error) {
+	refreshToken, tokenHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	session, err := sessionRepo.Create(booking.Session{UserId: user.Id, TokenHash: tokenHash, ExpiresAt: time.Now().Add(refreshTokenLifetime)})
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := GenerateJWT(user, session.Id)
+	if err != nil {
+		return nil, err
 	}
-	return GenerateJWT(username)
+	return &TokenPair{accessToken, refreshToken, session.ExpiresAt}, nil
 }
 
-func GenerateJWT(username string) (string, error) {
+// Exchanges a refresh token for a new token pair. The refresh token can only be used once: a
+// token exchanged concurrently by someone else revokes the whole session, as it was presumably stolen
+func RefreshRequest(refreshToken string) (*TokenPair, error) {
+	previousHash := hashToken(refreshToken)
+	session, err := sessionRepo.GetByTokenHash(previousHash)
+	if err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			return nil, ErrSessionExpired
+		}
+		return nil, err
+	}
+	if !session.Active(time.Now()) {
+		return nil, ErrSessionExpired
+	}
+	user, err := userRepo.GetById(session.UserId)
+	if err != nil {
+		return nil, err
+	}
+	newRefreshToken, tokenHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(refreshTokenLifetime)
+	if err := sessionRepo.Rotate(session.Id, previousHash, tokenHash, expiresAt); err != nil {
+		if errors.Is(err, booking.ErrNotFound) {
+			if err := sessionRepo.Revoke(session.Id); err != nil {
+				return nil, err
+			}
+			return nil, ErrSessionExpired
+		}
+		return nil, err
+	}
+	accessToken, err := GenerateJWT(user, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{accessToken, newRefreshToken, expiresAt}, nil
+}
+
+// Revokes the session, rejecting its access & refresh tokens from now on
+func LogoutRequest(sessionId int64) error {
+	return sessionRepo.Revoke(sessionId)
+}
+
+// Returns a random token & the hash to store. Used for refresh tokens & feed tokens
+func generateToken() (string, string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(token)
+	return encoded, hashToken(encoded), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Replaces the password of the user after verifying the current one & revokes all other sessions
+// of the user, so whoever else holds one loses access. Wrong current passwords count towards
+// locking the account like failed logins
+func ChangePasswordRequest(userId int64, sessionId int64, currentPassword string, newPassword string) error {
+	user, err := userRepo.GetById(userId)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(user, currentPassword); err != nil {
+		return err
+	}
+	if len(newPassword) < minPasswordLength {
+		return fmt.Errorf("Password has to be at least %d characters long", minPasswordLength)
+	}
+	if err := user.SetPassword(newPassword); err != nil {
+		return err
+	}
+	if err := userRepo.UpdatePasswordHash(user.Id, user.PasswordHash); err != nil {
+		return err
+	}
+	return sessionRepo.RevokeAllForUser(user.Id, sessionId)
+}
+
+func GenerateJWT(user *booking.User, sessionId int64) (string, error) {
 	// Define claims
-	claims := JwtClaims{"foo",
+	claims := JwtClaims{user.Id, sessionId,
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(10 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenLifetime)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "test",
-			Subject:   username,
+			Subject:   user.Name,
 		}}
 
-	// Initialize token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Generate token string
-	tokenString, err := token.SignedString(jwtSecretKey)
-	if err != nil {
-		return "", err
-	}
-	return tokenString, nil
+	// Sign with the active key
+	return jwtKeys.sign(claims)
 }
 
-func VerifyJWT(tokenString string) (*jwt.Token, error) {
+func VerifyJWT(tokenString string) (*JwtClaims, error) {
 	// Empty token provided
 	if len(tokenString) == 0 {
 		return nil, errors.New("No token provided")
 	}
 	// Parse token
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if t.Method != jwt.SigningMethodHS256 {
-			return nil, errors.New("Incompatible signing method")
-		}
-
-		return jwtSecretKey, nil
-	})
+	claims := &JwtClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, jwtKeys.keyFunc)
 	if err != nil {
 		return nil, err
 	}
-	return token, nil
+	return claims, nil
+}
+
+// Verifies the access token and loads the user of its session. Fails, if the session was revoked
+func authenticate(tokenString string) (*JwtClaims, *booking.User, error) {
+	claims, err := VerifyJWT(tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := sessionRepo.GetById(claims.SessionId)
+	if err != nil || !session.Active(time.Now()) || session.UserId != claims.UserId {
+		return nil, nil, ErrSessionExpired
+	}
+	// Load the user on every request, so role changes apply immediately
+	user, err := userRepo.GetById(claims.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, user, nil
 }
 
-// Middleware to redirect to /login page if Jwt-Token provided is not valid
+func setSessionCookies(c *gin.Context, tokens *TokenPair) {
+	c.SetCookie("Jwt-Token", tokens.AccessToken, int(accessTokenLifetime.Seconds()), "/", "localhost", true, true)
+	c.SetCookie("Refresh-Token", tokens.RefreshToken, int(time.Until(tokens.ExpiresAt).Seconds()), "/", "localhost", true, true)
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie("Jwt-Token", "", -1, "/", "localhost", true, true)
+	c.SetCookie("Refresh-Token", "", -1, "/", "localhost", true, true)
+}
+
+// Returns the id of the user the request was authenticated for
+func currentUserId(c *gin.Context) int64 {
+	return c.GetInt64("userId")
+}
+
+// Returns the id of the session the request was authenticated with. Zero for basic auth
+func currentSessionId(c *gin.Context) int64 {
+	if claims, ok := c.Get("token"); ok {
+		return claims.(*JwtClaims).SessionId
+	}
+	return 0
+}
+
+// Middleware to redirect to /login page if Jwt-Token provided is not valid. Expired access tokens
+// are renewed with the Refresh-Token cookie
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		jwt, err := c.Cookie("Jwt-Token")
+		jwt, _ := c.Cookie("Jwt-Token")
+		claims, user, err := authenticate(jwt)
 		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
+			refreshToken, _ := c.Cookie("Refresh-Token")
+			tokens, refreshErr := RefreshRequest(refreshToken)
+			if refreshErr == nil {
+				claims, user, err = authenticate(tokens.AccessToken)
+			}
+			if refreshErr != nil || err != nil {
+				clearSessionCookies(c)
+				c.Redirect(http.StatusFound, "/login")
+				c.Abort()
+				return
+			}
+			setSessionCookies(c, tokens)
+		}
+		c.Set("token", claims)
+		c.Set("userId", claims.UserId)
+		c.Set("user", user)
+	}
+}
+
+// Middleware authenticating every request with HTTP basic auth, as calendar clients cannot
+// log in. Answers 401 with a challenge, so clients ask for the credentials
+func BasicAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		var user *booking.User
+		err := ErrInvalidCredentials
+		if ok {
+			user, err = checkCredentials(username, password)
 		}
-		token, err := VerifyJWT(jwt)
 		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
+			c.Header("WWW-Authenticate", `Basic realm="booking-go", charset="UTF-8"`)
+			c.String(http.StatusUnauthorized, err.Error())
+			c.Abort()
+			return
 		}
-		c.Set("token", token)
+		c.Set("userId", user.Id)
+		c.Set("user", user)
 	}
 }
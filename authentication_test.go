@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Replaces the global repositories by repositories of a temporary database
+func useTestDatabase(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	previousUsers, previousRooms, previousBookings, previousSessions, previousFeeds, previousObjects, previousMigrator := userRepo, roomRepo, bookingRepo, sessionRepo, feedRepo, calendarObjectRepo, migrator
+	t.Cleanup(func() {
+		userRepo, roomRepo, bookingRepo, sessionRepo, feedRepo, calendarObjectRepo, migrator = previousUsers, previousRooms, previousBookings, previousSessions, previousFeeds, previousObjects, previousMigrator
+	})
+	userRepo = booking.NewUserRepositorySQLite(db)
+	roomRepo = booking.NewRoomsRepositorySQLite(db)
+	bookingRepo = booking.NewBookingRepositorySQLite(db, userRepo, roomRepo)
+	sessionRepo = booking.NewSessionRepositorySQLite(db)
+	feedRepo = booking.NewFeedRepositorySQLite(db)
+	calendarObjectRepo = booking.NewCalendarObjectRepositorySQLite(db)
+	if migrator, err = migrations.New(db); err != nil {
+		t.Fatal(err)
+	}
+	booking.AddMigrationSteps(migrator)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+}
+
+func createTestUser(t *testing.T, name string, password string, role booking.Role) *booking.User {
+	user := booking.User{Name: name, Role: role}
+	if err := user.SetPassword(password); err != nil {
+		t.Fatalf("Unable to hash password: %s", err)
+	}
+	created, err := userRepo.Create(user)
+	if err != nil {
+		t.Fatalf("Unable to create user: %s", err)
+	}
+	return created
+}
+
+// Replaces the global repositories by a temporary database holding the member "alice"
+func newTestUserRepository(t *testing.T, password string) *booking.User {
+	useTestDatabase(t)
+	return createTestUser(t, "alice", password, booking.RoleMember)
+}
+
+func TestLoginRequest_IssuesTokenWithUserId(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	tokens, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Expected login to succeed, received %s", err)
+	}
+	claims, err := VerifyJWT(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Expected valid token, received %s", err)
+	}
+	if claims.UserId != user.Id || claims.Subject != "alice" {
+		t.Fatalf("Expected claims of user %d, received %d '%s'", user.Id, claims.UserId, claims.Subject)
+	}
+}
+
+func TestLoginRequest_RejectsInvalidCredentials(t *testing.T) {
+	newTestUserRepository(t, "correct horse")
+
+	if _, err := LoginRequest("alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected invalid credentials for wrong password, received %v", err)
+	}
+	if _, err := LoginRequest("bob", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Expected invalid credentials for unknown user, received %v", err)
+	}
+}
+
+func TestLoginRequest_LocksAccountAfterRepeatedFailures(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	for i := 0; i < maxFailedLogins; i++ {
+		LoginRequest("alice", "wrong")
+	}
+	if _, err := LoginRequest("alice", "correct horse"); err == nil {
+		t.Fatalf("Expected locked account to reject correct password")
+	}
+	locked, err := userRepo.GetById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked.Locked(time.Now()) || locked.Locked(time.Now().Add(lockoutDuration+time.Minute)) {
+		t.Fatalf("Expected account to be locked for %s, received lock until %s", lockoutDuration, locked.LockedUntil)
+	}
+}
+
+func TestLoginRequest_LocksAccountAfterConcurrentFailures(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	var wg sync.WaitGroup
+	for range 2 * maxFailedLogins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			LoginRequest("alice", "wrong")
+		}()
+	}
+	wg.Wait()
+	locked, err := userRepo.GetById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked.Locked(time.Now()) {
+		t.Fatalf("Expected account to be locked after %d concurrent failures, received %d failed logins", 2*maxFailedLogins, locked.FailedLogins)
+	}
+}
+
+func TestLoginRequest_ResetsFailedLoginsOnSuccess(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	LoginRequest("alice", "wrong")
+	if _, err := LoginRequest("alice", "correct horse"); err != nil {
+		t.Fatalf("Expected login to succeed, received %s", err)
+	}
+	res, err := userRepo.GetById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FailedLogins != 0 {
+		t.Fatalf("Expected failed logins to be reset, received %d", res.FailedLogins)
+	}
+}
+
+func TestChangePasswordRequest(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	if err := ChangePasswordRequest(user.Id, 0, "wrong", "battery staple"); err == nil {
+		t.Fatalf("Expected wrong current password to be rejected")
+	}
+	if err := ChangePasswordRequest(user.Id, 0, "correct horse", "short"); err == nil {
+		t.Fatalf("Expected short password to be rejected")
+	}
+	if err := ChangePasswordRequest(user.Id, 0, "correct horse", "battery staple"); err != nil {
+		t.Fatalf("Expected password change to succeed, received %s", err)
+	}
+	if _, err := LoginRequest("alice", "battery staple"); err != nil {
+		t.Fatalf("Expected login with new password, received %s", err)
+	}
+}
+
+func TestChangePasswordRequest_RevokesOtherSessions(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+	current, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyJWT(current.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ChangePasswordRequest(user.Id, claims.SessionId, "correct horse", "battery staple"); err != nil {
+		t.Fatalf("Expected password change to succeed, received %s", err)
+	}
+	if _, err := RefreshRequest(stolen.RefreshToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected refresh token of other session to be rejected, received %v", err)
+	}
+	if _, _, err := authenticate(stolen.AccessToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected access token of other session to be rejected, received %v", err)
+	}
+	if _, err := RefreshRequest(current.RefreshToken); err != nil {
+		t.Fatalf("Expected session changing the password to stay valid, received %s", err)
+	}
+}
+
+func TestChangePasswordRequest_WrongCurrentPasswordsLockAccount(t *testing.T) {
+	user := newTestUserRepository(t, "correct horse")
+
+	for range maxFailedLogins {
+		if err := ChangePasswordRequest(user.Id, 0, "wrong", "battery staple"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected wrong current password to be rejected, received %v", err)
+		}
+	}
+	locked, err := userRepo.GetById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked.Locked(time.Now()) {
+		t.Fatalf("Expected account to be locked, received lock until %s", locked.LockedUntil)
+	}
+	if err := ChangePasswordRequest(user.Id, 0, "correct horse", "battery staple"); err == nil {
+		t.Fatalf("Expected locked account to reject correct current password")
+	}
+	if _, err := LoginRequest("alice", "correct horse"); err == nil {
+		t.Fatalf("Expected locked account to reject login")
+	}
+}
+
+func TestRefreshRequest_RotatesRefreshToken(t *testing.T) {
+	newTestUserRepository(t, "correct horse")
+	tokens, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := RefreshRequest(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Expected refresh to succeed, received %s", err)
+	}
+	if _, _, err := authenticate(refreshed.AccessToken); err != nil {
+		t.Fatalf("Expected refreshed access token to be valid, received %s", err)
+	}
+	if _, err := RefreshRequest(tokens.RefreshToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected used refresh token to be rejected, received %v", err)
+	}
+}
+
+// Answers lookups with the session as it was before, like a request racing a concurrent refresh
+type staleSessionRepository struct {
+	booking.SessionRepository
+	stale *booking.Session
+}
+
+func (r staleSessionRepository) GetByTokenHash(tokenHash string) (*booking.Session, error) {
+	return r.stale, nil
+}
+
+func TestRefreshRequest_ReplayedTokenRevokesSession(t *testing.T) {
+	newTestUserRepository(t, "correct horse")
+	tokens, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := sessionRepo.GetByTokenHash(hashToken(tokens.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := RefreshRequest(tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("Expected refresh to succeed, received %s", err)
+	}
+
+	sessionRepo = staleSessionRepository{sessionRepo, stale}
+	if _, err := RefreshRequest(tokens.RefreshToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected replayed refresh token to be rejected, received %v", err)
+	}
+	if _, _, err := authenticate(refreshed.AccessToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected replay to revoke the session, received %v", err)
+	}
+}
+
+func TestLogoutRequest_RevokesSession(t *testing.T) {
+	newTestUserRepository(t, "correct horse")
+	tokens, err := LoginRequest("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyJWT(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LogoutRequest(claims.SessionId); err != nil {
+		t.Fatalf("Expected logout to succeed, received %s", err)
+	}
+	if _, _, err := authenticate(tokens.AccessToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected access token of revoked session to be rejected, received %v", err)
+	}
+	if _, err := RefreshRequest(tokens.RefreshToken); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected refresh token of revoked session to be rejected, received %v", err)
+	}
+}
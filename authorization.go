@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"lucb31/booking-go/booking"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrForbidden = errors.New("You are not allowed to perform this action")
+
+// Returns the user the request was authenticated for
+func currentUser(c *gin.Context) *booking.User {
+	user, _ := c.MustGet("user").(*booking.User)
+	return user
+}
+
+// Fails with ErrForbidden, if the current user may not change the given booking
+func authorizeBookingChange(c *gin.Context, b *booking.Booking) error {
+	if !currentUser(c).CanChangeBooking(b) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Status code to render a failed htmx request with
+func errorStatus(err error) int {
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// Middleware rendering the error of the given fragment, if the role of the current user does
+// not satisfy allowed
+func requireRole(allowed func(booking.Role) bool, fragment string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowed(currentUser(c).Role) {
+			c.HTML(http.StatusForbidden, fragment, BookingPageData{Error: ErrForbidden.Error()})
+			c.Abort()
+		}
+	}
+}
+
+// Middleware answering with 403, if the role of the current user does not satisfy allowed
+func requireApiRole(allowed func(booking.Role) bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !allowed(currentUser(c).Role) {
+			abortWithApiError(c, ErrForbidden)
+		}
+	}
+}
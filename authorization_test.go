@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+func newTestBooking(t *testing.T, user *booking.User) *booking.Booking {
+	room, err := roomRepo.Create(booking.Room{Title: "Test room"})
+	if err != nil {
+		t.Fatalf("Unable to create room: %s", err)
+	}
+	start := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	created, err := bookingRepo.Create(booking.Booking{Room: *room, User: *user, StartTime: start, EndTime: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	return created
+}
+
+func apiRequest(t *testing.T, user *booking.User, method string, path string, body string) *httptest.ResponseRecorder {
+	return apiRequestWithContentType(t, user, method, path, "application/json", body)
+}
+
+func apiRequestWithContentType(t *testing.T, user *booking.User, method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+	r := newTestApiRouter(t)
+	tokens, err := StartSession(user)
+	if err != nil {
+		t.Fatalf("Unable to start session: %s", err)
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestApiAuthorization_MemberCannotDeleteForeignBooking(t *testing.T) {
+	useTestDatabase(t)
+	owner := createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	other := createTestUser(t, "bob", "correct horse", booking.RoleMember)
+	manager := createTestUser(t, "carol", "correct horse", booking.RoleRoomManager)
+	b := newTestBooking(t, owner)
+	path := fmt.Sprintf("/api/v1/bookings/%d", b.Id)
+
+	if w := apiRequest(t, other, http.MethodDelete, path, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for foreign booking, received %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+	if w := apiRequest(t, manager, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected room manager to delete booking, received %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestApiAuthorization_OnlyAdminsManageRooms(t *testing.T) {
+	useTestDatabase(t)
+	member := createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	admin := createTestUser(t, "root", "correct horse", booking.RoleAdmin)
+
+	if w := apiRequest(t, member, http.MethodPost, "/api/v1/rooms", `{"title": "Attic"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for member, received %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+	if w := apiRequest(t, admin, http.MethodPost, "/api/v1/rooms", `{"title": "Attic"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected admin to create room, received %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestApiAuthorization_ReadOnlyCannotBook(t *testing.T) {
+	useTestDatabase(t)
+	viewer := createTestUser(t, "alice", "correct horse", booking.RoleReadOnly)
+	room, err := roomRepo.Create(booking.Room{Title: "Test room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"roomId": %d, "userId": %d, "startTime": "2024-07-01T09:00:00Z", "endTime": "2024-07-01T10:00:00Z"}`, room.Id, viewer.Id)
+
+	if w := apiRequest(t, viewer, http.MethodPost, "/api/v1/bookings", body); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for read-only user, received %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+}
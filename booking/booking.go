@@ -2,9 +2,28 @@ package booking
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
+type Booking struct {
+	Id          int64
+	Title       string
+	Description string
+	Room        Room
+	User        User
+	StartTime   time.Time
+	EndTime     time.Time
+	// Expected number of attendees. 0 if unknown
+	Attendees int
+	// Set if the booking is the master of a recurring series
+	Recurrence *Recurrence
+	// Id of the series master, if this booking is an occurrence or an exception of a series
+	SeriesId int64
+	// Start of the series occurrence this booking stands for
+	OriginalStart time.Time
+}
+
 func (b *Booking) Duration() time.Duration {
 	return b.EndTime.Sub(b.StartTime)
 }
@@ -15,6 +34,146 @@ func (b *Booking) Within(t *time.Time) bool {
 	return !b.StartTime.After(*t) && !b.EndTime.Before(*t)
 }
 
+// Return true, if the booking and the interval [start, end) share any point in time
+func (b *Booking) Overlaps(start *time.Time, end *time.Time) bool {
+	return b.StartTime.Before(*end) && b.EndTime.After(*start)
+}
+
+// Return true, if the booking is an expanded occurrence of a recurring series
+func (b *Booking) IsOccurrence() bool {
+	return b.Recurrence != nil && b.SeriesId == b.Id
+}
+
+// Returns the end of the last occurrence, zero for series without end. Excluded dates are
+// ignored, so cancelling occurrences does not move the end
+func (b *Booking) LastEnd() time.Time {
+	if b.Recurrence == nil {
+		return b.EndTime
+	}
+	if !b.Recurrence.Bounded() {
+		return time.Time{}
+	}
+	series := *b
+	rule := *b.Recurrence
+	rule.ExDates = nil
+	series.Recurrence = &rule
+	intervals := bookingIntervals(&series)
+	if len(intervals) == 0 {
+		return b.EndTime
+	}
+	return intervals[len(intervals)-1].EndTime
+}
+
+// Returns a copy of the booking with its times in the given time zone
+func (b Booking) In(loc *time.Location) Booking {
+	b.StartTime = b.StartTime.In(loc)
+	b.EndTime = b.EndTime.In(loc)
+	if !b.OriginalStart.IsZero() {
+		b.OriginalStart = b.OriginalStart.In(loc)
+	}
+	return b
+}
+
+// Expands the booking into all occurrences overlapping the interval [start, end).
+// Series are expanded in the time zone of their room, so occurrences keep their local time
+// across daylight saving time changes. Non-recurring bookings are returned as is, if they
+// overlap the interval
+func (b *Booking) OccurrencesWithin(start *time.Time, end *time.Time) []Booking {
+	if b.Recurrence == nil {
+		if b.Overlaps(start, end) {
+			return []Booking{*b}
+		}
+		return []Booking{}
+	}
+	duration := b.Duration()
+	occurrences := []Booking{}
+	// Occurrences starting before the interval may still reach into it
+	for _, occurrenceStart := range b.Recurrence.Occurrences(b.StartTime.In(b.Room.Location()), *end) {
+		occurrence := b.occurrenceAt(occurrenceStart, duration)
+		if occurrence.Overlaps(start, end) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences
+}
+
+// Returns the occurrence of a recurring booking starting at the given time
+func (b *Booking) Occurrence(start time.Time) (*Booking, error) {
+	if b.Recurrence == nil {
+		return nil, fmt.Errorf("Booking %d is not recurring", b.Id)
+	}
+	end := start.Add(time.Second)
+	for _, occurrenceStart := range b.Recurrence.Occurrences(b.StartTime.In(b.Room.Location()), end) {
+		if occurrenceStart.Equal(start) {
+			occurrence := b.occurrenceAt(occurrenceStart, b.Duration())
+			return &occurrence, nil
+		}
+	}
+	return nil, fmt.Errorf("Booking %d has no occurrence at %s", b.Id, start)
+}
+
+// Splits the booking into the part starting before t & the part starting at or after it. Either
+// part is nil, if the booking has nothing on its side. Splitting a series ends it with its last
+// occurrence before t & continues it in a new series without ID, unless it lies on one side only
+func (b *Booking) SplitAt(t time.Time) (*Booking, *Booking) {
+	if b.Recurrence == nil {
+		if b.StartTime.Before(t) {
+			return b, nil
+		}
+		return nil, b
+	}
+	// Excluded dates count towards COUNT, so they are generated as well
+	rule := *b.Recurrence
+	rule.ExDates = nil
+	var last, next time.Time
+	generated := 0
+	for _, start := range rule.Occurrences(b.StartTime.In(b.Room.Location()), t.Add(recurrenceHorizon)) {
+		if start.Before(t) {
+			last = start
+		} else if !b.Recurrence.excluded(start) {
+			next = start
+			break
+		}
+		generated++
+	}
+	if last.IsZero() {
+		return nil, b
+	}
+	past := *b
+	pastRule := *b.Recurrence
+	pastRule.Count, pastRule.Until, pastRule.ExDates = 0, last, []time.Time{}
+	future := *b
+	futureRule := *b.Recurrence
+	futureRule.ExDates = []time.Time{}
+	for _, exDate := range b.Recurrence.ExDates {
+		if exDate.Before(t) {
+			pastRule.ExDates = append(pastRule.ExDates, exDate)
+		} else if !exDate.Before(next) {
+			futureRule.ExDates = append(futureRule.ExDates, exDate)
+		}
+	}
+	past.Recurrence = &pastRule
+	if next.IsZero() {
+		return &past, nil
+	}
+	if futureRule.Count > 0 {
+		futureRule.Count -= generated
+	}
+	future.Id = 0
+	future.StartTime, future.EndTime = next, next.Add(b.Duration())
+	future.Recurrence = &futureRule
+	return &past, &future
+}
+
+func (b *Booking) occurrenceAt(start time.Time, duration time.Duration) Booking {
+	occurrence := *b
+	occurrence.StartTime = start
+	occurrence.EndTime = start.Add(duration)
+	occurrence.SeriesId = b.Id
+	occurrence.OriginalStart = start
+	return occurrence
+}
+
 func (b *Booking) String() string {
 	jsonBytes, err := json.Marshal(b)
 	if err != nil {
@@ -1,16 +1,887 @@
 package booking
 
 import (
+	"cmp"
+	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
 )
 
-// Converts date in format "yyyy-mm-dd" & time in format "hh:mm" into unix timestamp
-func TimeFromDateAndTime(dateString string, timeString string) (time.Time, error) {
+// Converts date in format "yyyy-mm-dd" & time in format "hh:mm" of the given time zone into a point in time
+func TimeFromDateAndTime(dateString string, timeString string, loc *time.Location) (time.Time, error) {
 	s := fmt.Sprintf("%s %s", dateString, timeString)
-	res, err := time.Parse("2006-01-02 15:04", s)
+	res, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return res, nil
 }
+
+type BookingScan struct {
+	Id            int64
+	Title         sql.NullString
+	Description   sql.NullString
+	StartTime     time.Time `db:"start_time"`
+	EndTime       time.Time `db:"end_time"`
+	Attendees     int64
+	Recurrence    sql.NullString `db:"recurrence"`
+	SeriesId      sql.NullInt64  `db:"series_id"`
+	OriginalStart sql.NullTime   `db:"original_start"`
+	RoomId        int64          `db:"room_id"`
+	RoomTitle     sql.NullString `db:"room_title"`
+	RoomTimeZone  sql.NullString `db:"room_time_zone"`
+	UserId        int64          `db:"user_id"`
+	UserName      sql.NullString `db:"user_name"`
+}
+
+func BookingFromScan(s *BookingScan) (Booking, error) {
+	b := Booking{
+		Id:            s.Id,
+		Title:         s.Title.String,
+		Description:   s.Description.String,
+		Room:          Room{Id: s.RoomId, Title: s.RoomTitle.String, TimeZone: s.RoomTimeZone.String},
+		User:          User{Id: s.UserId, Name: s.UserName.String},
+		StartTime:     s.StartTime,
+		EndTime:       s.EndTime,
+		Attendees:     int(s.Attendees),
+		SeriesId:      s.SeriesId.Int64,
+		OriginalStart: s.OriginalStart.Time,
+	}
+	if s.Recurrence.Valid {
+		recurrence, err := ParseRecurrence(s.Recurrence.String)
+		if err != nil {
+			return b, err
+		}
+		b.Recurrence = recurrence
+	}
+	return b, nil
+}
+
+type BookingRepository interface {
+	Create(booking Booking) (*Booking, error)
+	GetAll() ([]*Booking, error)
+	GetById(id int64) (*Booking, error)
+	// Persists changes of time range, room, user, title & description. Fails on conflicts
+	Update(booking Booking) (*Booking, error)
+	// Deletes the booking, the exceptions of a series & their calendar objects
+	Delete(id int64) error
+	// Returns all bookings overlapping the interval. Recurring series are expanded into their occurrences
+	FindWithinTimeInterval(start *time.Time, end *time.Time) ([]*Booking, error)
+	// Same as FindWithinTimeInterval, restricted to the rooms & users of the filter
+	FindWithinTimeIntervalByFilter(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error)
+	// Returns all bookings matching the filter without expanding recurring series. Series come with their excluded dates
+	FindByFilter(filter BookingFilter) ([]*Booking, error)
+	// Returns a page of the bookings matching the query. Fails with ErrInvalidCursor for cursors of other orders
+	Query(query BookingQuery) (*BookingPage, error)
+	// Removes a single occurrence from a recurring series
+	CancelOccurrence(seriesId int64, originalStart time.Time) error
+	// Replaces a single occurrence of a recurring series with a standalone exception booking
+	UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error)
+	// Starts a batch of writes, that has to be committed or rolled back
+	Begin() (BookingBatch, error)
+}
+
+// Writes running in a single transaction. Conflict checks see the earlier writes of the batch,
+// so bookings can be checked against each other before anything is persisted
+type BookingBatch interface {
+	Create(booking Booking) (*Booking, error)
+	UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error)
+	// Overwrites the booking with the given one, including the excluded dates of a series.
+	// Exceptions of the series are removed, so they can be recreated with UpdateOccurrence
+	Replace(booking Booking) (*Booking, error)
+	// Stores the calendar object of a booking of the batch, replacing objects of the same booking, name or UID
+	SaveCalendarObject(object CalendarObject) error
+	Commit() error
+	// Discards the writes of the batch. Does nothing after Commit
+	Rollback() error
+}
+
+// Restricts bookings to the given rooms & users. Empty lists do not restrict
+type BookingFilter struct {
+	RoomIds []int64
+	UserIds []int64
+	// Only the exceptions of the given series
+	SeriesIds []int64
+	// Attributes the rooms of the bookings have to have
+	Rooms RoomFilter
+}
+
+// Returns the SQL condition matching the filter, starting with " AND", & its arguments
+func (f BookingFilter) sqlCondition() (string, []interface{}) {
+	condition := ""
+	args := []interface{}{}
+	columns := []struct {
+		name string
+		ids  []int64
+	}{{"b.room_id", f.RoomIds}, {"b.user_id", f.UserIds}, {"b.series_id", f.SeriesIds}}
+	for _, column := range columns {
+		if len(column.ids) == 0 {
+			continue
+		}
+		condition += " AND " + column.name + " IN (?" + strings.Repeat(", ?", len(column.ids)-1) + ")"
+		for _, id := range column.ids {
+			args = append(args, id)
+		}
+	}
+	roomCondition, roomArgs := f.Rooms.sqlCondition()
+	return condition + roomCondition, append(args, roomArgs...)
+}
+
+// Returned if a requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// Returned if a booking overlaps existing bookings of the same room
+type ErrBookingConflict struct {
+	Conflicts []*Booking
+}
+
+func (e *ErrBookingConflict) Error() string {
+	ids := []string{}
+	for _, id := range e.Ids() {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return fmt.Sprintf("Booking conflicts with existing bookings: %s", strings.Join(ids, ", "))
+}
+
+// Ids of the conflicting bookings. Occurrences of the same series are only listed once
+func (e *ErrBookingConflict) Ids() []int64 {
+	return distinctIds(e.Conflicts)
+}
+
+func distinctIds(bookings []*Booking) []int64 {
+	ids := []int64{}
+	for _, b := range bookings {
+		if !slices.Contains(ids, b.Id) {
+			ids = append(ids, b.Id)
+		}
+	}
+	return ids
+}
+
+// Queries shared by the SQLite & Postgres repositories. Conflict checks run in the same
+// transaction as the write, after locking the room on Postgres
+type bookingRepositorySQL struct {
+	db       *sqlx.DB
+	userRepo UserRepository
+	roomRepo RoomsRepository
+}
+
+type BookingRepositorySQLite struct {
+	*bookingRepositorySQL
+}
+
+// To serialize conflict checks across connections, the database has to be opened with "_txlock=immediate"
+func NewBookingRepositorySQLite(db *sqlx.DB, userRepo UserRepository, roomRepo RoomsRepository) *BookingRepositorySQLite {
+	return &BookingRepositorySQLite{&bookingRepositorySQL{db, userRepo, roomRepo}}
+}
+
+type BookingRepositoryPostgres struct {
+	*bookingRepositorySQL
+}
+
+func NewBookingRepositoryPostgres(db *sqlx.DB, userRepo UserRepository, roomRepo RoomsRepository) *BookingRepositoryPostgres {
+	return &BookingRepositoryPostgres{&bookingRepositorySQL{db, userRepo, roomRepo}}
+}
+
+const bookingSelect = `
+	SELECT
+		b.id,
+		b.title,
+		b.description,
+		b.start_time,
+		b.end_time,
+		b.attendees,
+		b.recurrence,
+		b.series_id,
+		b.original_start,
+		b.room_id AS room_id,
+		r.title AS room_title,
+		r.time_zone AS room_time_zone,
+		b.user_id AS user_id,
+		u.name AS user_name
+	FROM
+		booking b
+		LEFT JOIN room r ON r.id = b.room_id
+		LEFT JOIN "user" u ON u.id = b.user_id
+`
+
+func (r *bookingRepositorySQL) Create(booking Booking) (*Booking, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	created, err := r.create(tx, booking)
+	if err != nil {
+		return nil, err
+	}
+	return created, tx.Commit()
+}
+
+func (r *bookingRepositorySQL) create(tx *sqlx.Tx, booking Booking) (*Booking, error) {
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := loadRoom(tx, &booking); err != nil {
+		return nil, err
+	}
+	if err := r.checkConflicts(tx, &booking, 0, time.Time{}); err != nil {
+		return nil, err
+	}
+	if err := insertBooking(tx, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+func (r *bookingRepositorySQL) GetAll() ([]*Booking, error) {
+	query := bookingSelect + ` ORDER BY b.start_time, b.id; `
+	return r.queryBookings(r.db, query)
+}
+
+func (r *bookingRepositorySQL) GetById(id int64) (*Booking, error) {
+	return r.getById(r.db, id)
+}
+
+func (r *bookingRepositorySQL) getById(q sqlx.Ext, id int64) (*Booking, error) {
+	bookings, err := r.queryBookings(q, bookingSelect+` WHERE b.id = ?; `, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(bookings) == 0 {
+		return nil, fmt.Errorf("Booking %d %w", id, ErrNotFound)
+	}
+	if bookings[0].Recurrence != nil {
+		if err := r.loadExDates(q, bookings[0]); err != nil {
+			return nil, err
+		}
+	}
+	return bookings[0], nil
+}
+
+func (r *bookingRepositorySQL) Update(booking Booking) (*Booking, error) {
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := loadRoom(tx, &booking); err != nil {
+		return nil, err
+	}
+	stored, err := r.getById(tx, booking.Id)
+	if err != nil {
+		return nil, err
+	}
+	if reschedulesSeries(stored, &booking) {
+		if err := deleteExceptions(tx, booking.Id); err != nil {
+			return nil, err
+		}
+	}
+	// Excluded dates of a series have to be known to check its occurrences for conflicts
+	if booking.Recurrence != nil {
+		if err := r.loadExDates(tx, &booking); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.checkConflicts(tx, &booking, booking.Id, time.Time{}); err != nil {
+		return nil, err
+	}
+	query := `
+	UPDATE booking
+	SET room_id = ?, user_id = ?, title = ?, description = ?, start_time = ?, end_time = ?, attendees = ?, recurrence = ?, series_id = ?, original_start = ?, last_end_time = ?
+	WHERE id = ?; `
+	res, err := tx.Exec(tx.Rebind(query), append(bookingArgs(&booking), booking.Id)...)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, fmt.Errorf("Booking %d %w", booking.Id, ErrNotFound)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	// Reload to resolve room & user of the changed booking
+	return r.GetById(booking.Id)
+}
+
+func (r *bookingRepositorySQL) Delete(id int64) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteCalendarObjects(tx, id); err != nil {
+		return err
+	}
+	// Deleting a series also removes its exceptions
+	queries := []string{
+		` DELETE FROM booking_exdate WHERE booking_id = ?; `,
+		` DELETE FROM booking WHERE series_id = ?; `,
+		` DELETE FROM booking WHERE id = ?; `,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.Rebind(query), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *bookingRepositorySQL) FindWithinTimeInterval(start *time.Time, end *time.Time) ([]*Booking, error) {
+	return r.findWithinTimeInterval(r.db, start, end, BookingFilter{})
+}
+
+func (r *bookingRepositorySQL) FindWithinTimeIntervalByFilter(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error) {
+	return r.findWithinTimeInterval(r.db, start, end, filter)
+}
+
+func (r *bookingRepositorySQL) FindByFilter(filter BookingFilter) ([]*Booking, error) {
+	filterCondition, filterArgs := filter.sqlCondition()
+	bookings, err := r.queryBookings(r.db, bookingSelect+` WHERE 1 = 1`+filterCondition+` ORDER BY b.start_time, b.id;`, filterArgs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadExDates(r.db, bookings...); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+func (r *bookingRepositorySQL) Query(params BookingQuery) (*BookingPage, error) {
+	q, err := params.prepare(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	condition, args := q.BookingFilter.sqlCondition()
+	// Series without end have no last end time
+	if !q.From.IsZero() {
+		condition += " AND (b.last_end_time IS NULL OR b.last_end_time > ?)"
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		condition += " AND b.start_time < ?"
+		args = append(args, q.To.UTC())
+	}
+	if !q.endedBy.IsZero() {
+		condition += " AND b.last_end_time <= ?"
+		args = append(args, q.endedBy.UTC())
+	}
+	if len(q.Search) > 0 {
+		condition += ` AND LOWER(COALESCE(b.title, '')) LIKE LOWER(?) ESCAPE '\'`
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+	}
+	// The cursor holds the raw title, which is lowered like the titles of the rows
+	key, cursorKey := "b.start_time", "?"
+	if q.Sort.byTitle() {
+		key, cursorKey = "LOWER(COALESCE(b.title, ''))", "LOWER(?)"
+		if r.db.DriverName() == "postgres" {
+			// Byte order, like SQLite & the in-memory repository
+			key, cursorKey = key+` COLLATE "C"`, cursorKey+` COLLATE "C"`
+		}
+	}
+	order, after := "ASC", ">"
+	if q.Sort.Descending() {
+		order, after = "DESC", "<"
+	}
+	if q.after != nil {
+		condition += fmt.Sprintf(" AND (%s %s %s OR (%s = %s AND b.id %s ?))", key, after, cursorKey, key, cursorKey, after)
+		var value interface{} = q.after.StartTime.UTC()
+		if q.Sort.byTitle() {
+			value = q.after.Title
+		}
+		args = append(args, value, value, q.after.Id)
+	}
+	query := bookingSelect + ` WHERE 1 = 1` + condition + fmt.Sprintf(` ORDER BY %s %s, b.id %s LIMIT ?;`, key, order, order)
+	bookings, err := r.queryBookings(r.db, query, append(args, q.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	page := q.page(bookings)
+	if err := r.loadExDates(r.db, page.Bookings...); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (r *bookingRepositorySQL) CancelOccurrence(seriesId int64, originalStart time.Time) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lockBooking(tx, seriesId); err != nil {
+		return err
+	}
+	series, err := r.getById(tx, seriesId)
+	if err != nil {
+		return err
+	}
+	if _, err := series.Occurrence(originalStart); err != nil {
+		return err
+	}
+	if err := insertExDate(tx, seriesId, originalStart); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *bookingRepositorySQL) UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	updated, err := r.updateOccurrence(tx, seriesId, originalStart, booking)
+	if err != nil {
+		return nil, err
+	}
+	return updated, tx.Commit()
+}
+
+func (r *bookingRepositorySQL) updateOccurrence(tx *sqlx.Tx, seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	if err := lockBooking(tx, seriesId); err != nil {
+		return nil, err
+	}
+	series, err := r.getById(tx, seriesId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := series.Occurrence(originalStart); err != nil {
+		return nil, err
+	}
+	// Exceptions are single bookings tied to the occurrence they replace
+	booking.Recurrence = nil
+	booking.SeriesId = seriesId
+	booking.OriginalStart = originalStart
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := loadRoom(tx, &booking); err != nil {
+		return nil, err
+	}
+	if err := r.checkConflicts(tx, &booking, seriesId, originalStart); err != nil {
+		return nil, err
+	}
+	if err := insertExDate(tx, seriesId, originalStart); err != nil {
+		return nil, err
+	}
+	if err := insertBooking(tx, &booking); err != nil {
+		return nil, err
+	}
+	return r.getById(tx, booking.Id)
+}
+
+func (r *bookingRepositorySQL) Begin() (BookingBatch, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	return &bookingBatchSQL{r, tx}, nil
+}
+
+type bookingBatchSQL struct {
+	repo *bookingRepositorySQL
+	tx   *sqlx.Tx
+}
+
+func (b *bookingBatchSQL) Create(booking Booking) (*Booking, error) {
+	return b.repo.create(b.tx, booking)
+}
+
+func (b *bookingBatchSQL) UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	return b.repo.updateOccurrence(b.tx, seriesId, originalStart, booking)
+}
+
+func (b *bookingBatchSQL) Replace(booking Booking) (*Booking, error) {
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := loadRoom(b.tx, &booking); err != nil {
+		return nil, err
+	}
+	// Exceptions & excluded dates are dropped first, so they are neither checked for conflicts
+	// nor left behind for occurrences the series no longer has
+	if err := deleteExceptions(b.tx, booking.Id); err != nil {
+		return nil, err
+	}
+	if err := b.repo.checkConflicts(b.tx, &booking, booking.Id, time.Time{}); err != nil {
+		return nil, err
+	}
+	query := `
+	UPDATE booking
+	SET room_id = ?, user_id = ?, title = ?, description = ?, start_time = ?, end_time = ?, attendees = ?, recurrence = ?, series_id = ?, original_start = ?, last_end_time = ?
+	WHERE id = ?; `
+	res, err := b.tx.Exec(b.tx.Rebind(query), append(bookingArgs(&booking), booking.Id)...)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, fmt.Errorf("Booking %d %w", booking.Id, ErrNotFound)
+	}
+	if err := insertExDates(b.tx, &booking); err != nil {
+		return nil, err
+	}
+	return b.repo.getById(b.tx, booking.Id)
+}
+
+// Deletes the excluded dates & exceptions of the series
+func deleteExceptions(tx *sqlx.Tx, seriesId int64) error {
+	queries := []string{
+		` DELETE FROM booking_exdate WHERE booking_id = ?; `,
+		` DELETE FROM calendar_object WHERE booking_id IN (SELECT id FROM booking WHERE series_id = ?); `,
+		` DELETE FROM booking WHERE series_id = ?; `,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.Rebind(query), seriesId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bookingBatchSQL) SaveCalendarObject(object CalendarObject) error {
+	return saveCalendarObject(b.tx, object)
+}
+
+func (b *bookingBatchSQL) Commit() error {
+	return b.tx.Commit()
+}
+
+func (b *bookingBatchSQL) Rollback() error {
+	if err := b.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return err
+	}
+	return nil
+}
+
+// Returns bookings overlapping the interval & matching the filter.
+// Recurring series are expanded into their occurrences
+func (r *bookingRepositorySQL) findWithinTimeInterval(q sqlx.Ext, start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error) {
+	filterCondition, filterArgs := filter.sqlCondition()
+	// Single bookings & exceptions
+	query := bookingSelect + ` WHERE b.recurrence IS NULL AND b.start_time < ? AND b.end_time > ?` + filterCondition + `;`
+	bookings, err := r.queryBookings(q, query, append([]interface{}{end.UTC(), start.UTC()}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	// Series starting before the interval ends, that have not ended before it starts
+	query = bookingSelect + ` WHERE b.recurrence IS NOT NULL AND b.start_time < ? AND (b.last_end_time IS NULL OR b.last_end_time > ?)` + filterCondition + `;`
+	series, err := r.queryBookings(q, query, append([]interface{}{end.UTC(), start.UTC()}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadExDates(q, series...); err != nil {
+		return nil, err
+	}
+	for _, s := range series {
+		for _, occurrence := range s.OccurrencesWithin(start, end) {
+			bookings = append(bookings, &occurrence)
+		}
+	}
+	sortByStartTime(bookings)
+	return bookings, nil
+}
+
+// Orders bookings by start time. Bookings starting at the same time are ordered by ID
+func sortByStartTime(bookings []*Booking) {
+	slices.SortFunc(bookings, func(a, b *Booking) int {
+		if c := a.StartTime.Compare(b.StartTime); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+}
+
+// Returns an ErrBookingConflict, if existing bookings in the same room overlap any occurrence of the given booking.
+// The occurrence of excludeId starting at excludeStart (or all of its occurrences, if zero) is ignored
+func (r *bookingRepositorySQL) checkConflicts(q sqlx.Ext, booking *Booking, excludeId int64, excludeStart time.Time) error {
+	return checkConflicts(booking, excludeId, excludeStart, func(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error) {
+		return r.findWithinTimeInterval(q, start, end, filter)
+	})
+}
+
+// Conflict check shared by all repositories. find returns the bookings overlapping an interval
+// like FindWithinTimeIntervalByFilter
+func checkConflicts(booking *Booking, excludeId int64, excludeStart time.Time, find func(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error)) error {
+	candidates := bookingIntervals(booking)
+	if len(candidates) == 0 {
+		return nil
+	}
+	existing, err := find(&candidates[0].StartTime, &candidates[len(candidates)-1].EndTime, BookingFilter{RoomIds: []int64{booking.Room.Id}})
+	if err != nil {
+		return err
+	}
+	conflicts := []*Booking{}
+	for _, e := range existing {
+		if e.Id == excludeId && (excludeStart.IsZero() || e.OriginalStart.Equal(excludeStart)) {
+			continue
+		}
+		for _, candidate := range candidates {
+			if e.Overlaps(&candidate.StartTime, &candidate.EndTime) {
+				conflicts = append(conflicts, e)
+				break
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return &ErrBookingConflict{conflicts}
+	}
+	return nil
+}
+
+// Series are expanded in the time zone of their room, so the zone has to be known before
+// checking for conflicts. Fails if the room has been deleted or the attendees do not fit into it
+func loadRoom(tx *sqlx.Tx, booking *Booking) error {
+	if err := lockRoom(tx, booking.Room.Id); err != nil {
+		return err
+	}
+	room, err := getRoom(tx, booking.Room.Id)
+	if err != nil {
+		return err
+	}
+	return assignRoom(booking, room)
+}
+
+// Copies time zone & capacity of the room into the booking, if the room can take it
+func assignRoom(booking *Booking, room *Room) error {
+	if !room.ArchivedAt.IsZero() {
+		return fmt.Errorf("Room %s has been deleted", room.Title)
+	}
+	booking.Room.TimeZone, booking.Room.Capacity = room.TimeZone, room.Capacity
+	if !booking.Room.Fits(booking.Attendees) {
+		return fmt.Errorf("Room %s only fits %d attendees, received %d", room.Title, booking.Room.Capacity, booking.Attendees)
+	}
+	return nil
+}
+
+// Loads the excluded dates of the series among the bookings with one query per
+// exDateBatchSize series. Bookings without recurrence are skipped
+func (r *bookingRepositorySQL) loadExDates(q sqlx.Ext, bookings ...*Booking) error {
+	byId := map[int64]*Booking{}
+	ids := []int64{}
+	for _, b := range bookings {
+		if b.Recurrence == nil {
+			continue
+		}
+		b.Recurrence.ExDates = []time.Time{}
+		byId[b.Id] = b
+		ids = append(ids, b.Id)
+	}
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), exDateBatchSize)]
+		ids = ids[len(batch):]
+		query, args, err := sqlx.In(` SELECT booking_id, original_start FROM booking_exdate WHERE booking_id IN (?) ORDER BY original_start; `, batch)
+		if err != nil {
+			return err
+		}
+		exDates := []struct {
+			BookingId     int64     `db:"booking_id"`
+			OriginalStart time.Time `db:"original_start"`
+		}{}
+		if err := sqlx.Select(q, &exDates, q.Rebind(query), args...); err != nil {
+			return err
+		}
+		for _, exDate := range exDates {
+			series := byId[exDate.BookingId]
+			series.Recurrence.ExDates = append(series.Recurrence.ExDates, exDate.OriginalStart)
+		}
+	}
+	return nil
+}
+
+// Stays below the limit of query parameters of SQLite & Postgres
+const exDateBatchSize = 1000
+
+func (r *bookingRepositorySQL) queryBookings(q sqlx.Ext, query string, args ...interface{}) ([]*Booking, error) {
+	rows, err := q.Queryx(q.Rebind(query), args...)
+	bookings := []*Booking{}
+	if err != nil {
+		return bookings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var scan BookingScan
+		if err := rows.StructScan(&scan); err != nil {
+			return bookings, err
+		}
+		booking, err := BookingFromScan(&scan)
+		if err != nil {
+			return bookings, err
+		}
+		bookings = append(bookings, &booking)
+	}
+	return bookings, rows.Err()
+}
+
+// Expands a booking into all intervals it occupies. Unbounded series are expanded up to recurrenceHorizon
+func bookingIntervals(booking *Booking) []Booking {
+	if booking.Recurrence == nil {
+		return []Booking{*booking}
+	}
+	end := booking.StartTime.Add(recurrenceHorizon)
+	if !booking.Recurrence.Until.IsZero() {
+		end = booking.Recurrence.Until.Add(time.Second)
+	}
+	if booking.Recurrence.Count > 0 {
+		// Count limits the expansion
+		end = endOfSeries
+	}
+	return booking.OccurrencesWithin(&booking.StartTime, &end)
+}
+
+// Registers the steps of the schema migrations, that need the booking logic
+func AddMigrationSteps(migrator *migrations.Migrator) {
+	migrator.AfterUp(3, backfillLastEndTimes)
+}
+
+// Stores the end of the last occurrence of series, that were created before the end was stored
+// with them. Series without end keep NULL
+func backfillLastEndTimes(tx *sqlx.Tx) error {
+	// The booking queries only depend on the transaction they run in
+	bookings := &bookingRepositorySQL{}
+	series, err := bookings.queryBookings(tx, bookingSelect+` WHERE b.recurrence IS NOT NULL AND b.last_end_time IS NULL; `)
+	if err != nil {
+		return err
+	}
+	for _, s := range series {
+		end := s.LastEnd()
+		if end.IsZero() {
+			continue
+		}
+		if _, err := tx.Exec(tx.Rebind(` UPDATE booking SET last_end_time = ? WHERE id = ?; `), end.UTC(), s.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true, if the update moves the occurrences of the stored series or ends its recurrence.
+// Its excluded dates & exceptions are bound to occurrences the series then no longer has
+func reschedulesSeries(stored *Booking, updated *Booking) bool {
+	if stored.Recurrence == nil {
+		return false
+	}
+	return updated.Recurrence == nil || !updated.StartTime.Equal(stored.StartTime) || updated.Recurrence.String() != stored.Recurrence.String()
+}
+
+func validateBooking(booking *Booking) error {
+	if !booking.EndTime.After(booking.StartTime) {
+		return fmt.Errorf("Booking has to end after it starts")
+	}
+	if booking.Attendees < 0 {
+		return fmt.Errorf("Attendees cannot be negative")
+	}
+	if booking.Recurrence != nil {
+		if err := booking.Recurrence.Validate(); err != nil {
+			return err
+		}
+		if !booking.Recurrence.Until.IsZero() && booking.Recurrence.Until.Before(booking.StartTime) {
+			return fmt.Errorf("Recurrence cannot end before the booking starts")
+		}
+		if err := booking.Recurrence.validateLength(booking.StartTime.In(booking.Room.Location())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertBooking(tx *sqlx.Tx, booking *Booking) error {
+	query := `
+	INSERT INTO booking ( room_id, user_id, title, description, start_time, end_time, attendees, recurrence, series_id, original_start, last_end_time )
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id; `
+	if err := tx.Get(&booking.Id, tx.Rebind(query), bookingArgs(booking)...); err != nil {
+		return err
+	}
+	return insertExDates(tx, booking)
+}
+
+// Excludes the occurrence from the series. Fails, if it has been excluded already, e.g. by a
+// concurrent request
+func insertExDate(tx *sqlx.Tx, seriesId int64, originalStart time.Time) error {
+	query := ` INSERT INTO booking_exdate ( booking_id, original_start ) VALUES (?, ?) ON CONFLICT DO NOTHING; `
+	res, err := tx.Exec(tx.Rebind(query), seriesId, originalStart.UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("Occurrence of booking %d at %s has already been cancelled or replaced", seriesId, originalStart)
+	}
+	return nil
+}
+
+// Keeps concurrent changes of the occurrences of the series out until the transaction ends. On
+// SQLite, the transaction holds the lock of the whole database already
+func lockBooking(tx *sqlx.Tx, id int64) error {
+	if tx.DriverName() != "postgres" {
+		return nil
+	}
+	_, err := tx.Exec(` SELECT id FROM booking WHERE id = $1 FOR UPDATE; `, id)
+	return err
+}
+
+// Series may come with cancelled occurrences, e.g. when imported
+func insertExDates(tx *sqlx.Tx, booking *Booking) error {
+	if booking.Recurrence == nil {
+		return nil
+	}
+	for _, exDate := range booking.Recurrence.ExDates {
+		if _, err := tx.Exec(tx.Rebind(` INSERT INTO booking_exdate ( booking_id, original_start ) VALUES (?, ?); `), booking.Id, exDate.UTC()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bookingArgs(booking *Booking) []interface{} {
+	var recurrence sql.NullString
+	if booking.Recurrence != nil {
+		recurrence = sql.NullString{String: booking.Recurrence.String(), Valid: true}
+	}
+	var seriesId sql.NullInt64
+	if booking.SeriesId > 0 {
+		seriesId = sql.NullInt64{Int64: booking.SeriesId, Valid: true}
+	}
+	var originalStart sql.NullTime
+	if !booking.OriginalStart.IsZero() {
+		originalStart = sql.NullTime{Time: booking.OriginalStart.UTC(), Valid: true}
+	}
+	var lastEnd sql.NullTime
+	if end := booking.LastEnd(); !end.IsZero() {
+		lastEnd = sql.NullTime{Time: end.UTC(), Valid: true}
+	}
+	return []interface{}{
+		booking.Room.Id,
+		booking.User.Id,
+		booking.Title,
+		booking.Description,
+		booking.StartTime.UTC(),
+		booking.EndTime.UTC(),
+		booking.Attendees,
+		recurrence,
+		seriesId,
+		originalStart,
+		lastEnd,
+	}
+}
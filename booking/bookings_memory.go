@@ -0,0 +1,448 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errBatchDone = errors.New("Batch has already been committed or rolled back")
+
+// Keeps bookings in a MemoryStore. Behaves like the SQL repositories: the same conflict rules,
+// the same order & the same errors
+type BookingRepositoryMemory struct {
+	store *MemoryStore
+}
+
+func NewBookingRepositoryMemory(store *MemoryStore) *BookingRepositoryMemory {
+	return &BookingRepositoryMemory{store}
+}
+
+func (r *BookingRepositoryMemory) Create(booking Booking) (*Booking, error) {
+	var created *Booking
+	err := r.store.update(func(d *memoryData) error {
+		var err error
+		created, err = d.createBooking(booking)
+		return err
+	})
+	return created, err
+}
+
+func (r *BookingRepositoryMemory) GetAll() ([]*Booking, error) {
+	d := r.store.read()
+	bookings := []*Booking{}
+	for _, id := range sortedIds(d.bookings) {
+		bookings = append(bookings, d.resolveBooking(d.bookings[id], false))
+	}
+	sortByStartTime(bookings)
+	return bookings, nil
+}
+
+func (r *BookingRepositoryMemory) GetById(id int64) (*Booking, error) {
+	return r.store.read().getBooking(id)
+}
+
+func (r *BookingRepositoryMemory) Update(booking Booking) (*Booking, error) {
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	var updated *Booking
+	err := r.store.update(func(d *memoryData) error {
+		if err := d.loadRoom(&booking); err != nil {
+			return err
+		}
+		if stored, ok := d.bookings[booking.Id]; ok && reschedulesSeries(&stored, &booking) {
+			d.deleteExceptions(booking.Id)
+		}
+		stored, ok := d.bookings[booking.Id]
+		// Excluded dates of a series have to be known to check its occurrences for conflicts
+		if booking.Recurrence != nil {
+			rule := *booking.Recurrence
+			rule.ExDates = []time.Time{}
+			if ok && stored.Recurrence != nil {
+				rule.ExDates = slices.Clone(stored.Recurrence.ExDates)
+			}
+			booking.Recurrence = &rule
+		}
+		if err := d.checkConflicts(&booking, booking.Id, time.Time{}); err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Booking %d %w", booking.Id, ErrNotFound)
+		}
+		if err := d.storeBooking(booking); err != nil {
+			return err
+		}
+		var err error
+		updated, err = d.getBooking(booking.Id)
+		return err
+	})
+	return updated, err
+}
+
+func (r *BookingRepositoryMemory) Delete(id int64) error {
+	return r.store.update(func(d *memoryData) error {
+		// Deleting a series also removes its exceptions
+		d.deleteCalendarObject(id)
+		d.deleteExceptions(id)
+		delete(d.bookings, id)
+		return nil
+	})
+}
+
+func (r *BookingRepositoryMemory) FindWithinTimeInterval(start *time.Time, end *time.Time) ([]*Booking, error) {
+	return r.store.read().findWithinTimeInterval(start, end, BookingFilter{}), nil
+}
+
+func (r *BookingRepositoryMemory) FindWithinTimeIntervalByFilter(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error) {
+	return r.store.read().findWithinTimeInterval(start, end, filter), nil
+}
+
+func (r *BookingRepositoryMemory) FindByFilter(filter BookingFilter) ([]*Booking, error) {
+	d := r.store.read()
+	bookings := []*Booking{}
+	for _, id := range sortedIds(d.bookings) {
+		if b := d.bookings[id]; d.matches(&b, filter) {
+			bookings = append(bookings, d.resolveBooking(b, true))
+		}
+	}
+	sortByStartTime(bookings)
+	return bookings, nil
+}
+
+func (r *BookingRepositoryMemory) Query(query BookingQuery) (*BookingPage, error) {
+	q, err := query.prepare(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	d := r.store.read()
+	search := strings.ToLower(q.Search)
+	bookings := []*Booking{}
+	for _, id := range sortedIds(d.bookings) {
+		b := d.bookings[id]
+		if !d.matches(&b, q.BookingFilter) || !strings.Contains(strings.ToLower(b.Title), search) {
+			continue
+		}
+		// Series are expanded in the time zone of their room. Series without end have no last end
+		resolved := d.resolveBooking(b, true)
+		lastEnd := resolved.LastEnd()
+		if !q.From.IsZero() && !lastEnd.IsZero() && !lastEnd.After(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && !b.StartTime.Before(q.To) {
+			continue
+		}
+		if !q.endedBy.IsZero() && (lastEnd.IsZero() || lastEnd.After(q.endedBy)) {
+			continue
+		}
+		if q.after != nil && compareBookings(&b, q.after, q.Sort) <= 0 {
+			continue
+		}
+		bookings = append(bookings, resolved)
+	}
+	slices.SortFunc(bookings, func(a, b *Booking) int { return compareBookings(a, b, q.Sort) })
+	return q.page(bookings), nil
+}
+
+func (r *BookingRepositoryMemory) CancelOccurrence(seriesId int64, originalStart time.Time) error {
+	return r.store.update(func(d *memoryData) error {
+		series, err := d.getBooking(seriesId)
+		if err != nil {
+			return err
+		}
+		if _, err := series.Occurrence(originalStart); err != nil {
+			return err
+		}
+		d.addExDate(seriesId, originalStart)
+		return nil
+	})
+}
+
+func (r *BookingRepositoryMemory) UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	var updated *Booking
+	err := r.store.update(func(d *memoryData) error {
+		var err error
+		updated, err = d.updateOccurrence(seriesId, originalStart, booking)
+		return err
+	})
+	return updated, err
+}
+
+// Batches hold the write lock of the store until they end. Readers keep seeing the records as
+// they were before the batch
+func (r *BookingRepositoryMemory) Begin() (BookingBatch, error) {
+	return &bookingBatchMemory{store: r.store, data: r.store.begin()}, nil
+}
+
+type bookingBatchMemory struct {
+	store *MemoryStore
+	// Copy of the records the writes of the batch go to
+	data *memoryData
+	done bool
+}
+
+func (b *bookingBatchMemory) Create(booking Booking) (*Booking, error) {
+	if b.done {
+		return nil, errBatchDone
+	}
+	return b.data.createBooking(booking)
+}
+
+func (b *bookingBatchMemory) UpdateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	if b.done {
+		return nil, errBatchDone
+	}
+	return b.data.updateOccurrence(seriesId, originalStart, booking)
+}
+
+func (b *bookingBatchMemory) Replace(booking Booking) (*Booking, error) {
+	if b.done {
+		return nil, errBatchDone
+	}
+	d := b.data
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := d.loadRoom(&booking); err != nil {
+		return nil, err
+	}
+	// Exceptions & excluded dates are dropped first, so they are neither checked for conflicts
+	// nor left behind for occurrences the series no longer has
+	d.deleteExceptions(booking.Id)
+	if err := d.checkConflicts(&booking, booking.Id, time.Time{}); err != nil {
+		return nil, err
+	}
+	if _, ok := d.bookings[booking.Id]; !ok {
+		return nil, fmt.Errorf("Booking %d %w", booking.Id, ErrNotFound)
+	}
+	if err := d.storeBooking(booking); err != nil {
+		return nil, err
+	}
+	return d.getBooking(booking.Id)
+}
+
+func (b *bookingBatchMemory) SaveCalendarObject(object CalendarObject) error {
+	if b.done {
+		return errBatchDone
+	}
+	b.data.calendarObjects = slices.DeleteFunc(b.data.calendarObjects, func(o CalendarObject) bool {
+		return o.BookingId == object.BookingId || o.Name == object.Name || o.Uid == object.Uid
+	})
+	b.data.calendarObjects = append(b.data.calendarObjects, object)
+	return nil
+}
+
+func (b *bookingBatchMemory) Commit() error {
+	if b.done {
+		return errBatchDone
+	}
+	b.done = true
+	b.store.publish(b.data)
+	b.store.write.Unlock()
+	return nil
+}
+
+func (b *bookingBatchMemory) Rollback() error {
+	if b.done {
+		return nil
+	}
+	b.done = true
+	b.store.write.Unlock()
+	return nil
+}
+
+func (d *memoryData) createBooking(booking Booking) (*Booking, error) {
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := d.loadRoom(&booking); err != nil {
+		return nil, err
+	}
+	if err := d.checkConflicts(&booking, 0, time.Time{}); err != nil {
+		return nil, err
+	}
+	if err := d.insertBooking(&booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+func (d *memoryData) updateOccurrence(seriesId int64, originalStart time.Time, booking Booking) (*Booking, error) {
+	series, err := d.getBooking(seriesId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := series.Occurrence(originalStart); err != nil {
+		return nil, err
+	}
+	// Exceptions are single bookings tied to the occurrence they replace
+	booking.Recurrence = nil
+	booking.SeriesId = seriesId
+	booking.OriginalStart = originalStart
+	if err := validateBooking(&booking); err != nil {
+		return nil, err
+	}
+	if err := d.loadRoom(&booking); err != nil {
+		return nil, err
+	}
+	if err := d.checkConflicts(&booking, seriesId, originalStart); err != nil {
+		return nil, err
+	}
+	d.addExDate(seriesId, originalStart)
+	if err := d.insertBooking(&booking); err != nil {
+		return nil, err
+	}
+	return d.getBooking(booking.Id)
+}
+
+func (d *memoryData) getBooking(id int64) (*Booking, error) {
+	b, ok := d.bookings[id]
+	if !ok {
+		return nil, fmt.Errorf("Booking %d %w", id, ErrNotFound)
+	}
+	return d.resolveBooking(b, true), nil
+}
+
+// Returns a copy of the stored booking as the SQL repositories return it: with title & time zone
+// of its room & the name of its user. Series come with their excluded dates, if requested
+func (d *memoryData) resolveBooking(b Booking, withExDates bool) *Booking {
+	room, user := d.rooms[b.Room.Id], d.users[b.User.Id]
+	b.Room = Room{Id: b.Room.Id, Title: room.Title, TimeZone: room.TimeZone}
+	b.User = User{Id: b.User.Id, Name: user.Name}
+	if b.Recurrence != nil {
+		rule := *b.Recurrence
+		rule.ByDay = slices.Clone(rule.ByDay)
+		rule.ExDates = nil
+		if withExDates {
+			rule.ExDates = slices.Clone(b.Recurrence.ExDates)
+		}
+		b.Recurrence = &rule
+	}
+	return &b
+}
+
+// Return true, if the booking matches the rooms, users, series & room attributes of the filter
+func (d *memoryData) matches(b *Booking, filter BookingFilter) bool {
+	if len(filter.RoomIds) > 0 && !slices.Contains(filter.RoomIds, b.Room.Id) {
+		return false
+	}
+	if len(filter.UserIds) > 0 && !slices.Contains(filter.UserIds, b.User.Id) {
+		return false
+	}
+	if len(filter.SeriesIds) > 0 && !slices.Contains(filter.SeriesIds, b.SeriesId) {
+		return false
+	}
+	room := d.rooms[b.Room.Id]
+	return filter.Rooms.Matches(&room)
+}
+
+// Returns bookings overlapping the interval & matching the filter.
+// Recurring series are expanded into their occurrences
+func (d *memoryData) findWithinTimeInterval(start *time.Time, end *time.Time, filter BookingFilter) []*Booking {
+	bookings := []*Booking{}
+	for _, id := range sortedIds(d.bookings) {
+		b := d.bookings[id]
+		if !d.matches(&b, filter) {
+			continue
+		}
+		// Single bookings & exceptions
+		if b.Recurrence == nil {
+			if b.Overlaps(start, end) {
+				bookings = append(bookings, d.resolveBooking(b, false))
+			}
+			continue
+		}
+		// Series starting before the interval ends
+		if b.StartTime.Before(*end) {
+			series := d.resolveBooking(b, true)
+			for _, occurrence := range series.OccurrencesWithin(start, end) {
+				bookings = append(bookings, &occurrence)
+			}
+		}
+	}
+	sortByStartTime(bookings)
+	return bookings
+}
+
+func (d *memoryData) checkConflicts(booking *Booking, excludeId int64, excludeStart time.Time) error {
+	return checkConflicts(booking, excludeId, excludeStart, func(start *time.Time, end *time.Time, filter BookingFilter) ([]*Booking, error) {
+		return d.findWithinTimeInterval(start, end, filter), nil
+	})
+}
+
+// Series are expanded in the time zone of their room, so the zone has to be known before
+// checking for conflicts. Fails if the room has been deleted or the attendees do not fit into it
+func (d *memoryData) loadRoom(booking *Booking) error {
+	room, ok := d.rooms[booking.Room.Id]
+	if !ok {
+		return fmt.Errorf("Room %d %w", booking.Room.Id, ErrNotFound)
+	}
+	return assignRoom(booking, &room)
+}
+
+// Stores the booking under a new ID & sets the ID of the given booking
+func (d *memoryData) insertBooking(booking *Booking) error {
+	d.lastIds.booking++
+	booking.Id = d.lastIds.booking
+	return d.storeBooking(*booking)
+}
+
+// Stores the booking as the SQL repositories would: in UTC, with nothing but the IDs of room & user
+func (d *memoryData) storeBooking(booking Booking) error {
+	if _, ok := d.users[booking.User.Id]; !ok {
+		return fmt.Errorf("User %d %w", booking.User.Id, ErrNotFound)
+	}
+	booking.Room = Room{Id: booking.Room.Id}
+	booking.User = User{Id: booking.User.Id}
+	booking.StartTime, booking.EndTime = booking.StartTime.UTC(), booking.EndTime.UTC()
+	if !booking.OriginalStart.IsZero() {
+		booking.OriginalStart = booking.OriginalStart.UTC()
+	}
+	if booking.Recurrence != nil {
+		// The rule is stored in RRULE notation by the SQL repositories
+		rule, err := ParseRecurrence(booking.Recurrence.String())
+		if err != nil {
+			return err
+		}
+		rule.ExDates = []time.Time{}
+		for _, exDate := range booking.Recurrence.ExDates {
+			rule.ExDates = append(rule.ExDates, exDate.UTC())
+		}
+		booking.Recurrence = rule
+	}
+	d.bookings[booking.Id] = booking
+	return nil
+}
+
+func (d *memoryData) addExDate(seriesId int64, originalStart time.Time) {
+	series := d.bookings[seriesId]
+	rule := *series.Recurrence
+	rule.ExDates = append(slices.Clone(rule.ExDates), originalStart.UTC())
+	series.Recurrence = &rule
+	d.bookings[seriesId] = series
+}
+
+// Removes the calendar object of the booking, like deleting the booking does on the SQL databases
+func (d *memoryData) deleteCalendarObject(id int64) {
+	d.calendarObjects = slices.DeleteFunc(d.calendarObjects, func(o CalendarObject) bool {
+		return o.BookingId == id
+	})
+}
+
+// Removes the exceptions, their calendar objects & the excluded dates of the series
+func (d *memoryData) deleteExceptions(seriesId int64) {
+	if series, ok := d.bookings[seriesId]; ok && series.Recurrence != nil {
+		rule := *series.Recurrence
+		rule.ExDates = []time.Time{}
+		series.Recurrence = &rule
+		d.bookings[seriesId] = series
+	}
+	for id, b := range d.bookings {
+		if b.SeriesId == seriesId {
+			d.deleteCalendarObject(id)
+			delete(d.bookings, id)
+		}
+	}
+}
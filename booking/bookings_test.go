@@ -1,140 +1,808 @@
 package booking
 
 import (
-// "testing"
-// "time"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 const layout = "2006-01-02 15:04"
 
-//func resetBookings() {
-//	Bookings = []Booking{}
-//}
-//
-//func TestDateTimeToTimestamp_ReturnsCorrectUnixTimestamp(t *testing.T) {
-//	resetBookings()
-//	dateString := "2024-07-03"
-//	timeString := "20:54"
-//	res, err := TimeFromDateAndTime(dateString, timeString)
-//	if err != nil {
-//		t.Fatalf(`Unable to parse string "%q" or "%q": %v"`, dateString, timeString, err)
-//	}
-//	expectedTt := 1720040040
-//
-//	unixTt := res.Unix()
-//	if int(unixTt) != expectedTt {
-//		t.Fatalf(`Expected "%d", but received "%d"`, expectedTt, unixTt)
-//	}
-//}
-//
-//func TestFilterBookings_ReturnsIntersectingBookings(t *testing.T) {
-//	resetBookings()
-//	layout := "2006-01-02 15:04"
-//	// Add booking test data
-//	startDate, _ := time.Parse(layout, "2024-01-03 8:00")
-//	endDate, _ := time.Parse(layout, "2024-01-03 15:00")
-//	_, err := AddBooking(1, 1, startDate, endDate)
-//	if err != nil {
-//		t.Fatalf("Unable to create booking from '%s' to '%s'", startDate, endDate)
-//	}
-//
-//	// Test: Filter start date > start Date & fitler end date > end date
-//	// Fully enclosed
-//	filterStartDate, _ := time.Parse(layout, "2024-01-03 6:00")
-//	filterEndDate, _ := time.Parse(layout, "2024-01-03 18:00")
-//	res := FindBookingsWithinTimeInterval(&filterStartDate, &filterEndDate)
-//
-//	expectedLength := 1
-//	resLength := len(res)
-//	if resLength != expectedLength {
-//		t.Fatalf("Expected filter result length %d, received %d", expectedLength, resLength)
-//	}
-//	if res[0] != Bookings[0] {
-//		t.Fatalf("Expected booking '%v' to be included", Bookings[0])
-//	}
-//}
-//
-//func TestFilterBookings_OutsideWorkingHours(t *testing.T) {
-//	resetBookings()
-//	// Add booking starting AFTER end date
-//	startDate, _ := time.Parse(layout, "2024-07-08 23:00")
-//	endDate, _ := time.Parse(layout, "2024-07-09 19:00")
-//	b, err := AddBooking(1, 1, startDate, endDate)
-//	if err != nil {
-//		t.Errorf("Unable to create booking from '%s' to '%s': %s", startDate, endDate, err)
-//	}
-//
-//	filterStartDate, _ := time.Parse(layout, "2024-07-08 8:00")
-//	filterEndDate, _ := time.Parse(layout, "2024-07-08 17:00")
-//	res := FindBookingsWithinTimeInterval(&filterStartDate, &filterEndDate)
-//
-//	if len(res) != 0 {
-//		t.Fatalf("Expected booking '%s' to not be included in interval '%s' - '%s'", b, filterStartDate, filterEndDate)
-//	}
-//}
-//
-//func TestFilterBookings_StartingBeforeStartEndingAfterEnd(t *testing.T) {
-//	resetBookings()
-//	// Add booking starting BEFORE filter start date & ending AFTER filter end date
-//	startDate, _ := time.Parse(layout, "2024-07-08 01:00")
-//	endDate, _ := time.Parse(layout, "2024-07-09 07:00")
-//	b, err := AddBooking(1, 1, startDate, endDate)
-//	if err != nil {
-//		t.Errorf("Unable to create booking from '%s' to '%s': %s", startDate, endDate, err)
-//	}
-//
-//	filterStartDate, _ := time.Parse(layout, "2024-07-08 8:00")
-//	filterEndDate, _ := time.Parse(layout, "2024-07-08 17:00")
-//	res := FindBookingsWithinTimeInterval(&filterStartDate, &filterEndDate)
-//
-//	if len(res) != 1 {
-//		t.Fatalf("Expected booking '%s' to not be included in interval '%s' - '%s'", b, filterStartDate, filterEndDate)
-//	}
-//	if res[0] != *b {
-//		t.Fatalf("Expected booking '%s' to be included in interval '%s' - '%s'", b, filterStartDate, filterEndDate)
-//	}
-//}
-//
-//func TestFilterBookings_StartingAfterStartEndingBeforeEnd(t *testing.T) {
-//	resetBookings()
-//	// Add booking starting AFTER filter start date & ending BEFORE filter end date
-//	startDate, _ := time.Parse(layout, "2024-07-08 10:00")
-//	endDate, _ := time.Parse(layout, "2024-07-09 22:00")
-//	b, err := AddBooking(1, 1, startDate, endDate)
-//	if err != nil {
-//		t.Errorf("Unable to create booking from '%s' to '%s': %s", startDate, endDate, err)
-//	}
-//
-//	filterStartDate, _ := time.Parse(layout, "2024-07-08 8:00")
-//	filterEndDate, _ := time.Parse(layout, "2024-07-08 17:00")
-//	res := FindBookingsWithinTimeInterval(&filterStartDate, &filterEndDate)
-//
-//	if len(res) != 1 {
-//		t.Fatalf("Expected booking '%s' to not be included in interval '%s' - '%s'", b, filterStartDate, filterEndDate)
-//	}
-//	if res[0] != *b {
-//		t.Fatalf("Expected booking '%s' to be included in interval '%s' - '%s'", b, filterStartDate, filterEndDate)
-//	}
-//}
-//
-//func TestAddBooking_FailsWhenConflicting(t *testing.T) {
-//	resetBookings()
-//	// Add booking test data
-//	startDate, _ := time.Parse(layout, "2024-07-08 8:00")
-//	endDate, _ := time.Parse(layout, "2024-07-09 17:00")
-//	AddBooking(1, 1, startDate, endDate)
-//
-//	// Adding the same booking fails
-//	_, err := AddBooking(1, 1, startDate, endDate)
-//	if err == nil {
-//		t.Fatalf("Expected adding duplicate booking to fail")
-//	}
-//
-//	// Adding an intersecting booking fails
-//	startDate, _ = time.Parse(layout, "2024-07-08 15:00")
-//	endDate, _ = time.Parse(layout, "2024-07-09 22:00")
-//	_, err = AddBooking(1, 1, startDate, endDate)
-//	if err == nil {
-//		t.Fatalf("Expected adding intersecting booking to fail")
-//	}
-//}
+// Opens an empty, migrated database & returns it with its user & room repository. Tests run against SQLite, or against Postgres if
+// TEST_POSTGRES_DSN holds the URL of a database the tests may create schemas in
+func openTestDatabase(t *testing.T) (*sqlx.DB, UserRepository, RoomsRepository) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if len(dsn) == 0 {
+		dsn = fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000", filepath.Join(t.TempDir(), "test.db"))
+		db, err := sqlx.Connect("sqlite3", dsn)
+		if err != nil {
+			t.Fatalf("Unable to open database: %s", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		migrateTestDatabase(t, db)
+		return db, NewUserRepositorySQLite(db), NewRoomsRepositorySQLite(db)
+	}
+	admin, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	// Every test gets a schema of its own. Extensions are installed into public, which all schemas share
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(` CREATE EXTENSION IF NOT EXISTS btree_gist; CREATE SCHEMA ` + schema + `; `); err != nil {
+		t.Fatalf("Unable to create schema: %s", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(` DROP SCHEMA ` + schema + ` CASCADE; `)
+		admin.Close()
+	})
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	db, err := sqlx.Connect("postgres", dsn+separator+"search_path="+schema+",public&timezone=UTC")
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrateTestDatabase(t, db)
+	return db, NewUserRepositoryPostgres(db), NewRoomsRepositoryPostgres(db)
+}
+
+func migrateTestDatabase(t *testing.T, db *sqlx.DB) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddMigrationSteps(migrator)
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+}
+
+// Repositories of one implementation, sharing their records
+type testRepositories struct {
+	Users    UserRepository
+	Rooms    RoomsRepository
+	Bookings BookingRepository
+}
+
+// Returns empty repositories of an implementation, whose rooms are deleted according to the policy
+type openRepositories func(t *testing.T, policy RoomDeletePolicy) testRepositories
+
+// Repositories of a test database holding user 1 & room 1
+func openSQLRepositories(t *testing.T, policy RoomDeletePolicy) testRepositories {
+	db, userRepo, _ := openTestDatabase(t)
+	roomRepo := &roomsRepositorySQL{db: db, deletePolicy: policy}
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	return testRepositories{userRepo, roomRepo, &bookingRepositorySQL{db, userRepo, roomRepo}}
+}
+
+// Repositories of an empty memory store holding user 1 & room 1
+func openMemoryRepositories(t *testing.T, policy RoomDeletePolicy) testRepositories {
+	store := NewMemoryStore()
+	userRepo := NewUserRepositoryMemory(store)
+	roomRepo := NewRoomsRepositoryMemory(store).WithDeletePolicy(policy)
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	return testRepositories{userRepo, roomRepo, NewBookingRepositoryMemory(store)}
+}
+
+func createTestUserAndRoom(t *testing.T, userRepo UserRepository, roomRepo RoomsRepository) {
+	if _, err := userRepo.Create(User{Name: "Test user"}); err != nil {
+		t.Fatalf("Unable to create user: %s", err)
+	}
+	if _, err := roomRepo.Create(Room{Title: "Test room"}); err != nil {
+		t.Fatalf("Unable to create room: %s", err)
+	}
+}
+
+func testCreate_FailsWithConflictError(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	endDate, _ := time.Parse(layout, "2024-07-08 17:00")
+	existing, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+
+	// Adding an intersecting booking fails
+	startDate, _ = time.Parse(layout, "2024-07-08 15:00")
+	endDate, _ = time.Parse(layout, "2024-07-08 22:00")
+	_, err = repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+	var conflict *ErrBookingConflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict error, received '%v'", err)
+	}
+	if ids := conflict.Ids(); len(ids) != 1 || ids[0] != existing.Id {
+		t.Fatalf("Expected conflict with booking %d, received %v", existing.Id, ids)
+	}
+}
+
+func testCreate_FailsWhenConflictingWithSeriesOccurrence(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	startDate, _ := time.Parse(layout, "2024-07-01 09:00")
+	endDate, _ := time.Parse(layout, "2024-07-01 10:00")
+	series := Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate, Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1, Count: 5}}
+	if _, err := repo.Create(series); err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+
+	// Fourth occurrence
+	startDate, _ = time.Parse(layout, "2024-07-22 09:30")
+	endDate, _ = time.Parse(layout, "2024-07-22 11:00")
+	_, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+	var conflict *ErrBookingConflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict error, received '%v'", err)
+	}
+}
+
+func testUpdate_PersistsChangesAndChecksConflicts(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	endDate, _ := time.Parse(layout, "2024-07-08 10:00")
+	first, _ := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+	startDate, _ = time.Parse(layout, "2024-07-08 12:00")
+	endDate, _ = time.Parse(layout, "2024-07-08 14:00")
+	second, _ := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+
+	// Shifting a booking within its own slot does not conflict with itself
+	first.Title = "Moved"
+	first.EndTime = first.EndTime.Add(time.Hour)
+	updated, err := repo.Update(*first)
+	if err != nil {
+		t.Fatalf("Unable to update booking: %s", err)
+	}
+	if updated.Title != "Moved" || updated.EndTime.Format(layout) != "2024-07-08 11:00" {
+		t.Fatalf("Expected changes to be persisted, received '%s'", updated)
+	}
+
+	// Moving into another booking fails and leaves the record untouched
+	first.EndTime = second.StartTime.Add(time.Hour)
+	_, err = repo.Update(*first)
+	var conflict *ErrBookingConflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict error, received '%v'", err)
+	}
+	stored, _ := repo.GetById(first.Id)
+	if stored.EndTime.Format(layout) != "2024-07-08 11:00" {
+		t.Fatalf("Expected failed update to not be persisted, received '%s'", stored)
+	}
+}
+
+func testFindWithinTimeIntervalByFilter_RestrictsRoomsAndUsers(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	if _, err := repos.Users.Create(User{Name: "Other user"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repos.Rooms.Create(Room{Title: "Other room", Capacity: 8, Building: "HQ", Equipment: []string{"Projector", "whiteboard"}}); err != nil {
+		t.Fatal(err)
+	}
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	endDate, _ := time.Parse(layout, "2024-07-08 09:00")
+	for _, b := range []Booking{
+		{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate},
+		{Room: Room{Id: 2}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate},
+		{Room: Room{Id: 2}, User: User{Id: 2}, StartTime: startDate.Add(time.Hour), EndTime: endDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 3}},
+	} {
+		if _, err := repo.Create(b); err != nil {
+			t.Fatalf("Unable to create booking: %s", err)
+		}
+	}
+	// The second occurrence of the series moves into the first room
+	exceptionStart := startDate.AddDate(0, 0, 1).Add(4 * time.Hour)
+	if _, err := repo.UpdateOccurrence(3, startDate.AddDate(0, 0, 1).Add(time.Hour), Booking{Room: Room{Id: 1}, User: User{Id: 2}, StartTime: exceptionStart, EndTime: exceptionStart.Add(time.Hour)}); err != nil {
+		t.Fatalf("Unable to update occurrence: %s", err)
+	}
+
+	from, _ := time.Parse(layout, "2024-07-08 00:00")
+	to := from.AddDate(0, 0, 7)
+	tests := []struct {
+		name     string
+		filter   BookingFilter
+		expected int
+	}{
+		{"no filter", BookingFilter{}, 5},
+		{"single room", BookingFilter{RoomIds: []int64{1}}, 2},
+		{"multiple rooms", BookingFilter{RoomIds: []int64{1, 2}}, 5},
+		{"single user", BookingFilter{UserIds: []int64{2}}, 3},
+		{"room & user", BookingFilter{RoomIds: []int64{2}, UserIds: []int64{1}}, 1},
+		{"exceptions of series", BookingFilter{SeriesIds: []int64{3}}, 1},
+		{"capacity", BookingFilter{Rooms: RoomFilter{MinCapacity: 8}}, 3},
+		{"building & equipment", BookingFilter{Rooms: RoomFilter{Building: "hq", Equipment: []string{"projector"}}}, 3},
+		{"missing equipment", BookingFilter{Rooms: RoomFilter{Equipment: []string{"projector", "video-conference"}}}, 0},
+	}
+	for _, tt := range tests {
+		bookings, err := repo.FindWithinTimeIntervalByFilter(&from, &to, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if len(bookings) != tt.expected {
+			t.Errorf("%s: Expected %d bookings, received %d", tt.name, tt.expected, len(bookings))
+		}
+	}
+}
+
+func testCreate_ChecksConflictsInTimeZoneOfRoom(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	room, err := repos.Rooms.Create(Room{Title: "Berlin", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Weekly on Mondays at 09:00 Berlin time, starting before DST begins on 2024-03-31
+	startDate, _ := time.Parse(layout, "2024-03-25 08:00")
+	series := Booking{Room: Room{Id: room.Id}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1, Count: 3}}
+	if _, err := repo.Create(series); err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+
+	// 08:00 UTC is 10:00 Berlin time after the change
+	startDate, _ = time.Parse(layout, "2024-04-01 08:00")
+	if _, err := repo.Create(Booking{Room: Room{Id: room.Id}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour)}); err != nil {
+		t.Fatalf("Expected booking after the occurrence to succeed, received %s", err)
+	}
+	startDate, _ = time.Parse(layout, "2024-04-01 07:00")
+	_, err = repo.Create(Booking{Room: Room{Id: room.Id}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour)})
+	var conflict *ErrBookingConflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict with occurrence at 09:00 Berlin time, received '%v'", err)
+	}
+}
+
+func testCreate_ConcurrentRequestsCannotDoubleBook(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	endDate, _ := time.Parse(layout, "2024-07-08 17:00")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		var conflict *ErrBookingConflict
+		if err == nil {
+			created++
+		} else if !errors.As(err, &conflict) {
+			t.Fatalf("Expected conflict error, received '%s'", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("Expected exactly 1 booking to be created, received %d", created)
+	}
+}
+
+func testCreate_RejectsAttendeesAboveRoomCapacity(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	room, err := repos.Rooms.Create(Room{Title: "Small room", Capacity: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	endDate, _ := time.Parse(layout, "2024-07-08 09:00")
+	if _, err := repo.Create(Booking{Room: *room, User: User{Id: 1}, StartTime: startDate, EndTime: endDate, Attendees: 5}); err == nil {
+		t.Fatal("Expected error for 5 attendees in a room for 4")
+	}
+	created, err := repo.Create(Booking{Room: *room, User: User{Id: 1}, StartTime: startDate, EndTime: endDate, Attendees: 4})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	if created.Attendees != 4 {
+		t.Fatalf("Expected 4 attendees, received %d", created.Attendees)
+	}
+	// Rooms of unknown capacity fit everyone
+	startDate, endDate = startDate.Add(time.Hour), endDate.Add(time.Hour)
+	if _, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate, Attendees: 100}); err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+}
+
+func TestFindWithinTimeInterval_SkipsSeriesEndedBeforeInterval(t *testing.T) {
+	repos := openSQLRepositories(t, RoomDeleteBlock)
+	repo := repos.Bookings.(*bookingRepositorySQL)
+	startDate, _ := time.Parse(layout, "2020-01-06 10:00")
+	for idx, rule := range []*Recurrence{{Frequency: FrequencyWeekly, Interval: 1}, {Frequency: FrequencyWeekly, Interval: 1, Count: 2}} {
+		start := startDate.Add(time.Duration(idx) * 2 * time.Hour)
+		if _, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: start, EndTime: start.Add(time.Hour), Recurrence: rule}); err != nil {
+			t.Fatalf("Unable to create series: %s", err)
+		}
+	}
+	// Only the stored end decides, whether a series is expanded
+	if _, err := repo.db.Exec(repo.db.Rebind(` UPDATE booking SET last_end_time = ? WHERE id = 1; `), startDate.Add(time.Hour).UTC()); err != nil {
+		t.Fatal(err)
+	}
+	from, to := startDate.AddDate(0, 0, 7), startDate.AddDate(0, 0, 14)
+	bookings, err := repo.FindWithinTimeInterval(&from, &to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 1 || bookings[0].Id != 2 {
+		t.Fatalf("Expected the second occurrence of series 2 only, received %v", bookings)
+	}
+}
+
+func TestInsertExDate_RejectsOccurrenceExcludedConcurrently(t *testing.T) {
+	repos := openSQLRepositories(t, RoomDeleteBlock)
+	repo := repos.Bookings.(*bookingRepositorySQL)
+	startDate, _ := time.Parse(layout, "2024-07-01 09:00")
+	series, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 5}})
+	if err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+	// Excluded after the series was read by the request
+	if err := repo.CancelOccurrence(series.Id, startDate); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := repo.db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := insertExDate(tx, series.Id, startDate); err == nil || !strings.Contains(err.Error(), "already been cancelled") {
+		t.Fatalf("Expected error for excluded occurrence, received '%v'", err)
+	}
+}
+
+func TestMigrate_AddsColumnsToOlderSchema(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Schema before time zones, room metadata & attendees
+	if _, err := db.Exec(`
+CREATE TABLE room ( id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL );
+CREATE TABLE booking ( id INTEGER PRIMARY KEY AUTOINCREMENT, room_id INTEGER NOT NULL, user_id INTEGER NOT NULL, title TEXT, description TEXT, start_time DATETIME NOT NULL, end_time DATETIME NOT NULL, recurrence TEXT, series_id INTEGER, original_start DATETIME );
+INSERT INTO room ( title ) VALUES ("Old room"); `); err != nil {
+		t.Fatal(err)
+	}
+	migrateTestDatabase(t, db)
+	userRepo := NewUserRepositorySQLite(db)
+	roomRepo := NewRoomsRepositorySQLite(db)
+	bookingRepo := NewBookingRepositorySQLite(db, userRepo, roomRepo)
+	if _, err := roomRepo.Update(Room{Id: 1, Title: "Old room", Capacity: 6, Accessibility: []string{"hearing-loop"}}); err != nil {
+		t.Fatalf("Unable to update room: %s", err)
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].TimeZone != DefaultTimeZone || rooms[0].Capacity != 6 || len(rooms[0].Accessibility) != 1 {
+		t.Fatalf("Expected migrated room, received %+v", rooms)
+	}
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	if _, err := bookingRepo.Create(Booking{Room: *rooms[0], User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Attendees: 6}); err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+}
+
+func TestMigrate_BackfillsLastEndOfSeries(t *testing.T) {
+	db, userRepo, roomRepo := openTestDatabase(t)
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddMigrationSteps(migrator)
+	// Schema before bookings stored their last end time
+	if _, err := migrator.Down(1); err != nil {
+		t.Fatalf("Unable to revert migrations: %s", err)
+	}
+	startDate, _ := time.Parse(layout, "2020-01-06 10:00")
+	query := ` INSERT INTO booking ( room_id, user_id, title, start_time, end_time, recurrence ) VALUES (1, 1, ?, ?, ?, ?); `
+	series := []struct{ title, recurrence string }{
+		{"Ended standup", "FREQ=WEEKLY;COUNT=5"},
+		{"Weekly sync", "FREQ=WEEKLY"},
+	}
+	for _, s := range series {
+		if _, err := db.Exec(db.Rebind(query), s.title, startDate, startDate.Add(time.Hour), s.recurrence); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	repo := &bookingRepositorySQL{db, userRepo, roomRepo}
+	for status, expected := range map[BookingStatus][]int64{BookingStatusPast: {1}, BookingStatusUpcoming: {2}} {
+		if ids := queryAllPages(t, repo, BookingQuery{Status: status, Limit: 10}); !slices.Equal(ids, expected) {
+			t.Errorf("Expected bookings %v for status %s, received %v", expected, status, ids)
+		}
+	}
+}
+
+// Creates a series with an exception & the calendar objects of both in a batch. Fails, if the
+// batch cannot be committed
+func createSeriesWithCalendarObjects(t *testing.T, repo BookingRepository, startDate time.Time) *Booking {
+	batch, err := repo.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Rollback()
+	series, err := batch.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 3}})
+	if err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+	moved := startDate.AddDate(0, 0, 1).Add(2 * time.Hour)
+	exception, err := batch.UpdateOccurrence(series.Id, startDate.AddDate(0, 0, 1), Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: moved, EndTime: moved.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to update occurrence: %s", err)
+	}
+	for _, object := range []CalendarObject{
+		{BookingId: series.Id, Name: fmt.Sprintf("series-%d.ics", series.Id), Uid: fmt.Sprintf("series-%d@client", series.Id)},
+		{BookingId: exception.Id, Name: fmt.Sprintf("exception-%d.ics", exception.Id), Uid: fmt.Sprintf("exception-%d@client", exception.Id)},
+	} {
+		if err := batch.SaveCalendarObject(object); err != nil {
+			t.Fatalf("Unable to save calendar object: %s", err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func countCalendarObjects(t *testing.T, db *sqlx.DB) int {
+	var count int
+	if err := db.Get(&count, ` SELECT COUNT(*) FROM calendar_object; `); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestDelete_RemovesCalendarObjectsOfSeriesAndExceptions(t *testing.T) {
+	db, userRepo, roomRepo := openTestDatabase(t)
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	repo := &bookingRepositorySQL{db, userRepo, roomRepo}
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	series := createSeriesWithCalendarObjects(t, repo, startDate)
+	if count := countCalendarObjects(t, db); count != 2 {
+		t.Fatalf("Expected 2 calendar objects, received %d", count)
+	}
+
+	if err := repo.Delete(series.Id); err != nil {
+		t.Fatal(err)
+	}
+	if count := countCalendarObjects(t, db); count != 0 {
+		t.Fatalf("Expected calendar objects to be deleted with their bookings, received %d", count)
+	}
+
+	// Replacing a series drops its exceptions
+	series = createSeriesWithCalendarObjects(t, repo, startDate)
+	batch, err := repo.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Rollback()
+	if _, err := batch.Replace(*series); err != nil {
+		t.Fatalf("Unable to replace series: %s", err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countCalendarObjects(t, db); count != 1 {
+		t.Fatalf("Expected the calendar object of the series to be kept, received %d objects", count)
+	}
+}
+
+func TestRoomsDelete_CascadeRemovesCalendarObjects(t *testing.T) {
+	db, userRepo, _ := openTestDatabase(t)
+	roomRepo := &roomsRepositorySQL{db: db, deletePolicy: RoomDeleteCascade}
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	repo := &bookingRepositorySQL{db, userRepo, roomRepo}
+	createSeriesWithCalendarObjects(t, repo, time.Now().Add(24*time.Hour).Truncate(time.Hour))
+
+	if err := roomRepo.Delete(1); err != nil {
+		t.Fatalf("Unable to delete room: %s", err)
+	}
+	if count := countCalendarObjects(t, db); count != 0 {
+		t.Fatalf("Expected calendar objects of cancelled bookings to be deleted, received %d", count)
+	}
+}
+
+func TestMigrate_ExclusionConstraintRejectsOverlapsOnPostgres(t *testing.T) {
+	db, userRepo, roomRepo := openTestDatabase(t)
+	if db.DriverName() != "postgres" {
+		t.Skip("Exclusion constraints only exist on Postgres")
+	}
+	createTestUserAndRoom(t, userRepo, roomRepo)
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	query := ` INSERT INTO booking ( room_id, user_id, start_time, end_time ) VALUES ($1, 1, $2, $3); `
+	if _, err := db.Exec(query, 1, startDate, startDate.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Adjacent bookings do not overlap
+	if _, err := db.Exec(query, 1, startDate.Add(time.Hour), startDate.Add(2*time.Hour)); err != nil {
+		t.Fatalf("Expected adjacent booking to be inserted, received '%s'", err)
+	}
+	if _, err := db.Exec(query, 1, startDate.Add(30*time.Minute), startDate.Add(90*time.Minute)); err == nil {
+		t.Fatal("Expected the constraint to reject an overlapping booking")
+	}
+}
+
+func TestTimeFromDateAndTime_ReturnsTimeInLocation(t *testing.T) {
+	berlin, err := LoadTimeZone("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := TimeFromDateAndTime("2024-07-03", "20:54", berlin)
+	if err != nil {
+		t.Fatalf("Unable to parse date & time: %s", err)
+	}
+	if res.Unix() != 1720032840 {
+		t.Fatalf("Expected 2024-07-03 18:54 UTC, received %s", res.UTC())
+	}
+	if _, err := TimeFromDateAndTime("2024-07-03", "8", berlin); err == nil {
+		t.Fatal("Expected error for invalid time")
+	}
+}
+
+func testFindWithinTimeInterval_ReturnsOverlappingBookings(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	filterStart, _ := time.Parse(layout, "2024-07-08 08:00")
+	filterEnd, _ := time.Parse(layout, "2024-07-08 17:00")
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		expected bool
+	}{
+		{"enclosed", "2024-07-08 10:00", "2024-07-08 12:00", true},
+		{"enclosing", "2024-07-08 01:00", "2024-07-09 07:00", true},
+		{"starting before", "2024-07-08 06:00", "2024-07-08 09:00", true},
+		{"ending after", "2024-07-08 16:00", "2024-07-08 23:00", true},
+		{"ending at start", "2024-07-08 07:00", "2024-07-08 08:00", false},
+		{"starting at end", "2024-07-08 17:00", "2024-07-08 18:00", false},
+		{"next day", "2024-07-09 08:00", "2024-07-09 17:00", false},
+	}
+	for _, tt := range tests {
+		startDate, _ := time.Parse(layout, tt.start)
+		endDate, _ := time.Parse(layout, tt.end)
+		created, err := repo.Create(Booking{Title: tt.name, Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: endDate})
+		if err != nil {
+			t.Fatalf("%s: Unable to create booking: %s", tt.name, err)
+		}
+		res, err := repo.FindWithinTimeInterval(&filterStart, &filterEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := len(res) == 1 && res[0].Id == created.Id; found != tt.expected {
+			t.Errorf("%s: Expected booking to be included %v, received %v", tt.name, tt.expected, res)
+		}
+		if found := len(res) == 1; found && (res[0].Room.Title != "Test room" || res[0].User.Name != "Test user" || res[0].StartTime.Location() != time.UTC) {
+			t.Errorf("%s: Expected booking in UTC with room & user, received %v", tt.name, res[0])
+		}
+		if err := repo.Delete(created.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testGetAll_OrdersBookingsByStartTime(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 12:00")
+	for _, offset := range []time.Duration{2 * time.Hour, 0, time.Hour} {
+		if _, err := repo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate.Add(offset), EndTime: startDate.Add(offset + time.Hour)}); err != nil {
+			t.Fatalf("Unable to create booking: %s", err)
+		}
+	}
+	bookings, err := repo.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for _, b := range bookings {
+		ids = append(ids, b.Id)
+	}
+	if !slices.Equal(ids, []int64{2, 3, 1}) {
+		t.Fatalf("Expected bookings 2, 3 & 1, received %v", ids)
+	}
+	if _, err := repo.GetById(42); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error, received '%v'", err)
+	}
+	if _, err := repo.Update(Booking{Id: 42, Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate.AddDate(0, 0, 1), EndTime: startDate.AddDate(0, 0, 1).Add(time.Hour)}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error, received '%v'", err)
+	}
+}
+
+func testUpdateOccurrence_ReplacesOccurrenceWithException(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	startDate, _ := time.Parse(layout, "2024-07-01 09:00")
+	series, err := repo.Create(Booking{Title: "Stand-up", Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 5}})
+	if err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+	if err := repo.CancelOccurrence(series.Id, startDate.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("Unable to cancel occurrence: %s", err)
+	}
+	if err := repo.CancelOccurrence(series.Id, startDate.Add(time.Minute)); err == nil {
+		t.Fatal("Expected error cancelling a time without occurrence")
+	}
+	// The moved occurrence may take the slot of the cancelled one
+	moved := Booking{Title: "Moved", Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate.AddDate(0, 0, 1), EndTime: startDate.AddDate(0, 0, 1).Add(time.Hour)}
+	if _, err := repo.UpdateOccurrence(series.Id, startDate.AddDate(0, 0, 3), moved); err != nil {
+		t.Fatalf("Unable to update occurrence: %s", err)
+	}
+	// Cancelled & replaced occurrences cannot be cancelled again
+	for _, start := range []time.Time{startDate.AddDate(0, 0, 1), startDate.AddDate(0, 0, 3)} {
+		if err := repo.CancelOccurrence(series.Id, start); err == nil {
+			t.Fatalf("Expected error cancelling the occurrence at %s again", start)
+		}
+	}
+	// But not overlap another occurrence
+	moved.StartTime, moved.EndTime = startDate.AddDate(0, 0, 2), startDate.AddDate(0, 0, 2).Add(time.Hour)
+	var conflict *ErrBookingConflict
+	if _, err := repo.UpdateOccurrence(series.Id, startDate.AddDate(0, 0, 4), moved); !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict error, received '%v'", err)
+	}
+
+	stored, err := repo.GetById(series.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Recurrence.ExDates) != 2 {
+		t.Fatalf("Expected 2 excluded dates, received %v", stored.Recurrence.ExDates)
+	}
+	from, to := startDate, startDate.AddDate(0, 0, 7)
+	bookings, err := repo.FindWithinTimeInterval(&from, &to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, b := range bookings {
+		titles = append(titles, fmt.Sprintf("%s %s", b.StartTime.Format("02"), b.Title))
+	}
+	if expected := []string{"01 Stand-up", "02 Moved", "03 Stand-up", "05 Stand-up"}; !slices.Equal(titles, expected) {
+		t.Fatalf("Expected %v, received %v", expected, titles)
+	}
+	exceptions, err := repo.FindByFilter(BookingFilter{})
+	if err != nil || len(exceptions) != 2 || exceptions[1].SeriesId != series.Id {
+		t.Fatalf("Expected series & exception, received %v, %v", exceptions, err)
+	}
+	// Deleting the series deletes its exceptions
+	if err := repo.Delete(series.Id); err != nil {
+		t.Fatal(err)
+	}
+	if bookings, err := repo.GetAll(); err != nil || len(bookings) != 0 {
+		t.Fatalf("Expected no bookings, received %v, %v", bookings, err)
+	}
+}
+
+func testUpdate_ReschedulingSeriesDropsExceptions(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	startDate, _ := time.Parse(layout, "2024-07-01 09:00")
+	series, err := repo.Create(Booking{Title: "Stand-up", Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 5}})
+	if err != nil {
+		t.Fatalf("Unable to create series: %s", err)
+	}
+	if err := repo.CancelOccurrence(series.Id, startDate.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("Unable to cancel occurrence: %s", err)
+	}
+	moved := Booking{Title: "Moved", Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate.AddDate(0, 0, 2).Add(6 * time.Hour), EndTime: startDate.AddDate(0, 0, 2).Add(7 * time.Hour)}
+	if _, err := repo.UpdateOccurrence(series.Id, startDate.AddDate(0, 0, 2), moved); err != nil {
+		t.Fatalf("Unable to update occurrence: %s", err)
+	}
+	titles := func() []string {
+		from, to := startDate, startDate.AddDate(0, 0, 7)
+		bookings, err := repo.FindWithinTimeInterval(&from, &to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := []string{}
+		for _, b := range bookings {
+			titles = append(titles, fmt.Sprintf("%s %s", b.StartTime.Format("02 15:04"), b.Title))
+		}
+		return titles
+	}
+
+	// Occurrences stay where they are, so do the exceptions
+	series.Title = "Daily"
+	if _, err := repo.Update(*series); err != nil {
+		t.Fatalf("Unable to update series: %s", err)
+	}
+	if expected := []string{"01 09:00 Daily", "03 15:00 Moved", "04 09:00 Daily", "05 09:00 Daily"}; !slices.Equal(titles(), expected) {
+		t.Fatalf("Expected %v, received %v", expected, titles())
+	}
+	// Moved occurrences no longer match the excluded dates & exceptions
+	series.StartTime, series.EndTime = series.StartTime.Add(time.Hour), series.EndTime.Add(time.Hour)
+	if _, err := repo.Update(*series); err != nil {
+		t.Fatalf("Unable to update series: %s", err)
+	}
+	expected := []string{"01 10:00 Daily", "02 10:00 Daily", "03 10:00 Daily", "04 10:00 Daily", "05 10:00 Daily"}
+	if received := titles(); !slices.Equal(received, expected) {
+		t.Fatalf("Expected %v, received %v", expected, received)
+	}
+	stored, err := repo.GetById(series.Id)
+	if err != nil || len(stored.Recurrence.ExDates) != 0 {
+		t.Fatalf("Expected no excluded dates, received %v, %v", stored, err)
+	}
+	if bookings, err := repo.FindByFilter(BookingFilter{}); err != nil || len(bookings) != 1 {
+		t.Fatalf("Expected exception to be deleted, received %v, %v", bookings, err)
+	}
+	// As do the occurrences of a changed rule
+	if err := repo.CancelOccurrence(series.Id, stored.StartTime.AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Unable to cancel occurrence: %s", err)
+	}
+	stored.Recurrence = &Recurrence{Frequency: FrequencyDaily, Interval: 2, Count: 3}
+	if _, err := repo.Update(*stored); err != nil {
+		t.Fatalf("Unable to update series: %s", err)
+	}
+	expected = []string{"01 10:00 Daily", "03 10:00 Daily", "05 10:00 Daily"}
+	if received := titles(); !slices.Equal(received, expected) {
+		t.Fatalf("Expected %v, received %v", expected, received)
+	}
+}
+
+func testBegin_ChecksConflictsWithinBatch(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 08:00")
+	batch, err := repo.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Rollback()
+	if _, err := batch.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour)}); err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	var conflict *ErrBookingConflict
+	if _, err := batch.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour)}); !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict with the earlier write of the batch, received '%v'", err)
+	}
+	if err := batch.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if bookings, err := repo.GetAll(); err != nil || len(bookings) != 0 {
+		t.Fatalf("Expected rolled back batch to create nothing, received %v, %v", bookings, err)
+	}
+
+	batch, err = repo.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := batch.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate, EndTime: startDate.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 3}})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	created.Title = "Replaced"
+	created.Recurrence = &Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 2, ExDates: []time.Time{startDate.AddDate(0, 0, 1)}}
+	if _, err := batch.Replace(*created); err != nil {
+		t.Fatalf("Unable to replace booking: %s", err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Rolling back after the commit does nothing
+	if err := batch.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.GetById(created.Id)
+	if err != nil || stored.Title != "Replaced" || stored.Recurrence.Count != 2 || len(stored.Recurrence.ExDates) != 1 {
+		t.Fatalf("Expected replaced series, received %v, %v", stored, err)
+	}
+}
@@ -0,0 +1,105 @@
+package booking
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Resource name & UID a CalDAV client chose for a booking it created. Bookings without calendar
+// object are served under a name & UID derived from their id
+type CalendarObject struct {
+	// Booking or series master the object stands for
+	BookingId int64 `db:"booking_id"`
+	// Last segment of the resource URL, e.g. "2f1c6a0e.ics"
+	Name string
+	// UID of the iCalendar event
+	Uid string
+}
+
+// Objects are written by the booking repository, in the transaction of the booking they stand for:
+// BookingBatch.SaveCalendarObject stores them & deleting a booking removes its object
+type CalendarObjectRepository interface {
+	// Lookups only return objects of existing bookings
+	FindByRoom(roomId int64) ([]*CalendarObject, error)
+	GetByName(name string) (*CalendarObject, error)
+	GetByUid(uid string) (*CalendarObject, error)
+}
+
+// Queries shared by the SQLite & Postgres repositories
+type calendarObjectRepositorySQL struct {
+	db *sqlx.DB
+}
+
+type CalendarObjectRepositorySQLite struct {
+	*calendarObjectRepositorySQL
+}
+
+func NewCalendarObjectRepositorySQLite(db *sqlx.DB) *CalendarObjectRepositorySQLite {
+	return &CalendarObjectRepositorySQLite{&calendarObjectRepositorySQL{db}}
+}
+
+type CalendarObjectRepositoryPostgres struct {
+	*calendarObjectRepositorySQL
+}
+
+func NewCalendarObjectRepositoryPostgres(db *sqlx.DB) *CalendarObjectRepositoryPostgres {
+	return &CalendarObjectRepositoryPostgres{&calendarObjectRepositorySQL{db}}
+}
+
+const calendarObjectSelect = `
+	SELECT
+		o.booking_id,
+		o.name,
+		o.uid
+	FROM
+		calendar_object o
+		JOIN booking b ON b.id = o.booking_id
+`
+
+func (r *calendarObjectRepositorySQL) FindByRoom(roomId int64) ([]*CalendarObject, error) {
+	objects := []*CalendarObject{}
+	err := r.db.Select(&objects, r.db.Rebind(calendarObjectSelect+` WHERE b.room_id = ? ORDER BY o.booking_id; `), roomId)
+	return objects, err
+}
+
+func (r *calendarObjectRepositorySQL) GetByName(name string) (*CalendarObject, error) {
+	return r.get(` WHERE o.name = ?; `, name, fmt.Errorf("Calendar object %s %w", name, ErrNotFound))
+}
+
+func (r *calendarObjectRepositorySQL) GetByUid(uid string) (*CalendarObject, error) {
+	return r.get(` WHERE o.uid = ?; `, uid, fmt.Errorf("Calendar object of UID %s %w", uid, ErrNotFound))
+}
+
+func (r *calendarObjectRepositorySQL) get(where string, arg any, notFound error) (*CalendarObject, error) {
+	var object CalendarObject
+	if err := r.db.Get(&object, r.db.Rebind(calendarObjectSelect+where), arg); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	return &object, nil
+}
+
+// Stores the object, replacing objects of the same booking, name or UID
+func saveCalendarObject(tx *sqlx.Tx, object CalendarObject) error {
+	queries := []string{
+		` DELETE FROM calendar_object WHERE booking_id = ? OR name = ? OR uid = ?; `,
+		` INSERT INTO calendar_object ( booking_id, name, uid ) VALUES (?, ?, ?); `,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.Rebind(query), object.BookingId, object.Name, object.Uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the objects of the booking & of the exceptions of a series
+func deleteCalendarObjects(tx *sqlx.Tx, id int64) error {
+	query := ` DELETE FROM calendar_object WHERE booking_id = ? OR booking_id IN (SELECT id FROM booking WHERE series_id = ?); `
+	_, err := tx.Exec(tx.Rebind(query), id, id)
+	return err
+}
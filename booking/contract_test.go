@@ -0,0 +1,45 @@
+package booking
+
+import "testing"
+
+// Behavior every implementation of the user, room & booking repositories has to show
+var repositoryContract = []struct {
+	name string
+	test func(t *testing.T, open openRepositories)
+}{
+	{"UsersRepository_CreatesReadsAndUpdatesUsers", testUsersRepository_CreatesReadsAndUpdatesUsers},
+	{"RoomsRepository_CreatesReadsAndUpdatesRooms", testRoomsRepository_CreatesReadsAndUpdatesRooms},
+	{"RoomsDelete_BlocksRoomsWithFutureBookings", testRoomsDelete_BlocksRoomsWithFutureBookings},
+	{"RoomsDelete_CascadeCancelsFutureBookings", testRoomsDelete_CascadeCancelsFutureBookings},
+	{"RoomsDelete_ReassignMovesFutureBookingsToFreeRooms", testRoomsDelete_ReassignMovesFutureBookingsToFreeRooms},
+	{"RoomsDelete_ReassignFailsWithoutFreeRoom", testRoomsDelete_ReassignFailsWithoutFreeRoom},
+	{"Create_FailsWithConflictError", testCreate_FailsWithConflictError},
+	{"Create_FailsWhenConflictingWithSeriesOccurrence", testCreate_FailsWhenConflictingWithSeriesOccurrence},
+	{"Create_ChecksConflictsInTimeZoneOfRoom", testCreate_ChecksConflictsInTimeZoneOfRoom},
+	{"Create_ConcurrentRequestsCannotDoubleBook", testCreate_ConcurrentRequestsCannotDoubleBook},
+	{"Create_RejectsAttendeesAboveRoomCapacity", testCreate_RejectsAttendeesAboveRoomCapacity},
+	{"Update_PersistsChangesAndChecksConflicts", testUpdate_PersistsChangesAndChecksConflicts},
+	{"GetAll_OrdersBookingsByStartTime", testGetAll_OrdersBookingsByStartTime},
+	{"FindWithinTimeInterval_ReturnsOverlappingBookings", testFindWithinTimeInterval_ReturnsOverlappingBookings},
+	{"FindWithinTimeIntervalByFilter_RestrictsRoomsAndUsers", testFindWithinTimeIntervalByFilter_RestrictsRoomsAndUsers},
+	{"UpdateOccurrence_ReplacesOccurrenceWithException", testUpdateOccurrence_ReplacesOccurrenceWithException},
+	{"Update_ReschedulingSeriesDropsExceptions", testUpdate_ReschedulingSeriesDropsExceptions},
+	{"Begin_ChecksConflictsWithinBatch", testBegin_ChecksConflictsWithinBatch},
+	{"Query_PagesThroughBookingsInOrder", testQuery_PagesThroughBookingsInOrder},
+	{"Query_FiltersByTimeSearchStatusAndFilter", testQuery_FiltersByTimeSearchStatusAndFilter},
+}
+
+func runRepositoryContract(t *testing.T, open openRepositories) {
+	for _, c := range repositoryContract {
+		t.Run(c.name, func(t *testing.T) { c.test(t, open) })
+	}
+}
+
+// Runs against SQLite, or Postgres if TEST_POSTGRES_DSN is set
+func TestRepositoryContract_SQL(t *testing.T) {
+	runRepositoryContract(t, openSQLRepositories)
+}
+
+func TestRepositoryContract_Memory(t *testing.T) {
+	runRepositoryContract(t, openMemoryRepositories)
+}
@@ -0,0 +1,168 @@
+package booking
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// iCalendar subscription feed. Anyone knowing the token of the feed can read it, so calendar
+// clients do not need a login. Feeds stay readable until they are revoked
+type Feed struct {
+	Id int64
+	// Owner of the feed. Feeds without room list the bookings of their owner
+	UserId int64
+	// Room whose bookings are listed. 0 for the feed of the owner's bookings
+	RoomId int64
+	// SHA-256 hash of the token. The token itself is never stored
+	TokenHash string
+	CreatedAt time.Time
+	RevokedAt time.Time
+}
+
+// Return true, if the feed has not been revoked
+func (f *Feed) Active() bool {
+	return f.RevokedAt.IsZero()
+}
+
+// Bookings listed by the feed
+func (f *Feed) Filter() BookingFilter {
+	if f.RoomId > 0 {
+		return BookingFilter{RoomIds: []int64{f.RoomId}}
+	}
+	return BookingFilter{UserIds: []int64{f.UserId}}
+}
+
+type FeedScan struct {
+	Id        int64
+	UserId    int64         `db:"user_id"`
+	RoomId    sql.NullInt64 `db:"room_id"`
+	TokenHash string        `db:"token_hash"`
+	CreatedAt time.Time     `db:"created_at"`
+	RevokedAt sql.NullTime  `db:"revoked_at"`
+}
+
+func FeedFromScan(s *FeedScan) Feed {
+	return Feed{
+		Id:        s.Id,
+		UserId:    s.UserId,
+		RoomId:    s.RoomId.Int64,
+		TokenHash: s.TokenHash,
+		CreatedAt: s.CreatedAt,
+		RevokedAt: s.RevokedAt.Time,
+	}
+}
+
+type FeedRepository interface {
+	Create(feed Feed) (*Feed, error)
+	GetById(id int64) (*Feed, error)
+	GetByTokenHash(tokenHash string) (*Feed, error)
+	// Returns the feeds of the user that have not been revoked
+	GetAllByUser(userId int64) ([]*Feed, error)
+	Revoke(id int64) error
+}
+
+// Queries shared by the SQLite & Postgres repositories
+type feedRepositorySQL struct {
+	db *sqlx.DB
+}
+
+type FeedRepositorySQLite struct {
+	*feedRepositorySQL
+}
+
+func NewFeedRepositorySQLite(db *sqlx.DB) *FeedRepositorySQLite {
+	return &FeedRepositorySQLite{&feedRepositorySQL{db}}
+}
+
+type FeedRepositoryPostgres struct {
+	*feedRepositorySQL
+}
+
+func NewFeedRepositoryPostgres(db *sqlx.DB) *FeedRepositoryPostgres {
+	return &FeedRepositoryPostgres{&feedRepositorySQL{db}}
+}
+
+const feedSelect = `
+	SELECT
+		id,
+		user_id,
+		room_id,
+		token_hash,
+		created_at,
+		revoked_at
+	FROM
+		feed
+`
+
+func (r *feedRepositorySQL) Create(feed Feed) (*Feed, error) {
+	var roomId sql.NullInt64
+	if feed.RoomId > 0 {
+		var count int
+		if err := r.db.Get(&count, r.db.Rebind(` SELECT COUNT(*) FROM room WHERE id = ?; `), feed.RoomId); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("Room %d %w", feed.RoomId, ErrNotFound)
+		}
+		roomId = sql.NullInt64{Int64: feed.RoomId, Valid: true}
+	}
+	feed.CreatedAt = time.Now().UTC()
+	query := ` INSERT INTO feed ( user_id, room_id, token_hash, created_at ) VALUES (?, ?, ?, ?) RETURNING id; `
+	if err := r.db.Get(&feed.Id, r.db.Rebind(query), feed.UserId, roomId, feed.TokenHash, feed.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (r *feedRepositorySQL) get(where string, arg any, notFound error) (*Feed, error) {
+	var scan FeedScan
+	if err := r.db.QueryRowx(r.db.Rebind(feedSelect+where), arg).StructScan(&scan); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	feed := FeedFromScan(&scan)
+	return &feed, nil
+}
+
+func (r *feedRepositorySQL) GetById(id int64) (*Feed, error) {
+	return r.get(` WHERE id = ?; `, id, fmt.Errorf("Feed %d %w", id, ErrNotFound))
+}
+
+func (r *feedRepositorySQL) GetByTokenHash(tokenHash string) (*Feed, error) {
+	return r.get(` WHERE token_hash = ?; `, tokenHash, fmt.Errorf("Feed %w", ErrNotFound))
+}
+
+func (r *feedRepositorySQL) GetAllByUser(userId int64) ([]*Feed, error) {
+	rows, err := r.db.Queryx(r.db.Rebind(feedSelect+` WHERE user_id = ? AND revoked_at IS NULL ORDER BY id; `), userId)
+	feeds := []*Feed{}
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var scan FeedScan
+		if err := rows.StructScan(&scan); err != nil {
+			return feeds, err
+		}
+		feed := FeedFromScan(&scan)
+		feeds = append(feeds, &feed)
+	}
+	return feeds, rows.Err()
+}
+
+func (r *feedRepositorySQL) Revoke(id int64) error {
+	query := ` UPDATE feed SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL; `
+	res, err := r.db.Exec(r.db.Rebind(query), time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("Feed %d %w", id, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package booking
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Records of the in-memory repositories, which share them like the SQL repositories share a
+// database. Writes are serialized & work on a copy of the records, that replaces the published
+// records once the write succeeded. Readers never wait for writers & never see partial writes
+type MemoryStore struct {
+	// Held by every write & by batches until they end, so conflict checks see all earlier writes
+	write sync.Mutex
+	mu    sync.RWMutex
+	data  *memoryData
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: &memoryData{
+		users:    map[int64]User{},
+		rooms:    map[int64]Room{},
+		bookings: map[int64]Booking{},
+		sessions: map[int64]Session{},
+		feeds:    map[int64]Feed{},
+	}}
+}
+
+// Records are never changed once published. Writes replace them instead, so copying the maps is
+// enough to copy the data
+type memoryData struct {
+	users           map[int64]User
+	rooms           map[int64]Room
+	bookings        map[int64]Booking
+	sessions        map[int64]Session
+	feeds           map[int64]Feed
+	calendarObjects []CalendarObject
+	// Last ID handed out per kind of record. IDs are not reused, like AUTOINCREMENT columns
+	lastIds memoryIds
+}
+
+type memoryIds struct {
+	user    int64
+	room    int64
+	booking int64
+	session int64
+	feed    int64
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:           maps.Clone(d.users),
+		rooms:           maps.Clone(d.rooms),
+		bookings:        maps.Clone(d.bookings),
+		sessions:        maps.Clone(d.sessions),
+		feeds:           maps.Clone(d.feeds),
+		calendarObjects: slices.Clone(d.calendarObjects),
+		lastIds:         d.lastIds,
+	}
+}
+
+// Returns the published records. They must not be changed
+func (s *MemoryStore) read() *memoryData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// Runs fn on a copy of the records, that is published if fn succeeds
+func (s *MemoryStore) update(fn func(d *memoryData) error) error {
+	d := s.begin()
+	defer s.write.Unlock()
+	if err := fn(d); err != nil {
+		return err
+	}
+	s.publish(d)
+	return nil
+}
+
+// Takes the write lock & returns a copy of the records to write to. The caller has to release the lock
+func (s *MemoryStore) begin() *memoryData {
+	s.write.Lock()
+	return s.read().clone()
+}
+
+func (s *MemoryStore) publish(d *memoryData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = d
+}
+
+// Returns the IDs of the map in ascending order
+func sortedIds[T any](records map[int64]T) []int64 {
+	ids := make([]int64, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+type UserRepositoryMemory struct {
+	store *MemoryStore
+}
+
+func NewUserRepositoryMemory(store *MemoryStore) *UserRepositoryMemory {
+	return &UserRepositoryMemory{store}
+}
+
+func (r *UserRepositoryMemory) Create(user User) (*User, error) {
+	if err := validateUser(&user); err != nil {
+		return nil, err
+	}
+	err := r.store.update(func(d *memoryData) error {
+		for _, existing := range d.users {
+			if existing.Name == user.Name {
+				return fmt.Errorf("User '%s' exists already", user.Name)
+			}
+		}
+		d.lastIds.user++
+		user.Id = d.lastIds.user
+		d.users[user.Id] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepositoryMemory) GetAll() ([]*User, error) {
+	d := r.store.read()
+	users := []*User{}
+	for _, id := range sortedIds(d.users) {
+		user := d.users[id]
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+func (r *UserRepositoryMemory) GetById(id int64) (*User, error) {
+	user, ok := r.store.read().users[id]
+	if !ok {
+		return nil, fmt.Errorf("User %d %w", id, ErrNotFound)
+	}
+	return &user, nil
+}
+
+func (r *UserRepositoryMemory) GetByName(name string) (*User, error) {
+	for _, user := range r.store.read().users {
+		if user.Name == name {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("User '%s' %w", name, ErrNotFound)
+}
+
+// Applies fn to the user. Fails, if the user does not exist & mustExist is set
+func (r *UserRepositoryMemory) updateUser(id int64, mustExist bool, fn func(user *User)) error {
+	return r.store.update(func(d *memoryData) error {
+		user, ok := d.users[id]
+		if !ok {
+			if mustExist {
+				return fmt.Errorf("User %d %w", id, ErrNotFound)
+			}
+			return nil
+		}
+		fn(&user)
+		d.users[id] = user
+		return nil
+	})
+}
+
+func (r *UserRepositoryMemory) UpdateRole(id int64, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	return r.updateUser(id, true, func(user *User) { user.Role = role })
+}
+
+func (r *UserRepositoryMemory) UpdatePasswordHash(id int64, passwordHash string) error {
+	return r.updateUser(id, false, func(user *User) { user.PasswordHash = passwordHash })
+}
+
+func (r *UserRepositoryMemory) RecordFailedLogin(id int64, maxFailedLogins int, lockedUntil time.Time) error {
+	return r.updateUser(id, false, func(user *User) {
+		user.FailedLogins++
+		if user.FailedLogins >= maxFailedLogins {
+			user.FailedLogins, user.LockedUntil = 0, lockedUntil.UTC()
+		}
+	})
+}
+
+func (r *UserRepositoryMemory) ResetFailedLogins(id int64) error {
+	return r.updateUser(id, false, func(user *User) {
+		user.FailedLogins, user.LockedUntil = 0, time.Time{}
+	})
+}
+
+func (r *UserRepositoryMemory) UpdateTimeZone(id int64, timeZone string) error {
+	if _, err := LoadTimeZone(timeZone); err != nil {
+		return err
+	}
+	return r.updateUser(id, true, func(user *User) { user.TimeZone = timeZone })
+}
+
+type SessionRepositoryMemory struct {
+	store *MemoryStore
+}
+
+func NewSessionRepositoryMemory(store *MemoryStore) *SessionRepositoryMemory {
+	return &SessionRepositoryMemory{store}
+}
+
+func (r *SessionRepositoryMemory) Create(session Session) (*Session, error) {
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	session.RevokedAt = time.Time{}
+	err := r.store.update(func(d *memoryData) error {
+		if _, ok := d.users[session.UserId]; !ok {
+			return fmt.Errorf("User %d %w", session.UserId, ErrNotFound)
+		}
+		for _, existing := range d.sessions {
+			if existing.TokenHash == session.TokenHash {
+				return fmt.Errorf("Session token exists already")
+			}
+		}
+		d.lastIds.session++
+		session.Id = d.lastIds.session
+		d.sessions[session.Id] = session
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepositoryMemory) GetById(id int64) (*Session, error) {
+	session, ok := r.store.read().sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("Session %d %w", id, ErrNotFound)
+	}
+	return &session, nil
+}
+
+func (r *SessionRepositoryMemory) GetByTokenHash(tokenHash string) (*Session, error) {
+	for _, session := range r.store.read().sessions {
+		if session.TokenHash == tokenHash {
+			return &session, nil
+		}
+	}
+	return nil, fmt.Errorf("Session %w", ErrNotFound)
+}
+
+func (r *SessionRepositoryMemory) Rotate(id int64, previousHash string, tokenHash string, expiresAt time.Time) error {
+	return r.store.update(func(d *memoryData) error {
+		session, ok := d.sessions[id]
+		if !ok || session.TokenHash != previousHash || !session.Active(time.Now()) {
+			return fmt.Errorf("Session %d %w", id, ErrNotFound)
+		}
+		for _, existing := range d.sessions {
+			if existing.Id != id && existing.TokenHash == tokenHash {
+				return fmt.Errorf("Session token exists already")
+			}
+		}
+		session.TokenHash, session.ExpiresAt = tokenHash, expiresAt.UTC()
+		d.sessions[id] = session
+		return nil
+	})
+}
+
+func (r *SessionRepositoryMemory) Revoke(id int64) error {
+	return r.store.update(func(d *memoryData) error {
+		if session, ok := d.sessions[id]; ok && session.RevokedAt.IsZero() {
+			session.RevokedAt = time.Now().UTC()
+			d.sessions[id] = session
+		}
+		return nil
+	})
+}
+
+func (r *SessionRepositoryMemory) RevokeAllForUser(userId int64, exceptId int64) error {
+	return r.store.update(func(d *memoryData) error {
+		for id, session := range d.sessions {
+			if session.UserId == userId && id != exceptId && session.RevokedAt.IsZero() {
+				session.RevokedAt = time.Now().UTC()
+				d.sessions[id] = session
+			}
+		}
+		return nil
+	})
+}
+
+type FeedRepositoryMemory struct {
+	store *MemoryStore
+}
+
+func NewFeedRepositoryMemory(store *MemoryStore) *FeedRepositoryMemory {
+	return &FeedRepositoryMemory{store}
+}
+
+func (r *FeedRepositoryMemory) Create(feed Feed) (*Feed, error) {
+	feed.CreatedAt = time.Now().UTC()
+	feed.RevokedAt = time.Time{}
+	err := r.store.update(func(d *memoryData) error {
+		if _, ok := d.users[feed.UserId]; !ok {
+			return fmt.Errorf("User %d %w", feed.UserId, ErrNotFound)
+		}
+		if _, ok := d.rooms[feed.RoomId]; feed.RoomId > 0 && !ok {
+			return fmt.Errorf("Room %d %w", feed.RoomId, ErrNotFound)
+		}
+		for _, existing := range d.feeds {
+			if existing.TokenHash == feed.TokenHash {
+				return fmt.Errorf("Feed token exists already")
+			}
+		}
+		d.lastIds.feed++
+		feed.Id = d.lastIds.feed
+		d.feeds[feed.Id] = feed
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (r *FeedRepositoryMemory) GetById(id int64) (*Feed, error) {
+	feed, ok := r.store.read().feeds[id]
+	if !ok {
+		return nil, fmt.Errorf("Feed %d %w", id, ErrNotFound)
+	}
+	return &feed, nil
+}
+
+func (r *FeedRepositoryMemory) GetByTokenHash(tokenHash string) (*Feed, error) {
+	for _, feed := range r.store.read().feeds {
+		if feed.TokenHash == tokenHash {
+			return &feed, nil
+		}
+	}
+	return nil, fmt.Errorf("Feed %w", ErrNotFound)
+}
+
+func (r *FeedRepositoryMemory) GetAllByUser(userId int64) ([]*Feed, error) {
+	d := r.store.read()
+	feeds := []*Feed{}
+	for _, id := range sortedIds(d.feeds) {
+		if feed := d.feeds[id]; feed.UserId == userId && feed.Active() {
+			feeds = append(feeds, &feed)
+		}
+	}
+	return feeds, nil
+}
+
+func (r *FeedRepositoryMemory) Revoke(id int64) error {
+	return r.store.update(func(d *memoryData) error {
+		feed, ok := d.feeds[id]
+		if !ok || !feed.Active() {
+			return fmt.Errorf("Feed %d %w", id, ErrNotFound)
+		}
+		feed.RevokedAt = time.Now().UTC()
+		d.feeds[id] = feed
+		return nil
+	})
+}
+
+type CalendarObjectRepositoryMemory struct {
+	store *MemoryStore
+}
+
+func NewCalendarObjectRepositoryMemory(store *MemoryStore) *CalendarObjectRepositoryMemory {
+	return &CalendarObjectRepositoryMemory{store}
+}
+
+// Returns the objects of existing bookings matching fn, ordered by booking
+func (r *CalendarObjectRepositoryMemory) find(fn func(o CalendarObject, b Booking) bool) []*CalendarObject {
+	d := r.store.read()
+	objects := []*CalendarObject{}
+	for _, object := range d.calendarObjects {
+		if b, ok := d.bookings[object.BookingId]; ok && fn(object, b) {
+			objects = append(objects, &object)
+		}
+	}
+	slices.SortFunc(objects, func(a, b *CalendarObject) int { return int(a.BookingId - b.BookingId) })
+	return objects
+}
+
+func (r *CalendarObjectRepositoryMemory) FindByRoom(roomId int64) ([]*CalendarObject, error) {
+	return r.find(func(o CalendarObject, b Booking) bool { return b.Room.Id == roomId }), nil
+}
+
+func (r *CalendarObjectRepositoryMemory) GetByName(name string) (*CalendarObject, error) {
+	objects := r.find(func(o CalendarObject, b Booking) bool { return o.Name == name })
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("Calendar object %s %w", name, ErrNotFound)
+	}
+	return objects[0], nil
+}
+
+func (r *CalendarObjectRepositoryMemory) GetByUid(uid string) (*CalendarObject, error) {
+	objects := r.find(func(o CalendarObject, b Booking) bool { return o.Uid == uid })
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("Calendar object of UID %s %w", uid, ErrNotFound)
+	}
+	return objects[0], nil
+}
@@ -0,0 +1,197 @@
+package booking
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of a page of bookings, if the query does not limit it
+const DefaultPageSize = 50
+
+// Largest page of bookings a query returns
+const MaxPageSize = 500
+
+// Returned if the cursor of a query is malformed or belongs to a query with a different order
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Selects a page of bookings without expanding recurring series. Series come with their excluded dates
+type BookingQuery struct {
+	BookingFilter
+	// Only bookings ending after From & starting before To. Series count until their last
+	// occurrence ends. Zero times do not restrict
+	From time.Time
+	To   time.Time
+	// Only bookings whose title contains the text, ignoring case
+	Search string
+	Status BookingStatus
+	// Defaults to SortByStartTime
+	Sort BookingSort
+	// NextCursor of the previous page. Empty for the first page
+	Cursor string
+	// Maximum number of bookings of the page. Defaults to DefaultPageSize & is capped at MaxPageSize
+	Limit int
+}
+
+type BookingPage struct {
+	Bookings []*Booking
+	// Cursor of the next page. Empty on the last page
+	NextCursor string
+}
+
+// Restricts bookings to those that have not ended yet or those that have
+type BookingStatus string
+
+const (
+	BookingStatusAll BookingStatus = ""
+	// Bookings ending in the future, including series with occurrences left
+	BookingStatusUpcoming BookingStatus = "upcoming"
+	// Bookings & series that have ended
+	BookingStatusPast BookingStatus = "past"
+)
+
+func ParseBookingStatus(s string) (BookingStatus, error) {
+	for _, status := range []BookingStatus{BookingStatusAll, BookingStatusUpcoming, BookingStatusPast} {
+		if string(status) == s {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown booking status '%s'", s)
+}
+
+// Order of queried bookings. Descending orders are prefixed with "-". Bookings with the same
+// sort value are ordered by ID
+type BookingSort string
+
+const (
+	SortByStartTime     BookingSort = "startTime"
+	SortByStartTimeDesc BookingSort = "-startTime"
+	// Titles are compared ignoring case
+	SortByTitle     BookingSort = "title"
+	SortByTitleDesc BookingSort = "-title"
+)
+
+var BookingSorts = []BookingSort{SortByStartTime, SortByStartTimeDesc, SortByTitle, SortByTitleDesc}
+
+// Parses the sort order. Empty strings select SortByStartTime
+func ParseBookingSort(s string) (BookingSort, error) {
+	if len(s) == 0 {
+		return SortByStartTime, nil
+	}
+	for _, sort := range BookingSorts {
+		if string(sort) == s {
+			return sort, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown booking sort '%s'", s)
+}
+
+func (s BookingSort) Descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+func (s BookingSort) byTitle() bool {
+	return strings.TrimPrefix(string(s), "-") == "title"
+}
+
+// Query with its defaults applied & its cursor decoded
+type preparedBookingQuery struct {
+	BookingQuery
+	// Only bookings whose last occurrence ended by then. Zero does not restrict
+	endedBy time.Time
+	// Last booking of the previous page, holding only its ID & sort value
+	after *Booking
+}
+
+// Validates the query & applies its defaults. The status is relative to now
+func (q BookingQuery) prepare(now time.Time) (preparedBookingQuery, error) {
+	prepared := preparedBookingQuery{BookingQuery: q}
+	var err error
+	if prepared.Status, err = ParseBookingStatus(string(q.Status)); err != nil {
+		return prepared, err
+	}
+	if prepared.Sort, err = ParseBookingSort(string(q.Sort)); err != nil {
+		return prepared, err
+	}
+	if prepared.Limit <= 0 {
+		prepared.Limit = DefaultPageSize
+	}
+	prepared.Limit = min(prepared.Limit, MaxPageSize)
+	switch prepared.Status {
+	case BookingStatusUpcoming:
+		if prepared.From.Before(now) {
+			prepared.From = now
+		}
+	case BookingStatusPast:
+		prepared.endedBy = now
+	}
+	if len(q.Cursor) > 0 {
+		if prepared.after, err = decodeBookingCursor(q.Cursor, prepared.Sort); err != nil {
+			return prepared, err
+		}
+	}
+	return prepared, nil
+}
+
+// Cuts the bookings, fetched with one booking more than the limit, to the page
+func (q preparedBookingQuery) page(bookings []*Booking) *BookingPage {
+	if len(bookings) <= q.Limit {
+		return &BookingPage{Bookings: bookings}
+	}
+	bookings = bookings[:q.Limit]
+	return &BookingPage{Bookings: bookings, NextCursor: encodeBookingCursor(bookings[len(bookings)-1], q.Sort)}
+}
+
+// Cursors hold the sort order, the ID & the sort value of the last booking of a page
+func encodeBookingCursor(b *Booking, sort BookingSort) string {
+	value := b.StartTime.UTC().Format(time.RFC3339Nano)
+	if sort.byTitle() {
+		value = b.Title
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d|%s", sort, b.Id, value)))
+}
+
+func decodeBookingCursor(cursor string, sort BookingSort) (*Booking, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(decoded), "|", 3)
+	if len(parts) != 3 || parts[0] != string(sort) {
+		return nil, ErrInvalidCursor
+	}
+	b := Booking{}
+	if b.Id, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if sort.byTitle() {
+		b.Title = parts[2]
+	} else if b.StartTime, err = time.Parse(time.RFC3339Nano, parts[2]); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &b, nil
+}
+
+// Compares bookings in the order of the sort
+func compareBookings(a *Booking, b *Booking, sort BookingSort) int {
+	c := a.StartTime.Compare(b.StartTime)
+	if sort.byTitle() {
+		c = strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	}
+	if c == 0 {
+		c = cmp.Compare(a.Id, b.Id)
+	}
+	if sort.Descending() {
+		return -c
+	}
+	return c
+}
+
+// Escapes the LIKE wildcards of the text, for patterns using "\" as escape character
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
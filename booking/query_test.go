@@ -0,0 +1,138 @@
+package booking
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Collects the IDs of all pages of the query
+func queryAllPages(t *testing.T, repo BookingRepository, query BookingQuery) []int64 {
+	ids := []int64{}
+	for pages := 0; pages < 10; pages++ {
+		page, err := repo.Query(query)
+		if err != nil {
+			t.Fatalf("Unable to query bookings: %s", err)
+		}
+		if len(page.Bookings) > query.Limit {
+			t.Fatalf("Expected at most %d bookings, received %d", query.Limit, len(page.Bookings))
+		}
+		for _, b := range page.Bookings {
+			ids = append(ids, b.Id)
+		}
+		if len(page.NextCursor) == 0 {
+			return ids
+		}
+		query.Cursor = page.NextCursor
+	}
+	t.Fatalf("Expected the query to end, received %v", ids)
+	return nil
+}
+
+func testQuery_PagesThroughBookingsInOrder(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Bookings
+	startDate, _ := time.Parse(layout, "2024-07-08 12:00")
+	bookings := []struct {
+		title  string
+		offset time.Duration
+	}{{"beta", 2 * time.Hour}, {"Alpha", 0}, {"gamma", time.Hour}, {"alpha", 3 * time.Hour}, {"", 4 * time.Hour}}
+	for _, b := range bookings {
+		if _, err := repo.Create(Booking{Title: b.title, Room: Room{Id: 1}, User: User{Id: 1}, StartTime: startDate.Add(b.offset), EndTime: startDate.Add(b.offset + time.Hour)}); err != nil {
+			t.Fatalf("Unable to create booking: %s", err)
+		}
+	}
+	orders := []struct {
+		sort     BookingSort
+		expected []int64
+	}{
+		{"", []int64{2, 3, 1, 4, 5}},
+		{SortByStartTimeDesc, []int64{5, 4, 1, 3, 2}},
+		// Titles ignore case & are ordered by ID if they are equal
+		{SortByTitle, []int64{5, 2, 4, 1, 3}},
+		{SortByTitleDesc, []int64{3, 1, 4, 2, 5}},
+	}
+	for _, order := range orders {
+		if ids := queryAllPages(t, repo, BookingQuery{Sort: order.sort, Limit: 2}); !slices.Equal(ids, order.expected) {
+			t.Errorf("Expected bookings %v sorted by '%s', received %v", order.expected, order.sort, ids)
+		}
+	}
+	page, err := repo.Query(BookingQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Query(BookingQuery{Sort: SortByTitle, Cursor: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("Expected invalid cursor error for cursor of other order, received '%v'", err)
+	}
+	if _, err := repo.Query(BookingQuery{Cursor: "garbage"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("Expected invalid cursor error, received '%v'", err)
+	}
+	if _, err := repo.Query(BookingQuery{Sort: "room"}); err == nil {
+		t.Fatal("Expected error for unknown sort")
+	}
+}
+
+func testQuery_FiltersByTimeSearchStatusAndFilter(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Bookings
+	other, err := repos.Users.Create(User{Name: "Other user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(value string) time.Time {
+		res, _ := time.Parse(layout, value)
+		return res
+	}
+	weekly := func(count int) *Recurrence {
+		return &Recurrence{Frequency: FrequencyWeekly, Interval: 1, Count: count}
+	}
+	bookings := []Booking{
+		{Title: "Past review", StartTime: parse("2020-01-06 09:00"), EndTime: parse("2020-01-06 10:00"), User: User{Id: 1}},
+		// Ends on 2020-02-03
+		{Title: "Ended standup", StartTime: parse("2020-01-06 10:00"), EndTime: parse("2020-01-06 11:00"), Recurrence: weekly(5), User: User{Id: 1}},
+		{Title: "Weekly sync", StartTime: parse("2020-01-06 12:00"), EndTime: parse("2020-01-06 13:00"), Recurrence: weekly(0), User: User{Id: 1}},
+		{Title: "Budget 100% review", StartTime: parse("2100-01-04 09:00"), EndTime: parse("2100-01-04 10:00"), User: User{Id: other.Id}},
+		{Title: "Budget 1000 review", StartTime: parse("2100-01-05 09:00"), EndTime: parse("2100-01-05 10:00"), User: User{Id: 1}},
+	}
+	for _, b := range bookings {
+		b.Room = Room{Id: 1}
+		if _, err := repo.Create(b); err != nil {
+			t.Fatalf("Unable to create booking: %s", err)
+		}
+	}
+	queries := []struct {
+		name     string
+		query    BookingQuery
+		expected []int64
+	}{
+		{"all", BookingQuery{}, []int64{1, 2, 3, 4, 5}},
+		{"range", BookingQuery{From: parse("2020-02-01 00:00"), To: parse("2020-03-01 00:00")}, []int64{2, 3}},
+		{"range after series ended", BookingQuery{From: parse("2020-02-04 00:00"), To: parse("2100-01-05 00:00")}, []int64{3, 4}},
+		{"search ignoring case & wildcards", BookingQuery{Search: "100%"}, []int64{4}},
+		{"search", BookingQuery{Search: "REVIEW"}, []int64{1, 4, 5}},
+		{"upcoming", BookingQuery{Status: BookingStatusUpcoming}, []int64{3, 4, 5}},
+		{"past", BookingQuery{Status: BookingStatusPast}, []int64{1, 2}},
+		{"user", BookingQuery{BookingFilter: BookingFilter{UserIds: []int64{other.Id}}}, []int64{4}},
+		{"room", BookingQuery{BookingFilter: BookingFilter{RoomIds: []int64{2}}}, []int64{}},
+	}
+	for _, q := range queries {
+		q.query.Limit = 2
+		if ids := queryAllPages(t, repo, q.query); !slices.Equal(ids, q.expected) {
+			t.Errorf("Expected bookings %v for query %s, received %v", q.expected, q.name, ids)
+		}
+	}
+	// Series come with their excluded dates
+	if err := repo.CancelOccurrence(3, parse("2020-01-13 12:00")); err != nil {
+		t.Fatal(err)
+	}
+	page, err := repo.Query(BookingQuery{Search: "weekly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Bookings) != 1 || len(page.Bookings[0].Recurrence.ExDates) != 1 {
+		t.Fatalf("Expected series with excluded date, received %v", page.Bookings)
+	}
+	if _, err := repo.Query(BookingQuery{Status: "someday"}); err == nil {
+		t.Fatal("Expected error for unknown status")
+	}
+}
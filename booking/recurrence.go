@@ -0,0 +1,271 @@
+package booking
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	FrequencyDaily   Frequency = "DAILY"
+	FrequencyWeekly  Frequency = "WEEKLY"
+	FrequencyMonthly Frequency = "MONTHLY"
+)
+
+// Series without COUNT or UNTIL are only expanded this far for conflict checks
+const recurrenceHorizon = 2 * 365 * 24 * time.Hour
+
+// Upper bound of occurrences of a series. Longer series are rejected
+const maxOccurrences = 5000
+
+// Later than any occurrence of a series limited by count
+var endOfSeries = time.Unix(1<<62, 0)
+
+// Layout of UNTIL values and excluded dates in the RRULE representation
+const recurrenceTimeLayout = "20060102T150405Z"
+
+var weekdayCodes = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// Subset of the RFC 5545 recurrence rule (RRULE)
+type Recurrence struct {
+	Frequency Frequency
+	// Number of frequency units between two occurrences. Defaults to 1
+	Interval int
+	// Weekdays the series occurs on. Weekly series default to the weekday of the series start
+	ByDay []time.Weekday
+	// Maximum number of occurrences. 0 means unlimited
+	Count int
+	// Last point in time an occurrence may start. Zero means unlimited
+	Until time.Time
+	// Starts of occurrences that have been cancelled or replaced by an exception
+	ExDates []time.Time
+}
+
+// Parses a rule in RRULE notation, e.g. "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10"
+func ParseRecurrence(rule string) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("Invalid recurrence rule part '%s'", part)
+		}
+		switch key {
+		case "FREQ":
+			r.Frequency = Frequency(value)
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid recurrence interval '%s'", value)
+			}
+			r.Interval = interval
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				weekday, err := parseWeekdayCode(code)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid recurrence count '%s'", value)
+			}
+			r.Count = count
+		case "UNTIL":
+			until, err := time.Parse(recurrenceTimeLayout, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid recurrence end '%s'", value)
+			}
+			r.Until = until
+		default:
+			return nil, fmt.Errorf("Unsupported recurrence rule part '%s'", key)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func parseWeekdayCode(code string) (time.Weekday, error) {
+	for weekday, c := range weekdayCodes {
+		if c == code {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid weekday '%s'", code)
+}
+
+func (r *Recurrence) Validate() error {
+	switch r.Frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return fmt.Errorf("Unsupported recurrence frequency '%s'", r.Frequency)
+	}
+	if r.Interval < 1 {
+		return fmt.Errorf("Recurrence interval must be at least 1")
+	}
+	if r.Count < 0 {
+		return fmt.Errorf("Recurrence count cannot be negative")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return fmt.Errorf("Recurrence cannot be limited by count and end date at the same time")
+	}
+	if r.Count > maxOccurrences {
+		return errSeriesTooLong
+	}
+	return nil
+}
+
+var errSeriesTooLong = fmt.Errorf("Recurring series is too long, it may have at most %d occurrences", maxOccurrences)
+
+// Rejects series beginning at seriesStart with more than maxOccurrences occurrences. Series
+// without end are only expanded up to recurrenceHorizon, which never yields that many
+func (r *Recurrence) validateLength(seriesStart time.Time) error {
+	if r.Until.IsZero() {
+		return nil
+	}
+	rule := *r
+	rule.ExDates = nil
+	if len(rule.occurrences(seriesStart, r.Until.Add(time.Second), maxOccurrences+1)) > maxOccurrences {
+		return errSeriesTooLong
+	}
+	return nil
+}
+
+// Serializes the rule into RRULE notation. Excluded dates are not part of the rule
+func (r *Recurrence) String() string {
+	parts := []string{fmt.Sprintf("FREQ=%s", r.Frequency)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, len(r.ByDay))
+		for idx, weekday := range r.ByDay {
+			codes[idx] = weekdayCodes[weekday]
+		}
+		parts = append(parts, fmt.Sprintf("BYDAY=%s", strings.Join(codes, ",")))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, fmt.Sprintf("UNTIL=%s", r.Until.UTC().Format(recurrenceTimeLayout)))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Returns true, if the series ends by count or end date
+func (r *Recurrence) Bounded() bool {
+	return r.Count > 0 || !r.Until.IsZero()
+}
+
+// Returns the starts of all occurrences of a series beginning at seriesStart, that start before end.
+// Excluded dates are skipped, but still count towards COUNT (RFC 5545)
+func (r *Recurrence) Occurrences(seriesStart time.Time, end time.Time) []time.Time {
+	return r.occurrences(seriesStart, end, maxOccurrences)
+}
+
+// Returns at most limit occurrences, counting excluded dates
+func (r *Recurrence) occurrences(seriesStart time.Time, end time.Time, limit int) []time.Time {
+	occurrences := []time.Time{}
+	// Daily series stepping whole weeks stay on the weekday of their start
+	if r.Frequency == FrequencyDaily && r.Interval%7 == 0 && len(r.ByDay) > 0 && !slices.Contains(r.ByDay, seriesStart.Weekday()) {
+		return occurrences
+	}
+	generated := 0
+	// Periods without a matching day yield no candidates, but every other rule has a following
+	// period with candidates
+	for period := 0; generated < limit; period++ {
+		candidates := r.periodCandidates(seriesStart, period)
+		// All following periods start later, so we can stop here
+		if len(candidates) > 0 && !candidates[0].Before(end) {
+			break
+		}
+		for _, candidate := range candidates {
+			if candidate.Before(seriesStart) {
+				continue
+			}
+			if !candidate.Before(end) || (r.Count > 0 && generated >= r.Count) || generated >= limit {
+				return occurrences
+			}
+			if !r.Until.IsZero() && candidate.After(r.Until) {
+				return occurrences
+			}
+			generated++
+			if !r.excluded(candidate) {
+				occurrences = append(occurrences, candidate)
+			}
+		}
+	}
+	return occurrences
+}
+
+func (r *Recurrence) excluded(t time.Time) bool {
+	for _, exDate := range r.ExDates {
+		if exDate.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Candidate occurrence starts of the n-th period of the series, in chronological order
+func (r *Recurrence) periodCandidates(seriesStart time.Time, period int) []time.Time {
+	hour, minute, second := seriesStart.Clock()
+	loc := seriesStart.Location()
+	step := period * r.Interval
+	switch r.Frequency {
+	case FrequencyDaily:
+		day := time.Date(seriesStart.Year(), seriesStart.Month(), seriesStart.Day()+step, hour, minute, second, 0, loc)
+		if len(r.ByDay) > 0 && !slices.Contains(r.ByDay, day.Weekday()) {
+			return []time.Time{}
+		}
+		return []time.Time{day}
+	case FrequencyWeekly:
+		weekdays := r.ByDay
+		if len(weekdays) == 0 {
+			weekdays = []time.Weekday{seriesStart.Weekday()}
+		}
+		// Weeks start on Monday (RFC 5545 default WKST)
+		offsetToMonday := (int(seriesStart.Weekday()) + 6) % 7
+		monday := time.Date(seriesStart.Year(), seriesStart.Month(), seriesStart.Day()-offsetToMonday+7*step, hour, minute, second, 0, loc)
+		candidates := make([]time.Time, 0, len(weekdays))
+		for _, weekday := range weekdays {
+			candidates = append(candidates, monday.AddDate(0, 0, (int(weekday)+6)%7))
+		}
+		slices.SortFunc(candidates, func(a, b time.Time) int { return a.Compare(b) })
+		return candidates
+	case FrequencyMonthly:
+		firstOfMonth := time.Date(seriesStart.Year(), seriesStart.Month()+time.Month(step), 1, hour, minute, second, 0, loc)
+		if len(r.ByDay) == 0 {
+			day := firstOfMonth.AddDate(0, 0, seriesStart.Day()-1)
+			// Months without the day of the series start are skipped (e.g. 31st)
+			if day.Month() != firstOfMonth.Month() {
+				return []time.Time{}
+			}
+			return []time.Time{day}
+		}
+		candidates := []time.Time{}
+		for day := firstOfMonth; day.Month() == firstOfMonth.Month(); day = day.AddDate(0, 0, 1) {
+			if slices.Contains(r.ByDay, day.Weekday()) {
+				candidates = append(candidates, day)
+			}
+		}
+		return candidates
+	}
+	return []time.Time{}
+}
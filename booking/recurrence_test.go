@@ -0,0 +1,240 @@
+package booking
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence_RoundTrips(t *testing.T) {
+	rule := "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10"
+	r, err := ParseRecurrence(rule)
+	if err != nil {
+		t.Fatalf("Unable to parse rule '%s': %s", rule, err)
+	}
+	if r.String() != rule {
+		t.Fatalf("Expected '%s', received '%s'", rule, r.String())
+	}
+}
+
+func TestOccurrences_WeeklyByDayWithCount(t *testing.T) {
+	// Wednesday
+	start, _ := time.Parse(layout, "2024-07-03 09:00")
+	r := Recurrence{Frequency: FrequencyWeekly, Interval: 1, ByDay: []time.Weekday{time.Monday, time.Wednesday}, Count: 3}
+	end, _ := time.Parse(layout, "2025-01-01 00:00")
+
+	res := r.Occurrences(start, end)
+
+	expected := []string{"2024-07-03 09:00", "2024-07-08 09:00", "2024-07-10 09:00"}
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %d occurrences, received %v", len(expected), res)
+	}
+	for idx, e := range expected {
+		if res[idx].Format(layout) != e {
+			t.Fatalf("Expected occurrence %d at '%s', received '%s'", idx, e, res[idx].Format(layout))
+		}
+	}
+}
+
+func TestOccurrences_ExDatesCountTowardsCount(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-07-01 09:00")
+	exDate, _ := time.Parse(layout, "2024-07-02 09:00")
+	r := Recurrence{Frequency: FrequencyDaily, Interval: 1, Count: 3, ExDates: []time.Time{exDate}}
+	end, _ := time.Parse(layout, "2025-01-01 00:00")
+
+	res := r.Occurrences(start, end)
+
+	if len(res) != 2 || res[1].Format(layout) != "2024-07-03 09:00" {
+		t.Fatalf("Expected excluded occurrence to be skipped, received %v", res)
+	}
+}
+
+func TestOccurrences_MonthlySkipsShortMonths(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-01-31 09:00")
+	until, _ := time.Parse(layout, "2024-05-31 23:59")
+	r := Recurrence{Frequency: FrequencyMonthly, Interval: 1, Until: until}
+	end, _ := time.Parse(layout, "2025-01-01 00:00")
+
+	res := r.Occurrences(start, end)
+
+	expected := []string{"2024-01-31 09:00", "2024-03-31 09:00", "2024-05-31 09:00"}
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %d occurrences, received %v", len(expected), res)
+	}
+	for idx, e := range expected {
+		if res[idx].Format(layout) != e {
+			t.Fatalf("Expected occurrence %d at '%s', received '%s'", idx, e, res[idx].Format(layout))
+		}
+	}
+}
+
+func TestOccurrences_ExpandsSeriesWithRarelyMatchingPeriods(t *testing.T) {
+	// Monday
+	start, _ := time.Parse(layout, "2024-07-01 09:00")
+	r := Recurrence{Frequency: FrequencyDaily, Interval: 1, ByDay: []time.Weekday{time.Monday}, Count: 1000}
+	if res := r.Occurrences(start, endOfSeries); len(res) != 1000 {
+		t.Fatalf("Expected 1000 occurrences, received %d", len(res))
+	}
+	// Every 7 days, but never on a Monday
+	r = Recurrence{Frequency: FrequencyDaily, Interval: 7, ByDay: []time.Weekday{time.Tuesday}, Count: 3}
+	if res := r.Occurrences(start, endOfSeries); len(res) != 0 {
+		t.Fatalf("Expected no occurrences, received %v", res)
+	}
+}
+
+func TestLastEnd_IncludesAllOccurrencesOfLongSeries(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-01-15 09:00")
+	b := Booking{Id: 1, StartTime: start, EndTime: start.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyMonthly, Interval: 1, Count: 200}}
+
+	if received := b.LastEnd().Format(layout); received != "2040-08-15 10:00" {
+		t.Fatalf("Expected the 200th occurrence to end on '2040-08-15 10:00', received '%s'", received)
+	}
+}
+
+func TestValidateBooking_RejectsTooLongSeries(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-07-01 09:00")
+	tooLong := []*Recurrence{
+		{Frequency: FrequencyDaily, Interval: 1, Count: maxOccurrences + 1},
+		{Frequency: FrequencyDaily, Interval: 1, Until: start.AddDate(0, 0, maxOccurrences)},
+	}
+	for _, r := range tooLong {
+		b := Booking{StartTime: start, EndTime: start.Add(time.Hour), Recurrence: r}
+		if err := validateBooking(&b); err == nil || !strings.Contains(err.Error(), "too long") {
+			t.Errorf("Expected series %s to be too long, received %v", r, err)
+		}
+	}
+	allowed := []*Recurrence{
+		{Frequency: FrequencyDaily, Interval: 1, Count: maxOccurrences},
+		{Frequency: FrequencyDaily, Interval: 1, Until: start.AddDate(0, 0, maxOccurrences-1)},
+		{Frequency: FrequencyDaily, Interval: 1},
+	}
+	for _, r := range allowed {
+		b := Booking{StartTime: start, EndTime: start.Add(time.Hour), Recurrence: r}
+		if err := validateBooking(&b); err != nil {
+			t.Errorf("Expected series %s to be valid, received %s", r, err)
+		}
+	}
+	if _, err := ParseRecurrence(fmt.Sprintf("FREQ=WEEKLY;COUNT=%d", maxOccurrences+1)); err == nil {
+		t.Error("Expected rule exceeding the occurrences to be rejected")
+	}
+}
+
+func TestOccurrencesWithin_ExpandsSeriesIntoInterval(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-07-01 09:00")
+	end, _ := time.Parse(layout, "2024-07-01 10:00")
+	b := Booking{Id: 1, StartTime: start, EndTime: end, Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1}}
+
+	filterStart, _ := time.Parse(layout, "2024-07-15 08:00")
+	filterEnd, _ := time.Parse(layout, "2024-07-15 17:00")
+	res := b.OccurrencesWithin(&filterStart, &filterEnd)
+
+	if len(res) != 1 {
+		t.Fatalf("Expected 1 occurrence, received %d", len(res))
+	}
+	if res[0].StartTime.Format(layout) != "2024-07-15 09:00" || !res[0].IsOccurrence() {
+		t.Fatalf("Expected occurrence at '2024-07-15 09:00', received '%s'", &res[0])
+	}
+}
+
+func TestOccurrencesWithin_KeepsLocalTimeOfRoomAcrossDST(t *testing.T) {
+	// Daylight saving time in Europe/Berlin starts on 2024-03-31 and ends on 2024-10-27
+	tests := []struct {
+		seriesStart string
+		occurrence  string
+	}{
+		{"2024-03-25 08:00", "2024-04-01 07:00"},
+		{"2024-10-21 07:00", "2024-10-28 08:00"},
+	}
+	for _, tt := range tests {
+		start, _ := time.Parse(layout, tt.seriesStart)
+		b := Booking{Id: 1, Room: Room{TimeZone: "Europe/Berlin"}, StartTime: start, EndTime: start.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1}}
+		filterStart := start.AddDate(0, 0, 7).Add(-3 * time.Hour)
+		filterEnd := filterStart.Add(6 * time.Hour)
+		res := b.OccurrencesWithin(&filterStart, &filterEnd)
+
+		if len(res) != 1 {
+			t.Fatalf("Expected 1 occurrence, received %d", len(res))
+		}
+		// 09:00 local time before & after the change
+		if received := res[0].StartTime.UTC().Format(layout); received != tt.occurrence {
+			t.Fatalf("Expected occurrence at %s UTC, received %s UTC", tt.occurrence, received)
+		}
+		if _, err := b.Occurrence(res[0].StartTime); err != nil {
+			t.Fatalf("Expected occurrence to be found by its start, received %s", err)
+		}
+	}
+}
+
+func TestTimeFromDateAndTime_ConvertsOnDSTChangeDays(t *testing.T) {
+	berlin, err := LoadTimeZone("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		date     string
+		time     string
+		expected string
+	}{
+		{"2024-03-30", "09:00", "2024-03-30 08:00"},
+		{"2024-03-31", "01:30", "2024-03-31 00:30"},
+		{"2024-03-31", "09:00", "2024-03-31 07:00"},
+		{"2024-10-27", "01:30", "2024-10-26 23:30"},
+		{"2024-10-27", "09:00", "2024-10-27 08:00"},
+	}
+	for _, tt := range tests {
+		res, err := TimeFromDateAndTime(tt.date, tt.time, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if received := res.UTC().Format(layout); received != tt.expected {
+			t.Errorf("Expected %s %s Berlin to be %s UTC, received %s UTC", tt.date, tt.time, tt.expected, received)
+		}
+	}
+}
+
+func TestLoadTimeZone_RejectsUnknownZones(t *testing.T) {
+	for _, name := range []string{"Mars/Olympus", "Local", "+02:00"} {
+		if _, err := LoadTimeZone(name); err == nil {
+			t.Errorf("Expected time zone '%s' to be rejected", name)
+		}
+	}
+	if loc, err := LoadTimeZone(""); err != nil || loc != time.UTC {
+		t.Errorf("Expected empty time zone to default to UTC, received %v", loc)
+	}
+}
+
+func TestSplitAt_ContinuesSeriesAfterSplit(t *testing.T) {
+	start, _ := time.Parse(layout, "2024-07-01 09:00")
+	excluded, _ := time.Parse(layout, "2024-07-22 09:00")
+	split, _ := time.Parse(layout, "2024-07-16 12:00")
+	b := Booking{Id: 1, StartTime: start, EndTime: start.Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1, Count: 6, ExDates: []time.Time{excluded}}}
+
+	past, future := b.SplitAt(split)
+	if past == nil || future == nil {
+		t.Fatalf("Expected both parts, received %v & %v", past, future)
+	}
+	// 07-01, 07-08 & 07-15 stay with the series, 07-22 is excluded
+	if received := past.Recurrence.String(); received != "FREQ=WEEKLY;UNTIL=20240715T090000Z" {
+		t.Fatalf("Expected past series to end on 07-15, received %s", received)
+	}
+	if future.Id != 0 || future.StartTime.Format(layout) != "2024-07-29 09:00" || future.Duration() != time.Hour {
+		t.Fatalf("Expected new series starting on 07-29, received %s", future)
+	}
+	// 07-29 & 08-05 remain of the 6 occurrences
+	if future.Recurrence.Count != 2 || len(future.Recurrence.ExDates) != 0 {
+		t.Fatalf("Expected 2 remaining occurrences, received %s", future.Recurrence)
+	}
+	if b.Recurrence.Count != 6 {
+		t.Fatal("Expected the original series to remain unchanged")
+	}
+
+	// Series on one side of the split are not split
+	if past, future := b.SplitAt(start); past != nil || future != &b {
+		t.Fatalf("Expected series to lie after the split, received %v & %v", past, future)
+	}
+	end, _ := time.Parse(layout, "2024-09-01 00:00")
+	if past, future := b.SplitAt(end); past == nil || future != nil {
+		t.Fatalf("Expected series to lie before the split, received %v & %v", past, future)
+	}
+}
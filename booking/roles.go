@@ -0,0 +1,53 @@
+package booking
+
+import "fmt"
+
+// Role of a user, deciding which changes the user is allowed to make
+type Role string
+
+const (
+	// Manages rooms and users, and may change any booking
+	RoleAdmin Role = "admin"
+	// May change the bookings of every user
+	RoleRoomManager Role = "room_manager"
+	// May book rooms and change their own bookings
+	RoleMember Role = "member"
+	// May only view rooms & bookings
+	RoleReadOnly Role = "read_only"
+)
+
+var Roles = []Role{RoleAdmin, RoleRoomManager, RoleMember, RoleReadOnly}
+
+func ParseRole(s string) (Role, error) {
+	for _, role := range Roles {
+		if string(role) == s {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown role '%s'", s)
+}
+
+func (r Role) CanManageRooms() bool {
+	return r == RoleAdmin
+}
+
+func (r Role) CanManageUsers() bool {
+	return r == RoleAdmin
+}
+
+func (r Role) CanBook() bool {
+	return r == RoleAdmin || r == RoleRoomManager || r == RoleMember
+}
+
+// Return true, if the role may change bookings of other users
+func (r Role) CanManageAllBookings() bool {
+	return r == RoleAdmin || r == RoleRoomManager
+}
+
+// Return true, if the user may create, change or delete the given booking
+func (u *User) CanChangeBooking(b *Booking) bool {
+	if !u.Role.CanBook() {
+		return false
+	}
+	return u.Role.CanManageAllBookings() || b.User.Id == u.Id
+}
@@ -0,0 +1,39 @@
+package booking
+
+import "testing"
+
+func TestUser_CanChangeBooking(t *testing.T) {
+	own := &Booking{User: User{Id: 1}}
+	foreign := &Booking{User: User{Id: 2}}
+	tests := []struct {
+		role    Role
+		own     bool
+		foreign bool
+	}{
+		{RoleAdmin, true, true},
+		{RoleRoomManager, true, true},
+		{RoleMember, true, false},
+		{RoleReadOnly, false, false},
+	}
+	for _, tt := range tests {
+		user := User{Id: 1, Role: tt.role}
+		if res := user.CanChangeBooking(own); res != tt.own {
+			t.Errorf("Expected %s to change own booking: %t, received %t", tt.role, tt.own, res)
+		}
+		if res := user.CanChangeBooking(foreign); res != tt.foreign {
+			t.Errorf("Expected %s to change foreign booking: %t, received %t", tt.role, tt.foreign, res)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range Roles {
+		res, err := ParseRole(string(role))
+		if err != nil || res != role {
+			t.Fatalf("Expected %s, received %s (%v)", role, res, err)
+		}
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Fatalf("Expected unknown role to be rejected")
+	}
+}
@@ -2,96 +2,593 @@ package booking
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// Equipment offered as choices when editing rooms. Rooms may be tagged with any other equipment
+var CommonEquipment = []string{"projector", "video-conference", "whiteboard"}
+
+// Accessibility features rooms can be flagged with
+var AccessibilityFeatures = []string{"wheelchair-accessible", "step-free-access", "hearing-loop"}
+
 type Room struct {
 	Id    int64
 	Title string
+	// IANA time zone the room is located in, e.g. "Europe/Berlin"
+	TimeZone string
+	// Maximum number of attendees. 0 if unknown
+	Capacity    int
+	Building    string
+	Floor       string
+	Description string
+	// Normalized tags, e.g. "projector", ordered alphabetically
+	Equipment []string
+	// Subset of AccessibilityFeatures, ordered alphabetically
+	Accessibility []string
+	// Absolute http(s) URL of a photo of the room. Empty if there is none
+	ImageUrl string
+	// Set once the room has been deleted. Deleted rooms keep their past bookings, but cannot be
+	// booked or changed anymore
+	ArchivedAt time.Time
+}
+
+// Returns the time zone of the room. Falls back to UTC if none or an invalid one is set
+func (r Room) Location() *time.Location {
+	return locationOrUTC(r.TimeZone)
+}
+
+// Return true, if the room fits the number of attendees. Rooms of unknown capacity fit everyone
+func (r Room) Fits(attendees int) bool {
+	return r.Capacity == 0 || attendees <= r.Capacity
+}
+
+// Validates the room & normalizes its tags
+func (r *Room) Validate() error {
+	if len(strings.TrimSpace(r.Title)) == 0 {
+		return fmt.Errorf("Title cannot be empty")
+	}
+	if _, err := LoadTimeZone(r.TimeZone); err != nil {
+		return err
+	}
+	if r.Capacity < 0 {
+		return fmt.Errorf("Capacity cannot be negative")
+	}
+	r.Equipment = NormalizeTags(r.Equipment)
+	r.Accessibility = NormalizeTags(r.Accessibility)
+	for _, feature := range r.Accessibility {
+		if !slices.Contains(AccessibilityFeatures, feature) {
+			return fmt.Errorf("Unknown accessibility feature '%s'", feature)
+		}
+	}
+	if len(r.ImageUrl) > 0 {
+		u, err := url.Parse(r.ImageUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("Image has to be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// Lowercases tags & replaces everything but letters & digits by dashes, e.g. "Video Conference"
+// becomes "video-conference". Returns the distinct, non-empty tags in alphabetical order
+func NormalizeTags(tags []string) []string {
+	res := []string{}
+	for _, tag := range tags {
+		normalized := strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '-'
+		}, strings.ToLower(strings.TrimSpace(tag)))
+		normalized = strings.Trim(normalized, "-")
+		if len(normalized) > 0 && !slices.Contains(res, normalized) {
+			res = append(res, normalized)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// Splits a comma separated list of tags
+func ParseTags(s string) []string {
+	return NormalizeTags(strings.Split(s, ","))
+}
+
+// Restricts rooms to the ones having all given attributes. Zero values do not restrict
+type RoomFilter struct {
+	MinCapacity int
+	// Compared case-insensitively
+	Building      string
+	Equipment     []string
+	Accessibility []string
+}
+
+func (f RoomFilter) IsEmpty() bool {
+	return f.MinCapacity == 0 && len(f.Building) == 0 && len(f.Equipment) == 0 && len(f.Accessibility) == 0
+}
+
+// Return true, if the room has all attributes of the filter. Rooms of unknown capacity do not
+// match a minimum capacity
+func (f RoomFilter) Matches(room *Room) bool {
+	if f.MinCapacity > 0 && room.Capacity < f.MinCapacity {
+		return false
+	}
+	if len(f.Building) > 0 && !strings.EqualFold(f.Building, room.Building) {
+		return false
+	}
+	for _, tag := range NormalizeTags(f.Equipment) {
+		if !slices.Contains(room.Equipment, tag) {
+			return false
+		}
+	}
+	for _, tag := range NormalizeTags(f.Accessibility) {
+		if !slices.Contains(room.Accessibility, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the SQL condition on the room table aliased as r, starting with " AND", & its arguments
+func (f RoomFilter) sqlCondition() (string, []interface{}) {
+	condition := ""
+	args := []interface{}{}
+	if f.MinCapacity > 0 {
+		condition += " AND r.capacity >= ?"
+		args = append(args, f.MinCapacity)
+	}
+	if len(f.Building) > 0 {
+		condition += " AND LOWER(r.building) = LOWER(?)"
+		args = append(args, f.Building)
+	}
+	// Tags are stored comma separated & cannot contain commas or LIKE wildcards themselves
+	tagColumns := []struct {
+		name string
+		tags []string
+	}{{"r.equipment", f.Equipment}, {"r.accessibility", f.Accessibility}}
+	for _, column := range tagColumns {
+		for _, tag := range NormalizeTags(column.tags) {
+			condition += " AND (',' || " + column.name + " || ',') LIKE ?"
+			args = append(args, "%,"+tag+",%")
+		}
+	}
+	return condition, args
 }
 
 type RoomScan struct {
-	Id    int64
-	Title sql.NullString
+	Id            int64
+	Title         sql.NullString
+	TimeZone      sql.NullString `db:"time_zone"`
+	Capacity      sql.NullInt64
+	Building      sql.NullString
+	Floor         sql.NullString
+	Description   sql.NullString
+	Equipment     sql.NullString
+	Accessibility sql.NullString
+	ImageUrl      sql.NullString `db:"image_url"`
+	ArchivedAt    sql.NullTime   `db:"archived_at"`
 }
 
 func RoomFromScan(s *RoomScan) Room {
-	return Room{Id: s.Id, Title: s.Title.String}
+	return Room{
+		Id:            s.Id,
+		Title:         s.Title.String,
+		TimeZone:      s.TimeZone.String,
+		Capacity:      int(s.Capacity.Int64),
+		Building:      s.Building.String,
+		Floor:         s.Floor.String,
+		Description:   s.Description.String,
+		Equipment:     ParseTags(s.Equipment.String),
+		Accessibility: ParseTags(s.Accessibility.String),
+		ImageUrl:      s.ImageUrl.String,
+		ArchivedAt:    s.ArchivedAt.Time,
+	}
+}
+
+// What happens to the future bookings of a room when it is deleted. Bookings that started before
+// are kept in the deleted room
+type RoomDeletePolicy string
+
+const (
+	// Rooms with future bookings cannot be deleted
+	RoomDeleteBlock RoomDeletePolicy = "block"
+	// Future bookings are cancelled. Recurring series end with their last occurrence before
+	RoomDeleteCascade RoomDeletePolicy = "cascade"
+	// Future bookings move to the first other room that fits their attendees & is free for all
+	// of their occurrences. Fails, if there is no such room for any of them
+	RoomDeleteReassign RoomDeletePolicy = "reassign"
+)
+
+// Parses a policy as given in the configuration. Defaults to RoomDeleteBlock
+func ParseRoomDeletePolicy(s string) (RoomDeletePolicy, error) {
+	switch policy := RoomDeletePolicy(strings.ToLower(s)); policy {
+	case "":
+		return RoomDeleteBlock, nil
+	case RoomDeleteBlock, RoomDeleteCascade, RoomDeleteReassign:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Invalid room delete policy '%s'", s)
+	}
+}
+
+// Returned if a room cannot be deleted, because future bookings could neither be cancelled nor moved
+type ErrRoomInUse struct {
+	Bookings []*Booking
+}
+
+func (e *ErrRoomInUse) Error() string {
+	ids := []string{}
+	for _, id := range e.Ids() {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return fmt.Sprintf("Room still has future bookings: %s", strings.Join(ids, ", "))
+}
+
+func (e *ErrRoomInUse) Ids() []int64 {
+	return distinctIds(e.Bookings)
 }
 
 type RoomsRepository interface {
-	Migrate() error
-	SeedTestData() error
 	Create(room Room) (*Room, error)
+	// Returns all rooms that have not been deleted, ordered by ID
 	GetAll() ([]*Room, error)
+	// Overwrites all attributes of the room. Deleted rooms cannot be updated
+	Update(room Room) (*Room, error)
+	// Archives the room. Its future bookings are handled according to the delete policy of the repository
 	Delete(id int64) error
+	// Returns deleted rooms as well, so past bookings can still be resolved
 	GetById(id int64) (*Room, error)
 }
 
+// Queries shared by the SQLite & Postgres repositories
+type roomsRepositorySQL struct {
+	db           *sqlx.DB
+	deletePolicy RoomDeletePolicy
+}
+
+func (r *roomsRepositorySQL) withDeletePolicy(policy RoomDeletePolicy) *roomsRepositorySQL {
+	res := *r
+	res.deletePolicy = policy
+	return &res
+}
+
 type RoomsRepositorySQLite struct {
-	db *sqlx.DB
+	*roomsRepositorySQL
 }
 
 func NewRoomsRepositorySQLite(db *sqlx.DB) *RoomsRepositorySQLite {
-	return &RoomsRepositorySQLite{db}
+	return &RoomsRepositorySQLite{&roomsRepositorySQL{db: db, deletePolicy: RoomDeleteBlock}}
 }
 
-func (r *RoomsRepositorySQLite) Migrate() error {
-	query := `
-CREATE TABLE IF NOT EXISTS room (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	title TEXT NOT NULL
-); `
-	_, err := r.db.Exec(query)
-	return err
+// Returns a copy of the repository, that handles future bookings of deleted rooms according to the policy
+func (r *RoomsRepositorySQLite) WithDeletePolicy(policy RoomDeletePolicy) *RoomsRepositorySQLite {
+	return &RoomsRepositorySQLite{r.withDeletePolicy(policy)}
 }
 
-func (r *RoomsRepositorySQLite) SeedTestData() error {
-	query := ` INSERT INTO room (title) VALUES ("Test room"); `
-	_, err := r.db.Exec(query)
-	return err
+type RoomsRepositoryPostgres struct {
+	*roomsRepositorySQL
 }
 
-func (r *RoomsRepositorySQLite) Create(room Room) (*Room, error) {
-	query := ` INSERT INTO room ( title ) VALUES (?); `
-	rows, err := r.db.Exec(query, room.Title)
-	if room.Id, err = rows.LastInsertId(); err != nil {
-		return nil, err
-	}
-	return &room, nil
+func NewRoomsRepositoryPostgres(db *sqlx.DB) *RoomsRepositoryPostgres {
+	return &RoomsRepositoryPostgres{&roomsRepositorySQL{db: db, deletePolicy: RoomDeleteBlock}}
 }
 
-func (r *RoomsRepositorySQLite) GetAll() ([]*Room, error) {
-	query := `
+// Returns a copy of the repository, that handles future bookings of deleted rooms according to the policy
+func (r *RoomsRepositoryPostgres) WithDeletePolicy(policy RoomDeletePolicy) *RoomsRepositoryPostgres {
+	return &RoomsRepositoryPostgres{r.withDeletePolicy(policy)}
+}
+
+const roomSelect = `
 	SELECT
-		id
-		title
+		id,
+		title,
+		time_zone,
+		capacity,
+		building,
+		floor,
+		description,
+		equipment,
+		accessibility,
+		image_url,
+		archived_at
 	FROM
-		room;
+		room
 `
+
+func roomArgs(room *Room) []interface{} {
+	return []interface{}{
+		room.Title,
+		room.TimeZone,
+		room.Capacity,
+		room.Building,
+		room.Floor,
+		room.Description,
+		strings.Join(room.Equipment, ","),
+		strings.Join(room.Accessibility, ","),
+		room.ImageUrl,
+	}
+}
+
+func (r *roomsRepositorySQL) Create(room Room) (*Room, error) {
+	if len(room.TimeZone) == 0 {
+		room.TimeZone = DefaultTimeZone
+	}
+	if err := room.Validate(); err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO room ( title, time_zone, capacity, building, floor, description, equipment, accessibility, image_url )
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id; `
+	if err := r.db.Get(&room.Id, r.db.Rebind(query), roomArgs(&room)...); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *roomsRepositorySQL) GetAll() ([]*Room, error) {
+	query := roomSelect + ` WHERE archived_at IS NULL ORDER BY id; `
 	rows, err := r.db.Queryx(query)
 	rooms := []*Room{}
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var scan RoomScan
-		err = rows.StructScan(&scan)
 		if err := rows.StructScan(&scan); err != nil {
 			return rooms, err
 		}
 		room := RoomFromScan(&scan)
 		rooms = append(rooms, &room)
 	}
-	return rooms, nil
+	return rooms, rows.Err()
 }
 
-func (r *RoomsRepositorySQLite) Delete(id int64) error {
-	return fmt.Errorf("Missing implementation: Delete room")
+func (r *roomsRepositorySQL) Update(room Room) (*Room, error) {
+	if len(room.TimeZone) == 0 {
+		room.TimeZone = DefaultTimeZone
+	}
+	if err := room.Validate(); err != nil {
+		return nil, err
+	}
+	query := `
+	UPDATE room
+	SET title = ?, time_zone = ?, capacity = ?, building = ?, floor = ?, description = ?, equipment = ?, accessibility = ?, image_url = ?
+	WHERE id = ? AND archived_at IS NULL; `
+	res, err := r.db.Exec(r.db.Rebind(query), append(roomArgs(&room), room.Id)...)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, fmt.Errorf("Room %d %w", room.Id, ErrNotFound)
+	}
+	return &room, nil
+}
+
+func (r *roomsRepositorySQL) Delete(id int64) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := lockRoom(tx, id); err != nil {
+		return err
+	}
+	room, err := getRoom(tx, id)
+	if err != nil {
+		return err
+	}
+	if !room.ArchivedAt.IsZero() {
+		return fmt.Errorf("Room %d %w", id, ErrNotFound)
+	}
+	now := time.Now()
+	if err := r.vacate(tx, room, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(tx.Rebind(` UPDATE room SET archived_at = ? WHERE id = ?; `), now.UTC(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *roomsRepositorySQL) GetById(id int64) (*Room, error) {
+	return getRoom(r.db, id)
+}
+
+func getRoom(q sqlx.Ext, id int64) (*Room, error) {
+	var scan RoomScan
+	if err := sqlx.Get(q, &scan, q.Rebind(roomSelect+` WHERE id = ?; `), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("Room %d %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+	room := RoomFromScan(&scan)
+	return &room, nil
 }
 
-func (r *RoomsRepositorySQLite) GetById(id int64) (*Room, error) {
-	return nil, fmt.Errorf("Missing implementation: Get room by ID")
+// Locks the room until the end of the transaction, so writes to its bookings are serialized.
+// Does nothing on SQLite, whose transactions are serialized by opening them with "_txlock=immediate"
+func lockRoom(tx *sqlx.Tx, id int64) error {
+	if tx.DriverName() != "postgres" {
+		return nil
+	}
+	_, err := tx.Exec(` SELECT id FROM room WHERE id = $1 FOR UPDATE; `, id)
+	return err
+}
+
+// Cancels or moves the bookings of the room starting at or after now, depending on the delete policy
+func (r *roomsRepositorySQL) vacate(tx *sqlx.Tx, room *Room, now time.Time) error {
+	// The booking queries only depend on the transaction they run in
+	bookings := &bookingRepositorySQL{}
+	filterCondition, filterArgs := BookingFilter{RoomIds: []int64{room.Id}}.sqlCondition()
+	query := bookingSelect + ` WHERE b.recurrence IS NULL AND b.start_time >= ?` + filterCondition + ` ORDER BY b.start_time; `
+	singles, err := bookings.queryBookings(tx, query, append([]interface{}{now.UTC()}, filterArgs...)...)
+	if err != nil {
+		return err
+	}
+	query = bookingSelect + ` WHERE b.recurrence IS NOT NULL` + filterCondition + ` ORDER BY b.start_time; `
+	allSeries, err := bookings.queryBookings(tx, query, filterArgs...)
+	if err != nil {
+		return err
+	}
+	// Parts of the series before & after now
+	type split struct {
+		series *Booking
+		past   *Booking
+		future *Booking
+	}
+	splits := []split{}
+	if err := bookings.loadExDates(tx, allSeries...); err != nil {
+		return err
+	}
+	for _, series := range allSeries {
+		if past, future := series.SplitAt(now); future != nil {
+			splits = append(splits, split{series, past, future})
+		}
+	}
+	if len(singles) == 0 && len(splits) == 0 {
+		return nil
+	}
+
+	switch r.deletePolicy {
+	case RoomDeleteCascade:
+		for _, s := range splits {
+			if err := endSeries(tx, s.series, s.past); err != nil {
+				return err
+			}
+		}
+		for _, b := range singles {
+			if err := deleteCalendarObjects(tx, b.Id); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(tx.Rebind(` DELETE FROM booking WHERE id = ?; `), b.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	case RoomDeleteReassign:
+		rooms := []*Room{}
+		query := roomSelect + ` WHERE archived_at IS NULL AND id != ? ORDER BY id; `
+		scans := []RoomScan{}
+		if err := tx.Select(&scans, tx.Rebind(query), room.Id); err != nil {
+			return err
+		}
+		for idx := range scans {
+			candidate := RoomFromScan(&scans[idx])
+			rooms = append(rooms, &candidate)
+		}
+		unmoved := []*Booking{}
+		// Series first, as they need a room that is free for all of their occurrences
+		for _, s := range splits {
+			target, err := freeRoom(tx, bookings, rooms, s.future)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				unmoved = append(unmoved, s.series)
+				continue
+			}
+			if s.past == nil {
+				if _, err := tx.Exec(tx.Rebind(` UPDATE booking SET room_id = ? WHERE id = ?; `), target.Id, s.series.Id); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := endSeries(tx, s.series, s.past); err != nil {
+				return err
+			}
+			continued := *s.future
+			continued.Room = *target
+			if err := insertBooking(tx, &continued); err != nil {
+				return err
+			}
+			// Exceptions of later occurrences belong to the continued series
+			query := ` UPDATE booking SET series_id = ? WHERE series_id = ? AND original_start >= ?; `
+			if _, err := tx.Exec(tx.Rebind(query), continued.Id, s.series.Id, now.UTC()); err != nil {
+				return err
+			}
+		}
+		for _, b := range singles {
+			target, err := freeRoom(tx, bookings, rooms, b)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				unmoved = append(unmoved, b)
+				continue
+			}
+			if _, err := tx.Exec(tx.Rebind(` UPDATE booking SET room_id = ? WHERE id = ?; `), target.Id, b.Id); err != nil {
+				return err
+			}
+		}
+		if len(unmoved) > 0 {
+			return &ErrRoomInUse{unmoved}
+		}
+		return nil
+	default:
+		res := []*Booking{}
+		for _, s := range splits {
+			res = append(res, s.series)
+		}
+		return &ErrRoomInUse{append(res, singles...)}
+	}
+}
+
+// Ends the series with the given past part. Series without a past part are deleted. Exceptions of
+// later occurrences are left alone, as they are handled like single bookings
+func endSeries(tx *sqlx.Tx, series *Booking, past *Booking) error {
+	if past != nil {
+		query := ` UPDATE booking SET recurrence = ?, last_end_time = ? WHERE id = ?; `
+		_, err := tx.Exec(tx.Rebind(query), past.Recurrence.String(), past.LastEnd().UTC(), series.Id)
+		return err
+	}
+	queries := []string{
+		` DELETE FROM booking_exdate WHERE booking_id = ?; `,
+		` UPDATE booking SET series_id = NULL WHERE series_id = ?; `,
+		` DELETE FROM calendar_object WHERE booking_id = ?; `,
+		` DELETE FROM booking WHERE id = ?; `,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(tx.Rebind(query), series.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the first room fitting the attendees of the booking & free for all of its occurrences,
+// nil if there is none
+func freeRoom(tx *sqlx.Tx, bookings *bookingRepositorySQL, rooms []*Room, b *Booking) (*Room, error) {
+	for _, room := range rooms {
+		if !room.Fits(b.Attendees) {
+			continue
+		}
+		if err := lockRoom(tx, room.Id); err != nil {
+			return nil, err
+		}
+		moved := *b
+		moved.Room = *room
+		err := bookings.checkConflicts(tx, &moved, b.Id, time.Time{})
+		var conflict *ErrBookingConflict
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return room, nil
+	}
+	return nil, nil
 }
@@ -0,0 +1,268 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Keeps rooms in a MemoryStore. Deleted rooms are archived & their future bookings handled like
+// the SQL repositories handle them
+type RoomsRepositoryMemory struct {
+	store        *MemoryStore
+	deletePolicy RoomDeletePolicy
+}
+
+func NewRoomsRepositoryMemory(store *MemoryStore) *RoomsRepositoryMemory {
+	return &RoomsRepositoryMemory{store, RoomDeleteBlock}
+}
+
+// Returns a copy of the repository, that handles future bookings of deleted rooms according to the policy
+func (r *RoomsRepositoryMemory) WithDeletePolicy(policy RoomDeletePolicy) *RoomsRepositoryMemory {
+	return &RoomsRepositoryMemory{r.store, policy}
+}
+
+func (r *RoomsRepositoryMemory) Create(room Room) (*Room, error) {
+	if len(room.TimeZone) == 0 {
+		room.TimeZone = DefaultTimeZone
+	}
+	if err := room.Validate(); err != nil {
+		return nil, err
+	}
+	room.ArchivedAt = time.Time{}
+	err := r.store.update(func(d *memoryData) error {
+		d.lastIds.room++
+		room.Id = d.lastIds.room
+		d.rooms[room.Id] = copyRoom(room)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *RoomsRepositoryMemory) GetAll() ([]*Room, error) {
+	return r.store.read().activeRooms(0), nil
+}
+
+func (r *RoomsRepositoryMemory) Update(room Room) (*Room, error) {
+	if len(room.TimeZone) == 0 {
+		room.TimeZone = DefaultTimeZone
+	}
+	if err := room.Validate(); err != nil {
+		return nil, err
+	}
+	room.ArchivedAt = time.Time{}
+	err := r.store.update(func(d *memoryData) error {
+		if existing, ok := d.rooms[room.Id]; !ok || !existing.ArchivedAt.IsZero() {
+			return fmt.Errorf("Room %d %w", room.Id, ErrNotFound)
+		}
+		d.rooms[room.Id] = copyRoom(room)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *RoomsRepositoryMemory) Delete(id int64) error {
+	return r.store.update(func(d *memoryData) error {
+		room, ok := d.rooms[id]
+		if !ok || !room.ArchivedAt.IsZero() {
+			return fmt.Errorf("Room %d %w", id, ErrNotFound)
+		}
+		now := time.Now()
+		if err := r.vacate(d, &room, now); err != nil {
+			return err
+		}
+		room.ArchivedAt = now.UTC()
+		d.rooms[id] = room
+		return nil
+	})
+}
+
+func (r *RoomsRepositoryMemory) GetById(id int64) (*Room, error) {
+	room, ok := r.store.read().rooms[id]
+	if !ok {
+		return nil, fmt.Errorf("Room %d %w", id, ErrNotFound)
+	}
+	room = copyRoom(room)
+	return &room, nil
+}
+
+// Copies the tags of the room, so stored rooms do not share them with callers
+func copyRoom(room Room) Room {
+	room.Equipment = slices.Clone(room.Equipment)
+	room.Accessibility = slices.Clone(room.Accessibility)
+	return room
+}
+
+// Returns the rooms that have not been deleted, except for the given one, ordered by ID
+func (d *memoryData) activeRooms(exceptId int64) []*Room {
+	rooms := []*Room{}
+	for _, id := range sortedIds(d.rooms) {
+		if room := copyRoom(d.rooms[id]); room.ArchivedAt.IsZero() && id != exceptId {
+			rooms = append(rooms, &room)
+		}
+	}
+	return rooms
+}
+
+// Cancels or moves the bookings of the room starting at or after now, depending on the delete policy
+func (r *RoomsRepositoryMemory) vacate(d *memoryData, room *Room, now time.Time) error {
+	singles := []*Booking{}
+	allSeries := []*Booking{}
+	for _, id := range sortedIds(d.bookings) {
+		b := d.bookings[id]
+		if b.Room.Id != room.Id {
+			continue
+		}
+		if b.Recurrence != nil {
+			allSeries = append(allSeries, d.resolveBooking(b, true))
+		} else if !b.StartTime.Before(now) {
+			singles = append(singles, d.resolveBooking(b, false))
+		}
+	}
+	sortByStartTime(singles)
+	sortByStartTime(allSeries)
+	// Parts of the series before & after now
+	type split struct {
+		series *Booking
+		past   *Booking
+		future *Booking
+	}
+	splits := []split{}
+	for _, series := range allSeries {
+		if past, future := series.SplitAt(now); future != nil {
+			splits = append(splits, split{series, past, future})
+		}
+	}
+	if len(singles) == 0 && len(splits) == 0 {
+		return nil
+	}
+
+	switch r.deletePolicy {
+	case RoomDeleteCascade:
+		for _, s := range splits {
+			if err := d.endSeries(s.series, s.past); err != nil {
+				return err
+			}
+		}
+		for _, b := range singles {
+			d.deleteCalendarObject(b.Id)
+			delete(d.bookings, b.Id)
+		}
+		return nil
+	case RoomDeleteReassign:
+		rooms := d.activeRooms(room.Id)
+		unmoved := []*Booking{}
+		// Series first, as they need a room that is free for all of their occurrences
+		for _, s := range splits {
+			target, err := d.freeRoom(rooms, s.future)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				unmoved = append(unmoved, s.series)
+				continue
+			}
+			if s.past == nil {
+				d.moveBooking(s.series.Id, target.Id)
+				continue
+			}
+			if err := d.endSeries(s.series, s.past); err != nil {
+				return err
+			}
+			continued := *s.future
+			continued.Room = *target
+			if err := d.insertBooking(&continued); err != nil {
+				return err
+			}
+			// Exceptions of later occurrences belong to the continued series
+			for id, b := range d.bookings {
+				if b.SeriesId == s.series.Id && !b.OriginalStart.Before(now) {
+					b.SeriesId = continued.Id
+					d.bookings[id] = b
+				}
+			}
+		}
+		for _, b := range singles {
+			target, err := d.freeRoom(rooms, b)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				unmoved = append(unmoved, b)
+				continue
+			}
+			d.moveBooking(b.Id, target.Id)
+		}
+		if len(unmoved) > 0 {
+			return &ErrRoomInUse{unmoved}
+		}
+		return nil
+	default:
+		res := []*Booking{}
+		for _, s := range splits {
+			res = append(res, s.series)
+		}
+		return &ErrRoomInUse{append(res, singles...)}
+	}
+}
+
+func (d *memoryData) moveBooking(id int64, roomId int64) {
+	b := d.bookings[id]
+	b.Room = Room{Id: roomId}
+	d.bookings[id] = b
+}
+
+// Ends the series with the given past part. Series without a past part are deleted. Exceptions of
+// later occurrences are left alone, as they are handled like single bookings
+func (d *memoryData) endSeries(series *Booking, past *Booking) error {
+	if past != nil {
+		// Only the rule changes, the excluded dates are kept
+		rule, err := ParseRecurrence(past.Recurrence.String())
+		if err != nil {
+			return err
+		}
+		stored := d.bookings[series.Id]
+		rule.ExDates = stored.Recurrence.ExDates
+		stored.Recurrence = rule
+		d.bookings[series.Id] = stored
+		return nil
+	}
+	for id, b := range d.bookings {
+		if b.SeriesId == series.Id {
+			b.SeriesId = 0
+			d.bookings[id] = b
+		}
+	}
+	d.deleteCalendarObject(series.Id)
+	delete(d.bookings, series.Id)
+	return nil
+}
+
+// Returns the first room fitting the attendees of the booking & free for all of its occurrences,
+// nil if there is none
+func (d *memoryData) freeRoom(rooms []*Room, b *Booking) (*Room, error) {
+	for _, room := range rooms {
+		if !room.Fits(b.Attendees) {
+			continue
+		}
+		moved := *b
+		moved.Room = *room
+		err := d.checkConflicts(&moved, b.Id, time.Time{})
+		var conflict *ErrBookingConflict
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return room, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,255 @@
+package booking
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Returns the start of the hour the given number of days from now
+func daysFromNow(days int) time.Time {
+	return time.Now().UTC().Truncate(time.Hour).AddDate(0, 0, days)
+}
+
+func testRoomsRepository_CreatesReadsAndUpdatesRooms(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Rooms
+	created, err := repo.Create(Room{Title: "Board room", Capacity: 12, Building: "HQ", Equipment: []string{"Whiteboard", "projector"}})
+	if err != nil {
+		t.Fatalf("Unable to create room: %s", err)
+	}
+	if created.Id != 2 || created.TimeZone != DefaultTimeZone || !slices.Equal(created.Equipment, []string{"projector", "whiteboard"}) {
+		t.Fatalf("Expected normalized room 2, received %+v", created)
+	}
+	if _, err := repo.Create(Room{Title: " "}); err == nil {
+		t.Fatal("Expected error for room without title")
+	}
+
+	room, err := repo.GetById(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Title != "Board room" || room.Capacity != 12 || room.Building != "HQ" || !slices.Equal(room.Equipment, created.Equipment) {
+		t.Fatalf("Expected created room, received %+v", room)
+	}
+	if _, err := repo.GetById(42); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error, received '%v'", err)
+	}
+
+	room.Title = "Conference room"
+	room.TimeZone = "Europe/Berlin"
+	room.Accessibility = []string{"wheelchair-accessible"}
+	if _, err := repo.Update(*room); err != nil {
+		t.Fatalf("Unable to update room: %s", err)
+	}
+	if _, err := repo.Update(Room{Id: 42, Title: "Missing room"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error, received '%v'", err)
+	}
+	rooms, err := repo.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || rooms[0].Title != "Test room" || rooms[1].Title != "Conference room" {
+		t.Fatalf("Expected both rooms ordered by ID, received %+v", rooms)
+	}
+	if rooms[1].TimeZone != "Europe/Berlin" || !slices.Equal(rooms[1].Accessibility, []string{"wheelchair-accessible"}) || rooms[1].Capacity != 12 {
+		t.Fatalf("Expected updated room, received %+v", rooms[1])
+	}
+}
+
+func testRoomsDelete_BlocksRoomsWithFutureBookings(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteBlock)
+	repo := repos.Rooms
+	bookingRepo := repos.Bookings
+	past, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(-7), EndTime: daysFromNow(-7).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	future, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(7), EndTime: daysFromNow(7).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+
+	var inUse *ErrRoomInUse
+	if err := repo.Delete(1); !errors.As(err, &inUse) || !slices.Equal(inUse.Ids(), []int64{future.Id}) {
+		t.Fatalf("Expected room in use by booking %d, received '%v'", future.Id, err)
+	}
+	if err := bookingRepo.Delete(future.Id); err != nil {
+		t.Fatal(err)
+	}
+	// Past bookings do not block the deletion
+	if err := repo.Delete(1); err != nil {
+		t.Fatalf("Unable to delete room: %s", err)
+	}
+
+	rooms, err := repo.GetAll()
+	if err != nil || len(rooms) != 0 {
+		t.Fatalf("Expected no rooms, received %v, %v", rooms, err)
+	}
+	room, err := repo.GetById(1)
+	if err != nil || room.ArchivedAt.IsZero() {
+		t.Fatalf("Expected archived room, received %+v, %v", room, err)
+	}
+	if b, err := bookingRepo.GetById(past.Id); err != nil || b.Room.Title != "Test room" {
+		t.Fatalf("Expected past booking to keep its room, received %v, %v", b, err)
+	}
+	if _, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(7), EndTime: daysFromNow(7).Add(time.Hour)}); err == nil {
+		t.Fatal("Expected error booking a deleted room")
+	}
+	if _, err := repo.Update(*room); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error updating a deleted room, received '%v'", err)
+	}
+	if err := repo.Delete(1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error deleting a room twice, received '%v'", err)
+	}
+	if err := repo.Delete(42); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected not found error, received '%v'", err)
+	}
+}
+
+func testRoomsDelete_CascadeCancelsFutureBookings(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteCascade)
+	repo := repos.Rooms
+	bookingRepo := repos.Bookings
+	past, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(-1), EndTime: daysFromNow(-1).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	future, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(1), EndTime: daysFromNow(1).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	// Daily series from two weeks ago, one hour later each day than the single bookings
+	series, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(-14).Add(time.Hour), EndTime: daysFromNow(-14).Add(2 * time.Hour), Recurrence: &Recurrence{Frequency: FrequencyDaily, Interval: 1}})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+
+	if err := repo.Delete(1); err != nil {
+		t.Fatalf("Unable to delete room: %s", err)
+	}
+	if _, err := bookingRepo.GetById(future.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected future booking to be cancelled, received '%v'", err)
+	}
+	if _, err := bookingRepo.GetById(past.Id); err != nil {
+		t.Fatalf("Expected past booking to be kept, received '%v'", err)
+	}
+	ended, err := bookingRepo.GetById(series.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := daysFromNow(-14), daysFromNow(14)
+	occurrences := ended.OccurrencesWithin(&from, &to)
+	if len(occurrences) < 14 || !occurrences[len(occurrences)-1].StartTime.Before(time.Now()) {
+		t.Fatalf("Expected series to end before now, received %s", ended.Recurrence)
+	}
+}
+
+func testRoomsDelete_ReassignMovesFutureBookingsToFreeRooms(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteReassign)
+	repo := repos.Rooms
+	bookingRepo := repos.Bookings
+	small, err := repo.Create(Room{Title: "Small room", Capacity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := repo.Create(Room{Title: "Large room", Capacity: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Too many attendees for the small room
+	workshop, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(3), EndTime: daysFromNow(3).Add(time.Hour), Attendees: 10})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	// The small room is busy when the series meets next week
+	if _, err := bookingRepo.Create(Booking{Room: *small, User: User{Id: 1}, StartTime: daysFromNow(7), EndTime: daysFromNow(7).Add(time.Hour)}); err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	series, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(-14), EndTime: daysFromNow(-14).Add(time.Hour), Recurrence: &Recurrence{Frequency: FrequencyWeekly, Interval: 1, Count: 10}})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+
+	if err := repo.Delete(1); err != nil {
+		t.Fatalf("Unable to delete room: %s", err)
+	}
+	if moved, err := bookingRepo.GetById(workshop.Id); err != nil || moved.Room.Id != large.Id {
+		t.Fatalf("Expected workshop to move to the large room, received %v, %v", moved, err)
+	}
+	from, to := daysFromNow(-15), daysFromNow(70)
+	occurrences, err := bookingRepo.FindWithinTimeIntervalByFilter(&from, &to, BookingFilter{UserIds: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seriesRooms := map[int64]int{}
+	for _, o := range occurrences {
+		if o.Recurrence == nil {
+			continue
+		}
+		if o.StartTime.Before(time.Now()) != (o.Room.Id == 1) {
+			t.Errorf("Expected only past occurrences to stay in the deleted room, received %s in room %d", o.StartTime, o.Room.Id)
+		}
+		seriesRooms[o.Room.Id]++
+	}
+	// The past occurrences stay with the series, the remaining ones continue in the large room
+	if seriesRooms[1] != 3 || seriesRooms[large.Id] != 7 || len(seriesRooms) != 2 {
+		t.Fatalf("Expected 3 occurrences in room 1 & 7 in the large room, received %v", seriesRooms)
+	}
+	if ended, err := bookingRepo.GetById(series.Id); err != nil || ended.Room.Id != 1 {
+		t.Fatalf("Expected series to stay in room 1, received %v, %v", ended, err)
+	}
+}
+
+func testRoomsDelete_ReassignFailsWithoutFreeRoom(t *testing.T, open openRepositories) {
+	repos := open(t, RoomDeleteReassign)
+	repo := repos.Rooms
+	bookingRepo := repos.Bookings
+	other, err := repo.Create(Room{Title: "Other room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	movable, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(1), EndTime: daysFromNow(1).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	stuck, err := bookingRepo.Create(Booking{Room: Room{Id: 1}, User: User{Id: 1}, StartTime: daysFromNow(2), EndTime: daysFromNow(2).Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	if _, err := bookingRepo.Create(Booking{Room: *other, User: User{Id: 1}, StartTime: daysFromNow(2), EndTime: daysFromNow(2).Add(time.Hour)}); err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+
+	var inUse *ErrRoomInUse
+	if err := repo.Delete(1); !errors.As(err, &inUse) || !slices.Equal(inUse.Ids(), []int64{stuck.Id}) {
+		t.Fatalf("Expected room in use by booking %d, received '%v'", stuck.Id, err)
+	}
+	// Nothing is moved, if any booking cannot be moved
+	if b, err := bookingRepo.GetById(movable.Id); err != nil || b.Room.Id != 1 {
+		t.Fatalf("Expected booking to stay in room 1, received %v, %v", b, err)
+	}
+	if room, err := repo.GetById(1); err != nil || !room.ArchivedAt.IsZero() {
+		t.Fatalf("Expected room not to be deleted, received %+v, %v", room, err)
+	}
+}
+
+func TestParseRoomDeletePolicy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected RoomDeletePolicy
+		valid    bool
+	}{
+		{"", RoomDeleteBlock, true},
+		{"cascade", RoomDeleteCascade, true},
+		{"Reassign", RoomDeleteReassign, true},
+		{"delete", "", false},
+	}
+	for _, tt := range tests {
+		policy, err := ParseRoomDeletePolicy(tt.input)
+		if (err == nil) != tt.valid || policy != tt.expected {
+			t.Errorf("%s: Expected %s, received %s, %v", tt.input, tt.expected, policy, err)
+		}
+	}
+}
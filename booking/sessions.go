@@ -0,0 +1,147 @@
+package booking
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Login session of a user. The session stays alive as long as its refresh token is exchanged
+// before ExpiresAt and it has not been revoked
+type Session struct {
+	Id     int64
+	UserId int64
+	// SHA-256 hash of the current refresh token. The token itself is never stored
+	TokenHash string
+	ExpiresAt time.Time
+	RevokedAt time.Time
+}
+
+// Return true, if tokens of the session are still accepted
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && s.ExpiresAt.After(now)
+}
+
+type SessionScan struct {
+	Id        int64
+	UserId    int64        `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+func SessionFromScan(s *SessionScan) Session {
+	return Session{
+		Id:        s.Id,
+		UserId:    s.UserId,
+		TokenHash: s.TokenHash,
+		ExpiresAt: s.ExpiresAt,
+		RevokedAt: s.RevokedAt.Time,
+	}
+}
+
+type SessionRepository interface {
+	Create(session Session) (*Session, error)
+	GetById(id int64) (*Session, error)
+	GetByTokenHash(tokenHash string) (*Session, error)
+	// Replaces the refresh token of the session, invalidating the previous one. Only succeeds while
+	// the session is active and still holds previousHash, so every refresh token is exchanged once
+	Rotate(id int64, previousHash string, tokenHash string, expiresAt time.Time) error
+	Revoke(id int64) error
+	// Revokes the active sessions of the user except the session exceptId, e.g. the one changing
+	// the password
+	RevokeAllForUser(userId int64, exceptId int64) error
+}
+
+// Queries shared by the SQLite & Postgres repositories
+type sessionRepositorySQL struct {
+	db *sqlx.DB
+}
+
+type SessionRepositorySQLite struct {
+	*sessionRepositorySQL
+}
+
+func NewSessionRepositorySQLite(db *sqlx.DB) *SessionRepositorySQLite {
+	return &SessionRepositorySQLite{&sessionRepositorySQL{db}}
+}
+
+type SessionRepositoryPostgres struct {
+	*sessionRepositorySQL
+}
+
+func NewSessionRepositoryPostgres(db *sqlx.DB) *SessionRepositoryPostgres {
+	return &SessionRepositoryPostgres{&sessionRepositorySQL{db}}
+}
+
+const sessionSelect = `
+	SELECT
+		id,
+		user_id,
+		token_hash,
+		expires_at,
+		revoked_at
+	FROM
+		session
+`
+
+func (r *sessionRepositorySQL) Create(session Session) (*Session, error) {
+	query := ` INSERT INTO session ( user_id, token_hash, expires_at ) VALUES (?, ?, ?) RETURNING id; `
+	if err := r.db.Get(&session.Id, r.db.Rebind(query), session.UserId, session.TokenHash, session.ExpiresAt.UTC()); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepositorySQL) get(where string, arg any, notFound error) (*Session, error) {
+	var scan SessionScan
+	if err := r.db.QueryRowx(r.db.Rebind(sessionSelect+where), arg).StructScan(&scan); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	session := SessionFromScan(&scan)
+	return &session, nil
+}
+
+func (r *sessionRepositorySQL) GetById(id int64) (*Session, error) {
+	return r.get(` WHERE id = ?; `, id, fmt.Errorf("Session %d %w", id, ErrNotFound))
+}
+
+func (r *sessionRepositorySQL) GetByTokenHash(tokenHash string) (*Session, error) {
+	return r.get(` WHERE token_hash = ?; `, tokenHash, fmt.Errorf("Session %w", ErrNotFound))
+}
+
+func (r *sessionRepositorySQL) Rotate(id int64, previousHash string, tokenHash string, expiresAt time.Time) error {
+	query := `
+		UPDATE session SET token_hash = ?, expires_at = ?
+		WHERE id = ? AND token_hash = ? AND revoked_at IS NULL AND expires_at > ?;
+	`
+	res, err := r.db.Exec(r.db.Rebind(query), tokenHash, expiresAt.UTC(), id, previousHash, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("Session %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *sessionRepositorySQL) Revoke(id int64) error {
+	query := ` UPDATE session SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL; `
+	_, err := r.db.Exec(r.db.Rebind(query), time.Now().UTC(), id)
+	return err
+}
+
+func (r *sessionRepositorySQL) RevokeAllForUser(userId int64, exceptId int64) error {
+	query := ` UPDATE session SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL; `
+	_, err := r.db.Exec(r.db.Rebind(query), time.Now().UTC(), userId, exceptId)
+	return err
+}
@@ -0,0 +1,34 @@
+package booking
+
+import (
+	"fmt"
+	"time"
+)
+
+// Time zone of rooms & users without one
+const DefaultTimeZone = "UTC"
+
+// Loads an IANA time zone, e.g. "Europe/Berlin". An empty name stands for DefaultTimeZone
+func LoadTimeZone(name string) (*time.Location, error) {
+	if len(name) == 0 {
+		name = DefaultTimeZone
+	}
+	// The zone of the server is no valid choice, as it differs between deployments
+	if name == "Local" {
+		return nil, fmt.Errorf("Invalid time zone '%s'", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone '%s'", name)
+	}
+	return loc, nil
+}
+
+// Loads the time zone, falling back to UTC if it is invalid
+func locationOrUTC(name string) *time.Location {
+	loc, err := LoadTimeZone(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,244 @@
+package booking
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	Id   int64
+	Name string
+	Role Role
+	// bcrypt hash of the password. Users without hash cannot log in
+	PasswordHash string `json:"-"`
+	// Failed login attempts since the last successful login
+	FailedLogins int `json:"-"`
+	// Logins are rejected until this point in time
+	LockedUntil time.Time `json:"-"`
+	// IANA time zone times are displayed & entered in, e.g. "Europe/Berlin"
+	TimeZone string
+}
+
+// Returns the display time zone of the user. Falls back to UTC if none or an invalid one is set
+func (u *User) Location() *time.Location {
+	return locationOrUTC(u.TimeZone)
+}
+
+// Replaces the password hash of the user. Does not persist the change
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+func (u *User) CheckPassword(password string) bool {
+	if len(u.PasswordHash) == 0 {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// Return true, if logins of the user are currently rejected
+func (u *User) Locked(now time.Time) bool {
+	return u.LockedUntil.After(now)
+}
+
+type UserScan struct {
+	Id           int64
+	Name         sql.NullString
+	Role         sql.NullString
+	PasswordHash sql.NullString `db:"password_hash"`
+	FailedLogins int            `db:"failed_logins"`
+	LockedUntil  sql.NullTime   `db:"locked_until"`
+	TimeZone     sql.NullString `db:"time_zone"`
+}
+
+func UserFromScan(s *UserScan) User {
+	return User{
+		Id:           s.Id,
+		Name:         s.Name.String,
+		Role:         Role(s.Role.String),
+		PasswordHash: s.PasswordHash.String,
+		FailedLogins: s.FailedLogins,
+		LockedUntil:  s.LockedUntil.Time,
+		TimeZone:     s.TimeZone.String,
+	}
+}
+
+type UserRepository interface {
+	Create(user User) (*User, error)
+	GetAll() ([]*User, error)
+	GetById(id int64) (*User, error)
+	GetByName(name string) (*User, error)
+	UpdateRole(id int64, role Role) error
+	UpdatePasswordHash(id int64, passwordHash string) error
+	// Counts a failed login of the user. The failure that reaches maxFailedLogins locks the user
+	// until lockedUntil & restarts the count. Concurrent failures are all counted
+	RecordFailedLogin(id int64, maxFailedLogins int, lockedUntil time.Time) error
+	// Resets the failed login counter & lock of the user
+	ResetFailedLogins(id int64) error
+	UpdateTimeZone(id int64, timeZone string) error
+}
+
+// Queries shared by the SQLite & Postgres repositories. They are written with ? placeholders,
+// which are rebound to the notation of the database driver
+type userRepositorySQL struct {
+	db *sqlx.DB
+}
+
+type UserRepositorySQLite struct {
+	*userRepositorySQL
+}
+
+func NewUserRepositorySQLite(db *sqlx.DB) *UserRepositorySQLite {
+	return &UserRepositorySQLite{&userRepositorySQL{db}}
+}
+
+type UserRepositoryPostgres struct {
+	*userRepositorySQL
+}
+
+func NewUserRepositoryPostgres(db *sqlx.DB) *UserRepositoryPostgres {
+	return &UserRepositoryPostgres{&userRepositorySQL{db}}
+}
+
+const userSelect = `
+	SELECT
+		id,
+		name,
+		role,
+		password_hash,
+		failed_logins,
+		locked_until,
+		time_zone
+	FROM
+		"user"
+`
+
+// Applies the defaults of new users & validates role & time zone
+func validateUser(user *User) error {
+	// Users are members unless stated otherwise
+	if len(user.Role) == 0 {
+		user.Role = RoleMember
+	}
+	if _, err := ParseRole(string(user.Role)); err != nil {
+		return err
+	}
+	if len(user.TimeZone) == 0 {
+		user.TimeZone = DefaultTimeZone
+	}
+	_, err := LoadTimeZone(user.TimeZone)
+	return err
+}
+
+func (r *userRepositorySQL) Create(user User) (*User, error) {
+	if err := validateUser(&user); err != nil {
+		return nil, err
+	}
+	query := ` INSERT INTO "user" ( name, role, password_hash, time_zone ) VALUES (?, ?, ?, ?) RETURNING id; `
+	passwordHash := sql.NullString{String: user.PasswordHash, Valid: len(user.PasswordHash) > 0}
+	if err := r.db.Get(&user.Id, r.db.Rebind(query), user.Name, user.Role, passwordHash, user.TimeZone); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepositorySQL) GetAll() ([]*User, error) {
+	rows, err := r.db.Queryx(userSelect + ` ORDER BY id;`)
+	users := []*User{}
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var scan UserScan
+		if err := rows.StructScan(&scan); err != nil {
+			return users, err
+		}
+		user := UserFromScan(&scan)
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (r *userRepositorySQL) GetById(id int64) (*User, error) {
+	var scan UserScan
+	if err := r.db.QueryRowx(r.db.Rebind(userSelect+` WHERE id = ?; `), id).StructScan(&scan); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("User %d %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+	user := UserFromScan(&scan)
+	return &user, nil
+}
+
+func (r *userRepositorySQL) GetByName(name string) (*User, error) {
+	var scan UserScan
+	if err := r.db.QueryRowx(r.db.Rebind(userSelect+` WHERE name = ?; `), name).StructScan(&scan); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("User '%s' %w", name, ErrNotFound)
+		}
+		return nil, err
+	}
+	user := UserFromScan(&scan)
+	return &user, nil
+}
+
+func (r *userRepositorySQL) UpdateRole(id int64, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	res, err := r.db.Exec(r.db.Rebind(` UPDATE "user" SET role = ? WHERE id = ?; `), role, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("User %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (r *userRepositorySQL) UpdatePasswordHash(id int64, passwordHash string) error {
+	query := ` UPDATE "user" SET password_hash = ? WHERE id = ?; `
+	_, err := r.db.Exec(r.db.Rebind(query), passwordHash, id)
+	return err
+}
+
+func (r *userRepositorySQL) RecordFailedLogin(id int64, maxFailedLogins int, lockedUntil time.Time) error {
+	// Both assignments read the count before the update
+	query := `
+		UPDATE "user" SET
+			failed_logins = CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END
+		WHERE id = ?;
+	`
+	_, err := r.db.Exec(r.db.Rebind(query), maxFailedLogins, maxFailedLogins, lockedUntil.UTC(), id)
+	return err
+}
+
+func (r *userRepositorySQL) ResetFailedLogins(id int64) error {
+	_, err := r.db.Exec(r.db.Rebind(` UPDATE "user" SET failed_logins = 0, locked_until = NULL WHERE id = ?; `), id)
+	return err
+}
+
+func (r *userRepositorySQL) UpdateTimeZone(id int64, timeZone string) error {
+	if _, err := LoadTimeZone(timeZone); err != nil {
+		return err
+	}
+	res, err := r.db.Exec(r.db.Rebind(` UPDATE "user" SET time_zone = ? WHERE id = ?; `), timeZone, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("User %d %w", id, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package booking
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testUsersRepository_CreatesReadsAndUpdatesUsers(t *testing.T, open openRepositories) {
+	repo := open(t, RoomDeleteBlock).Users
+	created, err := repo.Create(User{Name: "alice", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatalf("Unable to create user: %s", err)
+	}
+	if created.Id != 2 || created.Role != RoleMember {
+		t.Fatalf("Expected member 2, received %+v", created)
+	}
+	if _, err := repo.Create(User{Name: "alice"}); err == nil {
+		t.Fatal("Expected error for duplicate name")
+	}
+	if _, err := repo.Create(User{Name: "bob", Role: "owner"}); err == nil {
+		t.Fatal("Expected error for unknown role")
+	}
+	if _, err := repo.Create(User{Name: "bob", TimeZone: "Mars/Olympus"}); err == nil {
+		t.Fatal("Expected error for unknown time zone")
+	}
+
+	if err := repo.UpdateRole(created.Id, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateTimeZone(created.Id, "America/New_York"); err != nil {
+		t.Fatal(err)
+	}
+	lockedUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+	for range 3 {
+		if err := repo.RecordFailedLogin(created.Id, 4, lockedUntil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := repo.GetByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleAdmin || user.TimeZone != "America/New_York" || user.FailedLogins != 3 || !user.LockedUntil.IsZero() {
+		t.Fatalf("Expected updated user, received %+v", user)
+	}
+	if err := repo.RecordFailedLogin(created.Id, 4, lockedUntil); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = repo.GetById(created.Id); err != nil || user.FailedLogins != 0 || !user.LockedUntil.Equal(lockedUntil) {
+		t.Fatalf("Expected user to be locked, received %+v, %v", user, err)
+	}
+	if err := repo.ResetFailedLogins(created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = repo.GetById(created.Id); err != nil || user.FailedLogins != 0 || !user.LockedUntil.IsZero() {
+		t.Fatalf("Expected user to be unlocked, received %+v, %v", user, err)
+	}
+	for _, err := range []error{
+		repo.UpdateRole(42, RoleAdmin),
+		repo.UpdateTimeZone(42, "UTC"),
+		func() error { _, err := repo.GetById(42); return err }(),
+		func() error { _, err := repo.GetByName("mallory"); return err }(),
+	} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected not found error, received '%v'", err)
+		}
+	}
+	users, err := repo.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "Test user" || users[1].Name != "alice" {
+		t.Fatalf("Expected both users ordered by ID, received %+v", users)
+	}
+}
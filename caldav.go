@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+
+	"lucb31/booking-go/caldav"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path the CalDAV handler is mounted at
+const calDavPrefix = "/caldav"
+
+// Domain of the UIDs served to CalDAV clients, if CALDAV_DOMAIN is not set
+const defaultCalDavDomain = "booking-go"
+
+var calDavDomain = defaultCalDavDomain
+
+// CalDAV clients authenticate every request with basic auth
+func registerCalDavRoutes(r *gin.Engine) {
+	calDav := r.Group(calDavPrefix, BasicAuthMiddleware())
+	for _, method := range caldav.Methods {
+		r.Handle(method, "/.well-known/caldav", handleCalDavDiscoveryRequest)
+		calDav.Handle(method, "/*path", handleCalDavRequest)
+	}
+}
+
+func calDavHandler() *caldav.Handler {
+	return &caldav.Handler{Prefix: calDavPrefix, Domain: calDavDomain, Bookings: bookingRepo, Rooms: roomRepo, Objects: calendarObjectRepo}
+}
+
+func handleCalDavRequest(c *gin.Context) {
+	calDavHandler().Serve(c.Writer, c.Request, currentUser(c))
+}
+
+// Clients configured with the server address only look up the CalDAV root at a well-known
+// location (RFC 6764)
+func handleCalDavDiscoveryRequest(c *gin.Context) {
+	c.Redirect(http.StatusMovedPermanently, calDavPrefix+"/")
+}
@@ -0,0 +1,202 @@
+// Package caldav serves every room as CalDAV (RFC 4791) calendar collection, so bookings can be
+// created, moved & cancelled from calendar clients like Thunderbird or Apple Calendar. Writes go
+// through booking.BookingRepository, so they are checked for conflicts like any other booking,
+// and users may only change the bookings their role allows them to.
+//
+// Resources below the prefix the handler is mounted at:
+//
+//	/                         service root, pointing clients to the principal
+//	/principals/<user id>/    principal of the authenticated user
+//	/rooms/                   calendar home with a collection per room
+//	/rooms/<room id>/         calendar collection of the room
+//	/rooms/<room id>/<name>   calendar object: a booking or series with its exceptions
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"lucb31/booking-go/booking"
+)
+
+// HTTP methods the handler answers to
+var Methods = []string{http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, "PROPFIND", "REPORT"}
+
+type Handler struct {
+	// Path the handler is mounted at, e.g. "/caldav"
+	Prefix string
+	// Domain of the UIDs of bookings created in the app, e.g. "booking.example.com". Fixed, so
+	// bookings keep their identity whatever host name clients use
+	Domain   string
+	Bookings booking.BookingRepository
+	Rooms    booking.RoomsRepository
+	Objects  booking.CalendarObjectRepository
+}
+
+type resourceKind int
+
+const (
+	kindRoot resourceKind = iota
+	kindPrincipal
+	kindHome
+	kindCollection
+	kindObject
+)
+
+// Resource addressed by a request
+type resource struct {
+	kind resourceKind
+	// Set for principals
+	userId int64
+	// Set for collections & objects
+	room *booking.Room
+	// Set for objects
+	name string
+}
+
+// Error answered with the given status. Precondition names the DAV:error child element
+// explaining the failure, e.g. "C:valid-calendar-data"
+type statusError struct {
+	status       int
+	precondition string
+	err          error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+func newStatusError(status int, precondition string, format string, args ...any) *statusError {
+	return &statusError{status, precondition, fmt.Errorf(format, args...)}
+}
+
+var errForbidden = &statusError{http.StatusForbidden, "D:need-privileges", errors.New("You are not allowed to perform this action")}
+
+// Serves the request on behalf of the authenticated user
+func (h *Handler) Serve(w http.ResponseWriter, r *http.Request, user *booking.User) {
+	res, err := h.resolve(r.URL.Path)
+	if err == nil {
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("DAV", "1, 3, calendar-access")
+			w.Header().Set("Allow", strings.Join(Methods, ", "))
+			w.WriteHeader(http.StatusOK)
+		case "PROPFIND":
+			err = h.propfind(w, r, user, res)
+		case "REPORT":
+			err = h.report(w, r, user, res)
+		case http.MethodGet, http.MethodHead:
+			err = h.get(w, r, res)
+		case http.MethodPut:
+			err = h.put(w, r, user, res)
+		case http.MethodDelete:
+			err = h.delete(w, r, user, res)
+		default:
+			w.Header().Set("Allow", strings.Join(Methods, ", "))
+			err = newStatusError(http.StatusMethodNotAllowed, "", "Method %s is not supported", r.Method)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusUnprocessableEntity
+	precondition := ""
+	var statusErr *statusError
+	var conflict *booking.ErrBookingConflict
+	switch {
+	case errors.As(err, &statusErr):
+		status, precondition = statusErr.status, statusErr.precondition
+	case errors.As(err, &conflict):
+		status = http.StatusConflict
+	case errors.Is(err, booking.ErrNotFound):
+		status = http.StatusNotFound
+	}
+	if len(precondition) == 0 {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<D:error xmlns:D="DAV:" xmlns:C="%s"><%s/><D:responsedescription>%s</D:responsedescription></D:error>`,
+		xmlHeader, calDavNamespace, precondition, escape(err.Error()))
+}
+
+// Maps the request path onto the addressed resource
+func (h *Handler) resolve(path string) (resource, error) {
+	rest, found := strings.CutPrefix(path, h.Prefix)
+	if !found {
+		return resource{}, newStatusError(http.StatusNotFound, "", "Resource %s not found", path)
+	}
+	notFound := newStatusError(http.StatusNotFound, "", "Resource %s not found", path)
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "":
+		return resource{kind: kindRoot}, nil
+	case segments[0] == "principals" && len(segments) == 2:
+		userId, err := strconv.ParseInt(segments[1], 10, 64)
+		if err != nil {
+			return resource{}, notFound
+		}
+		return resource{kind: kindPrincipal, userId: userId}, nil
+	case segments[0] != "rooms" || len(segments) > 3:
+		return resource{}, notFound
+	case len(segments) == 1:
+		return resource{kind: kindHome}, nil
+	}
+	roomId, err := strconv.ParseInt(segments[1], 10, 64)
+	if err != nil {
+		return resource{}, notFound
+	}
+	room, err := h.room(roomId)
+	if err != nil {
+		return resource{}, err
+	}
+	if len(segments) == 2 {
+		return resource{kind: kindCollection, room: room}, nil
+	}
+	return resource{kind: kindObject, room: room, name: segments[2]}, nil
+}
+
+func (h *Handler) room(id int64) (*booking.Room, error) {
+	rooms, err := h.Rooms.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range rooms {
+		if room.Id == id {
+			return room, nil
+		}
+	}
+	return nil, newStatusError(http.StatusNotFound, "", "Room %d not found", id)
+}
+
+func (h *Handler) rootHref() string {
+	return h.Prefix + "/"
+}
+
+func (h *Handler) principalHref(userId int64) string {
+	return fmt.Sprintf("%s/principals/%d/", h.Prefix, userId)
+}
+
+func (h *Handler) homeHref() string {
+	return h.Prefix + "/rooms/"
+}
+
+func (h *Handler) collectionHref(room *booking.Room) string {
+	return fmt.Sprintf("%s/rooms/%d/", h.Prefix, room.Id)
+}
+
+func (h *Handler) objectHref(room *booking.Room, name string) string {
+	return h.collectionHref(room) + (&url.URL{Path: name}).EscapedPath()
+}
@@ -0,0 +1,379 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testServer struct {
+	handler  *Handler
+	bookings *booking.BookingRepositorySQLite
+	room     *booking.Room
+	users    map[string]*booking.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	users := booking.NewUserRepositorySQLite(db)
+	rooms := booking.NewRoomsRepositorySQLite(db)
+	bookings := booking.NewBookingRepositorySQLite(db, users, rooms)
+	objects := booking.NewCalendarObjectRepositorySQLite(db)
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	room, err := rooms.Create(booking.Room{Title: "Berlin", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		handler:  &Handler{Prefix: "/caldav", Domain: "booking.example.com", Bookings: bookings, Rooms: rooms, Objects: objects},
+		bookings: bookings,
+		room:     room,
+		users:    map[string]*booking.User{},
+	}
+	for name, role := range map[string]booking.Role{"alice": booking.RoleMember, "bob": booking.RoleMember, "reader": booking.RoleReadOnly} {
+		if s.users[name], err = users.Create(booking.User{Name: name, Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// Stand-in for a calendar client, talking to the handler on behalf of a user
+type testClient struct {
+	t      *testing.T
+	server *testServer
+	user   *booking.User
+}
+
+func (s *testServer) client(t *testing.T, name string) *testClient {
+	return &testClient{t, s, s.users[name]}
+}
+
+func (c *testClient) do(method string, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Host = "example.com"
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	c.server.handler.Serve(w, req, c.user)
+	return w
+}
+
+func (c *testClient) expect(w *httptest.ResponseRecorder, status int) *httptest.ResponseRecorder {
+	c.t.Helper()
+	if w.Code != status {
+		c.t.Fatalf("Expected status %d, received %d: %s", status, w.Code, w.Body)
+	}
+	return w
+}
+
+type testMultistatus struct {
+	Responses []testResponse `xml:"DAV: response"`
+}
+
+type testResponse struct {
+	Href      string `xml:"DAV: href"`
+	Status    string `xml:"DAV: status"`
+	Propstats []struct {
+		Status string `xml:"DAV: status"`
+		Prop   struct {
+			ResourceType struct {
+				Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+			} `xml:"DAV: resourcetype"`
+			DisplayName      string      `xml:"DAV: displayname"`
+			CurrentPrincipal testHrefs   `xml:"DAV: current-user-principal"`
+			HomeSet          testHrefs   `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+			ETag             string      `xml:"DAV: getetag"`
+			CalendarData     string      `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			Privileges       []testNames `xml:"DAV: current-user-privilege-set>privilege"`
+			Unknown          *struct{}   `xml:"urn:example unknown"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+type testHrefs struct {
+	Href string `xml:"DAV: href"`
+}
+
+type testNames struct {
+	Names []xml.Name `xml:",any"`
+}
+
+func (c *testClient) multistatus(method string, path string, depth string, body string) testMultistatus {
+	c.t.Helper()
+	w := c.expect(c.do(method, path, map[string]string{"Depth": depth, "Content-Type": "application/xml"}, body), http.StatusMultiStatus)
+	var res testMultistatus
+	if err := xml.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		c.t.Fatalf("Invalid multistatus: %s\n%s", err, w.Body)
+	}
+	return res
+}
+
+// Returns the response of the href, failing if there is none
+func (m testMultistatus) response(t *testing.T, href string) testResponse {
+	t.Helper()
+	for _, r := range m.Responses {
+		if r.Href == href {
+			return r
+		}
+	}
+	t.Fatalf("Expected response of %s, received %+v", href, m.Responses)
+	return testResponse{}
+}
+
+const propfindBody = `<?xml version="1.0"?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:X="urn:example">
+  <D:prop><D:resourcetype/><D:displayname/><D:current-user-principal/><C:calendar-home-set/><D:getetag/><D:current-user-privilege-set/><X:unknown/></D:prop>
+</D:propfind>`
+
+// Weekly on Mondays at 09:00 Berlin time, with the second occurrence moved to the afternoon
+const testObject = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Client//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:3f2a@client\r\nSUMMARY:Planning\r\nDTSTART;TZID=Europe/Berlin:20240701T090000\r\nDTEND;TZID=Europe/Berlin:20240701T100000\r\nRRULE:FREQ=WEEKLY;COUNT=4\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:3f2a@client\r\nRECURRENCE-ID;TZID=Europe/Berlin:20240708T090000\r\nSUMMARY:Planning, moved\r\nDTSTART;TZID=Europe/Berlin:20240708T140000\r\nDTEND;TZID=Europe/Berlin:20240708T150000\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestDiscovery_LeadsFromRootToRoomCollections(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.client(t, "alice")
+
+	root := alice.multistatus("PROPFIND", "/caldav/", "0", propfindBody).response(t, "/caldav/")
+	principal := root.Propstats[0].Prop.CurrentPrincipal.Href
+	if principal != fmt.Sprintf("/caldav/principals/%d/", s.users["alice"].Id) {
+		t.Fatalf("Expected principal of alice, received %+v", root)
+	}
+	if len(root.Propstats) != 2 || root.Propstats[1].Prop.Unknown == nil || !strings.Contains(root.Propstats[1].Status, "404") {
+		t.Fatalf("Expected unknown property to be answered with 404, received %+v", root.Propstats)
+	}
+	home := alice.multistatus("PROPFIND", principal, "0", propfindBody).response(t, principal).Propstats[0].Prop.HomeSet.Href
+	if home != "/caldav/rooms/" {
+		t.Fatalf("Expected calendar home, received %s", home)
+	}
+	collection := alice.multistatus("PROPFIND", home, "1", propfindBody).response(t, fmt.Sprintf("/caldav/rooms/%d/", s.room.Id))
+	if props := collection.Propstats[0].Prop; props.DisplayName != "Berlin" || props.ResourceType.Calendar == nil {
+		t.Fatalf("Expected calendar collection of room, received %+v", props)
+	}
+	alice.expect(alice.do("PROPFIND", home, map[string]string{"Depth": "infinity"}, ""), http.StatusForbidden)
+	alice.expect(alice.do("PROPFIND", "/caldav/principals/99/", map[string]string{"Depth": "0"}, ""), http.StatusNotFound)
+}
+
+func TestObjects_CanBeCreatedSyncedChangedAndDeleted(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.client(t, "alice")
+	collection := fmt.Sprintf("/caldav/rooms/%d/", s.room.Id)
+	path := collection + "3f2a.ics"
+
+	alice.expect(alice.do(http.MethodPut, path, map[string]string{"If-None-Match": "*"}, testObject), http.StatusCreated)
+	alice.expect(alice.do(http.MethodPut, path, map[string]string{"If-None-Match": "*"}, testObject), http.StatusPreconditionFailed)
+	bookings, err := s.bookings.FindByFilter(booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 2 || bookings[0].User.Id != s.users["alice"].Id || bookings[1].SeriesId != bookings[0].Id {
+		t.Fatalf("Expected series of alice with exception, received %+v", bookings)
+	}
+	seriesId := bookings[0].Id
+
+	listing := alice.multistatus("PROPFIND", collection, "1", propfindBody)
+	etag := listing.response(t, path).Propstats[0].Prop.ETag
+	w := alice.expect(alice.do(http.MethodGet, path, nil, ""), http.StatusOK)
+	if w.Header().Get("ETag") != etag || len(etag) == 0 {
+		t.Fatalf("Expected ETag %s of listing, received %s", etag, w.Header().Get("ETag"))
+	}
+	for _, expected := range []string{"UID:3f2a@client", "RRULE:FREQ=WEEKLY;COUNT=4", "RECURRENCE-ID;TZID=Europe/Berlin:20240708T090000", "SUMMARY:Planning\\, moved"} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("Expected object to contain %s, received\n%s", expected, w.Body)
+		}
+	}
+	if strings.Contains(w.Body.String(), "METHOD:") {
+		t.Fatalf("Expected stored object without METHOD, received\n%s", w.Body)
+	}
+
+	// Only the series, but not its exception, lies in the week of the third occurrence
+	query := `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop>
+<C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:time-range start="%s" end="%s"/></C:comp-filter></C:comp-filter></C:filter></C:calendar-query>`
+	if res := alice.multistatus("REPORT", collection, "1", fmt.Sprintf(query, "20240715T000000Z", "20240722T000000Z")); len(res.Responses) != 1 {
+		t.Fatalf("Expected series to match, received %+v", res.Responses)
+	}
+	if res := alice.multistatus("REPORT", collection, "1", fmt.Sprintf(query, "20240801T000000Z", "20240901T000000Z")); len(res.Responses) != 0 {
+		t.Fatalf("Expected no match after the series ended, received %+v", res.Responses)
+	}
+	multiget := `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop>
+<D:href>` + path + `</D:href><D:href>` + collection + `unknown.ics</D:href></C:calendar-multiget>`
+	res := alice.multistatus("REPORT", collection, "1", multiget)
+	if data := res.response(t, path).Propstats[0].Prop.CalendarData; !strings.Contains(data, "UID:3f2a@client") {
+		t.Fatalf("Expected calendar data, received %s", data)
+	}
+	if status := res.response(t, collection+"unknown.ics").Status; !strings.Contains(status, "404") {
+		t.Fatalf("Expected unknown object to be missing, received %s", status)
+	}
+
+	// Moving the series a week later drops the exception, which no longer matches an occurrence
+	moved := strings.NewReplacer("20240701T", "20240708T", "20240708T090000", "20240715T090000", "20240708T1", "20240715T1").Replace(testObject)
+	alice.expect(alice.do(http.MethodPut, path, map[string]string{"If-Match": `"stale"`}, moved), http.StatusPreconditionFailed)
+	alice.expect(alice.do(http.MethodPut, path, map[string]string{"If-Match": etag}, moved), http.StatusNoContent)
+	series, err := s.bookings.GetById(seriesId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2024, 7, 8, 7, 0, 0, 0, time.UTC); !series.StartTime.Equal(expected) {
+		t.Fatalf("Expected series to keep its id & start at %s, received %s", expected, series.StartTime)
+	}
+	w = alice.expect(alice.do(http.MethodGet, path, nil, ""), http.StatusOK)
+	if w.Header().Get("ETag") == etag || !strings.Contains(w.Body.String(), "RECURRENCE-ID;TZID=Europe/Berlin:20240715T090000") {
+		t.Fatalf("Expected changed object with new ETag, received %s\n%s", w.Header().Get("ETag"), w.Body)
+	}
+
+	alice.expect(alice.do(http.MethodDelete, path, map[string]string{"If-Match": etag}, ""), http.StatusPreconditionFailed)
+	alice.expect(alice.do(http.MethodDelete, path, map[string]string{"If-Match": w.Header().Get("ETag")}, ""), http.StatusNoContent)
+	if bookings, _ := s.bookings.GetAll(); len(bookings) != 0 {
+		t.Fatalf("Expected series & exception to be deleted, received %+v", bookings)
+	}
+	alice.expect(alice.do(http.MethodGet, path, nil, ""), http.StatusNotFound)
+}
+
+func TestObjects_ApplyPermissionsAndConflicts(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob, reader := s.client(t, "alice"), s.client(t, "bob"), s.client(t, "reader")
+	collection := fmt.Sprintf("/caldav/rooms/%d/", s.room.Id)
+	start := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)
+	existing, err := s.bookings.Create(booking.Booking{Room: *s.room, User: *s.users["alice"], StartTime: start, EndTime: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Bookings created in the app are served under a name derived from their id
+	path := fmt.Sprintf("%sbooking-%d.ics", collection, existing.Id)
+	object := bob.expect(bob.do(http.MethodGet, path, nil, ""), http.StatusOK).Body.String()
+	if !strings.Contains(object, fmt.Sprintf("UID:booking-%d@booking.example.com", existing.Id)) {
+		t.Fatalf("Expected UID derived from id, received\n%s", object)
+	}
+	listing := bob.multistatus("PROPFIND", path, "0", propfindBody).response(t, path)
+	if privileges := listing.Propstats[0].Prop.Privileges; len(privileges) != 1 || privileges[0].Names[0].Local != "read" {
+		t.Fatalf("Expected bob to only read alice's booking, received %+v", privileges)
+	}
+
+	bob.expect(bob.do(http.MethodPut, path, nil, object), http.StatusForbidden)
+	bob.expect(bob.do(http.MethodDelete, path, nil, ""), http.StatusForbidden)
+	reader.expect(reader.do(http.MethodPut, collection+"new.ics", nil, testObject), http.StatusForbidden)
+	// The series overlaps alice's booking on its first Monday
+	bob.expect(bob.do(http.MethodPut, collection+"new.ics", nil, testObject), http.StatusConflict)
+	bob.expect(bob.do(http.MethodPut, collection+"reused.ics", nil, object), http.StatusForbidden)
+	bob.expect(bob.do(http.MethodPut, collection+"booking-999.ics", nil, strings.ReplaceAll(testObject, "20240701T", "20240702T")), http.StatusForbidden)
+	bob.expect(bob.do(http.MethodPut, collection+"invalid.ics", nil, "BEGIN:VCALENDAR\r\n"), http.StatusForbidden)
+	if bookings, _ := s.bookings.GetAll(); len(bookings) != 1 {
+		t.Fatalf("Expected no bookings to be created, received %+v", bookings)
+	}
+
+	alice.expect(alice.do(http.MethodPut, path, nil, strings.NewReplacer("T090000", "T100000", "T100000", "T110000").Replace(object)), http.StatusNoContent)
+	updated, err := s.bookings.GetById(existing.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.StartTime.Equal(start.Add(time.Hour)) {
+		t.Fatalf("Expected alice to move her booking, received %s", updated.StartTime)
+	}
+}
+
+func TestObjects_KeepExceptionsMovedToOtherRooms(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.client(t, "alice")
+	other, err := s.handler.Rooms.Create(booking.Room{Title: "Munich", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)
+	series, err := s.bookings.Create(booking.Booking{Room: *s.room, User: *s.users["alice"], StartTime: start, EndTime: start.Add(time.Hour), Recurrence: &booking.Recurrence{Frequency: booking.FrequencyDaily, Interval: 1, Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := start.AddDate(0, 0, 1)
+	if _, err := s.bookings.UpdateOccurrence(series.Id, moved, booking.Booking{Room: *other, User: *s.users["alice"], StartTime: moved, EndTime: moved.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/caldav/rooms/%d/booking-%d.ics", s.room.Id, series.Id)
+	if object := alice.expect(alice.do(http.MethodGet, path, nil, ""), http.StatusOK).Body.String(); !strings.Contains(object, "RECURRENCE-ID") {
+		t.Fatalf("Expected object of the series to hold the moved exception, received\n%s", object)
+	}
+	if listing := alice.multistatus("PROPFIND", fmt.Sprintf("/caldav/rooms/%d/", other.Id), "1", propfindBody); len(listing.Responses) != 1 {
+		t.Fatalf("Expected the other room to list no objects, received %+v", listing.Responses)
+	}
+}
+
+func TestObjects_KeepTheirIdentityAcrossHostNames(t *testing.T) {
+	s := newTestServer(t)
+	start := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)
+	existing, err := s.bookings.Create(booking.Booking{Room: *s.room, User: *s.users["alice"], StartTime: start, EndTime: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/caldav/rooms/%d/booking-%d.ics", s.room.Id, existing.Id)
+	etags := []string{}
+	for _, host := range []string{"example.com", "192.168.0.10:8080"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		s.handler.Serve(w, req, s.users["alice"])
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "@booking.example.com") {
+			t.Fatalf("Expected object with UID of the configured domain, received %d\n%s", w.Code, w.Body)
+		}
+		etags = append(etags, w.Header().Get("ETag"))
+	}
+	if etags[0] != etags[1] {
+		t.Fatalf("Expected the same ETag for every host name, received %v", etags)
+	}
+}
+
+// Fails to store calendar objects, so the batch writing them is rolled back
+type failingObjectRepository struct {
+	booking.BookingRepository
+}
+
+func (r failingObjectRepository) Begin() (booking.BookingBatch, error) {
+	batch, err := r.BookingRepository.Begin()
+	return failingObjectBatch{batch}, err
+}
+
+type failingObjectBatch struct {
+	booking.BookingBatch
+}
+
+func (b failingObjectBatch) SaveCalendarObject(object booking.CalendarObject) error {
+	return fmt.Errorf("Unable to save calendar object")
+}
+
+func TestObjects_AreCreatedWithTheirBookingsOrNotAtAll(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.client(t, "alice")
+	path := fmt.Sprintf("/caldav/rooms/%d/3f2a.ics", s.room.Id)
+	s.handler.Bookings = failingObjectRepository{s.bookings}
+
+	alice.expect(alice.do(http.MethodPut, path, nil, testObject), http.StatusUnprocessableEntity)
+	if bookings, _ := s.bookings.GetAll(); len(bookings) != 0 {
+		t.Fatalf("Expected no bookings without calendar object, received %+v", bookings)
+	}
+	s.handler.Bookings = s.bookings
+	alice.expect(alice.do(http.MethodPut, path, nil, testObject), http.StatusCreated)
+}
@@ -0,0 +1,295 @@
+package caldav
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/ical"
+)
+
+// Upper limit of calendar objects written by clients
+const maxObjectSize = 1 << 20
+
+// Bookings do not record when they were changed. A constant DTSTAMP keeps the serialized object,
+// and thus its ETag, the same until the booking changes
+var objectStamp = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Names & UIDs of bookings without calendar object, which are reserved for them
+var generatedName = regexp.MustCompile(`^booking-\d+\.ics$`)
+var generatedUid = regexp.MustCompile(`^booking-\d+@`)
+
+// Booking or series with its exceptions, served as a single calendar object resource
+type object struct {
+	name string
+	uid  string
+	// Series master or single booking first
+	bookings []*booking.Booking
+	data     []byte
+	etag     string
+}
+
+func (o *object) master() *booking.Booking {
+	return o.bookings[0]
+}
+
+// Returns the objects of the bookings & series held in the room. Exceptions belong to the object
+// of their series, even if they were moved to another room
+func (h *Handler) roomObjects(room *booking.Room) ([]*object, error) {
+	bookings, err := h.Bookings.FindByFilter(booking.BookingFilter{RoomIds: []int64{room.Id}})
+	if err != nil {
+		return nil, err
+	}
+	stored, err := h.Objects.FindByRoom(room.Id)
+	if err != nil {
+		return nil, err
+	}
+	storedByBooking := map[int64]*booking.CalendarObject{}
+	for _, s := range stored {
+		storedByBooking[s.BookingId] = s
+	}
+	objects := []*object{}
+	byMaster := map[int64]*object{}
+	seriesIds := []int64{}
+	for _, b := range bookings {
+		if b.SeriesId > 0 {
+			continue
+		}
+		o := &object{name: fmt.Sprintf("booking-%d.ics", b.Id), uid: ical.Uid(b, h.Domain), bookings: []*booking.Booking{b}}
+		if s, ok := storedByBooking[b.Id]; ok {
+			o.name, o.uid = s.Name, s.Uid
+		}
+		objects = append(objects, o)
+		byMaster[b.Id] = o
+		if b.Recurrence != nil {
+			seriesIds = append(seriesIds, b.Id)
+		}
+	}
+	if len(seriesIds) > 0 {
+		exceptions, err := h.Bookings.FindByFilter(booking.BookingFilter{SeriesIds: seriesIds})
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range exceptions {
+			if o, ok := byMaster[b.SeriesId]; ok {
+				o.bookings = append(o.bookings, b)
+			}
+		}
+	}
+	for _, o := range objects {
+		var buf bytes.Buffer
+		cal := ical.Calendar{Domain: h.Domain, Bookings: o.bookings, Uids: map[int64]string{o.master().Id: o.uid}, Stamp: objectStamp}
+		if err := ical.Write(&buf, cal); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		o.data, o.etag = buf.Bytes(), `"`+hex.EncodeToString(sum[:16])+`"`
+	}
+	return objects, nil
+}
+
+// Returns the object of the given name in the room, nil if there is none
+func findObject(objects []*object, room *booking.Room, name string) *object {
+	for _, o := range objects {
+		if o.name == name && o.master().Room.Id == room.Id {
+			return o
+		}
+	}
+	return nil
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, res resource) error {
+	if res.kind != kindObject {
+		return newStatusError(http.StatusMethodNotAllowed, "", "Only calendar objects can be read with %s", r.Method)
+	}
+	objects, err := h.roomObjects(res.room)
+	if err != nil {
+		return err
+	}
+	o := findObject(objects, res.room, res.name)
+	if o == nil {
+		return newStatusError(http.StatusNotFound, "", "Resource %s not found", r.URL.Path)
+	}
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.Header().Set("ETag", o.etag)
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(o.data)
+	}
+	return nil
+}
+
+// Creates or overwrites the object. As the stored object differs from the one sent by the
+// client, no ETag is returned, so clients fetch the object again
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, user *booking.User, res resource) error {
+	if res.kind != kindObject {
+		return newStatusError(http.StatusMethodNotAllowed, "", "Only calendar objects can be written")
+	}
+	objects, err := h.roomObjects(res.room)
+	if err != nil {
+		return err
+	}
+	existing := findObject(objects, res.room, res.name)
+	if err := checkPreconditions(r, existing); err != nil {
+		return err
+	}
+	if !user.Role.CanBook() {
+		return errForbidden
+	}
+	obj, err := ical.ReadObject(io.LimitReader(r.Body, maxObjectSize), *res.room)
+	if err != nil {
+		return &statusError{http.StatusForbidden, "C:valid-calendar-data", err}
+	}
+
+	if existing == nil {
+		if err := h.checkNewObject(res.name, obj.Uid); err != nil {
+			return err
+		}
+		if err := h.create(user, obj, res.name); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+	if obj.Uid != existing.uid {
+		return newStatusError(http.StatusForbidden, "C:no-uid-conflict", "The UID of %s cannot change", res.name)
+	}
+	if err := h.replace(user, existing, obj); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Fails, if the name or UID of a new object is taken by an object of any room. Names & UIDs
+// derived from booking ids are reserved for the bookings created in the app
+func (h *Handler) checkNewObject(name string, uid string) error {
+	if generatedName.MatchString(name) {
+		return newStatusError(http.StatusForbidden, "C:valid-calendar-object-resource", "Names like %s are reserved for bookings created in the app", name)
+	}
+	if generatedUid.MatchString(uid) && strings.HasSuffix(uid, "@"+h.Domain) {
+		return newStatusError(http.StatusForbidden, "C:no-uid-conflict", "UIDs like %s are reserved for bookings created in the app", uid)
+	}
+	if o, err := h.Objects.GetByUid(uid); err == nil {
+		return newStatusError(http.StatusForbidden, "C:no-uid-conflict", "UID %s is used by booking %d", uid, o.BookingId)
+	} else if !errors.Is(err, booking.ErrNotFound) {
+		return err
+	}
+	if _, err := h.Objects.GetByName(name); err == nil {
+		return newStatusError(http.StatusConflict, "", "Name %s is used in another calendar", name)
+	} else if !errors.Is(err, booking.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Creates the bookings of a new object, made by the user, & stores the object under its name
+func (h *Handler) create(user *booking.User, obj *ical.Object, name string) error {
+	batch, err := h.Bookings.Begin()
+	if err != nil {
+		return err
+	}
+	defer batch.Rollback()
+	obj.Booking.User = *user
+	created, err := batch.Create(obj.Booking)
+	if err != nil {
+		return err
+	}
+	for _, exception := range obj.Exceptions {
+		exception.User = *user
+		if _, err := batch.UpdateOccurrence(created.Id, exception.OriginalStart, exception); err != nil {
+			return err
+		}
+	}
+	if err := batch.SaveCalendarObject(booking.CalendarObject{BookingId: created.Id, Name: name, Uid: obj.Uid}); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// Overwrites the bookings of an existing object. Bookings keep their users, as clients cannot
+// choose among the users of the app
+func (h *Handler) replace(user *booking.User, existing *object, obj *ical.Object) error {
+	if !canChange(user, existing) {
+		return errForbidden
+	}
+	batch, err := h.Bookings.Begin()
+	if err != nil {
+		return err
+	}
+	defer batch.Rollback()
+	master := existing.master()
+	obj.Booking.Id, obj.Booking.User = master.Id, master.User
+	if _, err := batch.Replace(obj.Booking); err != nil {
+		return err
+	}
+	for _, exception := range obj.Exceptions {
+		exception.User = master.User
+		for _, b := range existing.bookings[1:] {
+			if b.OriginalStart.Equal(exception.OriginalStart) {
+				exception.User = b.User
+			}
+		}
+		if _, err := batch.UpdateOccurrence(master.Id, exception.OriginalStart, exception); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, user *booking.User, res resource) error {
+	if res.kind != kindObject {
+		return newStatusError(http.StatusForbidden, "", "Only calendar objects can be deleted")
+	}
+	objects, err := h.roomObjects(res.room)
+	if err != nil {
+		return err
+	}
+	existing := findObject(objects, res.room, res.name)
+	if existing == nil {
+		return newStatusError(http.StatusNotFound, "", "Resource %s not found", r.URL.Path)
+	}
+	if err := checkPreconditions(r, existing); err != nil {
+		return err
+	}
+	if !canChange(user, existing) {
+		return errForbidden
+	}
+	// Removes the calendar object along with the bookings
+	if err := h.Bookings.Delete(existing.master().Id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Evaluates If-Match & If-None-Match against the object, which is nil if it does not exist yet
+func checkPreconditions(r *http.Request, o *object) error {
+	failed := newStatusError(http.StatusPreconditionFailed, "", "The resource has been changed in the meantime")
+	if match := r.Header.Get("If-Match"); len(match) > 0 && (o == nil || !etagMatches(match, o.etag)) {
+		return failed
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); len(noneMatch) > 0 && o != nil && etagMatches(noneMatch, o.etag) {
+		return failed
+	}
+	return nil
+}
+
+// Return true, if the ETag is listed in the header value, or the value is "*"
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
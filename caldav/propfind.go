@@ -0,0 +1,185 @@
+package caldav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/ical"
+)
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     propNames `xml:"DAV: prop"`
+}
+
+// Answers PROPFIND with depth 0 or 1. Requests without body ask for all properties
+func (h *Handler) propfind(w http.ResponseWriter, r *http.Request, user *booking.User, res resource) error {
+	var req propfindRequest
+	if _, err := decodeBody(r, &req); err != nil {
+		return err
+	}
+	requested := req.Prop
+	if req.AllProp != nil || req.PropName != nil {
+		requested = nil
+	}
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" && (res.kind == kindHome || res.kind == kindCollection) {
+		return newStatusError(http.StatusForbidden, "D:propfind-finite-depth", "Depth %s is not supported", depth)
+	}
+	m := newMultistatus()
+	add := func(href string, properties []property) {
+		if requested == nil {
+			properties = withoutCalendarData(properties)
+		}
+		m.addProperties(href, properties, requested, req.PropName != nil)
+	}
+	switch res.kind {
+	case kindRoot:
+		add(h.rootHref(), append(h.principalProperties(user), davProperty("resourcetype", "<D:collection/>")))
+	case kindPrincipal:
+		if res.userId != user.Id {
+			return newStatusError(http.StatusNotFound, "", "Principal %d not found", res.userId)
+		}
+		add(h.principalHref(user.Id), append(h.principalProperties(user), davProperty("resourcetype", "<D:principal/>")))
+	case kindHome:
+		add(h.homeHref(), []property{
+			davProperty("resourcetype", "<D:collection/>"),
+			davProperty("displayname", "Rooms"),
+			davProperty("current-user-principal", href(h.principalHref(user.Id))),
+			davProperty("current-user-privilege-set", privilegeSet("read")),
+		})
+		if depth == "1" {
+			rooms, err := h.Rooms.GetAll()
+			if err != nil {
+				return err
+			}
+			for _, room := range rooms {
+				objects, err := h.roomObjects(room)
+				if err != nil {
+					return err
+				}
+				add(h.collectionHref(room), h.collectionProperties(user, room, objects))
+			}
+		}
+	case kindCollection:
+		objects, err := h.roomObjects(res.room)
+		if err != nil {
+			return err
+		}
+		add(h.collectionHref(res.room), h.collectionProperties(user, res.room, objects))
+		if depth == "1" {
+			for _, o := range objects {
+				add(h.objectHref(res.room, o.name), objectProperties(user, o))
+			}
+		}
+	case kindObject:
+		objects, err := h.roomObjects(res.room)
+		if err != nil {
+			return err
+		}
+		o := findObject(objects, res.room, res.name)
+		if o == nil {
+			return newStatusError(http.StatusNotFound, "", "Resource %s not found", r.URL.Path)
+		}
+		add(h.objectHref(res.room, o.name), objectProperties(user, o))
+	}
+	m.write(w)
+	return nil
+}
+
+// Properties clients discover the calendars of the user with
+func (h *Handler) principalProperties(user *booking.User) []property {
+	return []property{
+		davProperty("displayname", escape(user.Name)),
+		davProperty("current-user-principal", href(h.principalHref(user.Id))),
+		davProperty("principal-URL", href(h.principalHref(user.Id))),
+		calDavProperty("calendar-home-set", href(h.homeHref())),
+		davProperty("current-user-privilege-set", privilegeSet("read")),
+	}
+}
+
+func (h *Handler) collectionProperties(user *booking.User, room *booking.Room, objects []*object) []property {
+	privileges := []string{"read"}
+	if user.Role.CanBook() {
+		privileges = append(privileges, "write-content", "bind", "unbind")
+	}
+	return []property{
+		davProperty("resourcetype", "<D:collection/><C:calendar/>"),
+		davProperty("displayname", escape(room.Title)),
+		davProperty("current-user-principal", href(h.principalHref(user.Id))),
+		davProperty("current-user-privilege-set", privilegeSet(privileges...)),
+		davProperty("supported-report-set", supportedReport("C:calendar-query")+supportedReport("C:calendar-multiget")),
+		calDavProperty("supported-calendar-component-set", `<C:comp name="VEVENT"/>`),
+		calDavProperty("supported-calendar-data", `<C:calendar-data content-type="text/calendar" version="2.0"/>`),
+		property{xml.Name{Space: calendarServerNamespace, Local: "getctag"}, ctag(objects)},
+	}
+}
+
+func objectProperties(user *booking.User, o *object) []property {
+	privileges := []string{"read"}
+	if canChange(user, o) {
+		privileges = append(privileges, "write-content")
+	}
+	return []property{
+		davProperty("resourcetype", ""),
+		davProperty("getetag", escape(o.etag)),
+		davProperty("getcontenttype", escape(ical.ContentType)),
+		davProperty("getcontentlength", fmt.Sprint(len(o.data))),
+		davProperty("current-user-privilege-set", privilegeSet(privileges...)),
+		calDavProperty("calendar-data", escape(string(o.data))),
+	}
+}
+
+// Calendar data is only returned if asked for by name
+func withoutCalendarData(properties []property) []property {
+	res := []property{}
+	for _, p := range properties {
+		if p.name != (xml.Name{Space: calDavNamespace, Local: "calendar-data"}) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Return true, if the user may change all bookings of the object
+func canChange(user *booking.User, o *object) bool {
+	for _, b := range o.bookings {
+		if !user.CanChangeBooking(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// Changes whenever an object of the collection is added, changed or removed, so clients only
+// have to compare ETags after the ctag changed
+func ctag(objects []*object) string {
+	hash := sha256.New()
+	for _, o := range objects {
+		fmt.Fprintf(hash, "%s %s\n", o.name, o.etag)
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+func href(path string) string {
+	return "<D:href>" + escape(path) + "</D:href>"
+}
+
+func privilegeSet(privileges ...string) string {
+	var b strings.Builder
+	for _, privilege := range privileges {
+		fmt.Fprintf(&b, "<D:privilege><D:%s/></D:privilege>", privilege)
+	}
+	return b.String()
+}
+
+func supportedReport(report string) string {
+	return fmt.Sprintf("<D:supported-report><D:report><%s/></D:report></D:supported-report>", report)
+}
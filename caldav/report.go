@@ -0,0 +1,173 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+// Layout of time-range boundaries
+const timeRangeLayout = "20060102T150405Z"
+
+// Matched against calendar objects that do not end before the start of a time range without end
+const openTimeRange = 10 * 365 * 24 * time.Hour
+
+type reportRequest struct {
+	XMLName xml.Name
+	AllProp *struct{} `xml:"DAV: allprop"`
+	Prop    propNames `xml:"DAV: prop"`
+	// Objects of a calendar-multiget
+	Hrefs []string `xml:"DAV: href"`
+	// Filter of a calendar-query
+	Filter *compFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+}
+
+type compFilter struct {
+	Name         string       `xml:"name,attr"`
+	TimeRange    *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	CompFilters  []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters  []struct{}   `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	IsNotDefined *struct{}    `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// Answers calendar-multiget & calendar-query reports on calendar collections. The calendar data
+// returned is always the full object, as neither expansion nor partial data is supported
+func (h *Handler) report(w http.ResponseWriter, r *http.Request, user *booking.User, res resource) error {
+	var req reportRequest
+	if ok, err := decodeBody(r, &req); err != nil || !ok {
+		if err == nil {
+			err = newStatusError(http.StatusBadRequest, "", "Missing report")
+		}
+		return err
+	}
+	if res.kind != kindCollection {
+		return newStatusError(http.StatusForbidden, "D:supported-report", "Reports are only supported on calendar collections")
+	}
+	requested := req.Prop
+	if req.AllProp != nil {
+		requested = nil
+	}
+	objects, err := h.roomObjects(res.room)
+	if err != nil {
+		return err
+	}
+	m := newMultistatus()
+	switch req.XMLName {
+	case xml.Name{Space: calDavNamespace, Local: "calendar-multiget"}:
+		for _, hrefValue := range req.Hrefs {
+			u, err := url.Parse(hrefValue)
+			if err != nil {
+				m.addStatus(hrefValue, http.StatusNotFound)
+				continue
+			}
+			target, err := h.resolve(u.Path)
+			var o *object
+			if err == nil && target.kind == kindObject && target.room.Id == res.room.Id {
+				o = findObject(objects, res.room, target.name)
+			}
+			if o == nil {
+				m.addStatus(hrefValue, http.StatusNotFound)
+				continue
+			}
+			m.addProperties(h.objectHref(res.room, o.name), objectProperties(user, o), requested, false)
+		}
+	case xml.Name{Space: calDavNamespace, Local: "calendar-query"}:
+		matching, err := h.query(res.room, objects, req.Filter)
+		if err != nil {
+			return err
+		}
+		for _, o := range matching {
+			m.addProperties(h.objectHref(res.room, o.name), objectProperties(user, o), requested, false)
+		}
+	default:
+		return newStatusError(http.StatusForbidden, "D:supported-report", "Report %s is not supported", req.XMLName.Local)
+	}
+	m.write(w)
+	return nil
+}
+
+// Returns the objects matching the filter. Only filters on VCALENDAR & VEVENT with an optional
+// time range are supported, which is what clients use to sync a range of a calendar
+func (h *Handler) query(room *booking.Room, objects []*object, filter *compFilter) ([]*object, error) {
+	unsupported := newStatusError(http.StatusForbidden, "C:supported-filter", "Only VEVENT filters by time range are supported")
+	if filter == nil || filter.Name != "VCALENDAR" || filter.TimeRange != nil || len(filter.PropFilters) > 0 || filter.IsNotDefined != nil || len(filter.CompFilters) > 1 {
+		return nil, unsupported
+	}
+	if len(filter.CompFilters) == 0 {
+		return objects, nil
+	}
+	event := filter.CompFilters[0]
+	if event.Name != "VEVENT" || len(event.CompFilters) > 0 || len(event.PropFilters) > 0 || event.IsNotDefined != nil {
+		return nil, unsupported
+	}
+	if event.TimeRange == nil {
+		return objects, nil
+	}
+	start, end, err := parseTimeRange(event.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	occurrences, err := h.Bookings.FindWithinTimeIntervalByFilter(&start, &end, booking.BookingFilter{RoomIds: []int64{room.Id}})
+	if err != nil {
+		return nil, err
+	}
+	// Exceptions moved to other rooms still belong to their series
+	seriesIds := []int64{}
+	for _, o := range objects {
+		if o.master().Recurrence != nil {
+			seriesIds = append(seriesIds, o.master().Id)
+		}
+	}
+	if len(seriesIds) > 0 {
+		exceptions, err := h.Bookings.FindWithinTimeIntervalByFilter(&start, &end, booking.BookingFilter{SeriesIds: seriesIds})
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, exceptions...)
+	}
+	// Occurrences & exceptions belong to the object of their series
+	matchingIds := map[int64]bool{}
+	for _, o := range occurrences {
+		matchingIds[o.Id] = true
+		matchingIds[o.SeriesId] = true
+	}
+	res := []*object{}
+	for _, o := range objects {
+		if matchingIds[o.master().Id] {
+			res = append(res, o)
+		}
+	}
+	return res, nil
+}
+
+func parseTimeRange(tr *timeRange) (time.Time, time.Time, error) {
+	invalid := newStatusError(http.StatusBadRequest, "C:valid-filter", "Invalid time range %s - %s", tr.Start, tr.End)
+	if len(tr.Start) == 0 && len(tr.End) == 0 {
+		return time.Time{}, time.Time{}, invalid
+	}
+	start, end := time.Unix(0, 0).UTC(), time.Time{}
+	var err error
+	if len(tr.Start) > 0 {
+		if start, err = time.Parse(timeRangeLayout, tr.Start); err != nil {
+			return start, end, invalid
+		}
+	}
+	end = start.Add(openTimeRange)
+	if len(tr.End) > 0 {
+		if end, err = time.Parse(timeRangeLayout, tr.End); err != nil {
+			return start, end, invalid
+		}
+	}
+	if !end.After(start) {
+		return start, end, invalid
+	}
+	return start, end, nil
+}
@@ -0,0 +1,164 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	davNamespace            = "DAV:"
+	calDavNamespace         = "urn:ietf:params:xml:ns:caldav"
+	calendarServerNamespace = "http://calendarserver.org/ns/"
+	xmlHeader               = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+)
+
+// Prefixes of the namespaces used in responses
+var prefixes = map[string]string{
+	davNamespace:            "D",
+	calDavNamespace:         "C",
+	calendarServerNamespace: "CS",
+}
+
+// Upper limit of PROPFIND & REPORT bodies
+const maxRequestSize = 1 << 20
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Names of the properties requested by a prop element
+type propNames []xml.Name
+
+func (p *propNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			*p = append(*p, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Property of a resource. Value is the inner XML of the property element
+type property struct {
+	name  xml.Name
+	value string
+}
+
+func davProperty(local string, value string) property {
+	return property{xml.Name{Space: davNamespace, Local: local}, value}
+}
+
+func calDavProperty(local string, value string) property {
+	return property{xml.Name{Space: calDavNamespace, Local: local}, value}
+}
+
+// Writes the element of the property with the given inner XML. Properties of unknown
+// namespaces declare their namespace themselves
+func writeProperty(b *strings.Builder, name xml.Name, value string) {
+	tag := name.Local
+	declaration := ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if len(name.Space) > 0 {
+		tag = "X:" + name.Local
+		declaration = fmt.Sprintf(` xmlns:X="%s"`, escape(name.Space))
+	}
+	if len(value) == 0 {
+		fmt.Fprintf(b, "<%s%s/>", tag, declaration)
+		return
+	}
+	fmt.Fprintf(b, "<%s%s>%s</%s>", tag, declaration, value, tag)
+}
+
+// Collects the responses of a 207 Multi-Status answer
+type multistatus struct {
+	b strings.Builder
+}
+
+func newMultistatus() *multistatus {
+	m := &multistatus{}
+	m.b.WriteString(xmlHeader)
+	fmt.Fprintf(&m.b, `<D:multistatus xmlns:D="%s" xmlns:C="%s" xmlns:CS="%s">`, davNamespace, calDavNamespace, calendarServerNamespace)
+	return m
+}
+
+// Adds the response of a resource. Requested properties the resource does not have are
+// answered with 404. Without requested names, all given properties are returned
+func (m *multistatus) addProperties(href string, properties []property, requested propNames, namesOnly bool) {
+	found, missing := []property{}, []xml.Name{}
+	if requested == nil {
+		found = properties
+	} else {
+		for _, name := range requested {
+			idx := -1
+			for i, p := range properties {
+				if p.name == name {
+					idx = i
+				}
+			}
+			if idx < 0 {
+				missing = append(missing, name)
+			} else {
+				found = append(found, properties[idx])
+			}
+		}
+	}
+	fmt.Fprintf(&m.b, "<D:response><D:href>%s</D:href>", escape(href))
+	if len(found) > 0 {
+		m.b.WriteString("<D:propstat><D:prop>")
+		for _, p := range found {
+			value := p.value
+			if namesOnly {
+				value = ""
+			}
+			writeProperty(&m.b, p.name, value)
+		}
+		fmt.Fprintf(&m.b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", http.StatusOK, http.StatusText(http.StatusOK))
+	}
+	if len(missing) > 0 {
+		m.b.WriteString("<D:propstat><D:prop>")
+		for _, name := range missing {
+			writeProperty(&m.b, name, "")
+		}
+		fmt.Fprintf(&m.b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+	m.b.WriteString("</D:response>")
+}
+
+// Adds the response of a resource that cannot be returned, e.g. an unknown href of a multiget
+func (m *multistatus) addStatus(href string, status int) {
+	fmt.Fprintf(&m.b, "<D:response><D:href>%s</D:href><D:status>HTTP/1.1 %d %s</D:status></D:response>", escape(href), status, http.StatusText(status))
+}
+
+func (m *multistatus) write(w http.ResponseWriter) {
+	m.b.WriteString("</D:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, m.b.String())
+}
+
+// Decodes the XML body of the request into v. Returns false for empty bodies
+func decodeBody(r *http.Request, v any) (bool, error) {
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(v)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, newStatusError(http.StatusBadRequest, "", "Invalid XML body: %s", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lucb31/booking-go/booking"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCalDav_AuthenticatesWithBasicAuth(t *testing.T) {
+	useTestDatabase(t)
+	createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerCalDavRoutes(r)
+	propfind := func(path string, username string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PROPFIND", path, strings.NewReader(""))
+		req.Header.Set("Depth", "0")
+		if len(username) > 0 {
+			req.SetBasicAuth(username, password)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := propfind("/caldav/", "", ""); w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("Expected basic auth challenge, received %d %v", w.Code, w.Header())
+	}
+	if w := propfind("/caldav/", "alice", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected wrong password to be rejected, received %d", w.Code)
+	}
+	if w := propfind("/caldav/", "alice", "correct horse"); w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "<D:displayname>alice</D:displayname>") {
+		t.Fatalf("Expected properties of alice, received %d: %s", w.Code, w.Body)
+	}
+	if w := propfind("/.well-known/caldav", "", ""); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/caldav/" {
+		t.Fatalf("Expected redirect to CalDAV root, received %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,236 @@
+package calendar
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+// Longest window searched for free rooms or busy times
+const MaxAvailabilityWindow = 31 * 24 * time.Hour
+
+// Number of candidates returned by FindRooms if the query has no limit
+const DefaultCandidateLimit = 10
+
+// Answers when rooms are free without listing their bookings. Working hours are given in the time
+// zone of each room, e.g. a room in Berlin opening at 8 AM is free from 8 AM Berlin time
+type AvailabilityService interface {
+	// Returns free slots of the query's duration, best candidates first
+	FindRooms(query AvailabilityQuery) ([]Candidate, error)
+	// Returns the busy intervals of each room of the filter within [from, to)
+	FreeBusy(from time.Time, to time.Time, filter RoomSelection) ([]RoomBusy, error)
+}
+
+// Rooms of a search. The zero value selects all rooms
+type RoomSelection struct {
+	// Rooms to choose from. Empty for all rooms
+	RoomIds []int64
+	// Attributes the rooms have to have
+	Rooms booking.RoomFilter
+}
+
+func (s RoomSelection) Matches(room *booking.Room) bool {
+	return (len(s.RoomIds) == 0 || slices.Contains(s.RoomIds, room.Id)) && s.Rooms.Matches(room)
+}
+
+// Search for a room that is free for the duration within [From, To)
+type AvailabilityQuery struct {
+	Duration time.Duration
+	From     time.Time
+	To       time.Time
+	RoomSelection
+	// Maximum number of candidates. Defaults to DefaultCandidateLimit
+	Limit int
+}
+
+// Free slot of a room. Starts are aligned to the slots of the room's working hours
+type Candidate struct {
+	Room  *booking.Room
+	Start time.Time
+	End   time.Time
+	// Free interval within the working hours the slot lies in
+	FreeFrom  time.Time
+	FreeUntil time.Time
+}
+
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+type RoomBusy struct {
+	Room *booking.Room
+	// Merged bookings of the room, ordered by start
+	Busy []Interval
+}
+
+type AvailabilityServiceImpl struct {
+	bookingRepo booking.BookingRepository
+	roomRepo    booking.RoomsRepository
+	config      Config
+}
+
+func NewAvailabilityService(bookingRepo booking.BookingRepository, roomRepo booking.RoomsRepository, config Config) AvailabilityServiceImpl {
+	return AvailabilityServiceImpl{bookingRepo: bookingRepo, roomRepo: roomRepo, config: config}
+}
+
+func validateWindow(from time.Time, to time.Time) error {
+	if !to.After(from) {
+		return fmt.Errorf("The end of the search window has to be after its start")
+	}
+	if to.Sub(from) > MaxAvailabilityWindow {
+		return fmt.Errorf("The search window cannot exceed %d days", int(MaxAvailabilityWindow.Hours()/24))
+	}
+	return nil
+}
+
+func (q AvailabilityQuery) Validate() error {
+	if q.Duration < time.Minute {
+		return fmt.Errorf("Duration has to be at least a minute")
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("Limit cannot be negative")
+	}
+	return validateWindow(q.From, q.To)
+}
+
+// Candidates are ranked by start, so the earliest free slot comes first. Slots starting at the
+// same time prefer the room whose free interval fits the duration most tightly, which keeps
+// longer free intervals available for longer meetings
+func (s AvailabilityServiceImpl) FindRooms(query AvailabilityQuery) ([]Candidate, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	// Bookings just outside the window still limit the free intervals the slots are ranked by
+	busy, err := s.busy(query.From.AddDate(0, 0, -1), query.To.AddDate(0, 0, 1), query.RoomSelection)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []Candidate{}
+	for _, room := range busy {
+		candidates = append(candidates, s.roomCandidates(room, query)...)
+	}
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		if c := a.FreeUntil.Sub(a.FreeFrom) - b.FreeUntil.Sub(b.FreeFrom); c != 0 {
+			return int(c / time.Minute)
+		}
+		return strings.Compare(a.Room.Title, b.Room.Title)
+	})
+	limit := query.Limit
+	if limit == 0 {
+		limit = DefaultCandidateLimit
+	}
+	return candidates[:min(limit, len(candidates))], nil
+}
+
+// Returns the free slots of the room within the working days of the query window
+func (s AvailabilityServiceImpl) roomCandidates(room RoomBusy, query AvailabilityQuery) []Candidate {
+	hours := s.config.For(room.Room.Id)
+	loc := room.Room.Location()
+	res := []Candidate{}
+	for day := startOfDay(query.From.In(loc)); day.Before(query.To); day = day.AddDate(0, 0, 1) {
+		if !slices.Contains(hours.Days, day.Weekday()) {
+			continue
+		}
+		opening, closing := atTimeOfDay(day, hours.Start), atTimeOfDay(day, hours.End)
+		for _, free := range freeIntervals(opening, closing, room.Busy) {
+			for start := opening; !start.Add(query.Duration).After(free.End); start = start.Add(hours.slot()) {
+				end := start.Add(query.Duration)
+				if start.Before(free.Start) || start.Before(query.From) || end.After(query.To) {
+					continue
+				}
+				res = append(res, Candidate{Room: room.Room, Start: start, End: end, FreeFrom: free.Start, FreeUntil: free.End})
+			}
+		}
+	}
+	return res
+}
+
+// Returns the gaps between the busy intervals within [start, end)
+func freeIntervals(start time.Time, end time.Time, busy []Interval) []Interval {
+	res := []Interval{}
+	for _, b := range busy {
+		if !b.End.After(start) {
+			continue
+		}
+		if !b.Start.Before(end) {
+			break
+		}
+		if b.Start.After(start) {
+			res = append(res, Interval{start, b.Start})
+		}
+		start = b.End
+	}
+	if end.After(start) {
+		res = append(res, Interval{start, end})
+	}
+	return res
+}
+
+// Bookings outside the working hours count as busy as well, so FreeBusy shows every booking of
+// the rooms
+func (s AvailabilityServiceImpl) FreeBusy(from time.Time, to time.Time, filter RoomSelection) ([]RoomBusy, error) {
+	if err := validateWindow(from, to); err != nil {
+		return nil, err
+	}
+	return s.busy(from, to, filter)
+}
+
+func (s AvailabilityServiceImpl) busy(from time.Time, to time.Time, filter RoomSelection) ([]RoomBusy, error) {
+	rooms, err := s.roomRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	bookings, err := s.bookingRepo.FindWithinTimeIntervalByFilter(&from, &to, booking.BookingFilter{RoomIds: filter.RoomIds, Rooms: filter.Rooms})
+	if err != nil {
+		return nil, err
+	}
+	res := []RoomBusy{}
+	for _, room := range rooms {
+		if !filter.Matches(room) {
+			continue
+		}
+		busy := []Interval{}
+		for _, b := range bookings {
+			if b.Room.Id == room.Id {
+				busy = append(busy, Interval{maxTime(b.StartTime, from), minTime(b.EndTime, to)})
+			}
+		}
+		res = append(res, RoomBusy{Room: room, Busy: mergeIntervals(busy)})
+	}
+	return res, nil
+}
+
+// Sorts the intervals & merges overlapping or adjacent ones
+func mergeIntervals(intervals []Interval) []Interval {
+	slices.SortFunc(intervals, func(a, b Interval) int { return a.Start.Compare(b.Start) })
+	res := []Interval{}
+	for _, interval := range intervals {
+		if last := len(res) - 1; last >= 0 && !interval.Start.After(res[last].End) {
+			res[last].End = maxTime(res[last].End, interval.End)
+			continue
+		}
+		res = append(res, interval)
+	}
+	return res
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,147 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+func TestFindRooms_RanksEarliestAndTightestSlotsFirst(t *testing.T) {
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		// Room 1 is free from 10 to 12, room 2 from 10 to 18
+		newStubBooking("2024-07-01 08:00", "2024-07-01 10:00", 1),
+		newStubBooking("2024-07-01 12:00", "2024-07-01 18:00", 1),
+		newStubBooking("2024-07-01 08:00", "2024-07-01 09:30", 2),
+		// Room 3 is busy all day
+		newStubBooking("2024-07-01 07:00", "2024-07-01 19:00", 3),
+	}}
+	rooms := stubRoomsRepository{rooms: []*booking.Room{{Id: 1, Title: "A"}, {Id: 2, Title: "B"}, {Id: 3, Title: "C"}}}
+	service := NewAvailabilityService(repo, rooms, DefaultConfig())
+	from, _ := time.Parse(layout, "2024-07-01 08:00")
+	to, _ := time.Parse(layout, "2024-07-01 18:00")
+
+	candidates, err := service.FindRooms(AvailabilityQuery{Duration: 2 * time.Hour, From: from, To: to, Limit: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		roomId int64
+		start  string
+	}{
+		{1, "2024-07-01 10:00"},
+		{2, "2024-07-01 10:00"},
+		{2, "2024-07-01 11:00"},
+		{2, "2024-07-01 12:00"},
+	}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Expected %d candidates, received %d", len(expected), len(candidates))
+	}
+	for idx, candidate := range candidates {
+		start := candidate.Start.Format(layout)
+		if candidate.Room.Id != expected[idx].roomId || start != expected[idx].start {
+			t.Errorf("Expected candidate %d in room %d at %s, received room %d at %s", idx, expected[idx].roomId, expected[idx].start, candidate.Room.Id, start)
+		}
+		if candidate.End.Sub(candidate.Start) != 2*time.Hour {
+			t.Errorf("Expected candidate %d to last 2 hours, received %s", idx, candidate.End.Sub(candidate.Start))
+		}
+	}
+}
+
+func TestFindRooms_KeepsToWorkingHoursOfTheRoom(t *testing.T) {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	rooms := stubRoomsRepository{rooms: []*booking.Room{{Id: 1, Title: "A", TimeZone: "Europe/Berlin"}}}
+	service := NewAvailabilityService(stubBookingRepository{}, rooms, DefaultConfig())
+	// Friday 6 PM until Monday 10 AM UTC
+	from, _ := time.Parse(layout, "2024-07-05 18:00")
+	to, _ := time.Parse(layout, "2024-07-08 10:00")
+
+	candidates, err := service.FindRooms(AvailabilityQuery{Duration: time.Hour, From: from, To: to, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Working hours on Monday start at 8 AM Berlin time, i.e. 6 AM UTC
+	expected := []string{"2024-07-08 08:00", "2024-07-08 09:00", "2024-07-08 10:00", "2024-07-08 11:00"}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Expected %d candidates, received %d", len(expected), len(candidates))
+	}
+	for idx, candidate := range candidates {
+		if start := candidate.Start.In(berlin).Format(layout); start != expected[idx] {
+			t.Errorf("Expected candidate %d at %s, received %s", idx, expected[idx], start)
+		}
+	}
+}
+
+func TestFindRooms_FiltersByRoomAttributes(t *testing.T) {
+	rooms := stubRoomsRepository{rooms: []*booking.Room{
+		{Id: 1, Title: "Small", Capacity: 4, Equipment: []string{"projector"}},
+		{Id: 2, Title: "Large", Capacity: 20, Equipment: []string{"whiteboard"}},
+		{Id: 3, Title: "Hall", Capacity: 100, Equipment: []string{"projector", "video-conference"}},
+	}}
+	service := NewAvailabilityService(stubBookingRepository{}, rooms, DefaultConfig())
+	from, _ := time.Parse(layout, "2024-07-01 08:00")
+	query := AvailabilityQuery{Duration: time.Hour, From: from, To: from.Add(time.Hour)}
+	query.Rooms = booking.RoomFilter{MinCapacity: 10, Equipment: []string{"Projector"}}
+
+	candidates, err := service.FindRooms(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Room.Id != 3 {
+		t.Fatalf("Expected the hall only, received %+v", candidates)
+	}
+}
+
+func TestFindRooms_RejectsInvalidQueries(t *testing.T) {
+	service := NewAvailabilityService(stubBookingRepository{}, stubRoomsRepository{}, DefaultConfig())
+	from, _ := time.Parse(layout, "2024-07-01 08:00")
+	tests := []struct {
+		name  string
+		query AvailabilityQuery
+	}{
+		{"no duration", AvailabilityQuery{From: from, To: from.Add(time.Hour)}},
+		{"empty window", AvailabilityQuery{Duration: time.Hour, From: from, To: from}},
+		{"window too long", AvailabilityQuery{Duration: time.Hour, From: from, To: from.AddDate(0, 2, 0)}},
+		{"negative limit", AvailabilityQuery{Duration: time.Hour, From: from, To: from.Add(time.Hour), Limit: -1}},
+	}
+	for _, tt := range tests {
+		if _, err := service.FindRooms(tt.query); err == nil {
+			t.Errorf("%s: Expected error", tt.name)
+		}
+	}
+}
+
+func TestFreeBusy_MergesBookingsPerRoom(t *testing.T) {
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		newStubBooking("2024-07-01 09:00", "2024-07-01 10:00", 1),
+		newStubBooking("2024-07-01 09:30", "2024-07-01 11:00", 1),
+		newStubBooking("2024-07-01 11:00", "2024-07-01 12:00", 1),
+		newStubBooking("2024-07-01 14:00", "2024-07-01 20:00", 1),
+		newStubBooking("2024-07-01 09:00", "2024-07-01 10:00", 2),
+	}}
+	rooms := stubRoomsRepository{rooms: []*booking.Room{{Id: 1, Title: "A"}, {Id: 2, Title: "B"}, {Id: 3, Title: "C"}}}
+	service := NewAvailabilityService(repo, rooms, DefaultConfig())
+	from, _ := time.Parse(layout, "2024-07-01 08:00")
+	to, _ := time.Parse(layout, "2024-07-01 18:00")
+
+	busy, err := service.FreeBusy(from, to, RoomSelection{RoomIds: []int64{1, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(busy) != 2 || busy[0].Room.Id != 1 || busy[1].Room.Id != 3 {
+		t.Fatalf("Expected rooms 1 & 3, received %+v", busy)
+	}
+	// Intervals are merged & cut off at the end of the range
+	expected := [][2]string{{"2024-07-01 09:00", "2024-07-01 12:00"}, {"2024-07-01 14:00", "2024-07-01 18:00"}}
+	if len(busy[0].Busy) != len(expected) {
+		t.Fatalf("Expected %d busy intervals, received %+v", len(expected), busy[0].Busy)
+	}
+	for idx, interval := range busy[0].Busy {
+		if interval.Start.Format(layout) != expected[idx][0] || interval.End.Format(layout) != expected[idx][1] {
+			t.Errorf("Expected busy interval %s - %s, received %s - %s", expected[idx][0], expected[idx][1], interval.Start.Format(layout), interval.End.Format(layout))
+		}
+	}
+	if len(busy[1].Busy) != 0 {
+		t.Errorf("Expected room 3 to be free, received %+v", busy[1].Busy)
+	}
+}
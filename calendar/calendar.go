@@ -3,27 +3,63 @@ package calendar
 import (
 	"fmt"
 	"lucb31/booking-go/booking"
+	"slices"
 	"time"
 )
 
+// Views of the calendar only show the bookings matching the filter. An empty filter shows
+// the bookings of all rooms & users. Days, working hours & bookings are given in the time zone
+// of the service
 type CalendarService interface {
-	GetCalendarDayData(year int, week int) ([]CalendarDayData, error)
-	GenerateTimeMarkers() []string
+	// Returns the working days of the week
+	GetCalendarDayData(year int, week int, filter booking.BookingFilter) ([]CalendarDayData, error)
+	// Returns the working hours of a single day
+	GetDayData(date time.Time, filter booking.BookingFilter) (CalendarDayData, error)
+	// Returns the working hours of a single day with one column per room
+	GetResourceData(date time.Time, filter booking.BookingFilter) ([]CalendarResourceData, error)
+	// Returns the weeks overlapping the month, including the days of adjacent months
+	GetMonthData(year int, month time.Month, filter booking.BookingFilter) (CalendarMonthData, error)
+	// Returns the days within [from, from + numDays) that have bookings
+	GetAgendaData(from time.Time, numDays int, filter booking.BookingFilter) ([]AgendaDay, error)
+	GenerateTimeMarkers(filter booking.BookingFilter) []string
+	// Returns the working hours covering all rooms of the filter
+	WorkingHours(filter booking.BookingFilter) WorkingHours
 }
 
 type CalendarServiceImpl struct {
 	bookingRepo booking.BookingRepository
+	roomRepo    booking.RoomsRepository
+	config      Config
+	// Time zone the calendar is displayed in. Nil for UTC
+	location *time.Location
 }
 
-func NewService(bookingRepo booking.BookingRepository) CalendarServiceImpl {
-	return CalendarServiceImpl{bookingRepo}
+func NewService(bookingRepo booking.BookingRepository, roomRepo booking.RoomsRepository, config Config) CalendarServiceImpl {
+	return CalendarServiceImpl{bookingRepo: bookingRepo, roomRepo: roomRepo, config: config}
+}
+
+// Returns a copy of the service displaying the calendar in the given time zone
+func (s CalendarServiceImpl) WithLocation(loc *time.Location) CalendarServiceImpl {
+	s.location = loc
+	return s
+}
+
+func (s CalendarServiceImpl) loc() *time.Location {
+	if s.location == nil {
+		return time.UTC
+	}
+	return s.location
 }
 
 type CalendarEvent struct {
-	// Relative to workingHourStart
-	StartHour int
-	// Relative to workingHourStart
-	EndHour int
+	// Grid line the event starts at. 1 is the start of working hours
+	StartRow int
+	// Grid line the event ends at, exclusive
+	EndRow int
+	// Column of the event within its collision group, starting at 0
+	Lane int
+	// Number of columns the collision group is split into
+	Lanes   int
 	Booking *booking.Booking
 }
 
@@ -31,19 +67,49 @@ type CalendarDayData struct {
 	DayNum    int
 	DayString string
 	Events    []CalendarEvent
+	Date      time.Time
 	//Bookings []booking.Booking
 }
 
-// Working hours will be split in numTimeMarkers blocks within the calendar
-const numTimeMarkers = 10
-const workingHourStart = 8
-const workingHoursEnd = 17
+// Column of the resource view
+type CalendarResourceData struct {
+	Room   *booking.Room
+	Events []CalendarEvent
+}
+
+type CalendarMonthDay struct {
+	Date time.Time
+	// False for days of the adjacent months filling the first & last week
+	InMonth  bool
+	Bookings []*booking.Booking
+}
+
+type CalendarMonthData struct {
+	Year  int
+	Month time.Month
+	// Weeks from Monday to Sunday
+	Weeks [][]CalendarMonthDay
+}
+
+type AgendaDay struct {
+	Date     time.Time
+	Bookings []*booking.Booking
+}
+
+func (s CalendarServiceImpl) WorkingHours(filter booking.BookingFilter) WorkingHours {
+	return s.config.ForRooms(filter.RoomIds)
+}
 
-func (s CalendarServiceImpl) GenerateTimeMarkers() []string {
-	// Generate time markers
-	var timeMarkers [numTimeMarkers]string
-	for i := 0; i < numTimeMarkers; i++ {
-		workingHour := i + workingHourStart
+// Returns a label for every slot of the working hours. Only full hours are labeled
+func (s CalendarServiceImpl) GenerateTimeMarkers(filter booking.BookingFilter) []string {
+	hours := s.WorkingHours(filter)
+	timeMarkers := make([]string, hours.NumSlots())
+	for i := range timeMarkers {
+		offset := hours.Start + time.Duration(i)*hours.slot()
+		if offset%time.Hour != 0 {
+			continue
+		}
+		workingHour := int(offset / time.Hour)
 		// Convert 24h hours into AM/PM format
 		amPm := "AM"
 		if workingHour > 11 {
@@ -51,67 +117,239 @@ func (s CalendarServiceImpl) GenerateTimeMarkers() []string {
 		}
 		timeMarkers[i] = fmt.Sprintf("%d %s", workingHour, amPm)
 	}
-	return timeMarkers[:]
+	return timeMarkers
 }
 
-func (s CalendarServiceImpl) GetCalendarDayData(year int, week int) ([]CalendarDayData, error) {
-	dateOfFirstMonday := WeekStart(year, week)
+func (s CalendarServiceImpl) GetCalendarDayData(year int, week int, filter booking.BookingFilter) ([]CalendarDayData, error) {
+	monday := WeekStart(year, week)
+	dateOfFirstMonday := time.Date(monday.Year(), monday.Month(), monday.Day(), 0, 0, 0, 0, s.loc())
+	hours := s.WorkingHours(filter)
+
+	dayData := make([]CalendarDayData, len(hours.Days))
+	for idx, workingDay := range hours.Days {
+		// Weeks start on Monday
+		workingTime := dateOfFirstMonday.AddDate(0, 0, (int(workingDay)+6)%7)
+		var err error
+		if dayData[idx], err = s.getDayData(workingTime, hours, filter); err != nil {
+			return dayData, err
+		}
+	}
+
+	return dayData, nil
+}
+
+func (s CalendarServiceImpl) GetDayData(date time.Time, filter booking.BookingFilter) (CalendarDayData, error) {
+	return s.getDayData(date, s.WorkingHours(filter), filter)
+}
+
+func (s CalendarServiceImpl) getDayData(date time.Time, hours WorkingHours, filter booking.BookingFilter) (CalendarDayData, error) {
+	midnight := startOfDay(date.In(s.loc()))
+	// Abbreviate name of weekday to 3 characters
+	dayString := midnight.Weekday().String()[0:3]
+	// Working hours are wall clock times, which are not evenly spaced on days with DST changes
+	filterStartDate := atTimeOfDay(midnight, hours.Start)
+	filterEndDate := atTimeOfDay(midnight, hours.End)
+
+	// Filter bookings by calendar date
+	filteredBookings, err := s.findBookings(filterStartDate, filterEndDate, filter)
+	if err != nil {
+		return CalendarDayData{}, err
+	}
+
+	// Map bookings to Event data
+	events := make([]CalendarEvent, len(filteredBookings))
+	for idx, b := range filteredBookings {
+		events[idx] = mapBookingToCalendarEvent(b, filterStartDate, hours)
+	}
+	layoutEvents(events)
+	return CalendarDayData{midnight.Day(), dayString, events, midnight}, nil
+}
+
+// Returns one column for each room of the filter, or for all rooms if the filter has none.
+// Rooms lacking attributes of the filter have no column. Columns share the working hours covering all of their rooms
+func (s CalendarServiceImpl) GetResourceData(date time.Time, filter booking.BookingFilter) ([]CalendarResourceData, error) {
+	rooms, err := s.roomRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	columns := []CalendarResourceData{}
+	roomIds := []int64{}
+	for _, room := range rooms {
+		if (len(filter.RoomIds) == 0 || slices.Contains(filter.RoomIds, room.Id)) && filter.Rooms.Matches(room) {
+			columns = append(columns, CalendarResourceData{Room: room})
+			roomIds = append(roomIds, room.Id)
+		}
+	}
+	if len(columns) == 0 {
+		return columns, nil
+	}
+	filter.RoomIds = roomIds
+	day, err := s.GetDayData(date, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range day.Events {
+		idx := slices.Index(roomIds, event.Booking.Room.Id)
+		columns[idx].Events = append(columns[idx].Events, event)
+	}
+	// Events only collide with events of the same room
+	for _, column := range columns {
+		layoutEvents(column.Events)
+	}
+	return columns, nil
+}
 
-	workingDays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
-	var dayData [5]CalendarDayData
-	for idx, workingDay := range workingDays {
-		// Abbreviate name of weekday to 3 characters
-		dayString := workingDay.String()[0:3]
-		workingTime := dateOfFirstMonday.AddDate(0, 0, idx)
-		filterStartDate := time.Date(workingTime.Year(), workingTime.Month(), workingTime.Day(), workingHourStart, 0, 0, 0, workingTime.Location())
-		filterEndDate := time.Date(workingTime.Year(), workingTime.Month(), workingTime.Day(), workingHoursEnd+1, 0, 0, 0, workingTime.Location())
-		dayNum := workingTime.Day()
+func (s CalendarServiceImpl) GetMonthData(year int, month time.Month, filter booking.BookingFilter) (CalendarMonthData, error) {
+	firstOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, s.loc())
+	// Pad with the days of the adjacent months to full weeks from Monday to Sunday
+	start := firstOfMonth.AddDate(0, 0, -((int(firstOfMonth.Weekday()) + 6) % 7))
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	end := lastOfMonth.AddDate(0, 0, 7-((int(lastOfMonth.Weekday())+6)%7))
 
-		// Filter bookings by calendar date
-		filteredBookings, err := s.bookingRepo.FindWithinTimeInterval(&filterStartDate, &filterEndDate)
-		if err != nil {
-			return dayData[:], err
+	bookings, err := s.findBookings(start, end, filter)
+	if err != nil {
+		return CalendarMonthData{}, err
+	}
+	res := CalendarMonthData{Year: firstOfMonth.Year(), Month: firstOfMonth.Month()}
+	for weekStart := start; weekStart.Before(end); weekStart = weekStart.AddDate(0, 0, 7) {
+		week := make([]CalendarMonthDay, 7)
+		for idx := range week {
+			date := weekStart.AddDate(0, 0, idx)
+			week[idx] = CalendarMonthDay{date, date.Month() == firstOfMonth.Month(), bookingsOfDay(bookings, date)}
 		}
+		res.Weeks = append(res.Weeks, week)
+	}
+	return res, nil
+}
 
-		// Map bookings to Event data
-		events := make([]CalendarEvent, len(filteredBookings))
-		for idx, b := range filteredBookings {
-			events[idx] = mapBookingToCalendarEvent(b, &filterStartDate, &filterEndDate)
+func (s CalendarServiceImpl) GetAgendaData(from time.Time, numDays int, filter booking.BookingFilter) ([]AgendaDay, error) {
+	start := startOfDay(from.In(s.loc()))
+	end := start.AddDate(0, 0, numDays)
+	bookings, err := s.findBookings(from, end, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := []AgendaDay{}
+	for date := start; date.Before(end); date = date.AddDate(0, 0, 1) {
+		if dayBookings := bookingsOfDay(bookings, date); len(dayBookings) > 0 {
+			res = append(res, AgendaDay{date, dayBookings})
 		}
-		dayData[idx] = CalendarDayData{dayNum, dayString, events}
 	}
+	return res, nil
+}
 
-	return dayData[:], nil
+// Returns the bookings & occurrences overlapping [start, end), ordered by start. Times are
+// converted into the time zone of the service
+func (s CalendarServiceImpl) findBookings(start time.Time, end time.Time, filter booking.BookingFilter) ([]*booking.Booking, error) {
+	bookings, err := s.bookingRepo.FindWithinTimeIntervalByFilter(&start, &end, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*booking.Booking, len(bookings))
+	for idx, b := range bookings {
+		local := b.In(s.loc())
+		res[idx] = &local
+	}
+	return res, nil
 }
 
-func mapBookingToCalendarEvent(b *booking.Booking, startLimit *time.Time, endLimit *time.Time) CalendarEvent {
-	relativeStartHour := 1
-	if !b.StartTime.Before(*startLimit) {
-		// Offset by starting work hour, starting at 1; cannot be lower than 1
-		relativeStartHour = max(1, min(numTimeMarkers, b.StartTime.Hour()-workingHourStart+1))
+// Returns the bookings overlapping the day of date. Bookings spanning multiple days are part of each day
+func bookingsOfDay(bookings []*booking.Booking, date time.Time) []*booking.Booking {
+	dayStart := startOfDay(date)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	res := []*booking.Booking{}
+	for _, b := range bookings {
+		if b.StartTime.Before(dayEnd) && b.EndTime.After(dayStart) {
+			res = append(res, b)
+		}
 	}
-	relativeEndHour := numTimeMarkers
-	if !b.EndTime.After(*endLimit) {
-		// Offset by starting work hour, starting at 1; cannot be lower than numTimeMarkers
-		relativeEndHour = max(1, min(numTimeMarkers, b.EndTime.Hour()-workingHourStart+1))
+	return res
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Returns the wall clock time at the offset from midnight of the day. 24:00 is the start of the next day
+func atTimeOfDay(midnight time.Time, offset time.Duration) time.Time {
+	return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), 0, int(offset/time.Minute), 0, 0, midnight.Location())
+}
+
+// Returns the difference between the wall clock times of t and start, ignoring DST changes in between
+func wallClockSub(t time.Time, start time.Time) time.Duration {
+	t = t.In(start.Location())
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
 	}
-	return CalendarEvent{relativeStartHour, relativeEndHour, b}
+	return wall(t).Sub(wall(start))
 }
 
-func WeekStart(year, week int) time.Time {
-	// Start from the middle of the year:
-	t := time.Date(year, 7, 1, 0, 0, 0, 0, time.UTC)
+// Places the booking on the grid of the working day starting at dayStart. Bookings are
+// stretched to full slots and cut off at the end of the working hours. Rows are wall clock
+// times of the time zone of dayStart
+func mapBookingToCalendarEvent(b *booking.Booking, dayStart time.Time, hours WorkingHours) CalendarEvent {
+	numSlots := hours.NumSlots()
+	slot := hours.slot()
+	startSlot := 0
+	if b.StartTime.After(dayStart) {
+		startSlot = min(numSlots-1, int(wallClockSub(b.StartTime, dayStart)/slot))
+	}
+	// Round up to the end of the slot the booking ends in
+	endSlot := int((wallClockSub(b.EndTime, dayStart) + slot - 1) / slot)
+	endSlot = max(startSlot+1, min(numSlots, endSlot))
+	return CalendarEvent{StartRow: startSlot + 1, EndRow: endSlot + 1, Lane: 0, Lanes: 1, Booking: b}
+}
 
-	// Roll back to Monday:
-	if wd := t.Weekday(); wd == time.Sunday {
-		t = t.AddDate(0, 0, -6)
-	} else {
-		t = t.AddDate(0, 0, -int(wd)+1)
+// Places overlapping events side by side. Events overlapping each other, directly or through a
+// chain of overlapping events, form a collision group that is split into lanes. Each event takes
+// the first lane that is free at its start. Sorts the events by start
+func layoutEvents(events []CalendarEvent) {
+	slices.SortStableFunc(events, func(a, b CalendarEvent) int {
+		if a.StartRow != b.StartRow {
+			return a.StartRow - b.StartRow
+		}
+		// Longer events first, so they end up in the leftmost lane
+		return b.EndRow - a.EndRow
+	})
+	groupStart := 0
+	groupEnd := 0
+	// End row of the last event of each lane of the current group
+	laneEnds := []int{}
+	finishGroup := func(end int) {
+		for idx := groupStart; idx < end; idx++ {
+			events[idx].Lanes = len(laneEnds)
+		}
+	}
+	for idx := range events {
+		event := &events[idx]
+		if event.StartRow >= groupEnd {
+			finishGroup(idx)
+			groupStart = idx
+			laneEnds = laneEnds[:0]
+		}
+		groupEnd = max(groupEnd, event.EndRow)
+		event.Lane = slices.IndexFunc(laneEnds, func(end int) bool { return end <= event.StartRow })
+		if event.Lane < 0 {
+			event.Lane = len(laneEnds)
+			laneEnds = append(laneEnds, 0)
+		}
+		laneEnds[event.Lane] = event.EndRow
 	}
+	finishGroup(len(events))
+}
 
-	// Difference in weeks:
-	_, w := t.ISOWeek()
-	t = t.AddDate(0, 0, (week-w)*7)
+// Returns Monday of the ISO week. Weeks beyond the last week of the year continue into the next year
+func WeekStart(year, week int) time.Time {
+	// January 4th is always part of the first ISO week
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	// Roll back to Monday
+	firstMonday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return firstMonday.AddDate(0, 0, (week-1)*7)
+}
 
-	return t
+// Returns the number of ISO weeks of the year, either 52 or 53
+func WeeksInYear(year int) int {
+	// December 28th is always part of the last ISO week
+	_, week := time.Date(year, time.December, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+	return week
 }
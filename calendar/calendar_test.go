@@ -0,0 +1,421 @@
+package calendar
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+const layout = "2006-01-02 15:04"
+
+func TestMapBookingToCalendarEvent(t *testing.T) {
+	hours := WorkingHours{Start: 7 * time.Hour, End: 20 * time.Hour, SlotMinutes: 15}
+	dayStart, _ := time.Parse(layout, "2024-07-01 07:00")
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		startRow int
+		endRow   int
+	}{
+		{"first slot", "2024-07-01 07:00", "2024-07-01 07:15", 1, 2},
+		{"quarter hours", "2024-07-01 09:15", "2024-07-01 10:45", 10, 16},
+		{"rounded to full slots", "2024-07-01 09:20", "2024-07-01 09:40", 10, 12},
+		{"starts before working hours", "2024-07-01 06:00", "2024-07-01 07:30", 1, 3},
+		{"ends after working hours", "2024-07-01 19:30", "2024-07-01 22:00", 51, 53},
+		{"spans multiple days", "2024-06-30 12:00", "2024-07-02 12:00", 1, 53},
+	}
+	for _, tt := range tests {
+		start, _ := time.Parse(layout, tt.start)
+		end, _ := time.Parse(layout, tt.end)
+		event := mapBookingToCalendarEvent(&booking.Booking{StartTime: start, EndTime: end}, dayStart, hours)
+		if event.StartRow != tt.startRow || event.EndRow != tt.endRow {
+			t.Errorf("%s: Expected rows %d-%d, received %d-%d", tt.name, tt.startRow, tt.endRow, event.StartRow, event.EndRow)
+		}
+	}
+}
+
+func TestLayoutEvents_AssignsLanesToCollisionGroups(t *testing.T) {
+	type row struct{ start, end int }
+	type lane struct{ lane, lanes int }
+	tests := []struct {
+		name     string
+		rows     []row
+		expected []lane
+	}{
+		{"no overlap", []row{{1, 3}, {3, 5}}, []lane{{0, 1}, {0, 1}}},
+		{"same rows", []row{{1, 3}, {1, 3}, {1, 3}}, []lane{{0, 3}, {1, 3}, {2, 3}}},
+		{"chain reuses freed lane", []row{{1, 3}, {2, 4}, {3, 5}}, []lane{{0, 2}, {1, 2}, {0, 2}}},
+		{"chain spans long event", []row{{1, 9}, {2, 4}, {4, 6}, {5, 7}}, []lane{{0, 3}, {1, 3}, {1, 3}, {2, 3}}},
+		{"separate groups", []row{{1, 4}, {2, 3}, {5, 6}, {7, 9}, {8, 10}}, []lane{{0, 2}, {1, 2}, {0, 1}, {0, 2}, {1, 2}}},
+		{"longer event first", []row{{1, 2}, {1, 5}, {3, 4}}, []lane{{1, 2}, {0, 2}, {1, 2}}},
+	}
+	for _, tt := range tests {
+		events := make([]CalendarEvent, len(tt.rows))
+		for idx, r := range tt.rows {
+			events[idx] = CalendarEvent{StartRow: r.start, EndRow: r.end, Booking: &booking.Booking{Id: int64(idx)}}
+		}
+		layoutEvents(events)
+		for _, event := range events {
+			expected := tt.expected[event.Booking.Id]
+			if event.Lane != expected.lane || event.Lanes != expected.lanes {
+				t.Errorf("%s: Expected event %d in lane %d of %d, received %d of %d", tt.name, event.Booking.Id, expected.lane, expected.lanes, event.Lane, event.Lanes)
+			}
+		}
+	}
+}
+
+func TestGetDayData_UsesWallClockOnDSTChangeDays(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Times in UTC. Berlin switches from +01:00 to +02:00 on 2024-03-31 and back on 2024-10-27
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		newStubBooking("2024-03-31 02:00", "2024-03-31 03:00", 1),
+		newStubBooking("2024-03-31 07:00", "2024-03-31 08:00", 1),
+		newStubBooking("2024-10-27 03:00", "2024-10-27 04:00", 1),
+	}}
+	config := DefaultConfig()
+	config.Default = WorkingHours{Start: 0, End: 24 * time.Hour, Days: config.Default.Days, SlotMinutes: 60}
+	service := NewService(repo, nil, config).WithLocation(berlin)
+	tests := []struct {
+		date string
+		rows [][2]int
+	}{
+		// 04:00 & 09:00 local time on the 23 hour day
+		{"2024-03-31", [][2]int{{5, 6}, {10, 11}}},
+		// 04:00 local time on the 25 hour day
+		{"2024-10-27", [][2]int{{5, 6}}},
+	}
+	for _, tt := range tests {
+		date, _ := time.ParseInLocation(time.DateOnly, tt.date, berlin)
+		day, err := service.GetDayData(date, booking.BookingFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(day.Events) != len(tt.rows) {
+			t.Fatalf("%s: Expected %d events, received %d", tt.date, len(tt.rows), len(day.Events))
+		}
+		for idx, rows := range tt.rows {
+			event := day.Events[idx]
+			if event.StartRow != rows[0] || event.EndRow != rows[1] {
+				t.Errorf("%s: Expected event in rows %d-%d, received %d-%d", tt.date, rows[0], rows[1], event.StartRow, event.EndRow)
+			}
+			if event.Booking.StartTime.Location() != berlin {
+				t.Errorf("%s: Expected booking in time zone of the calendar, received %s", tt.date, event.Booking.StartTime.Location())
+			}
+		}
+	}
+}
+
+func TestGetCalendarDayData_StartsDaysAtLocalMidnight(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20:00 on Monday in New York, which is Tuesday in UTC
+	repo := stubBookingRepository{bookings: []*booking.Booking{newStubBooking("2024-11-05 01:00", "2024-11-05 02:00", 1)}}
+	config := DefaultConfig()
+	config.Default.End = 22 * time.Hour
+	days, err := NewService(repo, nil, config).WithLocation(newYork).GetCalendarDayData(2024, 45, booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days[0].Date.Format(time.DateOnly) != "2024-11-04" || days[0].Date.Location() != newYork {
+		t.Fatalf("Expected week to start on Monday 2024-11-04 in New York, received %s", days[0].Date)
+	}
+	if len(days[0].Events) != 1 || len(days[1].Events) != 0 {
+		t.Fatalf("Expected booking on Monday, received %d on Monday and %d on Tuesday", len(days[0].Events), len(days[1].Events))
+	}
+	if event := days[0].Events[0]; event.StartRow != 13 || event.EndRow != 14 {
+		t.Fatalf("Expected event in rows 13-14, received %d-%d", event.StartRow, event.EndRow)
+	}
+}
+
+func TestGenerateTimeMarkers_LabelsFullHours(t *testing.T) {
+	config := DefaultConfig()
+	config.Default.Start = 7*time.Hour + 30*time.Minute
+	config.Default.End = 9 * time.Hour
+	config.Default.SlotMinutes = 30
+	markers := NewService(nil, nil, config).GenerateTimeMarkers(booking.BookingFilter{})
+
+	expected := []string{"", "8 AM", ""}
+	if len(markers) != len(expected) {
+		t.Fatalf("Expected %d markers, received %d", len(expected), len(markers))
+	}
+	for idx := range expected {
+		if markers[idx] != expected[idx] {
+			t.Fatalf("Expected marker %d to be '%s', received '%s'", idx, expected[idx], markers[idx])
+		}
+	}
+}
+
+func TestLoadConfig_MergesRoomOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.json")
+	data := `{
+		"default": {"start": "07:00", "end": "20:00", "days": ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat"], "slotMinutes": 15},
+		"rooms": {"2": {"start": "09:00", "end": "17:00", "slotMinutes": 30}}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unable to load config: %s", err)
+	}
+
+	if hours := config.For(1); hours.Start != 7*time.Hour || hours.NumSlots() != 52 || len(hours.Days) != 6 {
+		t.Fatalf("Expected default hours for room without override, received %+v", hours)
+	}
+	room := config.For(2)
+	if room.Start != 9*time.Hour || room.End != 17*time.Hour || room.SlotMinutes != 30 || len(room.Days) != 6 {
+		t.Fatalf("Expected override merged with default, received %+v", room)
+	}
+}
+
+func TestLoadConfig_RejectsInvalidWorkingHours(t *testing.T) {
+	for _, data := range []string{
+		`{"default": {"start": "18:00", "end": "08:00"}}`,
+		`{"default": {"slotMinutes": 25}}`,
+		`{"default": {"start": "08:10", "slotMinutes": 15}}`,
+		`{"default": {"days": ["Funday"]}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "calendar.json")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("Expected config %s to be rejected", data)
+		}
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	tests := []struct {
+		year     int
+		week     int
+		expected string
+	}{
+		// Week 1 starting in the previous year
+		{2025, 1, "2024-12-30"},
+		{2020, 1, "2019-12-30"},
+		// Week 1 starting in the year itself
+		{2021, 1, "2021-01-04"},
+		{2024, 1, "2024-01-01"},
+		// Last weeks of 52 & 53 week years
+		{2024, 52, "2024-12-23"},
+		{2020, 53, "2020-12-28"},
+		{2026, 53, "2026-12-28"},
+		{2015, 53, "2015-12-28"},
+		// Week 53 of a 52 week year continues into the next year
+		{2025, 53, "2025-12-29"},
+		{2023, 26, "2023-06-26"},
+	}
+	for _, tt := range tests {
+		if start := WeekStart(tt.year, tt.week).Format(time.DateOnly); start != tt.expected {
+			t.Errorf("Expected week %d of %d to start on %s, received %s", tt.week, tt.year, tt.expected, start)
+		}
+	}
+}
+
+func TestWeekStart_RoundTripsISOWeek(t *testing.T) {
+	for year := 1999; year <= 2031; year++ {
+		for week := 1; week <= WeeksInYear(year); week++ {
+			start := WeekStart(year, week)
+			if start.Weekday() != time.Monday {
+				t.Fatalf("Expected week %d of %d to start on Monday, received %s", week, year, start.Weekday())
+			}
+			if y, w := start.ISOWeek(); y != year || w != week {
+				t.Fatalf("Expected %s to be in week %d of %d, received week %d of %d", start, week, year, w, y)
+			}
+		}
+	}
+}
+
+func TestWeeksInYear(t *testing.T) {
+	expected := map[int]int{2015: 53, 2019: 52, 2020: 53, 2021: 52, 2024: 52, 2026: 53, 2032: 53}
+	for year, weeks := range expected {
+		if received := WeeksInYear(year); received != weeks {
+			t.Errorf("Expected %d weeks in %d, received %d", weeks, year, received)
+		}
+	}
+}
+
+func TestConfigForRooms_CoversAllRooms(t *testing.T) {
+	config := DefaultConfig()
+	config.Rooms[1] = WorkingHours{Start: 7 * time.Hour, End: 12 * time.Hour, Days: []time.Weekday{time.Saturday}, SlotMinutes: 30}
+	config.Rooms[2] = WorkingHours{Start: 9*time.Hour + 15*time.Minute, End: 20 * time.Hour, Days: []time.Weekday{time.Monday}, SlotMinutes: 15}
+
+	hours := config.ForRooms([]int64{1, 2, 3})
+	if hours.Start != 7*time.Hour || hours.End != 20*time.Hour || hours.SlotMinutes != 15 {
+		t.Fatalf("Expected 7 AM to 8 PM in 15 minute slots, received %+v", hours)
+	}
+	expectedDays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	if !slices.Equal(hours.Days, expectedDays) {
+		t.Fatalf("Expected days %v, received %v", expectedDays, hours.Days)
+	}
+	if err := hours.Validate(); err != nil {
+		t.Fatalf("Expected valid working hours, received %s", err)
+	}
+	// The calendar of all rooms covers every override
+	if all := config.ForRooms(nil); all.Start != hours.Start || all.End != hours.End || all.SlotMinutes != hours.SlotMinutes || !slices.Equal(all.Days, expectedDays) {
+		t.Fatalf("Expected the hours of all rooms to equal %+v, received %+v", hours, all)
+	}
+	if hours := DefaultConfig().ForRooms(nil); !reflect.DeepEqual(hours, DefaultConfig().Default) {
+		t.Fatalf("Expected default hours without overrides, received %+v", hours)
+	}
+}
+
+// Repository returning a fixed list of bookings. Other methods are not implemented
+type stubBookingRepository struct {
+	booking.BookingRepository
+	bookings []*booking.Booking
+}
+
+func (r stubBookingRepository) FindWithinTimeInterval(start *time.Time, end *time.Time) ([]*booking.Booking, error) {
+	return r.FindWithinTimeIntervalByFilter(start, end, booking.BookingFilter{})
+}
+
+func (r stubBookingRepository) FindWithinTimeIntervalByFilter(start *time.Time, end *time.Time, filter booking.BookingFilter) ([]*booking.Booking, error) {
+	res := []*booking.Booking{}
+	for _, b := range r.bookings {
+		if len(filter.RoomIds) > 0 && !slices.Contains(filter.RoomIds, b.Room.Id) {
+			continue
+		}
+		if len(filter.UserIds) > 0 && !slices.Contains(filter.UserIds, b.User.Id) {
+			continue
+		}
+		if !filter.Rooms.Matches(&b.Room) {
+			continue
+		}
+		if b.StartTime.Before(*end) && b.EndTime.After(*start) {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
+
+// Repository returning a fixed list of rooms. Other methods are not implemented
+type stubRoomsRepository struct {
+	booking.RoomsRepository
+	rooms []*booking.Room
+}
+
+func (r stubRoomsRepository) GetAll() ([]*booking.Room, error) {
+	return r.rooms, nil
+}
+
+func newStubBooking(start string, end string, roomId int64) *booking.Booking {
+	startTime, _ := time.Parse(layout, start)
+	endTime, _ := time.Parse(layout, end)
+	return &booking.Booking{StartTime: startTime, EndTime: endTime, Room: booking.Room{Id: roomId}}
+}
+
+func TestGetResourceData_OneColumnPerRoom(t *testing.T) {
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		newStubBooking("2024-07-01 09:00", "2024-07-01 10:00", 1),
+		newStubBooking("2024-07-01 09:00", "2024-07-01 11:00", 3),
+		newStubBooking("2024-07-01 12:00", "2024-07-01 13:00", 3),
+		newStubBooking("2024-07-02 09:00", "2024-07-02 10:00", 2),
+	}}
+	rooms := stubRoomsRepository{rooms: []*booking.Room{{Id: 1, Title: "A"}, {Id: 2, Title: "B"}, {Id: 3, Title: "C"}}}
+	config := DefaultConfig()
+	config.Rooms[3] = WorkingHours{Start: 6 * time.Hour, End: 14 * time.Hour, Days: config.Default.Days, SlotMinutes: 60}
+	date, _ := time.Parse(layout, "2024-07-01 00:00")
+	service := NewService(repo, rooms, config)
+
+	columns, err := service.GetResourceData(date, booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int64]int{1: 1, 2: 0, 3: 2}
+	if len(columns) != len(expected) {
+		t.Fatalf("Expected %d columns, received %d", len(expected), len(columns))
+	}
+	for _, column := range columns {
+		if len(column.Events) != expected[column.Room.Id] {
+			t.Fatalf("Expected %d events in room %d, received %d", expected[column.Room.Id], column.Room.Id, len(column.Events))
+		}
+	}
+	// Rows are relative to the shared working hours starting at 6 AM
+	if event := columns[0].Events[0]; event.StartRow != 4 || event.EndRow != 5 {
+		t.Fatalf("Expected event in rows 4-5, received %d-%d", event.StartRow, event.EndRow)
+	}
+
+	// Without room 3 the working hours start at 8 AM
+	columns, err = service.GetResourceData(date, booking.BookingFilter{RoomIds: []int64{2, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || columns[0].Room.Id != 1 || columns[1].Room.Id != 2 {
+		t.Fatalf("Expected columns of rooms 1 & 2, received %v", columns)
+	}
+	if event := columns[0].Events[0]; event.StartRow != 2 || event.EndRow != 3 {
+		t.Fatalf("Expected event in rows 2-3, received %d-%d", event.StartRow, event.EndRow)
+	}
+}
+
+func TestGetMonthData_PadsFullWeeks(t *testing.T) {
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		newStubBooking("2024-12-31 09:00", "2024-12-31 10:00", 1),
+		newStubBooking("2025-01-01 09:00", "2025-01-01 10:00", 1),
+	}}
+	month, err := NewService(repo, nil, DefaultConfig()).GetMonthData(2025, time.January, booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// January 2025 starts on a Wednesday and ends on a Friday
+	if len(month.Weeks) != 5 {
+		t.Fatalf("Expected 5 weeks, received %d", len(month.Weeks))
+	}
+	first := month.Weeks[0][0]
+	if first.Date.Format(time.DateOnly) != "2024-12-30" || first.InMonth {
+		t.Fatalf("Expected padding to start on Monday 2024-12-30, received %s", first.Date)
+	}
+	last := month.Weeks[4][6]
+	if last.Date.Format(time.DateOnly) != "2025-02-02" || last.InMonth {
+		t.Fatalf("Expected padding to end on Sunday 2025-02-02, received %s", last.Date)
+	}
+	if len(month.Weeks[0][1].Bookings) != 1 || len(month.Weeks[0][2].Bookings) != 1 {
+		t.Fatalf("Expected a booking on Dec 31 and Jan 1")
+	}
+}
+
+func TestGetAgendaData_GroupsByDay(t *testing.T) {
+	repo := stubBookingRepository{bookings: []*booking.Booking{
+		newStubBooking("2024-07-01 09:00", "2024-07-01 10:00", 1),
+		newStubBooking("2024-07-01 11:00", "2024-07-01 12:00", 2),
+		newStubBooking("2024-07-03 23:00", "2024-07-04 01:00", 1),
+		newStubBooking("2024-08-01 09:00", "2024-08-01 10:00", 1),
+	}}
+	from, _ := time.Parse(layout, "2024-07-01 00:00")
+	agenda, err := NewService(repo, nil, DefaultConfig()).GetAgendaData(from, 7, booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"2024-07-01": 2, "2024-07-03": 1, "2024-07-04": 1}
+	if len(agenda) != len(expected) {
+		t.Fatalf("Expected %d days, received %d", len(expected), len(agenda))
+	}
+	for _, day := range agenda {
+		if len(day.Bookings) != expected[day.Date.Format(time.DateOnly)] {
+			t.Fatalf("Expected %d bookings on %s, received %d", expected[day.Date.Format(time.DateOnly)], day.Date, len(day.Bookings))
+		}
+	}
+
+	roomAgenda, err := NewService(repo, nil, DefaultConfig()).GetAgendaData(from, 7, booking.BookingFilter{RoomIds: []int64{2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roomAgenda) != 1 || len(roomAgenda[0].Bookings) != 1 {
+		t.Fatalf("Expected a single booking of room 2, received %v", roomAgenda)
+	}
+}
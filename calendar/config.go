@@ -0,0 +1,205 @@
+package calendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Part of the day & week the calendar grid is rendered for
+type WorkingHours struct {
+	// Offset from midnight
+	Start time.Duration
+	End   time.Duration
+	Days  []time.Weekday
+	// Length of a single grid row
+	SlotMinutes int
+}
+
+// Number of grid rows of a working day
+func (h WorkingHours) NumSlots() int {
+	return int((h.End - h.Start) / h.slot())
+}
+
+func (h WorkingHours) slot() time.Duration {
+	return time.Duration(h.SlotMinutes) * time.Minute
+}
+
+func (h WorkingHours) Validate() error {
+	if h.Start < 0 || h.End > 24*time.Hour || h.Start >= h.End {
+		return fmt.Errorf("Working hours have to start before they end within a day")
+	}
+	if h.SlotMinutes < 1 || h.SlotMinutes > 60 || 60%h.SlotMinutes != 0 {
+		return fmt.Errorf("Slot length has to divide an hour, received %d minutes", h.SlotMinutes)
+	}
+	if (h.End-h.Start)%h.slot() != 0 {
+		return fmt.Errorf("Working hours have to be a multiple of the slot length")
+	}
+	if len(h.Days) == 0 {
+		return fmt.Errorf("Working days cannot be empty")
+	}
+	return nil
+}
+
+// Working hours of the calendar with overrides for single rooms
+type Config struct {
+	Default WorkingHours
+	Rooms   map[int64]WorkingHours
+}
+
+// Mondays to Fridays from 8 AM to 6 PM in hourly slots
+func DefaultConfig() Config {
+	return Config{
+		Default: WorkingHours{
+			Start:       8 * time.Hour,
+			End:         18 * time.Hour,
+			Days:        []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+			SlotMinutes: 60,
+		},
+		Rooms: map[int64]WorkingHours{},
+	}
+}
+
+// Returns the working hours of the given room. Room 0 stands for the calendar of all rooms
+func (c Config) For(roomId int64) WorkingHours {
+	if hours, ok := c.Rooms[roomId]; ok {
+		return hours
+	}
+	return c.Default
+}
+
+// Returns the working hours covering all given rooms. No rooms stand for the calendar of all rooms,
+// which covers the default & every room override
+func (c Config) ForRooms(roomIds []int64) WorkingHours {
+	if len(roomIds) == 0 {
+		roomIds = []int64{0}
+		for roomId := range c.Rooms {
+			roomIds = append(roomIds, roomId)
+		}
+	}
+	res := c.For(roomIds[0])
+	for _, roomId := range roomIds[1:] {
+		hours := c.For(roomId)
+		// Slots have to align with the working hours of both rooms
+		slot := gcd(res.SlotMinutes, hours.SlotMinutes)
+		slot = gcd(slot, int((res.Start - hours.Start).Abs().Minutes()))
+		slot = gcd(slot, int((res.End - hours.End).Abs().Minutes()))
+		res.SlotMinutes = slot
+		res.Start = min(res.Start, hours.Start)
+		res.End = max(res.End, hours.End)
+		days := slices.Clone(res.Days)
+		for _, day := range hours.Days {
+			if !slices.Contains(days, day) {
+				days = append(days, day)
+			}
+		}
+		// Weeks start on Monday
+		slices.SortFunc(days, func(a, b time.Weekday) int { return (int(a)+6)%7 - (int(b)+6)%7 })
+		res.Days = days
+	}
+	return res
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Working hours as written in the config file, e.g.
+//
+//	{"start": "07:00", "end": "20:00", "days": ["Mon", "Tue", "Sat"], "slotMinutes": 15}
+//
+// Omitted fields of room overrides are taken from the default
+type workingHoursConfig struct {
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Days        []string `json:"days"`
+	SlotMinutes int      `json:"slotMinutes"`
+}
+
+type configFile struct {
+	Default workingHoursConfig            `json:"default"`
+	Rooms   map[string]workingHoursConfig `json:"rooms"`
+}
+
+// Loads the config from a JSON file. Omitted fields are taken from DefaultConfig
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Config{}, fmt.Errorf("Invalid calendar config %s: %w", path, err)
+	}
+	config := DefaultConfig()
+	if config.Default, err = file.Default.apply(config.Default); err != nil {
+		return Config{}, fmt.Errorf("Invalid default working hours: %w", err)
+	}
+	for roomIdString, roomConfig := range file.Rooms {
+		roomId, err := strconv.ParseInt(roomIdString, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("Invalid room id '%s'", roomIdString)
+		}
+		if config.Rooms[roomId], err = roomConfig.apply(config.Default); err != nil {
+			return Config{}, fmt.Errorf("Invalid working hours of room %d: %w", roomId, err)
+		}
+	}
+	return config, nil
+}
+
+// Overrides the fields of base set in the config
+func (c workingHoursConfig) apply(base WorkingHours) (WorkingHours, error) {
+	res := base
+	var err error
+	if len(c.Start) > 0 {
+		if res.Start, err = parseTimeOfDay(c.Start); err != nil {
+			return res, err
+		}
+	}
+	if len(c.End) > 0 {
+		if res.End, err = parseTimeOfDay(c.End); err != nil {
+			return res, err
+		}
+	}
+	if len(c.Days) > 0 {
+		res.Days = make([]time.Weekday, len(c.Days))
+		for idx, day := range c.Days {
+			if res.Days[idx], err = parseWeekday(day); err != nil {
+				return res, err
+			}
+		}
+	}
+	if c.SlotMinutes != 0 {
+		res.SlotMinutes = c.SlotMinutes
+	}
+	return res, res.Validate()
+}
+
+// Parses "15:04" into the offset from midnight. "24:00" is accepted as end of the day
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day '%s'", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Parses the english name of a weekday or its abbreviation, e.g. "Sat"
+func parseWeekday(s string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(s, day.String()) || strings.EqualFold(s, day.String()[0:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid weekday '%s'", s)
+}
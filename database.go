@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/fixtures"
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite database used, if DATABASE_DSN is not set. Immediate transactions serialize the booking
+// conflict checks of concurrent requests, so every SQLite DSN is opened with them
+const defaultDatabaseDsn = "file:test.db?_txlock=immediate&_busy_timeout=5000"
+
+// Returns the SQLite DSN with "_txlock=immediate", replacing any other lock mode. With deferred
+// transactions, concurrent requests could both pass the conflict checks of overlapping bookings
+func sqliteDsn(dsn string) string {
+	path, query, _ := strings.Cut(dsn, "?")
+	params := []string{}
+	for _, param := range strings.Split(query, "&") {
+		if len(param) > 0 && !strings.HasPrefix(param, "_txlock=") {
+			params = append(params, param)
+		}
+	}
+	return path + "?" + strings.Join(append(params, "_txlock=immediate"), "&")
+}
+
+// Connects to the database of the DSN & sets up the repositories & the migrator of its schema.
+// DSNs starting with postgres:// or postgresql:// select Postgres, any other DSN is opened with SQLite
+// in immediate transaction mode
+func openRepositories(dsn string, roomDeletePolicy booking.RoomDeletePolicy) error {
+	var db *sqlx.DB
+	var err error
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if db, err = sqlx.Connect("postgres", dsn); err != nil {
+			return err
+		}
+		userRepo = booking.NewUserRepositoryPostgres(db)
+		roomRepo = booking.NewRoomsRepositoryPostgres(db).WithDeletePolicy(roomDeletePolicy)
+		bookingRepo = booking.NewBookingRepositoryPostgres(db, userRepo, roomRepo)
+		sessionRepo = booking.NewSessionRepositoryPostgres(db)
+		feedRepo = booking.NewFeedRepositoryPostgres(db)
+		calendarObjectRepo = booking.NewCalendarObjectRepositoryPostgres(db)
+	} else {
+		if db, err = sqlx.Connect("sqlite3", sqliteDsn(dsn)); err != nil {
+			return err
+		}
+		userRepo = booking.NewUserRepositorySQLite(db)
+		roomRepo = booking.NewRoomsRepositorySQLite(db).WithDeletePolicy(roomDeletePolicy)
+		bookingRepo = booking.NewBookingRepositorySQLite(db, userRepo, roomRepo)
+		sessionRepo = booking.NewSessionRepositorySQLite(db)
+		feedRepo = booking.NewFeedRepositorySQLite(db)
+		calendarObjectRepo = booking.NewCalendarObjectRepositorySQLite(db)
+	}
+	if migrator, err = migrations.New(db); err != nil {
+		return err
+	}
+	booking.AddMigrationSteps(migrator)
+	return nil
+}
+
+// Sets up repositories keeping their records in memory & seeds them with the development
+// fixtures. There is no schema to migrate, so the migrator stays unset
+func openDemoRepositories(roomDeletePolicy booking.RoomDeletePolicy) error {
+	store := booking.NewMemoryStore()
+	userRepo = booking.NewUserRepositoryMemory(store)
+	roomRepo = booking.NewRoomsRepositoryMemory(store).WithDeletePolicy(roomDeletePolicy)
+	bookingRepo = booking.NewBookingRepositoryMemory(store)
+	sessionRepo = booking.NewSessionRepositoryMemory(store)
+	feedRepo = booking.NewFeedRepositoryMemory(store)
+	calendarObjectRepo = booking.NewCalendarObjectRepositoryMemory(store)
+	migrator = nil
+	_, err := fixtures.Dev().Seed(fixtures.Repositories{Users: userRepo, Rooms: roomRepo, Bookings: bookingRepo}, time.Now())
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"lucb31/booking-go/booking"
+)
+
+func TestOpenDemoRepositories_SeedsDevelopmentFixtures(t *testing.T) {
+	// Restores the global repositories afterwards
+	useTestDatabase(t)
+	if err := openDemoRepositories(booking.RoomDeleteBlock); err != nil {
+		t.Fatalf("Unable to open demo repositories: %s", err)
+	}
+	root, err := userRepo.GetByName("root")
+	if err != nil || !root.CheckPassword("root") || root.Role != booking.RoleAdmin {
+		t.Fatalf("Expected admin root, received %+v, %v", root, err)
+	}
+	bookings, err := bookingRepo.GetAll()
+	if err != nil || len(bookings) == 0 {
+		t.Fatalf("Expected fixture bookings, received %v, %v", bookings, err)
+	}
+	if _, err := sessionRepo.Create(booking.Session{UserId: root.Id, TokenHash: "hash"}); err != nil {
+		t.Fatalf("Unable to create session: %s", err)
+	}
+}
+
+func TestSqliteDsn_EnforcesImmediateTransactions(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		expected string
+	}{
+		{"test.db", "test.db?_txlock=immediate"},
+		{"file:test.db?_busy_timeout=5000", "file:test.db?_busy_timeout=5000&_txlock=immediate"},
+		{"file:test.db?_txlock=deferred&_busy_timeout=5000", "file:test.db?_busy_timeout=5000&_txlock=immediate"},
+		{defaultDatabaseDsn, "file:test.db?_busy_timeout=5000&_txlock=immediate"},
+	}
+	for _, tt := range tests {
+		if res := sqliteDsn(tt.dsn); res != tt.expected {
+			t.Errorf("Expected %s for %s, received %s", tt.expected, tt.dsn, res)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/ical"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feed as listed in the account section
+type FeedListItem struct {
+	Id   int64
+	Name string
+}
+
+type FeedPageData struct {
+	Feeds []FeedListItem
+	// Rooms a feed can be created for
+	Rooms []booking.Room
+	// URL of the feed just created. Tokens are not stored, so it can only be shown once
+	NewUrl string
+	Error  string
+}
+
+// Creates a feed of the bookings of the room, or of the user's own bookings if roomId is 0.
+// Returns the feed & its token
+func CreateFeedRequest(userId int64, roomId int64) (*booking.Feed, string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	feed, err := feedRepo.Create(booking.Feed{UserId: userId, RoomId: roomId, TokenHash: tokenHash})
+	if err != nil {
+		return nil, "", err
+	}
+	return feed, token, nil
+}
+
+// Revokes the feed, if it belongs to the user
+func RevokeFeedRequest(userId int64, feedId int64) error {
+	feed, err := feedRepo.GetById(feedId)
+	if err != nil {
+		return err
+	}
+	if feed.UserId != userId {
+		return ErrForbidden
+	}
+	return feedRepo.Revoke(feedId)
+}
+
+// Name of the calendar shown by clients subscribing to the feed
+func feedName(feed *booking.Feed, rooms []*booking.Room) string {
+	if feed.RoomId == 0 {
+		return "My bookings"
+	}
+	for _, room := range rooms {
+		if room.Id == feed.RoomId {
+			return "Room " + room.Title
+		}
+	}
+	return fmt.Sprintf("Room %d", feed.RoomId)
+}
+
+// Absolute URL calendar clients subscribe to
+func feedUrl(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/feeds/%s/calendar.ics", scheme, c.Request.Host, token)
+}
+
+func getFeedPageData(userId int64) (FeedPageData, error) {
+	feeds, err := feedRepo.GetAllByUser(userId)
+	if err != nil {
+		return FeedPageData{Error: err.Error()}, err
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		return FeedPageData{Error: err.Error()}, err
+	}
+	data := FeedPageData{Feeds: []FeedListItem{}, Rooms: pointerSliceToValueSlice(rooms)}
+	for _, feed := range feeds {
+		data.Feeds = append(data.Feeds, FeedListItem{Id: feed.Id, Name: feedName(feed, rooms)})
+	}
+	return data, nil
+}
+
+// Renders the feed list of the current user, keeping newUrl & err of the preceding change
+func renderFeeds(c *gin.Context, status int, newUrl string, err error) {
+	data, loadErr := getFeedPageData(currentUserId(c))
+	if loadErr != nil {
+		c.HTML(http.StatusUnprocessableEntity, "feeds", data)
+		return
+	}
+	data.NewUrl = newUrl
+	if err != nil {
+		data.Error = err.Error()
+	}
+	c.HTML(status, "feeds", data)
+}
+
+func handleAddFeedRequest(c *gin.Context) {
+	var roomId int64
+	if roomParam := c.PostForm("roomId"); len(roomParam) > 0 {
+		var err error
+		if roomId, err = strconv.ParseInt(roomParam, 10, 64); err != nil {
+			renderFeeds(c, http.StatusUnprocessableEntity, "", fmt.Errorf("Invalid room '%s'", roomParam))
+			return
+		}
+	}
+	_, token, err := CreateFeedRequest(currentUserId(c), roomId)
+	if err != nil {
+		renderFeeds(c, http.StatusUnprocessableEntity, "", err)
+		return
+	}
+	renderFeeds(c, http.StatusOK, feedUrl(c, token), nil)
+}
+
+func handleRevokeFeedRequest(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err == nil {
+		err = RevokeFeedRequest(currentUserId(c), id)
+	}
+	if err != nil {
+		renderFeeds(c, errorStatus(err), "", err)
+		return
+	}
+	renderFeeds(c, http.StatusOK, "", nil)
+}
+
+// Serves the feed of the token in the URL. Unknown & revoked tokens are answered with 404
+func handleGetFeedRequest(c *gin.Context) {
+	feed, err := feedRepo.GetByTokenHash(hashToken(c.Param("token")))
+	if err != nil && !errors.Is(err, booking.ErrNotFound) {
+		logger.Print("Failed to load feed: ", err)
+		c.String(http.StatusInternalServerError, "Failed to load feed")
+		return
+	}
+	if err != nil || !feed.Active() {
+		c.String(http.StatusNotFound, "Feed not found")
+		return
+	}
+	bookings, err := bookingRepo.FindByFilter(feed.Filter())
+	if err != nil {
+		logger.Print("Failed to load bookings of feed: ", err)
+		c.String(http.StatusInternalServerError, "Failed to load feed")
+		return
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		logger.Print("Failed to load rooms of feed: ", err)
+		c.String(http.StatusInternalServerError, "Failed to load feed")
+		return
+	}
+	c.Header("Content-Type", ical.ContentType)
+	c.Header("Content-Disposition", `inline; filename="calendar.ics"`)
+	c.Status(http.StatusOK)
+	cal := ical.Calendar{Name: feedName(feed, rooms), Domain: c.Request.Host, Bookings: bookings, Method: "PUBLISH"}
+	if err := ical.Write(c.Writer, cal); err != nil {
+		logger.Print("Failed to write feed: ", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/ical"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getFeed(t *testing.T, url string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/feeds/:token/calendar.ics", handleGetFeedRequest)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	return w
+}
+
+func TestFeeds_ServeBookingsUntilRevoked(t *testing.T) {
+	useTestDatabase(t)
+	owner := createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	other := createTestUser(t, "bob", "correct horse", booking.RoleMember)
+	own := newTestBooking(t, owner)
+	foreign := newTestBooking(t, other)
+
+	w := apiRequest(t, owner, http.MethodPost, "/api/v1/feeds", `{}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	var feed ApiFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	url := strings.TrimPrefix(feed.Url, "http://example.com")
+
+	w = getFeed(t, url)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ical.ContentType {
+		t.Fatalf("Expected calendar, received %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	if !strings.Contains(w.Body.String(), fmt.Sprintf("UID:booking-%d@", own.Id)) {
+		t.Fatalf("Expected own booking in feed, received %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), fmt.Sprintf("UID:booking-%d@", foreign.Id)) {
+		t.Fatalf("Expected foreign booking to be left out, received %s", w.Body)
+	}
+
+	path := fmt.Sprintf("/api/v1/feeds/%d", feed.Id)
+	if w := apiRequest(t, other, http.MethodDelete, path, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for foreign feed, received %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+	if w := apiRequest(t, owner, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+	if w := getFeed(t, url); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected revoked feed to be gone, received %d", w.Code)
+	}
+	if w := getFeed(t, "/feeds/unknown/calendar.ics"); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected unknown token to be rejected, received %d", w.Code)
+	}
+}
+
+func TestFeeds_ListBookingsOfRoomInItsTimeZone(t *testing.T) {
+	useTestDatabase(t)
+	user := createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	room, err := roomRepo.Create(booking.Room{Title: "Berlin", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)
+	if _, err := bookingRepo.Create(booking.Booking{Room: *room, User: *user, StartTime: start, EndTime: start.Add(time.Hour),
+		Recurrence: &booking.Recurrence{Frequency: booking.FrequencyWeekly, Interval: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	newTestBooking(t, user)
+
+	if w := apiRequest(t, user, http.MethodPost, "/api/v1/feeds", `{"roomId": 999}`); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d for unknown room, received %d: %s", http.StatusNotFound, w.Code, w.Body)
+	}
+	w := apiRequest(t, user, http.MethodPost, "/api/v1/feeds", fmt.Sprintf(`{"roomId": %d}`, room.Id))
+	var feed ApiFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Name != "Room Berlin" {
+		t.Fatalf("Expected feed to be named after room, received '%s'", feed.Name)
+	}
+	body := getFeed(t, strings.TrimPrefix(feed.Url, "http://example.com")).Body.String()
+	for _, expected := range []string{"TZID:Europe/Berlin", "DTSTART;TZID=Europe/Berlin:20240701T090000", "RRULE:FREQ=WEEKLY", "LOCATION:Berlin"} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("Expected '%s' in feed, received %s", expected, body)
+		}
+	}
+	if strings.Count(body, "BEGIN:VEVENT") != 1 {
+		t.Fatalf("Expected only the booking of the room, received %s", body)
+	}
+
+	w = apiRequest(t, user, http.MethodGet, "/api/v1/feeds", "")
+	var feeds []ApiFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feeds); err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 1 || feeds[0].Url != "" {
+		t.Fatalf("Expected feed to be listed without URL, received %+v", feeds)
+	}
+}
@@ -0,0 +1,261 @@
+package fixtures
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Users, rooms & bookings to start development with
+//
+//go:embed dev.yaml
+var devFixtures []byte
+
+// Fixtures as written in a YAML or JSON file, e.g.
+//
+//	users:
+//	  - {name: root, password: root, role: admin}
+//	rooms:
+//	  - {title: Board room, capacity: 12, equipment: [projector]}
+//	bookings:
+//	  - {title: Planning, room: Board room, user: root, day: 1, start: "14:00", duration: 1h}
+//
+// Bookings refer to rooms by title & to users by name
+type Fixtures struct {
+	Users    []User    `yaml:"users"`
+	Rooms    []Room    `yaml:"rooms"`
+	Bookings []Booking `yaml:"bookings"`
+}
+
+type User struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	// Defaults to member
+	Role     string `yaml:"role"`
+	TimeZone string `yaml:"timeZone"`
+}
+
+type Room struct {
+	Title         string   `yaml:"title"`
+	TimeZone      string   `yaml:"timeZone"`
+	Capacity      int      `yaml:"capacity"`
+	Building      string   `yaml:"building"`
+	Floor         string   `yaml:"floor"`
+	Description   string   `yaml:"description"`
+	Equipment     []string `yaml:"equipment"`
+	Accessibility []string `yaml:"accessibility"`
+	ImageUrl      string   `yaml:"imageUrl"`
+}
+
+// Bookings are placed relative to the day they are seeded on, in the time zone of their room
+type Booking struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Room        string `yaml:"room"`
+	User        string `yaml:"user"`
+	// Weekday of the current week, e.g. "Mon". Defaults to today
+	Weekday string `yaml:"weekday"`
+	// Days added to the weekday or today, e.g. 1 for tomorrow
+	Day int `yaml:"day"`
+	// Time of day, e.g. "09:30"
+	Start string `yaml:"start"`
+	// E.g. "30m" or "1h30m"
+	Duration  string `yaml:"duration"`
+	Attendees int    `yaml:"attendees"`
+	// Rule in RRULE notation, e.g. "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=30"
+	Recurrence string `yaml:"recurrence"`
+}
+
+type Repositories struct {
+	Users    booking.UserRepository
+	Rooms    booking.RoomsRepository
+	Bookings booking.BookingRepository
+}
+
+// Number of records created by Seed. Records that existed already are not counted
+type Report struct {
+	Users    int
+	Rooms    int
+	Bookings int
+}
+
+// Returns the fixtures to start development with
+func Dev() *Fixtures {
+	fixtures, err := Parse(devFixtures)
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+// Loads fixtures from a YAML or JSON file
+func Load(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixtures, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid fixtures %s: %w", path, err)
+	}
+	return fixtures, nil
+}
+
+// Parses fixtures in YAML or JSON notation, which is a subset of YAML. Unknown fields are rejected
+func Parse(data []byte) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(fixtures); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// Creates the users, rooms & bookings of the fixtures, that do not exist yet. Users are
+// identified by name, rooms by title & bookings by title, room & user, so seeding twice does
+// not create anything the second time. Bookings are placed relative to today
+func (f *Fixtures) Seed(repos Repositories, today time.Time) (*Report, error) {
+	report := &Report{}
+	users := map[string]*booking.User{}
+	existingUsers, err := repos.Users.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range existingUsers {
+		users[user.Name] = user
+	}
+	for _, fixture := range f.Users {
+		if _, ok := users[fixture.Name]; ok {
+			continue
+		}
+		user := booking.User{Name: fixture.Name, Role: booking.Role(fixture.Role), TimeZone: fixture.TimeZone}
+		if len(fixture.Password) > 0 {
+			if err := user.SetPassword(fixture.Password); err != nil {
+				return nil, err
+			}
+		}
+		created, err := repos.Users.Create(user)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create user %s: %w", fixture.Name, err)
+		}
+		users[created.Name] = created
+		report.Users++
+	}
+
+	rooms := map[string]*booking.Room{}
+	existingRooms, err := repos.Rooms.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, room := range existingRooms {
+		rooms[room.Title] = room
+	}
+	for _, fixture := range f.Rooms {
+		if _, ok := rooms[fixture.Title]; ok {
+			continue
+		}
+		created, err := repos.Rooms.Create(booking.Room{
+			Title:         fixture.Title,
+			TimeZone:      fixture.TimeZone,
+			Capacity:      fixture.Capacity,
+			Building:      fixture.Building,
+			Floor:         fixture.Floor,
+			Description:   fixture.Description,
+			Equipment:     fixture.Equipment,
+			Accessibility: fixture.Accessibility,
+			ImageUrl:      fixture.ImageUrl,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create room %s: %w", fixture.Title, err)
+		}
+		rooms[created.Title] = created
+		report.Rooms++
+	}
+
+	existingBookings, err := repos.Bookings.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, fixture := range f.Bookings {
+		room, ok := rooms[fixture.Room]
+		if !ok {
+			return nil, fmt.Errorf("Booking %s refers to unknown room '%s'", fixture.Title, fixture.Room)
+		}
+		user, ok := users[fixture.User]
+		if !ok {
+			return nil, fmt.Errorf("Booking %s refers to unknown user '%s'", fixture.Title, fixture.User)
+		}
+		exists := false
+		for _, b := range existingBookings {
+			exists = exists || (b.Title == fixture.Title && b.Room.Id == room.Id && b.User.Id == user.Id)
+		}
+		if exists {
+			continue
+		}
+		b, err := fixture.booking(*room, *user, today)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid booking %s: %w", fixture.Title, err)
+		}
+		if _, err := repos.Bookings.Create(*b); err != nil {
+			return nil, fmt.Errorf("Unable to create booking %s: %w", fixture.Title, err)
+		}
+		report.Bookings++
+	}
+	return report, nil
+}
+
+func (f Booking) booking(room booking.Room, user booking.User, today time.Time) (*booking.Booking, error) {
+	today = today.In(room.Location())
+	offset := f.Day
+	if len(f.Weekday) > 0 {
+		weekday, err := parseWeekday(f.Weekday)
+		if err != nil {
+			return nil, err
+		}
+		// Weeks start on Monday
+		offset += (int(weekday)+6)%7 - (int(today.Weekday())+6)%7
+	}
+	startOfDay, err := time.Parse("15:04", f.Start)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid start '%s'", f.Start)
+	}
+	duration, err := time.ParseDuration(f.Duration)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("Invalid duration '%s'", f.Duration)
+	}
+	start := time.Date(today.Year(), today.Month(), today.Day()+offset, startOfDay.Hour(), startOfDay.Minute(), 0, 0, today.Location())
+	b := &booking.Booking{
+		Title:       f.Title,
+		Description: f.Description,
+		Room:        room,
+		User:        user,
+		StartTime:   start.UTC(),
+		EndTime:     start.Add(duration).UTC(),
+		Attendees:   f.Attendees,
+	}
+	if len(f.Recurrence) > 0 {
+		if b.Recurrence, err = booking.ParseRecurrence(f.Recurrence); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Parses the english name of a weekday or its abbreviation, e.g. "Mon"
+func parseWeekday(s string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(s, day.String()) || strings.EqualFold(s, day.String()[0:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid weekday '%s'", s)
+}
@@ -0,0 +1,87 @@
+package fixtures
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestRepositories(t *testing.T) Repositories {
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	users := booking.NewUserRepositorySQLite(db)
+	rooms := booking.NewRoomsRepositorySQLite(db)
+	return Repositories{users, rooms, booking.NewBookingRepositorySQLite(db, users, rooms)}
+}
+
+func TestSeed_CreatesDevFixturesOnce(t *testing.T) {
+	repos := newTestRepositories(t)
+	// Wednesday
+	today := time.Date(2024, 7, 10, 18, 0, 0, 0, time.UTC)
+	report, err := Dev().Seed(repos, today)
+	if err != nil {
+		t.Fatalf("Unable to seed: %s", err)
+	}
+	if report.Users != 2 || report.Rooms != 2 || report.Bookings != 2 {
+		t.Fatalf("Expected 2 users, rooms & bookings, received %+v", report)
+	}
+	root, err := repos.Users.GetByName("root")
+	if err != nil || root.Role != booking.RoleAdmin || !root.CheckPassword("root") {
+		t.Fatalf("Expected admin root, received %+v, %v", root, err)
+	}
+	bookings, err := repos.Bookings.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := map[string]string{}
+	for _, b := range bookings {
+		starts[b.Title] = b.StartTime.In(b.Room.Location()).Format("Mon 2006-01-02 15:04")
+	}
+	// The stand-up starts on Monday of the week, the planning tomorrow in Berlin
+	if starts["Stand-up"] != "Mon 2024-07-08 09:00" || starts["Planning"] != "Thu 2024-07-11 14:00" {
+		t.Fatalf("Expected bookings relative to today, received %v", starts)
+	}
+
+	report, err = Dev().Seed(repos, today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Unable to seed again: %s", err)
+	}
+	if *report != (Report{}) {
+		t.Fatalf("Expected nothing to be created again, received %+v", report)
+	}
+}
+
+func TestSeed_FailsForUnknownReferences(t *testing.T) {
+	fixtures, err := Parse([]byte(`{"bookings": [{"title": "Lost", "room": "Attic", "user": "nobody", "start": "09:00", "duration": "1h"}]}`))
+	if err != nil {
+		t.Fatalf("Unable to parse JSON fixtures: %s", err)
+	}
+	if _, err := fixtures.Seed(newTestRepositories(t), time.Now()); err == nil || !strings.Contains(err.Error(), "Attic") {
+		t.Fatalf("Expected error for unknown room, received '%v'", err)
+	}
+}
+
+func TestParse_RejectsUnknownFields(t *testing.T) {
+	if _, err := Parse([]byte("rooms:\n  - title: Attic\n    seats: 4\n")); err == nil {
+		t.Fatal("Expected error for unknown field")
+	}
+}
@@ -0,0 +1,275 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+// Returned for DATE values, as bookings always start & end at a time of day
+var ErrAllDay = errors.New("All-day events are not supported")
+
+// Converts local times of a TZID into points in time
+type zone interface {
+	// Returns the point in time the wall clock shows the given time, which is passed in UTC
+	resolve(wall time.Time) time.Time
+}
+
+type locationZone struct {
+	loc *time.Location
+}
+
+func (z locationZone) resolve(wall time.Time) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, z.loc)
+}
+
+// Time zone defined by a VTIMEZONE of the imported calendar, used if its TZID is no IANA time zone,
+// e.g. "W. Europe Standard Time"
+type definedZone struct {
+	observances []definedObservance
+}
+
+type definedObservance struct {
+	// Wall clock times are given in UTC
+	start      time.Time
+	offsetFrom int
+	offsetTo   int
+	// Yearly rule, e.g. the last Sunday of March. Month is 0 for observances without rule
+	month   time.Month
+	nth     int
+	weekday time.Weekday
+	until   time.Time
+	rDates  []time.Time
+}
+
+// Returns the starts of the observance in the given & the previous year
+func (o definedObservance) onsets(year int) []time.Time {
+	res := append([]time.Time{o.start}, o.rDates...)
+	if o.month == 0 {
+		return res
+	}
+	for y := year - 1; y <= year; y++ {
+		onset := nthWeekday(y, o.month, o.nth, o.weekday).Add(time.Duration(o.start.Hour())*time.Hour +
+			time.Duration(o.start.Minute())*time.Minute + time.Duration(o.start.Second())*time.Second)
+		if onset.Before(o.start) || (!o.until.IsZero() && onset.After(o.until)) {
+			continue
+		}
+		res = append(res, onset)
+	}
+	return res
+}
+
+func (z definedZone) resolve(wall time.Time) time.Time {
+	var latest time.Time
+	offset, found := 0, false
+	for _, o := range z.observances {
+		for _, onset := range o.onsets(wall.Year()) {
+			if !onset.After(wall) && (!found || onset.After(latest)) {
+				latest, offset, found = onset, o.offsetTo, true
+			}
+		}
+	}
+	// Before the first observance, the offset it changes from applies
+	if !found {
+		first := slices.MinFunc(z.observances, func(a, b definedObservance) int { return a.start.Compare(b.start) })
+		offset = first.offsetFrom
+	}
+	return wall.Add(-time.Duration(offset) * time.Second)
+}
+
+// Returns midnight of the n-th weekday of the month. Negative n count from the end of the month
+func nthWeekday(year int, month time.Month, n int, weekday time.Weekday) time.Time {
+	if n < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.AddDate(0, 0, -((int(last.Weekday())-int(weekday)+7)%7)+7*(n+1))
+	}
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	return first.AddDate(0, 0, (int(weekday)-int(first.Weekday())+7)%7+7*(n-1))
+}
+
+var weekdaysByCode = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+var byDayPattern = regexp.MustCompile(`^([+-]?\d)?(SU|MO|TU|WE|TH|FR|SA)$`)
+
+func parseDefinedZone(c *Component) (definedZone, error) {
+	z := definedZone{}
+	for _, child := range c.Components {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+		o := definedObservance{}
+		var err error
+		if o.start, err = time.Parse(localLayout, child.Value("DTSTART")); err != nil {
+			return z, fmt.Errorf("Invalid start of observance '%s'", child.Value("DTSTART"))
+		}
+		if o.offsetFrom, err = parseOffset(child.Value("TZOFFSETFROM")); err != nil {
+			return z, err
+		}
+		if o.offsetTo, err = parseOffset(child.Value("TZOFFSETTO")); err != nil {
+			return z, err
+		}
+		for _, rDate := range child.GetAll("RDATE") {
+			for _, value := range strings.Split(rDate.Value, ",") {
+				if t, err := time.Parse(localLayout, value); err == nil {
+					o.rDates = append(o.rDates, t)
+				}
+			}
+		}
+		if rule := child.Value("RRULE"); len(rule) > 0 {
+			parseObservanceRule(&o, rule)
+		}
+		z.observances = append(z.observances, o)
+	}
+	if len(z.observances) == 0 {
+		return z, fmt.Errorf("Time zone without observances")
+	}
+	return z, nil
+}
+
+// Reads yearly rules like "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU". Other rules are ignored, leaving
+// the observance with its start only
+func parseObservanceRule(o *definedObservance, rule string) {
+	parts := map[string]string{}
+	for _, part := range strings.Split(rule, ";") {
+		if key, value, found := strings.Cut(part, "="); found {
+			parts[key] = value
+		}
+	}
+	month, err := strconv.Atoi(parts["BYMONTH"])
+	match := byDayPattern.FindStringSubmatch(parts["BYDAY"])
+	if parts["FREQ"] != "YEARLY" || err != nil || month < 1 || month > 12 || match == nil {
+		return
+	}
+	o.nth = 1
+	if len(match[1]) > 0 {
+		o.nth, _ = strconv.Atoi(match[1])
+	}
+	o.month, o.weekday = time.Month(month), weekdaysByCode[match[2]]
+	if until, err := time.Parse(utcLayout, parts["UNTIL"]); err == nil {
+		o.until = until
+	}
+}
+
+// Parses offsets like "+0100" or "-043000" into seconds east of UTC
+func parseOffset(s string) (int, error) {
+	if (len(s) != 5 && len(s) != 7) || (s[0] != '+' && s[0] != '-') {
+		return 0, fmt.Errorf("Invalid UTC offset '%s'", s)
+	}
+	offset := 0
+	for idx, unit := range []int{3600, 60, 1} {
+		if 1+2*idx >= len(s) {
+			break
+		}
+		value, err := strconv.Atoi(s[1+2*idx : 3+2*idx])
+		if err != nil {
+			return 0, fmt.Errorf("Invalid UTC offset '%s'", s)
+		}
+		offset += value * unit
+	}
+	if s[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// Resolves DATE-TIME values of a calendar using its VTIMEZONE components
+type resolver struct {
+	defined map[string]definedZone
+}
+
+func newResolver(calendar *Component) (*resolver, error) {
+	r := &resolver{defined: map[string]definedZone{}}
+	for _, c := range calendar.Children("VTIMEZONE") {
+		tzid := c.Value("TZID")
+		z, err := parseDefinedZone(c)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone '%s': %w", tzid, err)
+		}
+		r.defined[tzid] = z
+	}
+	return r, nil
+}
+
+// IANA time zones are preferred over the definition in the calendar
+func (r *resolver) zone(tzid string) (zone, error) {
+	if loc, err := booking.LoadTimeZone(tzid); err == nil && len(tzid) > 0 {
+		return locationZone{loc}, nil
+	}
+	if z, ok := r.defined[tzid]; ok {
+		return z, nil
+	}
+	return nil, fmt.Errorf("Unknown time zone '%s'", tzid)
+}
+
+// Returns the points in time of a DATE-TIME property, which may list several comma separated values.
+// Floating times without time zone are taken to be in floating
+func (r *resolver) dateTimes(p Property, floating *time.Location) ([]time.Time, error) {
+	if p.Params["VALUE"] == "DATE" {
+		return nil, ErrAllDay
+	}
+	var z zone = locationZone{floating}
+	if tzid, ok := p.Params["TZID"]; ok {
+		var err error
+		if z, err = r.zone(tzid); err != nil {
+			return nil, err
+		}
+	}
+	res := []time.Time{}
+	for _, value := range strings.Split(p.Value, ",") {
+		switch {
+		case len(value) == len("20060102"):
+			return nil, ErrAllDay
+		case strings.HasSuffix(value, "Z"):
+			t, err := time.Parse(utcLayout, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s '%s'", p.Name, value)
+			}
+			res = append(res, t)
+		default:
+			wall, err := time.Parse(localLayout, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s '%s'", p.Name, value)
+			}
+			res = append(res, z.resolve(wall).UTC())
+		}
+	}
+	return res, nil
+}
+
+func (r *resolver) dateTime(p Property, floating *time.Location) (time.Time, error) {
+	res, err := r.dateTimes(p, floating)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(res) != 1 {
+		return time.Time{}, fmt.Errorf("Expected a single value of %s", p.Name)
+	}
+	return res[0], nil
+}
+
+var durationPattern = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Parses positive durations like "PT1H30M" or "P1D"
+func parseDuration(s string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(s)
+	if match == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("Invalid duration '%s'", s)
+	}
+	var res time.Duration
+	for idx, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if len(match[idx+1]) > 0 {
+			value, _ := strconv.Atoi(match[idx+1])
+			res += time.Duration(value) * unit
+		}
+	}
+	return res, nil
+}
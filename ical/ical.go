@@ -0,0 +1,194 @@
+// Package ical exports bookings in the iCalendar format (RFC 5545), so they can be subscribed to
+// from desktop & mobile calendar clients, and imports bookings from iCalendar files
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"lucb31/booking-go/booking"
+)
+
+// Value of PRODID identifying the exporter
+const ProductId = "-//booking-go//Room bookings//EN"
+
+// Media type of iCalendar files
+const ContentType = "text/calendar; charset=utf-8"
+
+// Layouts of DATE-TIME values in UTC & local time
+const (
+	utcLayout   = "20060102T150405Z"
+	localLayout = "20060102T150405"
+)
+
+// Users have no email address, so organizers are identified by a URN ending in the user id
+const organizerUrnPrefix = "urn:x-booking-go:user:"
+
+// Content lines must not be longer than 75 octets, excluding the line break
+const maxLineLength = 75
+
+// Calendar of bookings to export
+type Calendar struct {
+	// Shown by clients as name of the subscribed calendar
+	Name string
+	// Right hand side of the event UIDs, e.g. the host name of the server
+	Domain   string
+	Bookings []*booking.Booking
+	// UIDs of events by id of their booking or series. Events not listed get the UID returned by Uid
+	Uids map[int64]string
+	// METHOD of the calendar, e.g. "PUBLISH" for feeds. Left out if empty, as CalDAV requires
+	// for stored objects
+	Method string
+	// DTSTAMP of all events. Defaults to the current time
+	Stamp time.Time
+}
+
+// Writes the calendar as VCALENDAR object. Bookings are expected as stored, i.e. series are not
+// expanded into their occurrences. Exceptions of a series are written as overrides of the series
+// occurrence they replace. Times are given in the time zone of the room, described by a
+// VTIMEZONE component
+func Write(w io.Writer, c Calendar) error {
+	stamp := c.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	lw := &lineWriter{w: bufio.NewWriter(w)}
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + ProductId)
+	lw.line("CALSCALE:GREGORIAN")
+	if len(c.Method) > 0 {
+		lw.line("METHOD:" + c.Method)
+	}
+	if len(c.Name) > 0 {
+		lw.line("X-WR-CALNAME:" + escapeText(c.Name))
+	}
+	for _, zone := range usedTimeZones(c.Bookings, stamp) {
+		writeTimeZone(lw, zone)
+	}
+	for _, b := range c.Bookings {
+		writeEvent(lw, b, c.uid(b), stamp)
+	}
+	lw.line("END:VCALENDAR")
+	if lw.err != nil {
+		return lw.err
+	}
+	return lw.w.Flush()
+}
+
+// Returns the UID of the event of a booking. Exceptions share the UID of their series
+func Uid(b *booking.Booking, domain string) string {
+	id := b.Id
+	if b.SeriesId > 0 {
+		id = b.SeriesId
+	}
+	return fmt.Sprintf("booking-%d@%s", id, domain)
+}
+
+func (c *Calendar) uid(b *booking.Booking) string {
+	id := b.Id
+	if b.SeriesId > 0 {
+		id = b.SeriesId
+	}
+	if uid, ok := c.Uids[id]; ok {
+		return uid
+	}
+	return Uid(b, c.Domain)
+}
+
+func writeEvent(lw *lineWriter, b *booking.Booking, uid string, stamp time.Time) {
+	loc := b.Room.Location()
+	lw.line("BEGIN:VEVENT")
+	lw.line("UID:" + escapeText(uid))
+	lw.line("DTSTAMP:" + stamp.UTC().Format(utcLayout))
+	lw.line("DTSTART" + dateTime(b.StartTime, loc))
+	lw.line("DTEND" + dateTime(b.EndTime, loc))
+	if b.Recurrence != nil {
+		lw.line("RRULE:" + b.Recurrence.String())
+		exDates := slices.Clone(b.Recurrence.ExDates)
+		slices.SortFunc(exDates, func(a, b time.Time) int { return a.Compare(b) })
+		for _, exDate := range exDates {
+			lw.line("EXDATE" + dateTime(exDate, loc))
+		}
+	} else if b.SeriesId > 0 && !b.OriginalStart.IsZero() {
+		lw.line("RECURRENCE-ID" + dateTime(b.OriginalStart, loc))
+	}
+	summary := b.Title
+	if len(summary) == 0 {
+		summary = b.Room.Title
+	}
+	lw.line("SUMMARY:" + escapeText(summary))
+	if len(b.Description) > 0 {
+		lw.line("DESCRIPTION:" + escapeText(b.Description))
+	}
+	if len(b.Room.Title) > 0 {
+		lw.line("LOCATION:" + escapeText(b.Room.Title))
+	}
+	lw.line(fmt.Sprintf("ORGANIZER;CN=%s:%s%d", paramValue(b.User.Name), organizerUrnPrefix, b.User.Id))
+	lw.line("END:VEVENT")
+}
+
+// Returns the parameters & value of a DATE-TIME property in the given time zone, e.g.
+// ";TZID=Europe/Berlin:20240101T090000". UTC times are written in UTC form
+func dateTime(t time.Time, loc *time.Location) string {
+	if loc == time.UTC {
+		return ":" + t.UTC().Format(utcLayout)
+	}
+	return fmt.Sprintf(";TZID=%s:%s", paramValue(loc.String()), t.In(loc).Format(localLayout))
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escapes a TEXT value
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// Quotes parameter values containing separators. Double quotes cannot be escaped and are dropped
+func paramValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '"' || r < ' ' {
+			return -1
+		}
+		return r
+	}, s)
+	if strings.ContainsAny(s, ":;,") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// Writes content lines, folding them after maxLineLength octets. The first error is kept and
+// stops all further writes
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (lw *lineWriter) line(s string) {
+	if lw.err != nil {
+		return
+	}
+	var b strings.Builder
+	limit := maxLineLength
+	for len(s) > limit {
+		// Do not split multi-byte characters
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space
+		limit = maxLineLength - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, lw.err = lw.w.WriteString(b.String())
+}
@@ -0,0 +1,174 @@
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"lucb31/booking-go/booking"
+)
+
+var stamp = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func writeTestCalendar(t *testing.T, bookings ...*booking.Booking) string {
+	var buf bytes.Buffer
+	if err := Write(&buf, Calendar{Name: "Test", Domain: "example.com", Bookings: bookings, Stamp: stamp}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// Content lines of the calendar with folded lines joined again
+func unfold(s string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func containsLines(lines []string, expected ...string) (string, bool) {
+	idx := 0
+	for _, line := range lines {
+		if idx < len(expected) && line == expected[idx] {
+			idx++
+		}
+	}
+	if idx < len(expected) {
+		return expected[idx], false
+	}
+	return "", true
+}
+
+func TestWrite_WritesSeriesWithExceptionsInTimeZoneOfRoom(t *testing.T) {
+	room := booking.Room{Id: 1, Title: "Berlin, 2nd floor", TimeZone: "Europe/Berlin"}
+	user := booking.User{Id: 2, Name: "alice"}
+	// Mondays at 09:00 Berlin time
+	start := time.Date(2024, 3, 25, 8, 0, 0, 0, time.UTC)
+	cancelled := time.Date(2024, 4, 8, 7, 0, 0, 0, time.UTC)
+	moved := time.Date(2024, 4, 1, 7, 0, 0, 0, time.UTC)
+	series := &booking.Booking{Id: 1, Title: "Stand-up", Room: room, User: user, StartTime: start, EndTime: start.Add(30 * time.Minute),
+		Recurrence: &booking.Recurrence{Frequency: booking.FrequencyWeekly, Interval: 1, Count: 10, ExDates: []time.Time{cancelled, moved}}}
+	exception := &booking.Booking{Id: 2, Title: "Stand-up", Room: room, User: user, StartTime: moved.Add(time.Hour), EndTime: moved.Add(90 * time.Minute),
+		SeriesId: 1, OriginalStart: moved}
+
+	lines := unfold(writeTestCalendar(t, series, exception))
+	expected := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:" + ProductId,
+		"X-WR-CALNAME:Test",
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Berlin",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:booking-1@example.com",
+		"DTSTAMP:20240601T120000Z",
+		"DTSTART;TZID=Europe/Berlin:20240325T090000",
+		"DTEND;TZID=Europe/Berlin:20240325T093000",
+		"RRULE:FREQ=WEEKLY;COUNT=10",
+		"EXDATE;TZID=Europe/Berlin:20240401T090000",
+		"EXDATE;TZID=Europe/Berlin:20240408T090000",
+		"SUMMARY:Stand-up",
+		`LOCATION:Berlin\, 2nd floor`,
+		"ORGANIZER;CN=alice:urn:x-booking-go:user:2",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:booking-1@example.com",
+		"DTSTART;TZID=Europe/Berlin:20240401T100000",
+		"RECURRENCE-ID;TZID=Europe/Berlin:20240401T090000",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+	if missing, ok := containsLines(lines, expected...); !ok {
+		t.Fatalf("Expected line '%s' in order, received\n%s", missing, strings.Join(lines, "\n"))
+	}
+}
+
+func TestWrite_WritesUtcTimesWithoutTimeZone(t *testing.T) {
+	start := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	b := &booking.Booking{Id: 3, Description: "Line one\nLine two; with, separators", Room: booking.Room{Id: 1, Title: "Test room", TimeZone: "UTC"},
+		User: booking.User{Id: 1, Name: "Doe, John"}, StartTime: start, EndTime: start.Add(time.Hour)}
+	res := writeTestCalendar(t, b)
+	if strings.Contains(res, "VTIMEZONE") {
+		t.Fatalf("Expected no VTIMEZONE for UTC rooms")
+	}
+	expected := []string{
+		"DTSTART:20240701T090000Z",
+		"DTEND:20240701T100000Z",
+		"SUMMARY:Test room",
+		`DESCRIPTION:Line one\nLine two\; with\, separators`,
+		`ORGANIZER;CN="Doe, John":urn:x-booking-go:user:1`,
+	}
+	if missing, ok := containsLines(unfold(res), expected...); !ok {
+		t.Fatalf("Expected line '%s' in order, received\n%s", missing, res)
+	}
+}
+
+func TestWrite_FoldsLongLines(t *testing.T) {
+	start := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	description := strings.Repeat("Großer Raum für alle ", 20)
+	b := &booking.Booking{Id: 1, Description: description, Room: booking.Room{Id: 1, Title: "Test room"}, StartTime: start, EndTime: start.Add(time.Hour)}
+	res := writeTestCalendar(t, b)
+	for _, line := range strings.Split(res, "\r\n") {
+		if len(line) > maxLineLength {
+			t.Fatalf("Expected lines of at most %d octets, received %d: %s", maxLineLength, len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Fatalf("Expected folding to keep characters intact, received %q", line)
+		}
+	}
+	if _, ok := containsLines(unfold(res), "DESCRIPTION:"+description); !ok {
+		t.Fatalf("Expected unfolded description to match")
+	}
+}
+
+func TestObservances_DescribeDaylightSavingTime(t *testing.T) {
+	tests := []struct {
+		zone     string
+		expected []string
+	}{
+		{"Europe/Berlin", []string{
+			"BEGIN:DAYLIGHT", "DTSTART:20240331T020000", "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT",
+			"BEGIN:STANDARD", "DTSTART:20241027T030000", "RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD",
+		}},
+		{"America/New_York", []string{
+			"BEGIN:DAYLIGHT", "DTSTART:20240310T020000", "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU", "TZOFFSETFROM:-0500", "TZOFFSETTO:-0400", "END:DAYLIGHT",
+			"BEGIN:STANDARD", "DTSTART:20241103T020000", "RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU", "TZOFFSETFROM:-0400", "TZOFFSETTO:-0500", "END:STANDARD",
+		}},
+		// No changes since 1945
+		{"Asia/Kolkata", []string{"BEGIN:STANDARD", "TZOFFSETTO:+0530", "TZNAME:IST", "END:STANDARD", "END:VTIMEZONE"}},
+	}
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		lw := &lineWriter{w: bufio.NewWriter(&buf)}
+		// A single year, so the last transitions fall into 2024
+		from := time.Date(2024, 1, 1, 0, 0, 0, 0, loc)
+		writeTimeZone(lw, usedTimeZone{loc, from, from.AddDate(1, 0, 0)})
+		lw.w.Flush()
+		lines := unfold(buf.String())
+		if missing, ok := containsLines(lines, tt.expected...); !ok {
+			t.Errorf("%s: Expected line '%s' in order, received\n%s", tt.zone, missing, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestUsedTimeZones_CoversAllEventsOfZone(t *testing.T) {
+	berlin := booking.Room{Id: 1, TimeZone: "Europe/Berlin"}
+	early := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	late := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	zones := usedTimeZones([]*booking.Booking{
+		{Room: berlin, StartTime: late, EndTime: late.Add(time.Hour)},
+		{Room: booking.Room{Id: 2, TimeZone: "UTC"}, StartTime: early, EndTime: early.Add(time.Hour)},
+		{Room: berlin, StartTime: early, EndTime: early.Add(time.Hour)},
+	}, stamp)
+	if len(zones) != 1 {
+		t.Fatalf("Expected 1 time zone, received %d", len(zones))
+	}
+	if !zones[0].from.Equal(early) || !zones[0].to.Equal(late.Add(time.Hour+timeZoneHorizon)) {
+		t.Fatalf("Expected zone to be described from %s to after %s, received %s to %s", early, late, zones[0].from, zones[0].to)
+	}
+}
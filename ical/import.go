@@ -0,0 +1,335 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+// Outcome of importing a single event
+type ImportStatus string
+
+const (
+	ImportCreated  ImportStatus = "created"
+	ImportSkipped  ImportStatus = "skipped"
+	ImportConflict ImportStatus = "conflict"
+)
+
+type ImportEntry struct {
+	Uid string
+	// Start of the replaced occurrence, if the event is an exception of a series
+	RecurrenceId time.Time
+	Summary      string
+	Start        time.Time
+	Status       ImportStatus
+	// Why the event was skipped or conflicts
+	Reason string
+	// Id of the created booking. 0 on dry runs
+	BookingId             int64
+	ConflictingBookingIds []int64
+}
+
+type ImportReport struct {
+	// Nothing has been persisted. Created entries would have been created
+	DryRun  bool
+	Entries []ImportEntry
+}
+
+// Returns the number of entries with the given status
+func (r *ImportReport) Count(status ImportStatus) int {
+	count := 0
+	for _, entry := range r.Entries {
+		if entry.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Maps the events of iCalendar files onto bookings
+type Importer struct {
+	Bookings booking.BookingRepository
+	// Matched against LOCATION by title
+	Rooms []*booking.Room
+	// Matched against ORGANIZER by name
+	Users []*booking.User
+}
+
+var errCancelled = errors.New("Event is cancelled")
+
+// Creates a booking for every VEVENT of the calendar. All bookings are created in a single batch,
+// checking each for conflicts with existing bookings & the events before it, like
+// BookingRepository.Create does. Exceptions of a series are imported after all series.
+// On dry runs the batch is rolled back. Events that cannot be imported are reported as skipped;
+// an error is only returned if the file cannot be parsed or the batch fails
+func (i *Importer) Import(r io.Reader, dryRun bool) (*ImportReport, error) {
+	components, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := i.Bookings.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer batch.Rollback()
+	report := &ImportReport{DryRun: dryRun, Entries: []ImportEntry{}}
+	for _, calendar := range components {
+		if calendar.Name != "VCALENDAR" {
+			return nil, fmt.Errorf("Expected VCALENDAR, received %s", calendar.Name)
+		}
+		res, err := newResolver(calendar)
+		if err != nil {
+			return nil, err
+		}
+		i.importCalendar(batch, calendar, res, report)
+	}
+	if dryRun {
+		for idx := range report.Entries {
+			report.Entries[idx].BookingId = 0
+		}
+		return report, batch.Rollback()
+	}
+	return report, batch.Commit()
+}
+
+func (i *Importer) importCalendar(batch booking.BookingBatch, calendar *Component, res *resolver, report *ImportReport) {
+	events := calendar.Children("VEVENT")
+	overrides := map[string][]*Component{}
+	for _, event := range events {
+		if _, ok := event.Get("RECURRENCE-ID"); ok {
+			uid := event.Value("UID")
+			overrides[uid] = append(overrides[uid], event)
+		}
+	}
+	seriesIds := map[string]int64{}
+	for _, event := range events {
+		if _, ok := event.Get("RECURRENCE-ID"); ok {
+			continue
+		}
+		entry := ImportEntry{Uid: event.Value("UID"), Summary: unescapeText(event.Value("SUMMARY"))}
+		b, err := i.bookingFromEvent(event, res)
+		if err == nil && b.Recurrence != nil {
+			applyOverrides(b, overrides[entry.Uid], res)
+		}
+		entry.Start = b.StartTime
+		if err == nil {
+			var created *booking.Booking
+			if created, err = batch.Create(*b); err == nil {
+				entry.BookingId = created.Id
+				if created.Recurrence != nil {
+					seriesIds[entry.Uid] = created.Id
+				}
+			}
+		}
+		report.Entries = append(report.Entries, entryWithResult(entry, err))
+	}
+	for _, event := range events {
+		ridProperty, ok := event.Get("RECURRENCE-ID")
+		if !ok {
+			continue
+		}
+		entry := ImportEntry{Uid: event.Value("UID"), Summary: unescapeText(event.Value("SUMMARY"))}
+		b, err := i.bookingFromEvent(event, res)
+		if err == nil {
+			entry.Start = b.StartTime
+			entry.RecurrenceId, err = res.dateTime(ridProperty, b.Room.Location())
+		}
+		seriesId, imported := seriesIds[entry.Uid]
+		switch {
+		case err != nil:
+		case !imported:
+			err = fmt.Errorf("Series '%s' has not been imported", entry.Uid)
+		default:
+			// Recurrence rules of exceptions are ignored
+			b.Recurrence = nil
+			var updated *booking.Booking
+			if updated, err = batch.UpdateOccurrence(seriesId, entry.RecurrenceId, *b); err == nil {
+				entry.BookingId = updated.Id
+			}
+		}
+		report.Entries = append(report.Entries, entryWithResult(entry, err))
+	}
+}
+
+// Cancelled exceptions exclude their occurrence from the series. Other exceptions replace their
+// occurrence, so it must not be excluded, even if the series lists it in EXDATE
+func applyOverrides(series *booking.Booking, overrides []*Component, res *resolver) {
+	for _, override := range overrides {
+		ridProperty, _ := override.Get("RECURRENCE-ID")
+		rid, err := res.dateTime(ridProperty, series.Room.Location())
+		if err != nil {
+			continue
+		}
+		exDates := slices.DeleteFunc(series.Recurrence.ExDates, func(t time.Time) bool { return t.Equal(rid) })
+		if strings.EqualFold(override.Value("STATUS"), "CANCELLED") {
+			exDates = append(exDates, rid)
+		}
+		series.Recurrence.ExDates = exDates
+	}
+}
+
+func entryWithResult(entry ImportEntry, err error) ImportEntry {
+	var conflict *booking.ErrBookingConflict
+	switch {
+	case err == nil:
+		entry.Status = ImportCreated
+	case errors.As(err, &conflict):
+		entry.Status = ImportConflict
+		entry.Reason = err.Error()
+		entry.ConflictingBookingIds = conflict.Ids()
+	default:
+		entry.Status = ImportSkipped
+		entry.Reason = err.Error()
+	}
+	return entry
+}
+
+// Maps the event onto a booking in the room matching its LOCATION, made by the user matching its
+// ORGANIZER
+func (i *Importer) bookingFromEvent(event *Component, res *resolver) (*booking.Booking, error) {
+	if strings.EqualFold(event.Value("STATUS"), "CANCELLED") {
+		return &booking.Booking{}, errCancelled
+	}
+	room, err := i.room(unescapeText(event.Value("LOCATION")))
+	if err != nil {
+		return &booking.Booking{}, err
+	}
+	organizer, ok := event.Get("ORGANIZER")
+	if !ok {
+		return &booking.Booking{}, fmt.Errorf("Missing ORGANIZER")
+	}
+	user, err := i.user(organizer)
+	if err != nil {
+		return &booking.Booking{}, err
+	}
+	b, err := bookingFromComponent(event, res, room)
+	b.User = *user
+	return b, err
+}
+
+// Maps title, description, times & recurrence of the event onto a booking in the given room.
+// Floating times are taken to be in the time zone of the room. The booking is returned on errors
+// as well, with the fields read so far
+func bookingFromComponent(event *Component, res *resolver, room *booking.Room) (*booking.Booking, error) {
+	b := &booking.Booking{
+		Title:       unescapeText(event.Value("SUMMARY")),
+		Description: unescapeText(event.Value("DESCRIPTION")),
+		Room:        *room,
+	}
+	start, ok := event.Get("DTSTART")
+	if !ok {
+		return b, fmt.Errorf("Missing DTSTART")
+	}
+	var err error
+	if b.StartTime, err = res.dateTime(start, room.Location()); err != nil {
+		return b, err
+	}
+	if end, ok := event.Get("DTEND"); ok {
+		if b.EndTime, err = res.dateTime(end, room.Location()); err != nil {
+			return b, err
+		}
+	} else if duration, ok := event.Get("DURATION"); ok {
+		d, err := parseDuration(duration.Value)
+		if err != nil {
+			return b, err
+		}
+		b.EndTime = b.StartTime.Add(d)
+	} else {
+		return b, fmt.Errorf("Missing DTEND")
+	}
+
+	if rule, ok := event.Get("RRULE"); ok {
+		if b.Recurrence, err = recurrenceFromRule(rule.Value, start, res, room.Location()); err != nil {
+			return b, err
+		}
+		for _, exDate := range event.GetAll("EXDATE") {
+			exDates, err := res.dateTimes(exDate, room.Location())
+			if err != nil {
+				return b, err
+			}
+			for _, t := range exDates {
+				if !slices.ContainsFunc(b.Recurrence.ExDates, t.Equal) {
+					b.Recurrence.ExDates = append(b.Recurrence.ExDates, t)
+				}
+			}
+		}
+	}
+	return b, nil
+}
+
+// Parses the RRULE, converting UNTIL into UTC as required by booking.ParseRecurrence.
+// UNTIL may be given as date or in the time zone of DTSTART
+func recurrenceFromRule(rule string, start Property, res *resolver, floating *time.Location) (*booking.Recurrence, error) {
+	parts := []string{}
+	for _, part := range strings.Split(rule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch {
+		// Weeks start on Monday anyway
+		case key == "WKST" && value == "MO":
+			continue
+		case key == "UNTIL" && !strings.HasSuffix(value, "Z"):
+			if len(value) == len("20060102") {
+				// Occurrences may start anytime on the last day
+				value += "T235959"
+			}
+			until, err := res.dateTime(Property{Name: "UNTIL", Params: start.Params, Value: value}, floating)
+			if err != nil {
+				return nil, err
+			}
+			part = "UNTIL=" + until.Format(utcLayout)
+		}
+		parts = append(parts, part)
+	}
+	return booking.ParseRecurrence(strings.Join(parts, ";"))
+}
+
+func (i *Importer) room(location string) (*booking.Room, error) {
+	location = strings.TrimSpace(location)
+	if len(location) == 0 {
+		return nil, fmt.Errorf("Missing LOCATION")
+	}
+	for _, room := range i.Rooms {
+		if strings.EqualFold(room.Title, location) {
+			return room, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown room '%s'", location)
+}
+
+// Matches the user id of exported organizers, the common name & the local part of mailto addresses
+func (i *Importer) user(organizer Property) (*booking.User, error) {
+	if idString, found := strings.CutPrefix(organizer.Value, organizerUrnPrefix); found {
+		id, _ := strconv.ParseInt(idString, 10, 64)
+		for _, user := range i.Users {
+			if user.Id == id {
+				return user, nil
+			}
+		}
+	}
+	names := []string{organizer.Params["CN"]}
+	if address, found := strings.CutPrefix(strings.ToLower(organizer.Value), "mailto:"); found {
+		localPart, _, _ := strings.Cut(address, "@")
+		names = append(names, localPart)
+	}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		for _, user := range i.Users {
+			if strings.EqualFold(user.Name, name) {
+				return user, nil
+			}
+		}
+	}
+	if cn := organizer.Params["CN"]; len(cn) > 0 {
+		return nil, fmt.Errorf("Unknown organizer '%s'", cn)
+	}
+	return nil, fmt.Errorf("Unknown organizer '%s'", organizer.Value)
+}
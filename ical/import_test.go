@@ -0,0 +1,277 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/fixtures"
+	"lucb31/booking-go/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testRepos struct {
+	bookings *booking.BookingRepositorySQLite
+	rooms    *booking.RoomsRepositorySQLite
+	users    *booking.UserRepositorySQLite
+}
+
+const testFixtures = `
+rooms:
+  - {title: Berlin, timeZone: Europe/Berlin}
+  - {title: Lobby}
+users:
+  - {name: alice}
+  - {name: bob}
+`
+
+func newTestRepos(t *testing.T) testRepos {
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate", filepath.Join(t.TempDir(), "test.db"))
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	users := booking.NewUserRepositorySQLite(db)
+	rooms := booking.NewRoomsRepositorySQLite(db)
+	bookings := booking.NewBookingRepositorySQLite(db, users, rooms)
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	seed, err := fixtures.Parse([]byte(testFixtures))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.Seed(fixtures.Repositories{Users: users, Rooms: rooms, Bookings: bookings}, time.Now()); err != nil {
+		t.Fatalf("Unable to seed: %s", err)
+	}
+	return testRepos{bookings, rooms, users}
+}
+
+func (r testRepos) importer(t *testing.T) *Importer {
+	rooms, err := r.rooms.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := r.users.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Importer{Bookings: r.bookings, Rooms: rooms, Users: users}
+}
+
+// Calendar as exported by Outlook, with a time zone unknown to the IANA database
+const testImportCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//EN
+BEGIN:VTIMEZONE
+TZID:W. Europe Standard Time
+BEGIN:STANDARD
+DTSTART:16010101T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:16010101T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:weekly@test
+SUMMARY:Weekly\, with exceptions
+DTSTART;TZID=W. Europe Standard Time:20240304T090000
+DTEND;TZID=W. Europe Standard Time:20240304T100000
+RRULE:FREQ=WEEKLY;WKST=MO;UNTIL=20240401
+EXDATE;TZID=W. Europe Standard Time:20240311T090000,20240325T090000
+LOCATION:berlin
+ORGANIZER;CN="Alice":mailto:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly@test
+RECURRENCE-ID;TZID=W. Europe Standard Time:20240325T090000
+SUMMARY:Moved
+DTSTART;TZID=W. Europe Standard Time:20240325T140000
+DURATION:PT30M
+LOCATION:Berlin
+ORGANIZER;CN=Alice:mailto:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:single@test
+SUMMARY:Floating time in room time zone
+DTSTART:20240702T090000
+DTEND:20240702T100000
+LOCATION:Berlin
+ORGANIZER:mailto:bob@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:overlap@test
+SUMMARY:Overlaps the previous event
+DTSTART:20240702T073000Z
+DTEND:20240702T083000Z
+LOCATION:Berlin
+ORGANIZER:mailto:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:existing@test
+DTSTART:20240701T090000Z
+DTEND:20240701T100000Z
+LOCATION:Lobby
+ORGANIZER:mailto:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:unknown-room@test
+DTSTART:20240701T090000Z
+DTEND:20240701T100000Z
+LOCATION:Basement
+ORGANIZER:mailto:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+UID:all-day@test
+DTSTART;VALUE=DATE:20240701
+DTEND;VALUE=DATE:20240702
+LOCATION:Lobby
+ORGANIZER:mailto:alice@example.com
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestImport_ReportsDryRunAndCreatesBookings(t *testing.T) {
+	repos := newTestRepos(t)
+	start := time.Date(2024, 7, 1, 9, 30, 0, 0, time.UTC)
+	existing, err := repos.bookings.Create(booking.Booking{Room: booking.Room{Id: 2}, User: booking.User{Id: 2}, StartTime: start, EndTime: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		uid    string
+		status ImportStatus
+		reason string
+	}{
+		{"weekly@test", ImportCreated, ""},
+		{"single@test", ImportCreated, ""},
+		{"overlap@test", ImportConflict, "conflicts"},
+		{"existing@test", ImportConflict, fmt.Sprint(existing.Id)},
+		{"unknown-room@test", ImportSkipped, "Unknown room 'Basement'"},
+		{"all-day@test", ImportSkipped, ErrAllDay.Error()},
+		{"weekly@test", ImportCreated, ""},
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		report, err := repos.importer(t).Import(strings.NewReader(strings.ReplaceAll(testImportCalendar, "\n", "\r\n")), dryRun)
+		if err != nil {
+			t.Fatalf("Import failed: %s", err)
+		}
+		if len(report.Entries) != len(expected) {
+			t.Fatalf("Expected %d entries, received %+v", len(expected), report.Entries)
+		}
+		for idx, e := range expected {
+			entry := report.Entries[idx]
+			if entry.Uid != e.uid || entry.Status != e.status || !strings.Contains(entry.Reason, e.reason) {
+				t.Errorf("Expected entry %d to be %s %s (%s), received %+v", idx, e.uid, e.status, e.reason, entry)
+			}
+			if (entry.BookingId > 0) != (!dryRun && e.status == ImportCreated) {
+				t.Errorf("Expected booking id only for created entries without dry run, received %+v", entry)
+			}
+		}
+		bookings, err := repos.bookings.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dryRun && len(bookings) != 1 {
+			t.Fatalf("Expected dry run to leave bookings untouched, received %d bookings", len(bookings))
+		}
+	}
+
+	report, _ := repos.importer(t).Import(strings.NewReader(testImportCalendar), true)
+	// The exception is skipped, as its series conflicts
+	if report.Count(ImportConflict) != 4 || report.Count(ImportCreated) != 0 {
+		t.Fatalf("Expected all bookings to conflict on second import, received %+v", report.Entries)
+	}
+
+	// 09:00 Berlin time on Mondays in March, with DST starting on 2024-03-31
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	occurrences, err := repos.bookings.FindWithinTimeIntervalByFilter(&from, &to, booking.BookingFilter{RoomIds: []int64{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := []string{}
+	for _, o := range occurrences {
+		starts = append(starts, o.StartTime.UTC().Format(time.DateTime)+" "+o.Title)
+	}
+	expectedStarts := []string{
+		"2024-03-04 08:00:00 Weekly, with exceptions",
+		"2024-03-18 08:00:00 Weekly, with exceptions",
+		"2024-03-25 13:00:00 Moved",
+		"2024-04-01 07:00:00 Weekly, with exceptions",
+	}
+	if strings.Join(starts, "\n") != strings.Join(expectedStarts, "\n") {
+		t.Fatalf("Expected occurrences\n%s\nreceived\n%s", strings.Join(expectedStarts, "\n"), strings.Join(starts, "\n"))
+	}
+}
+
+func TestImport_ReadsExportedCalendar(t *testing.T) {
+	source := newTestRepos(t)
+	start := time.Date(2024, 10, 21, 7, 0, 0, 0, time.UTC)
+	series, err := source.bookings.Create(booking.Booking{Title: "Planning", Description: "Line one\nLine two", Room: booking.Room{Id: 1}, User: booking.User{Id: 2},
+		StartTime: start, EndTime: start.Add(time.Hour), Recurrence: &booking.Recurrence{Frequency: booking.FrequencyWeekly, Interval: 1, Count: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := start.AddDate(0, 0, 7).Add(time.Hour)
+	if _, err := source.bookings.UpdateOccurrence(series.Id, moved, booking.Booking{Title: "Planning, moved", Room: booking.Room{Id: 2}, User: booking.User{Id: 1},
+		StartTime: moved.Add(2 * time.Hour), EndTime: moved.Add(3 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.bookings.CancelOccurrence(series.Id, start.AddDate(0, 0, 14).Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	bookings, err := source.bookings.FindByFilter(booking.BookingFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, Calendar{Domain: "example.com", Bookings: bookings}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestRepos(t)
+	report, err := target.importer(t).Import(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count(ImportCreated) != 2 {
+		t.Fatalf("Expected series & exception to be created, received %+v", report.Entries)
+	}
+	expected := occurrenceSummary(t, source.bookings, start)
+	if received := occurrenceSummary(t, target.bookings, start); received != expected {
+		t.Fatalf("Expected imported occurrences\n%s\nreceived\n%s", expected, received)
+	}
+}
+
+// Start, room, user, title & description of all occurrences in the two months after from
+func occurrenceSummary(t *testing.T, repo *booking.BookingRepositorySQLite, from time.Time) string {
+	to := from.AddDate(0, 2, 0)
+	occurrences, err := repo.FindWithinTimeInterval(&from, &to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []string{}
+	for _, o := range occurrences {
+		res = append(res, fmt.Sprintf("%s %d %d %q %q", o.StartTime.UTC().Format(time.DateTime), o.Room.Id, o.User.Id, o.Title, o.Description))
+	}
+	return strings.Join(res, "\n")
+}
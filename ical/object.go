@@ -0,0 +1,92 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"lucb31/booking-go/booking"
+)
+
+// Calendar object resource as stored by CalDAV clients: the events sharing a UID, i.e. a booking
+// or series with its exceptions
+type Object struct {
+	Uid     string
+	Booking booking.Booking
+	// Exceptions replacing single occurrences of the series, with OriginalStart set
+	Exceptions []booking.Booking
+}
+
+// Reads a calendar object resource. All events are placed in the given room, so LOCATION is
+// ignored, and the user is left to the caller. Cancelled exceptions are added to the excluded
+// dates of the series
+func ReadObject(r io.Reader, room booking.Room) (*Object, error) {
+	components, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(components) != 1 || components[0].Name != "VCALENDAR" {
+		return nil, fmt.Errorf("Expected a single VCALENDAR")
+	}
+	calendar := components[0]
+	res, err := newResolver(calendar)
+	if err != nil {
+		return nil, err
+	}
+	events := calendar.Children("VEVENT")
+	if len(events) == 0 {
+		return nil, fmt.Errorf("Expected a VEVENT")
+	}
+	obj := &Object{Uid: events[0].Value("UID"), Exceptions: []booking.Booking{}}
+	if len(obj.Uid) == 0 {
+		return nil, fmt.Errorf("Missing UID")
+	}
+	var master *Component
+	overrides := []*Component{}
+	for _, event := range events {
+		if event.Value("UID") != obj.Uid {
+			return nil, fmt.Errorf("All events of a calendar object have to share their UID")
+		}
+		if _, ok := event.Get("RECURRENCE-ID"); ok {
+			overrides = append(overrides, event)
+		} else if master == nil {
+			master = event
+		} else {
+			return nil, fmt.Errorf("Expected a single VEVENT without RECURRENCE-ID")
+		}
+	}
+	if master == nil {
+		return nil, fmt.Errorf("Exceptions without their series are not supported")
+	}
+	if strings.EqualFold(master.Value("STATUS"), "CANCELLED") {
+		return nil, errCancelled
+	}
+	b, err := bookingFromComponent(master, res, &room)
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) > 0 && b.Recurrence == nil {
+		return nil, fmt.Errorf("Exceptions of an event without RRULE")
+	}
+	if b.Recurrence != nil {
+		applyOverrides(b, overrides, res)
+	}
+	obj.Booking = *b
+	for _, override := range overrides {
+		if strings.EqualFold(override.Value("STATUS"), "CANCELLED") {
+			continue
+		}
+		exception, err := bookingFromComponent(override, res, &room)
+		if err != nil {
+			return nil, err
+		}
+		ridProperty, _ := override.Get("RECURRENCE-ID")
+		if exception.OriginalStart, err = res.dateTime(ridProperty, room.Location()); err != nil {
+			return nil, err
+		}
+		// Recurrence rules of exceptions are ignored
+		exception.Recurrence = nil
+		obj.Exceptions = append(obj.Exceptions, *exception)
+	}
+	return obj, nil
+}
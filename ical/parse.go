@@ -0,0 +1,196 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Property of a content line, e.g. "DTSTART;TZID=Europe/Berlin:20240101T090000"
+type Property struct {
+	Name string
+	// Parameter values by upper case name, without quotes
+	Params map[string]string
+	Value  string
+}
+
+// Component like VCALENDAR, VEVENT or VTIMEZONE with its properties & nested components
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+// Returns the first property of the given name
+func (c *Component) Get(name string) (Property, bool) {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Returns the value of the first property of the given name, or an empty string
+func (c *Component) Value(name string) string {
+	p, _ := c.Get(name)
+	return p.Value
+}
+
+// Returns all properties of the given name
+func (c *Component) GetAll(name string) []Property {
+	res := []Property{}
+	for _, p := range c.Properties {
+		if p.Name == name {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Returns the nested components of the given name
+func (c *Component) Children(name string) []*Component {
+	res := []*Component{}
+	for _, child := range c.Components {
+		if child.Name == name {
+			res = append(res, child)
+		}
+	}
+	return res
+}
+
+// Parses an iCalendar stream into its top level components, usually one VCALENDAR
+func Parse(r io.Reader) ([]*Component, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	res := []*Component{}
+	stack := []*Component{}
+	for idx, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		p, err := parseContentLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", idx+1, err)
+		}
+		switch p.Name {
+		case "BEGIN":
+			component := &Component{Name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else {
+				res = append(res, component)
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("Line %d: Unexpected END:%s", idx+1, p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("Line %d: Property %s outside of a component", idx+1, p.Name)
+			}
+			component := stack[len(stack)-1]
+			component.Properties = append(component.Properties, p)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("Missing END:%s", stack[len(stack)-1].Name)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("No calendar found")
+	}
+	return res, nil
+}
+
+// Splits the stream into content lines, joining folded lines
+func unfoldLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// Parses "NAME;PARAM=value;PARAM=\"quoted:value\":value"
+func parseContentLine(line string) (Property, error) {
+	p := Property{Params: map[string]string{}}
+	nameEnd := strings.IndexAny(line, ";:")
+	if nameEnd <= 0 {
+		return p, fmt.Errorf("Invalid content line '%s'", line)
+	}
+	p.Name = strings.ToUpper(line[:nameEnd])
+	rest := line[nameEnd:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		paramName, value, found := strings.Cut(rest, "=")
+		if !found {
+			return p, fmt.Errorf("Invalid parameter of %s", p.Name)
+		}
+		rest = value
+		var paramValue strings.Builder
+		// Values may list several, partly quoted values separated by commas
+		for {
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.Index(rest[1:], `"`)
+				if end < 0 {
+					return p, fmt.Errorf("Unterminated quote in parameter %s of %s", paramName, p.Name)
+				}
+				paramValue.WriteString(rest[1 : end+1])
+				rest = rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ",;:")
+				if end < 0 {
+					return p, fmt.Errorf("Missing value of %s", p.Name)
+				}
+				paramValue.WriteString(rest[:end])
+				rest = rest[end:]
+			}
+			if !strings.HasPrefix(rest, ",") {
+				break
+			}
+			paramValue.WriteString(",")
+			rest = rest[1:]
+		}
+		p.Params[strings.ToUpper(paramName)] = paramValue.String()
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return p, fmt.Errorf("Missing value of %s", p.Name)
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+// Reverts escapeText
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] != '\\' || idx == len(s)-1 {
+			b.WriteByte(s[idx])
+			continue
+		}
+		idx++
+		switch s[idx] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[idx])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse_UnfoldsLinesAndReadsParameters(t *testing.T) {
+	components, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDESCRIPTION:Folded\r\n  line\\, escaped\r\n" +
+		"ORGANIZER;CN=\"Doe, John\";ROLE=CHAIR:mailto:john@example.com\r\nX-LIST;A=\"x:y\",z:value\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := components[0].Children("VEVENT")[0]
+	if description := unescapeText(event.Value("DESCRIPTION")); description != "Folded line, escaped" {
+		t.Fatalf("Expected unfolded description, received '%s'", description)
+	}
+	organizer, _ := event.Get("ORGANIZER")
+	if organizer.Params["CN"] != "Doe, John" || organizer.Params["ROLE"] != "CHAIR" || organizer.Value != "mailto:john@example.com" {
+		t.Fatalf("Expected quoted parameter to be read, received %+v", organizer)
+	}
+	if list, _ := event.Get("X-LIST"); list.Params["A"] != "x:y,z" || list.Value != "value" {
+		t.Fatalf("Expected parameter with several values, received %+v", list)
+	}
+}
+
+func TestParse_RejectsUnbalancedComponents(t *testing.T) {
+	for _, input := range []string{"BEGIN:VCALENDAR\r\n", "BEGIN:VCALENDAR\r\nEND:VEVENT\r\n", "SUMMARY:Outside\r\n", ""} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestNthWeekday(t *testing.T) {
+	tests := []struct {
+		month    time.Month
+		nth      int
+		weekday  time.Weekday
+		expected string
+	}{
+		{time.March, -1, time.Sunday, "2024-03-31"},
+		{time.October, -1, time.Sunday, "2024-10-27"},
+		{time.March, 2, time.Sunday, "2024-03-10"},
+		{time.November, 1, time.Sunday, "2024-11-03"},
+		{time.April, 1, time.Monday, "2024-04-01"},
+		{time.February, -2, time.Thursday, "2024-02-22"},
+	}
+	for _, tt := range tests {
+		if received := nthWeekday(2024, tt.month, tt.nth, tt.weekday).Format(time.DateOnly); received != tt.expected {
+			t.Errorf("Expected %d. %s of %s to be %s, received %s", tt.nth, tt.weekday, tt.month, tt.expected, received)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT1H30M": 90 * time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"PT45S":   45 * time.Second,
+		"P1DT2H":  26 * time.Hour,
+	}
+	for input, expected := range tests {
+		if received, err := parseDuration(input); err != nil || received != expected {
+			t.Errorf("Expected %s to be %s, received %s (%v)", input, expected, received, err)
+		}
+	}
+	for _, input := range []string{"P", "PT", "-PT1H", "1H"} {
+		if _, err := parseDuration(input); err == nil {
+			t.Errorf("Expected %s to be rejected", input)
+		}
+	}
+}
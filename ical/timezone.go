@@ -0,0 +1,187 @@
+package ical
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"lucb31/booking-go/booking"
+)
+
+// Observances of a time zone are listed from the first event until this long after the last event
+// or the export, whichever is later. The last daylight saving time rules repeat yearly beyond
+const timeZoneHorizon = 2 * 365 * 24 * time.Hour
+
+// Time zone of the events & the period it has to be described for
+type usedTimeZone struct {
+	loc  *time.Location
+	from time.Time
+	to   time.Time
+}
+
+// Returns the time zones of the rooms of the given bookings, ordered by name. UTC is left out,
+// as UTC times need no VTIMEZONE
+func usedTimeZones(bookings []*booking.Booking, stamp time.Time) []usedTimeZone {
+	zones := map[string]*usedTimeZone{}
+	for _, b := range bookings {
+		loc := b.Room.Location()
+		if loc == time.UTC {
+			continue
+		}
+		from := b.StartTime
+		if !b.OriginalStart.IsZero() && b.OriginalStart.Before(from) {
+			from = b.OriginalStart
+		}
+		to := b.EndTime
+		if stamp.After(to) {
+			to = stamp
+		}
+		if b.Recurrence != nil && b.Recurrence.Until.After(to) {
+			to = b.Recurrence.Until
+		}
+		to = to.Add(timeZoneHorizon)
+		zone, ok := zones[loc.String()]
+		if !ok {
+			zones[loc.String()] = &usedTimeZone{loc, from, to}
+			continue
+		}
+		if from.Before(zone.from) {
+			zone.from = from
+		}
+		if to.After(zone.to) {
+			zone.to = to
+		}
+	}
+	res := []usedTimeZone{}
+	for _, zone := range zones {
+		res = append(res, *zone)
+	}
+	slices.SortFunc(res, func(a, b usedTimeZone) int { return strings.Compare(a.loc.String(), b.loc.String()) })
+	return res
+}
+
+// STANDARD or DAYLIGHT sub-component of a VTIMEZONE
+type observance struct {
+	// Point in time the observance takes effect
+	start      time.Time
+	offsetFrom int
+	offsetTo   int
+	name       string
+	dst        bool
+	// Yearly RRULE, if the observance repeats beyond the described period
+	rule string
+}
+
+// Local time the observance takes effect, in the offset before the change
+func (o observance) localStart() time.Time {
+	return o.start.In(time.FixedZone("", o.offsetFrom))
+}
+
+func writeTimeZone(lw *lineWriter, zone usedTimeZone) {
+	lw.line("BEGIN:VTIMEZONE")
+	lw.line("TZID:" + zone.loc.String())
+	for _, o := range observances(zone.loc, zone.from, zone.to) {
+		component := "STANDARD"
+		if o.dst {
+			component = "DAYLIGHT"
+		}
+		lw.line("BEGIN:" + component)
+		lw.line("DTSTART:" + o.localStart().Format(localLayout))
+		if len(o.rule) > 0 {
+			lw.line("RRULE:" + o.rule)
+		}
+		lw.line("TZOFFSETFROM:" + formatOffset(o.offsetFrom))
+		lw.line("TZOFFSETTO:" + formatOffset(o.offsetTo))
+		lw.line("TZNAME:" + escapeText(o.name))
+		lw.line("END:" + component)
+	}
+	lw.line("END:VTIMEZONE")
+}
+
+// Returns the observance in effect at from & all changes of the offset until to. If the last two
+// changes follow the same yearly rule as the two after, they are given that rule
+func observances(loc *time.Location, from time.Time, to time.Time) []observance {
+	t := from.In(loc)
+	start, end := t.ZoneBounds()
+	name, offset := t.Zone()
+	first := observance{start: start, offsetFrom: offset, offsetTo: offset, name: name, dst: t.IsDST()}
+	if start.IsZero() {
+		// Zone without any recorded change
+		first.start = time.Date(1970, 1, 1, 0, 0, 0, 0, time.FixedZone("", offset))
+	} else {
+		_, first.offsetFrom = start.Add(-time.Second).In(loc).Zone()
+	}
+	res := []observance{first}
+	next := func() (observance, bool) {
+		if end.IsZero() {
+			return observance{}, false
+		}
+		t := end.In(loc)
+		name, offset := t.Zone()
+		o := observance{start: end, offsetTo: offset, name: name, dst: t.IsDST()}
+		_, o.offsetFrom = end.Add(-time.Second).In(loc).Zone()
+		_, end = t.ZoneBounds()
+		return o, true
+	}
+	for !end.IsZero() && end.Before(to) {
+		o, _ := next()
+		res = append(res, o)
+	}
+	if len(res) < 3 {
+		return res
+	}
+	following := []observance{}
+	for range 2 {
+		if o, ok := next(); ok {
+			following = append(following, o)
+		}
+	}
+	if len(following) < 2 {
+		return res
+	}
+	last := res[len(res)-2:]
+	for idx, o := range last {
+		if !sameYearlyRule(o, following[idx]) {
+			return res
+		}
+	}
+	for idx := range last {
+		last[idx].rule = yearlyRule(last[idx])
+	}
+	return res
+}
+
+// Returns true, if both observances change to the same offset on the same day of the year
+func sameYearlyRule(a observance, b observance) bool {
+	return a.offsetFrom == b.offsetFrom && a.offsetTo == b.offsetTo && a.name == b.name &&
+		a.localStart().Format("150405") == b.localStart().Format("150405") &&
+		yearlyRule(a) == yearlyRule(b)
+}
+
+// Describes the day of the change as n-th or last weekday of its month,
+// e.g. "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU"
+func yearlyRule(o observance) string {
+	local := o.localStart()
+	daysInMonth := time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	nth := (local.Day()-1)/7 + 1
+	if local.Day() > daysInMonth-7 {
+		nth = -1
+	}
+	weekday := strings.ToUpper(local.Weekday().String()[0:2])
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", local.Month(), nth, weekday)
+}
+
+// Formats an offset in seconds east of UTC as "+0100"
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	res := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	if offset%60 != 0 {
+		res += fmt.Sprintf("%02d", offset%60)
+	}
+	return res
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"lucb31/booking-go/ical"
+)
+
+// Runs the command line command of the given name
+func runCommand(name string, args []string) error {
+	switch name {
+	case "import":
+		return runImportCommand(args, os.Stdout)
+	case "migrate":
+		return runMigrateCommand(migrator, args, os.Stdout)
+	case "seed":
+		return runSeedCommand(args, os.Stdout)
+	}
+	return fmt.Errorf("Unknown command '%s'. Available commands: import, migrate, seed", name)
+}
+
+// Imports the bookings of an iCalendar file, matching its locations & organizers against the
+// existing rooms & users
+func ImportBookingsRequest(r io.Reader, dryRun bool) (*ical.ImportReport, error) {
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	users, err := userRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	importer := ical.Importer{Bookings: bookingRepo, Rooms: rooms, Users: users}
+	return importer.Import(r, dryRun)
+}
+
+// booking-go import [-dry-run] <file.ics>
+func runImportCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dryRun := flags.Bool("dry-run", false, "Report the outcome without creating any booking")
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: booking-go import [-dry-run] <file.ics>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("Expected a single file to import")
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	report, err := ImportBookingsRequest(file, *dryRun)
+	if err != nil {
+		return err
+	}
+	return printImportReport(out, report)
+}
+
+func printImportReport(out io.Writer, report *ical.ImportReport) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tSTART\tUID\tSUMMARY\tBOOKING\tREASON")
+	for _, entry := range report.Entries {
+		start := ""
+		if !entry.Start.IsZero() {
+			start = entry.Start.UTC().Format(time.RFC3339)
+		}
+		bookingId := ""
+		if entry.BookingId > 0 {
+			bookingId = fmt.Sprint(entry.BookingId)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Status, start, entry.Uid, entry.Summary, bookingId, entry.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	summary := fmt.Sprintf("Created %d, skipped %d, conflicting %d", report.Count(ical.ImportCreated), report.Count(ical.ImportSkipped), report.Count(ical.ImportConflict))
+	if report.DryRun {
+		summary += ". Dry run, nothing has been imported"
+	}
+	_, err := fmt.Fprintln(out, summary)
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/ical"
+)
+
+const testImportCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+	"BEGIN:VEVENT\r\nUID:standup@test\r\nSUMMARY:Standup\r\nDTSTART;TZID=Europe/Berlin:20240701T090000\r\n" +
+	"DTEND;TZID=Europe/Berlin:20240701T091500\r\nRRULE:FREQ=DAILY;COUNT=5\r\nLOCATION:Berlin\r\nORGANIZER;CN=alice:mailto:alice@example.com\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:unknown@test\r\nDTSTART:20240701T090000Z\r\nDTEND:20240701T100000Z\r\nLOCATION:Basement\r\nORGANIZER;CN=alice:mailto:alice@example.com\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestApiImport_ReportsDryRunAndCreatesBookings(t *testing.T) {
+	useTestDatabase(t)
+	admin := createTestUser(t, "root", "correct horse", booking.RoleAdmin)
+	member := createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	if _, err := roomRepo.Create(booking.Room{Title: "Berlin", TimeZone: "Europe/Berlin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := apiRequestWithContentType(t, member, http.MethodPost, "/api/v1/bookings/import", "text/calendar", testImportCalendar); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected members to be forbidden, received %d: %s", w.Code, w.Body)
+	}
+	for _, dryRun := range []bool{true, false} {
+		path, expectedStatus := "/api/v1/bookings/import", http.StatusCreated
+		if dryRun {
+			path, expectedStatus = path+"?dryRun=true", http.StatusOK
+		}
+		w := apiRequestWithContentType(t, admin, http.MethodPost, path, "text/calendar", testImportCalendar)
+		if w.Code != expectedStatus {
+			t.Fatalf("Expected status %d, received %d: %s", expectedStatus, w.Code, w.Body)
+		}
+		var report ApiImportReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.DryRun != dryRun || report.Created != 1 || report.Skipped != 1 || report.Entries[1].Reason != "Unknown room 'Basement'" {
+			t.Fatalf("Expected standup to be created and unknown room to be skipped, received %+v", report)
+		}
+		if (report.Entries[0].BookingId > 0) == dryRun {
+			t.Fatalf("Expected booking id only without dry run, received %+v", report.Entries[0])
+		}
+	}
+	bookings, err := bookingRepo.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 1 || bookings[0].User.Id != member.Id || bookings[0].StartTime.UTC() != time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC) {
+		t.Fatalf("Expected a single series of alice at 09:00 Berlin time, received %+v", bookings)
+	}
+
+	if w := apiRequestWithContentType(t, admin, http.MethodPost, "/api/v1/bookings/import", "text/calendar", "BEGIN:VEVENT\r\n"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected invalid file to be rejected, received %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestImportCommand_PrintsReport(t *testing.T) {
+	useTestDatabase(t)
+	createTestUser(t, "alice", "correct horse", booking.RoleMember)
+	if _, err := roomRepo.Create(booking.Room{Title: "Berlin", TimeZone: "Europe/Berlin"}); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "calendar.ics")
+	if err := os.WriteFile(file, []byte(testImportCalendar), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := runImportCommand([]string{"-dry-run", file}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{string(ical.ImportCreated) + "  ", "standup@test", "Unknown room 'Basement'", "Created 1, skipped 1, conflicting 0. Dry run"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected report to contain %q, received\n%s", expected, out.String())
+		}
+	}
+	if bookings, _ := bookingRepo.GetAll(); len(bookings) != 0 {
+		t.Fatalf("Expected dry run to create no bookings, received %d", len(bookings))
+	}
+	if err := runImportCommand([]string{}, &out); err == nil {
+		t.Fatal("Expected missing file to be rejected")
+	}
+}
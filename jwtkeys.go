@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key to sign or verify JWTs with, identified by the "kid" header of the token
+type jwtKey struct {
+	Kid    string
+	Method jwt.SigningMethod
+	// Nil for keys that only verify tokens issued before a rotation
+	SignKey   any
+	VerifyKey any
+}
+
+// Keys accepted by VerifyJWT. New tokens are signed with the active key only
+type jwtKeySet struct {
+	Active *jwtKey
+	Keys   map[string]*jwtKey
+}
+
+// Entry of the JWT_KEYS_FILE. Secrets are given inline, PEM encoded keys as file paths relative
+// to the key file
+type jwtKeyConfig struct {
+	Kid            string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKeyFile string `json:"privateKeyFile"`
+	PublicKeyFile  string `json:"publicKeyFile"`
+}
+
+type jwtKeysConfig struct {
+	// Kid of the key new tokens are signed with
+	Active string         `json:"active"`
+	Keys   []jwtKeyConfig `json:"keys"`
+}
+
+var jwtKeys = randomJwtKeySet()
+
+// Single HS256 key, that is lost on restart
+func randomJwtKeySet() *jwtKeySet {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	key := &jwtKey{Kid: "random", Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret}
+	return &jwtKeySet{Active: key, Keys: map[string]*jwtKey{key.Kid: key}}
+}
+
+// Loads the signing keys from the file at JWT_KEYS_FILE, or a single HS256 key from JWT_SECRET.
+// Falls back to a random key, invalidating all sessions on restart
+func loadJwtKeys() (*jwtKeySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); len(path) > 0 {
+		return loadJwtKeysFile(path)
+	}
+	if secret := os.Getenv("JWT_SECRET"); len(secret) > 0 {
+		key := &jwtKey{Kid: "default", Method: jwt.SigningMethodHS256, SignKey: []byte(secret), VerifyKey: []byte(secret)}
+		return &jwtKeySet{Active: key, Keys: map[string]*jwtKey{key.Kid: key}}, nil
+	}
+	logger.Print("Neither JWT_KEYS_FILE nor JWT_SECRET set. Using a random key, sessions end on restart")
+	return randomJwtKeySet(), nil
+}
+
+func loadJwtKeysFile(path string) (*jwtKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config jwtKeysConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("Invalid key file %s: %w", path, err)
+	}
+	set := &jwtKeySet{Keys: map[string]*jwtKey{}}
+	for _, keyConfig := range config.Keys {
+		key, err := jwtKeyFromConfig(keyConfig, filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key '%s': %w", keyConfig.Kid, err)
+		}
+		if _, exists := set.Keys[key.Kid]; exists {
+			return nil, fmt.Errorf("Duplicate key '%s'", key.Kid)
+		}
+		set.Keys[key.Kid] = key
+	}
+	set.Active = set.Keys[config.Active]
+	if set.Active == nil {
+		return nil, fmt.Errorf("Active key '%s' is not configured", config.Active)
+	}
+	if set.Active.SignKey == nil {
+		return nil, fmt.Errorf("Active key '%s' has no private key", config.Active)
+	}
+	return set, nil
+}
+
+func jwtKeyFromConfig(config jwtKeyConfig, dir string) (*jwtKey, error) {
+	if len(config.Kid) == 0 {
+		return nil, errors.New("Missing kid")
+	}
+	key := &jwtKey{Kid: config.Kid, Method: jwt.GetSigningMethod(config.Alg)}
+	readPem := func(path string) ([]byte, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return os.ReadFile(path)
+	}
+	switch key.Method {
+	case jwt.SigningMethodHS256:
+		if len(config.Secret) == 0 {
+			return nil, errors.New("Missing secret")
+		}
+		key.SignKey, key.VerifyKey = []byte(config.Secret), []byte(config.Secret)
+	case jwt.SigningMethodRS256:
+		if len(config.PrivateKeyFile) > 0 {
+			pem, err := readPem(config.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.SignKey, key.VerifyKey = privateKey, &privateKey.PublicKey
+		} else if len(config.PublicKeyFile) > 0 {
+			pem, err := readPem(config.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.VerifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+	case jwt.SigningMethodEdDSA:
+		if len(config.PrivateKeyFile) > 0 {
+			pem, err := readPem(config.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			privateKey, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := privateKey.(crypto.Signer)
+			if !ok {
+				return nil, errors.New("Unsupported private key")
+			}
+			key.SignKey, key.VerifyKey = privateKey, signer.Public()
+		} else if len(config.PublicKeyFile) > 0 {
+			pem, err := readPem(config.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			if key.VerifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported algorithm '%s'. Expected HS256, RS256 or EdDSA", config.Alg)
+	}
+	if key.VerifyKey == nil {
+		return nil, errors.New("Missing privateKeyFile or publicKeyFile")
+	}
+	return key, nil
+}
+
+// Looks up the key of a token by its "kid" header
+func (s *jwtKeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := s.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key '%s'", kid)
+	}
+	// Reject tokens claiming a different algorithm than the key is meant for
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("Incompatible signing method")
+	}
+	return key.VerifyKey, nil
+}
+
+func (s *jwtKeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.Active.Method, claims)
+	token.Header["kid"] = s.Active.Kid
+	return token.SignedString(s.Active.SignKey)
+}
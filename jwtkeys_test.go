@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lucb31/booking-go/booking"
+)
+
+// Writes the key file and PEM files into a temporary directory and returns the key file path
+func writeTestKeyFile(t *testing.T, config string) string {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDer, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicDer, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"ed25519.pem":    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDer}),
+		"rsa.pem":        pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"rsa.public.pem": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublicDer}),
+		"keys.json":      []byte(config),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "keys.json")
+}
+
+func useJwtKeys(t *testing.T, keys *jwtKeySet) {
+	previous := jwtKeys
+	jwtKeys = keys
+	t.Cleanup(func() { jwtKeys = previous })
+}
+
+func TestLoadJwtKeysFile_SupportsAllAlgorithms(t *testing.T) {
+	user := &booking.User{Id: 1, Name: "alice"}
+	for _, kid := range []string{"hmac", "ed", "rsa"} {
+		path := writeTestKeyFile(t, `{"active": "`+kid+`", "keys": [
+			{"kid": "hmac", "alg": "HS256", "secret": "secret"},
+			{"kid": "ed", "alg": "EdDSA", "privateKeyFile": "ed25519.pem"},
+			{"kid": "rsa", "alg": "RS256", "privateKeyFile": "rsa.pem"}
+		]}`)
+		keys, err := loadJwtKeysFile(path)
+		if err != nil {
+			t.Fatalf("Unable to load keys: %s", err)
+		}
+		useJwtKeys(t, keys)
+		token, err := GenerateJWT(user, 1)
+		if err != nil {
+			t.Fatalf("Unable to sign with %s: %s", kid, err)
+		}
+		if _, err := VerifyJWT(token); err != nil {
+			t.Fatalf("Expected token signed with %s to verify, received %s", kid, err)
+		}
+	}
+}
+
+func TestVerifyJWT_AcceptsTokensOfRotatedKeys(t *testing.T) {
+	user := &booking.User{Id: 1, Name: "alice"}
+	before, err := loadJwtKeysFile(writeTestKeyFile(t, `{"active": "old", "keys": [{"kid": "old", "alg": "HS256", "secret": "old secret"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJwtKeys(t, before)
+	oldToken, err := GenerateJWT(user, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := loadJwtKeysFile(writeTestKeyFile(t, `{"active": "new", "keys": [
+		{"kid": "old", "alg": "HS256", "secret": "old secret"},
+		{"kid": "new", "alg": "EdDSA", "privateKeyFile": "ed25519.pem"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJwtKeys(t, after)
+	if _, err := VerifyJWT(oldToken); err != nil {
+		t.Fatalf("Expected token of rotated key to verify, received %s", err)
+	}
+
+	retired, err := loadJwtKeysFile(writeTestKeyFile(t, `{"active": "new", "keys": [{"kid": "new", "alg": "EdDSA", "privateKeyFile": "ed25519.pem"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useJwtKeys(t, retired)
+	if _, err := VerifyJWT(oldToken); err == nil {
+		t.Fatalf("Expected token of removed key to be rejected")
+	}
+}
+
+func TestLoadJwtKeysFile_RequiresPrivateActiveKey(t *testing.T) {
+	path := writeTestKeyFile(t, `{"active": "rsa", "keys": [{"kid": "rsa", "alg": "RS256", "publicKeyFile": "rsa.public.pem"}]}`)
+	if _, err := loadJwtKeysFile(path); err == nil {
+		t.Fatalf("Expected verify-only active key to be rejected")
+	}
+}
+
+func TestVerifyJWT_RejectsAlgorithmOfOtherKey(t *testing.T) {
+	user := &booking.User{Id: 1, Name: "alice"}
+	keys, err := loadJwtKeysFile(writeTestKeyFile(t, `{"active": "hmac", "keys": [
+		{"kid": "hmac", "alg": "HS256", "secret": "secret"},
+		{"kid": "rsa", "alg": "RS256", "publicKeyFile": "rsa.public.pem"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HS256 token claiming to be signed with the RSA key
+	keys.Active = &jwtKey{Kid: "rsa", Method: keys.Keys["hmac"].Method, SignKey: []byte("secret")}
+	useJwtKeys(t, keys)
+	token, err := GenerateJWT(user, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyJWT(token); err == nil {
+		t.Fatalf("Expected token with mismatching algorithm to be rejected")
+	}
+}
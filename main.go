@@ -1,32 +1,96 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	// Time zones of rooms & users do not depend on the zoneinfo of the host
+	_ "time/tzdata"
 
 	"lucb31/booking-go/booking"
 	"lucb31/booking-go/calendar"
+	"lucb31/booking-go/migrations"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type RoomPageData struct {
-	Rooms []booking.Room
+	// Edit form of every room
+	Rooms []RoomForm
+	Error string
+}
+
+// Checkbox or option of a form
+type FormOption struct {
+	Value   string
+	Checked bool
+}
+
+// Inputs of the form adding or editing a room
+type RoomForm struct {
+	Room      booking.Room
+	Equipment []FormOption
+	// Equipment beyond booking.CommonEquipment, comma separated
+	OtherEquipment string
+	Accessibility  []FormOption
+}
+
+// Inputs filtering rooms by their attributes, shared by the calendar & the availability search
+type RoomFilterForm struct {
+	Filter        booking.RoomFilter
+	Buildings     []FormOption
+	Equipment     []FormOption
+	Accessibility []FormOption
 }
 
 type BookingPageData struct {
+	// Page of bookings selected by Query
 	Bookings []booking.Booking
+	Query    BookingQueryForm
 	Rooms    []booking.Room
 	Users    []booking.User
 	Error    string
+	RoomPage RoomPageData
+	// Empty form adding a room
+	NewRoom RoomForm
+	// Bookings holding the requested slot, if the request failed due to a conflict
+	Conflicts []booking.Booking
+	// Time zone times are displayed & entered in
+	TimeZone string
+	// Calendar feeds of the current user
+	Feeds FeedPageData
+}
+
+// Inputs filtering & sorting the bookings of the index page
+type BookingQueryForm struct {
+	Search string
+	// Dates in format yyyy-mm-dd
+	From   string
+	To     string
+	Status booking.BookingStatus
+	Sort   booking.BookingSort
+	Rooms  []CalendarFilterOption
+	Users  []CalendarFilterOption
+	// URL of the next page of bookings. Empty on the last page
+	NextUrl string
 }
 
 type BookingDetailData struct {
 	Booking booking.Booking
+	Rooms   []booking.Room
+	Users   []booking.User
+	Error   string
+}
+
+type PasswordChangeData struct {
+	Message string
 	Error   string
 }
 
@@ -35,91 +99,200 @@ type LoginResponse struct {
 	ErrorMessage string
 }
 
+type CalendarViewLink struct {
+	View string
+	Url  string
+}
+
+// Room or user of the calendar filter form
+type CalendarFilterOption struct {
+	Id       int64
+	Label    string
+	Selected bool
+}
+
 type CalendarData struct {
 	TimeMarkers []string
 	DayData     []calendar.CalendarDayData
-	Cw          int
-	NextCw      int
-	PrevCw      int
+	// ISO year & week of the week view
+	Year int
+	Cw   int
+	// First day shown, formatted as 2006-01-02. Initial value of the jump to date input
+	Date        string
+	SlotMinutes int
+	// Rooms & users the calendar is filtered by
+	Filter      booking.BookingFilter
+	RoomOptions []CalendarFilterOption
+	UserOptions []CalendarFilterOption
+	RoomFilter  RoomFilterForm
+	// One of month, week, day, resource & agenda
+	View  string
+	Title string
+	Month *calendar.CalendarMonthData
+	// Columns of the resource view
+	Resources []calendar.CalendarResourceData
+	// Upcoming days with bookings
+	Agenda []calendar.AgendaDay
+	// URLs of the current, previous & next page of the view. Empty if there is no such page
+	Url     string
+	PrevUrl string
+	NextUrl string
+	// Query parameters of the current page, kept when the filter changes
+	PageParams map[string]string
+	// URL of the day view without date, used by the days of the month view
+	DayUrl string
+	// Links to switch to each view
+	Views []CalendarViewLink
+	Error string
+}
+
+type AvailabilityData struct {
+	// Form values: the day, the window within the day as 15:04 & the duration in minutes
+	Date        string
+	From        string
+	To          string
+	Duration    int
+	RoomOptions []CalendarFilterOption
+	RoomFilter  RoomFilterForm
+	// Whether the form has been submitted
+	Searched bool
+	// Times are converted into the time zone of the current user
+	Candidates []calendar.Candidate
+	Busy       []calendar.RoomBusy
+	TimeZone   string
+	Error      string
 }
 
 var logger = log.Default()
 var bookingRepo booking.BookingRepository
 var userRepo booking.UserRepository
 var roomRepo booking.RoomsRepository
+var sessionRepo booking.SessionRepository
+var feedRepo booking.FeedRepository
+var calendarObjectRepo booking.CalendarObjectRepository
+var migrator *migrations.Migrator
+var calendarConfig = calendar.DefaultConfig()
 
 func main() {
-	// Initialize router
-	r := gin.Default()
-	r.LoadHTMLGlob("templates/*")
-	r.Static("/assets", "./assets/")
-
-	// Initialize DB
-	db, err := sqlx.Connect("sqlite3", "file:test.db")
+	// What happens to future bookings of deleted rooms: block (default), cascade or reassign
+	roomDeletePolicy, err := booking.ParseRoomDeletePolicy(os.Getenv("ROOM_DELETE_POLICY"))
 	if err != nil {
 		log.Fatalln(err)
 	}
-	// Init repos
-	userRepo = booking.NewUserRepositorySQLite(db)
-	if err := userRepo.Migrate(); err != nil {
-		log.Fatalln(err)
+	// Domain of the UIDs of CalDAV objects. Changing it makes clients see every booking as new
+	if domain := os.Getenv("CALDAV_DOMAIN"); len(domain) > 0 {
+		calDavDomain = domain
 	}
-	roomRepo = booking.NewRoomsRepositorySQLite(db)
-	if err := roomRepo.Migrate(); err != nil {
-		log.Fatalln(err)
+	// Postgres URL or SQLite DSN. SQLite always runs with "_txlock=immediate", which serializes the
+	// booking conflict checks, so a lock mode given in the DSN is replaced
+	dsn := os.Getenv("DATABASE_DSN")
+	if len(dsn) == 0 {
+		dsn = defaultDatabaseDsn
 	}
-	bookingRepo = booking.NewBookingRepositorySQLite(db, userRepo, roomRepo)
-	if err = bookingRepo.Migrate(); err != nil {
-		log.Fatalln(err)
+	// "booking-go --demo" runs the server on the development fixtures without any database
+	if len(os.Args) == 2 && os.Args[1] == "--demo" {
+		if err := openDemoRepositories(roomDeletePolicy); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Running in demo mode. Log in as root with password root, changes are lost on exit")
+	} else {
+		if err := openRepositories(dsn, roomDeletePolicy); err != nil {
+			log.Fatalln(err)
+		}
+		// "booking-go migrate" changes the schema itself, everything else runs on its latest version
+		if len(os.Args) < 2 || os.Args[1] != "migrate" {
+			applied, err := migrator.Up()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			for _, migration := range applied {
+				log.Printf("Applied migration %s", migration)
+			}
+		}
+		// Commands run instead of the server, e.g. "booking-go import calendar.ics"
+		if len(os.Args) > 1 {
+			if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
 	}
-	// Seed test data
-	if err := userRepo.SeedTestData(); err != nil {
+	if jwtKeys, err = loadJwtKeys(); err != nil {
 		log.Fatalln(err)
 	}
-	if err := roomRepo.SeedTestData(); err != nil {
-		log.Fatalln(err)
+	// Working hours of the calendar
+	if path := os.Getenv("CALENDAR_CONFIG_FILE"); len(path) > 0 {
+		if calendarConfig, err = calendar.LoadConfig(path); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	if err := bookingRepo.SeedTestData(); err != nil {
-		log.Fatalln(err)
+	if users, err := userRepo.GetAll(); err == nil && len(users) == 0 {
+		log.Println("No users yet. Run \"booking-go seed\" to create the development fixtures")
 	}
 
+	// Initialize router
+	r := gin.Default()
+	r.LoadHTMLGlob("templates/*")
+	r.Static("/assets", "./assets/")
+
 	// Unauthorized routes
 	r.GET("/login", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "login.html", LoginResponse{"", ""})
 	})
 	r.POST("/login", handleLoginRequest)
+	// Calendar clients authenticate with the token in the URL
+	r.GET("/feeds/:token/calendar.ics", handleGetFeedRequest)
+	registerCalDavRoutes(r)
 
 	// Authorized routes
 	authenticated := r.Group("/")
 	authenticated.Use(AuthMiddleware())
 	{
 		authenticated.GET("/", func(c *gin.Context) {
-			data, err := getBookingPageData()
+			data, err := getBookingPageData(c)
 			if err != nil {
 				logger.Panic(err)
 				c.HTML(http.StatusOK, "index.html", BookingPageData{})
 				return
 			}
+			if data.Feeds, err = getFeedPageData(currentUserId(c)); err != nil {
+				logger.Print("Failed to load feeds: ", err)
+			}
 			c.HTML(http.StatusOK, "index.html", data)
 		})
-		authenticated.GET("/logout", func(c *gin.Context) {
-			c.SetCookie("Jwt-Token", "", 0, "", "localhost", true, true)
-			c.Redirect(http.StatusFound, "/login")
-		})
+		authenticated.GET("/logout", handleLogoutRequest)
+
+		authenticated.POST("/account/password", handleChangePasswordRequest)
+		authenticated.POST("/account/time-zone", handleChangeTimeZoneRequest)
+
+		feedEndpoints := authenticated.Group("/feeds")
+		{
+			feedEndpoints.POST("/", handleAddFeedRequest)
+			feedEndpoints.DELETE("/:id", handleRevokeFeedRequest)
+		}
 
 		roomEndpoints := authenticated.Group("/rooms")
+		roomEndpoints.Use(requireRole(booking.Role.CanManageRooms, "rooms"))
 		{
 			roomEndpoints.DELETE("/:id", handleDeleteRoomRequest)
 			roomEndpoints.POST("/", handleAddRoomRequest)
+			roomEndpoints.PUT("/:id", handleUpdateRoomRequest)
 		}
 		bookingEndpoints := authenticated.Group("/bookings")
 		{
-			bookingEndpoints.POST("/", makeBookingRequest(handleAddBookingRequest))
+			bookingEndpoints.GET("/", makeBookingRequest(handleListBookingsRequest))
+			bookingEndpoints.POST("/", requireRole(booking.Role.CanBook, "bookings"), makeBookingRequest(handleAddBookingRequest))
 			bookingEndpoints.GET("/:id", makeBookingRequest(handleEditBookingRequest))
-			bookingEndpoints.DELETE("/:id", makeBookingRequest(handleDeleteBookingRequest))
-			bookingEndpoints.PATCH("/:id", makeBookingModalRequest(handleUpdateBookingRequest))
+			bookingEndpoints.DELETE("/:id", requireRole(booking.Role.CanBook, "bookings"), makeBookingRequest(handleDeleteBookingRequest))
+			bookingEndpoints.PATCH("/:id", handleUpdateBookingRequest)
+			bookingEndpoints.DELETE("/:id/occurrences/:start", makeBookingModalRequest(handleCancelOccurrenceRequest))
 		}
 		authenticated.GET("/calendar", handleGetCalendarRequest)
+		authenticated.GET("/availability", handleGetAvailabilityRequest)
+	}
+	// JSON API authenticated via bearer token
+	if err := registerApiRoutes(r); err != nil {
+		log.Fatalln(err)
 	}
 
 	r.Run("0.0.0.0:8000")
@@ -130,22 +303,60 @@ func handleLoginRequest(c *gin.Context) {
 	password := c.Request.FormValue("password")
 	logger.Printf("Login request for user %s", username)
 
-	jwt, err := LoginRequest(username, password)
+	tokens, err := LoginRequest(username, password)
 	if err != nil {
 		logger.Print("Failed login request: ", err)
-		c.HTML(http.StatusUnauthorized, "login.html", LoginResponse{jwt, err.Error()})
+		c.HTML(http.StatusUnauthorized, "login.html", LoginResponse{"", err.Error()})
 		return
 	}
-	c.SetCookie("Jwt-Token", jwt, 86400, "", "localhost", true, true)
+	setSessionCookies(c, tokens)
 	c.Redirect(http.StatusFound, "/")
 }
 
+func handleLogoutRequest(c *gin.Context) {
+	if err := LogoutRequest(c.MustGet("token").(*JwtClaims).SessionId); err != nil {
+		logger.Print("Failed to revoke session: ", err)
+	}
+	clearSessionCookies(c)
+	c.Redirect(http.StatusFound, "/login")
+}
+
+func handleChangePasswordRequest(c *gin.Context) {
+	err := ChangePasswordRequest(currentUserId(c), currentSessionId(c), c.PostForm("currentPassword"), c.PostForm("newPassword"))
+	if err != nil {
+		c.HTML(http.StatusUnprocessableEntity, "password-result", PasswordChangeData{Error: err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "password-result", PasswordChangeData{Message: "Password changed"})
+}
+
+func handleChangeTimeZoneRequest(c *gin.Context) {
+	if err := userRepo.UpdateTimeZone(currentUserId(c), c.PostForm("timeZone")); err != nil {
+		c.HTML(http.StatusUnprocessableEntity, "password-result", PasswordChangeData{Error: err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "password-result", PasswordChangeData{Message: "Time zone changed"})
+}
+
+// Returns the time zone of the current user. Defaults to UTC
+func displayLocation(c *gin.Context) *time.Location {
+	if user, ok := c.Get("user"); ok {
+		return user.(*booking.User).Location()
+	}
+	return time.UTC
+}
+
 // Middleware for booking request errors
 func makeBookingRequest(h func(c *gin.Context) error) func(c *gin.Context) {
 	return func(c *gin.Context) {
 		err := h(c)
 		if err != nil {
-			c.HTML(http.StatusUnprocessableEntity, "bookings", BookingPageData{Error: err.Error()})
+			data := BookingPageData{Error: err.Error()}
+			var conflict *booking.ErrBookingConflict
+			if errors.As(err, &conflict) {
+				data.Conflicts = bookingsIn(pointerSliceToValueSlice(conflict.Conflicts), displayLocation(c))
+			}
+			c.HTML(errorStatus(err), "bookings", data)
 			return
 		}
 	}
@@ -156,13 +367,51 @@ func makeBookingModalRequest(h func(c *gin.Context) error) func(c *gin.Context)
 	return func(c *gin.Context) {
 		err := h(c)
 		if err != nil {
-			c.HTML(http.StatusUnprocessableEntity, "booking-modal", BookingDetailData{Error: err.Error()})
+			c.HTML(errorStatus(err), "booking-modal", BookingDetailData{Error: err.Error()})
 			return
 		}
 	}
 }
 
+// Renders the page of bookings selected by the query parameters
+func handleListBookingsRequest(c *gin.Context) error {
+	data, err := getBookingPageData(c)
+	if err != nil {
+		return err
+	}
+	c.HTML(http.StatusOK, "bookings", data)
+	return nil
+}
+
 func handleAddBookingRequest(c *gin.Context) error {
+	var b booking.Booking
+	if err := bookingFromForm(c, &b); err != nil {
+		return err
+	}
+	recurrence, err := recurrenceFromForm(c)
+	if err != nil {
+		return err
+	}
+	b.Recurrence = recurrence
+	if err := authorizeBookingChange(c, &b); err != nil {
+		return err
+	}
+
+	_, err = bookingRepo.Create(b)
+	if err != nil {
+		return err
+	}
+	data, err := getBookingPageData(c)
+	if err != nil {
+		return err
+	}
+	c.HTML(http.StatusOK, "bookings", data)
+	return nil
+}
+
+// Applies the inputs of the booking forms to the given booking. Times are entered in the
+// time zone of the current user
+func bookingFromForm(c *gin.Context, b *booking.Booking) error {
 	// Fetch inputs
 	roomId, _ := c.GetPostForm("roomId")
 	userId, _ := c.GetPostForm("userId")
@@ -182,37 +431,85 @@ func handleAddBookingRequest(c *gin.Context) error {
 	}
 
 	// Convert date inputs into unix TT
-	startAt, err := booking.TimeFromDateAndTime(startDate, startTime)
+	startAt, err := booking.TimeFromDateAndTime(startDate, startTime, displayLocation(c))
 	if err != nil {
 		return err
 	}
-	endAt, err := booking.TimeFromDateAndTime(endDate, endTime)
+	endAt, err := booking.TimeFromDateAndTime(endDate, endTime, displayLocation(c))
 	if err != nil {
 		return err
 	}
 
-	_, err = bookingRepo.Create(booking.Booking{Room: booking.Room{Id: roomNumericId}, User: booking.User{Id: userNumericId}, StartTime: startAt, EndTime: endAt})
-	if err != nil {
-		return err
-	}
-	data, err := getBookingPageData()
-	if err != nil {
-		return err
+	b.Room = booking.Room{Id: roomNumericId}
+	b.User = booking.User{Id: userNumericId}
+	b.StartTime = startAt
+	b.EndTime = endAt
+	b.Title = c.PostForm("title")
+	b.Attendees = 0
+	if attendees := c.PostForm("attendees"); len(attendees) > 0 {
+		if b.Attendees, err = strconv.Atoi(attendees); err != nil {
+			return fmt.Errorf("Invalid number of attendees '%s'", attendees)
+		}
 	}
-	c.HTML(http.StatusOK, "bookings", data)
 	return nil
 }
 
+// Parses the optional recurrence inputs of the booking form. Returns nil for single bookings
+func recurrenceFromForm(c *gin.Context) (*booking.Recurrence, error) {
+	frequency := c.PostForm("frequency")
+	if len(frequency) == 0 {
+		return nil, nil
+	}
+	recurrence := booking.Recurrence{Frequency: booking.Frequency(frequency), Interval: 1}
+	if interval := c.PostForm("interval"); len(interval) > 0 {
+		numericInterval, err := strconv.Atoi(interval)
+		if err != nil {
+			return nil, err
+		}
+		recurrence.Interval = numericInterval
+	}
+	for _, day := range c.PostFormArray("byDay") {
+		weekday, err := strconv.Atoi(day)
+		if err != nil || weekday < 0 || weekday > 6 {
+			return nil, fmt.Errorf("Invalid weekday '%s'", day)
+		}
+		recurrence.ByDay = append(recurrence.ByDay, time.Weekday(weekday))
+	}
+	if count := c.PostForm("count"); len(count) > 0 {
+		numericCount, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, err
+		}
+		recurrence.Count = numericCount
+	}
+	if untilDate := c.PostForm("untilDate"); len(untilDate) > 0 {
+		// Series ends after the last occurrence on the given day
+		until, err := booking.TimeFromDateAndTime(untilDate, "23:59", displayLocation(c))
+		if err != nil {
+			return nil, err
+		}
+		recurrence.Until = until
+	}
+	return &recurrence, recurrence.Validate()
+}
+
 func handleDeleteBookingRequest(c *gin.Context) error {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		return err
 	}
+	record, err := bookingRepo.GetById(id)
+	if err != nil {
+		return err
+	}
+	if err := authorizeBookingChange(c, record); err != nil {
+		return err
+	}
 	err = bookingRepo.Delete(id)
 	if err != nil {
 		return err
 	}
-	data, err := getBookingPageData()
+	data, err := getBookingPageData(c)
 	if err != nil {
 		return err
 	}
@@ -220,8 +517,12 @@ func handleDeleteBookingRequest(c *gin.Context) error {
 	return nil
 }
 
-func getBookingPageData() (BookingPageData, error) {
-	bookings, err := bookingRepo.GetAll()
+// Returns the data of the index page with times in the time zone of the current user. The bookings
+// are a page selected by the query parameters of the request
+func getBookingPageData(c *gin.Context) (BookingPageData, error) {
+	loc := displayLocation(c)
+	query, form := bookingQueryFromRequest(c, loc)
+	page, err := bookingRepo.Query(query)
 	if err != nil {
 		return BookingPageData{Error: err.Error()}, err
 	}
@@ -233,10 +534,55 @@ func getBookingPageData() (BookingPageData, error) {
 	if err != nil {
 		return BookingPageData{Error: err.Error()}, err
 	}
-	return BookingPageData{pointerSliceToValueSlice(bookings), pointerSliceToValueSlice(rooms), pointerSliceToValueSlice(users), ""}, nil
+	form.Rooms, form.Users = roomOptions(rooms, query.RoomIds), userOptions(users, query.UserIds)
+	if len(page.NextCursor) > 0 {
+		next := c.Request.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		form.NextUrl = "/bookings/?" + next.Encode()
+	}
+	return BookingPageData{
+		Bookings: bookingsIn(pointerSliceToValueSlice(page.Bookings), loc),
+		Query:    form,
+		Rooms:    pointerSliceToValueSlice(rooms),
+		Users:    pointerSliceToValueSlice(users),
+		TimeZone: loc.String(),
+		RoomPage: roomPageData(rooms),
+		NewRoom:  newRoomForm(booking.Room{TimeZone: booking.DefaultTimeZone}),
+	}, nil
+}
+
+// Parses the ?q, ?from, ?to, ?status, ?sort & ?cursor parameters & the repeatable ?room & ?user ids
+// of the bookings list. Dates are days in the given time zone, both included. Invalid values are ignored
+func bookingQueryFromRequest(c *gin.Context, loc *time.Location) (booking.BookingQuery, BookingQueryForm) {
+	query := booking.BookingQuery{
+		BookingFilter: booking.BookingFilter{RoomIds: parseIds(c.QueryArray("room")), UserIds: parseIds(c.QueryArray("user"))},
+		Search:        c.Query("q"),
+		Cursor:        c.Query("cursor"),
+	}
+	form := BookingQueryForm{Search: query.Search}
+	if from, err := time.ParseInLocation(time.DateOnly, c.Query("from"), loc); err == nil {
+		query.From, form.From = from, c.Query("from")
+	}
+	if to, err := time.ParseInLocation(time.DateOnly, c.Query("to"), loc); err == nil {
+		query.To, form.To = to.AddDate(0, 0, 1), c.Query("to")
+	}
+	query.Status, _ = booking.ParseBookingStatus(c.Query("status"))
+	if sort, err := booking.ParseBookingSort(c.Query("sort")); err == nil {
+		query.Sort = sort
+	}
+	form.Status, form.Sort = query.Status, query.Sort
+	return query, form
 }
 
-func pointerSliceToValueSlice[t comparable](vals []*t) []t {
+func bookingsIn(bookings []booking.Booking, loc *time.Location) []booking.Booking {
+	res := make([]booking.Booking, len(bookings))
+	for idx, b := range bookings {
+		res[idx] = b.In(loc)
+	}
+	return res
+}
+
+func pointerSliceToValueSlice[t any](vals []*t) []t {
 	res := make([]t, len(vals))
 	for idx, val := range vals {
 		res[idx] = *val
@@ -253,87 +599,585 @@ func handleEditBookingRequest(c *gin.Context) error {
 	if err != nil {
 		return err
 	}
-	c.HTML(http.StatusOK, "booking-modal", BookingDetailData{Booking: *record})
+	// Occurrences of a series are identified by their original start
+	if occurrenceParam := c.Query("occurrence"); len(occurrenceParam) > 0 {
+		occurrenceStart, err := strconv.ParseInt(occurrenceParam, 10, 64)
+		if err != nil {
+			return err
+		}
+		record, err = record.Occurrence(time.Unix(occurrenceStart, 0).UTC())
+		if err != nil {
+			return err
+		}
+	}
+	data, err := getBookingDetailData(*record, displayLocation(c))
+	if err != nil {
+		return err
+	}
+	c.HTML(http.StatusOK, "booking-modal", data)
 	return nil
 }
 
-func handleUpdateBookingRequest(c *gin.Context) error {
+// Returns the data of the booking modal with times in the given time zone
+func getBookingDetailData(record booking.Booking, loc *time.Location) (BookingDetailData, error) {
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		return BookingDetailData{Error: err.Error()}, err
+	}
+	users, err := userRepo.GetAll()
+	if err != nil {
+		return BookingDetailData{Error: err.Error()}, err
+	}
+	return BookingDetailData{Booking: record.In(loc), Rooms: pointerSliceToValueSlice(rooms), Users: pointerSliceToValueSlice(users)}, nil
+}
+
+func handleCancelOccurrenceRequest(c *gin.Context) error {
 	idParam, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		return err
 	}
-	titleParam := c.Request.FormValue("title")
+	startParam, err := strconv.ParseInt(c.Param("start"), 10, 64)
+	if err != nil {
+		return err
+	}
 	record, err := bookingRepo.GetById(idParam)
 	if err != nil {
 		return err
 	}
-	record.Title = titleParam
-	// Todo Seems to be triggering update twice. Need to investigate
+	if err := authorizeBookingChange(c, record); err != nil {
+		return err
+	}
+	if err := bookingRepo.CancelOccurrence(idParam, time.Unix(startParam, 0).UTC()); err != nil {
+		return err
+	}
 	c.Header("HX-Trigger", "calendar-update")
-	c.HTML(http.StatusOK, "booking-modal-form", BookingDetailData{Booking: *record})
+	// Empty response removes the modal
+	c.Status(http.StatusOK)
 	return nil
 }
 
-func handleDeleteRoomRequest(c *gin.Context) {
+func handleUpdateBookingRequest(c *gin.Context) {
 	idParam, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: err.Error()})
+		c.HTML(http.StatusUnprocessableEntity, "booking-modal-form", BookingDetailData{Error: err.Error()})
 		return
 	}
-	err = roomRepo.Delete(idParam)
+	record, err := bookingRepo.GetById(idParam)
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: err.Error()})
+		c.HTML(http.StatusUnprocessableEntity, "booking-modal-form", BookingDetailData{Error: err.Error()})
 		return
 	}
+	// Re-render the submitted form on errors, so no input is lost
+	renderError := func(err error) {
+		data, _ := getBookingDetailData(*record, displayLocation(c))
+		data.Error = err.Error()
+		c.HTML(errorStatus(err), "booking-modal-form", data)
+	}
+	// Members may neither change bookings of others, nor hand their bookings over
+	if err := authorizeBookingChange(c, record); err != nil {
+		renderError(err)
+		return
+	}
+	if err := bookingFromForm(c, record); err != nil {
+		renderError(err)
+		return
+	}
+	if err := authorizeBookingChange(c, record); err != nil {
+		renderError(err)
+		return
+	}
+	var updated *booking.Booking
+	// Changing a single occurrence of a series detaches it into an exception
+	if occurrenceParam := c.PostForm("occurrence"); len(occurrenceParam) > 0 {
+		occurrenceStart, err := strconv.ParseInt(occurrenceParam, 10, 64)
+		if err != nil {
+			renderError(err)
+			return
+		}
+		record.SeriesId = record.Id
+		record.OriginalStart = time.Unix(occurrenceStart, 0).UTC()
+		updated, err = bookingRepo.UpdateOccurrence(idParam, record.OriginalStart, *record)
+	} else {
+		updated, err = bookingRepo.Update(*record)
+	}
+	if err != nil {
+		renderError(err)
+		return
+	}
+	data, err := getBookingDetailData(*updated, displayLocation(c))
+	if err != nil {
+		renderError(err)
+		return
+	}
+	c.Header("HX-Trigger", "calendar-update")
+	c.HTML(http.StatusOK, "booking-modal-form", data)
+}
 
-	rooms, err := roomRepo.GetAll()
+func handleDeleteRoomRequest(c *gin.Context) {
+	idParam, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: err.Error()})
+		renderRooms(c, err)
+		return
 	}
-	data := RoomPageData{pointerSliceToValueSlice(rooms)}
-	c.HTML(http.StatusOK, "rooms", data)
+	renderRooms(c, roomRepo.Delete(idParam))
 }
 
 func handleAddRoomRequest(c *gin.Context) {
-	title, exists := c.GetPostForm("title")
-	if !exists || len(title) == 0 {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: "Title cannot be empty"})
+	var room booking.Room
+	if err := roomFromForm(c, &room); err != nil {
+		renderRooms(c, err)
 		return
 	}
-	_, err := roomRepo.Create(booking.Room{Title: title})
+	_, err := roomRepo.Create(room)
+	renderRooms(c, err)
+}
+
+func handleUpdateRoomRequest(c *gin.Context) {
+	idParam, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: err.Error()})
+		renderRooms(c, err)
 		return
 	}
-	rooms, err := roomRepo.GetAll()
+	room := booking.Room{Id: idParam}
+	if err := roomFromForm(c, &room); err != nil {
+		renderRooms(c, err)
+		return
+	}
+	_, err = roomRepo.Update(room)
+	renderRooms(c, err)
+}
+
+// Renders the list of rooms with the error of the preceding change, if any
+func renderRooms(c *gin.Context, err error) {
+	rooms, listErr := roomRepo.GetAll()
+	data := roomPageData(rooms)
+	if err == nil {
+		err = listErr
+	}
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "rooms", BookingPageData{Error: err.Error()})
+		data.Error = err.Error()
+		c.HTML(http.StatusUnprocessableEntity, "rooms", data)
+		return
 	}
-	data := RoomPageData{pointerSliceToValueSlice(rooms)}
 	c.HTML(http.StatusOK, "rooms", data)
 }
 
-func handleGetCalendarRequest(c *gin.Context) {
-	week, err := strconv.Atoi(c.Query("week"))
-	// Fallback to current week if invalid or none provided
-	if err != nil || week < 1 || week > 53 {
-		_, week = time.Now().ISOWeek()
+func roomPageData(rooms []*booking.Room) RoomPageData {
+	data := RoomPageData{Rooms: make([]RoomForm, len(rooms))}
+	for idx, room := range rooms {
+		data.Rooms[idx] = newRoomForm(*room)
+	}
+	return data
+}
+
+func newRoomForm(room booking.Room) RoomForm {
+	other := []string{}
+	for _, tag := range room.Equipment {
+		if !slices.Contains(booking.CommonEquipment, tag) {
+			other = append(other, tag)
+		}
+	}
+	return RoomForm{
+		Room:           room,
+		Equipment:      formOptions(booking.CommonEquipment, room.Equipment),
+		OtherEquipment: strings.Join(other, ", "),
+		Accessibility:  formOptions(booking.AccessibilityFeatures, room.Accessibility),
+	}
+}
+
+// Returns an option for every value, checked if it is one of the checked values
+func formOptions(values []string, checked []string) []FormOption {
+	options := make([]FormOption, len(values))
+	for idx, value := range values {
+		options[idx] = FormOption{value, slices.Contains(checked, value)}
+	}
+	return options
+}
+
+// Applies the inputs of the room forms to the given room
+func roomFromForm(c *gin.Context, room *booking.Room) error {
+	room.Title = c.PostForm("title")
+	room.TimeZone = c.PostForm("timeZone")
+	room.Building = c.PostForm("building")
+	room.Floor = c.PostForm("floor")
+	room.Description = c.PostForm("description")
+	room.ImageUrl = c.PostForm("imageUrl")
+	room.Capacity = 0
+	if capacity := c.PostForm("capacity"); len(capacity) > 0 {
+		var err error
+		if room.Capacity, err = strconv.Atoi(capacity); err != nil {
+			return fmt.Errorf("Invalid capacity '%s'", capacity)
+		}
+	}
+	room.Equipment = append(c.PostFormArray("equipment"), booking.ParseTags(c.PostForm("otherEquipment"))...)
+	room.Accessibility = c.PostFormArray("accessibility")
+	return nil
+}
+
+// Parses the room attributes of the calendar & availability filters. Invalid capacities are ignored
+func roomFilterFromQuery(c *gin.Context) booking.RoomFilter {
+	filter := booking.RoomFilter{
+		Building:      c.Query("building"),
+		Equipment:     booking.NormalizeTags(c.QueryArray("equipment")),
+		Accessibility: booking.NormalizeTags(c.QueryArray("accessibility")),
+	}
+	if capacity, err := strconv.Atoi(c.Query("capacity")); err == nil && capacity > 0 {
+		filter.MinCapacity = capacity
+	}
+	return filter
+}
+
+// Offers the buildings & equipment of all rooms as choices
+func newRoomFilterForm(rooms []*booking.Room, filter booking.RoomFilter) RoomFilterForm {
+	buildings := []string{}
+	equipment := slices.Clone(booking.CommonEquipment)
+	for _, room := range rooms {
+		if len(room.Building) > 0 && !slices.Contains(buildings, room.Building) {
+			buildings = append(buildings, room.Building)
+		}
+		for _, tag := range room.Equipment {
+			if !slices.Contains(equipment, tag) {
+				equipment = append(equipment, tag)
+			}
+		}
+	}
+	slices.Sort(buildings)
+	slices.Sort(equipment)
+	return RoomFilterForm{
+		Filter:        filter,
+		Buildings:     formOptions(buildings, []string{filter.Building}),
+		Equipment:     formOptions(equipment, filter.Equipment),
+		Accessibility: formOptions(booking.AccessibilityFeatures, filter.Accessibility),
+	}
+}
+
+// Adds the room attributes of the filter to the query parameters
+func addRoomFilterParams(query url.Values, filter booking.RoomFilter) {
+	if filter.MinCapacity > 0 {
+		query.Set("capacity", strconv.Itoa(filter.MinCapacity))
 	}
-	year, err := strconv.Atoi(c.Query("year"))
-	// Fallback to current year if invalid or none provided
-	if err != nil || year < 1 || year > 1000 {
-		year, _ = time.Now().ISOWeek()
+	if len(filter.Building) > 0 {
+		query.Set("building", filter.Building)
 	}
-	// Disable next week button if last week reached
-	nextWeek := week + 1
-	if nextWeek > 53 {
-		nextWeek = 0
+	for _, tag := range filter.Equipment {
+		query.Add("equipment", tag)
+	}
+	for _, tag := range filter.Accessibility {
+		query.Add("accessibility", tag)
+	}
+}
+
+// Number of days listed by the agenda view
+const agendaDays = 30
+
+// Years the calendar navigates within, as supported by dates formatted as 2006-01-02
+const (
+	minCalendarYear = 1
+	maxCalendarYear = 9999
+)
+
+// Renders the calendar. The view is selected by ?view=month|week|day|resource|agenda and defaults
+// to week. Bookings are filtered by the optional, repeatable ?room= & ?user= ids & by the
+// attributes of their rooms
+func handleGetCalendarRequest(c *gin.Context) {
+	filter := booking.BookingFilter{RoomIds: parseIds(c.QueryArray("room")), UserIds: parseIds(c.QueryArray("user")), Rooms: roomFilterFromQuery(c)}
+	var service calendar.CalendarService = calendar.NewService(bookingRepo, roomRepo, calendarConfig).WithLocation(displayLocation(c))
+	var data CalendarData
+	var err error
+	switch c.Query("view") {
+	case "month":
+		data, err = getMonthCalendarData(c, service, filter)
+	case "day":
+		data, err = getDayCalendarData(c, service, filter)
+	case "resource":
+		data, err = getResourceCalendarData(c, service, filter)
+	case "agenda":
+		data, err = getAgendaCalendarData(c, service, filter)
+	default:
+		data, err = getWeekCalendarData(c, service, filter)
+	}
+	if err == nil {
+		data.RoomOptions, data.UserOptions, data.RoomFilter, err = getCalendarFilterOptions(filter)
 	}
-	var service calendar.CalendarService = calendar.NewService(bookingRepo)
-	dayData, err := service.GetCalendarDayData(year, week)
 	if err != nil {
-		c.HTML(http.StatusUnprocessableEntity, "calendar.html", CalendarData{})
+		c.HTML(http.StatusUnprocessableEntity, "calendar.html", CalendarData{View: data.View, Error: err.Error()})
+		return
+	}
+	data.Filter = filter
+	data.PageParams = map[string]string{}
+	if params, err := url.ParseQuery(data.Url); err == nil {
+		for key := range params {
+			data.PageParams[key] = params.Get(key)
+		}
+	}
+	data.Url = calendarUrl(data.View, filter, data.Url)
+	data.DayUrl = calendarUrl("day", filter, "")
+	for _, view := range []string{"month", "week", "day", "resource", "agenda"} {
+		data.Views = append(data.Views, CalendarViewLink{view, calendarUrl(view, filter, "")})
+	}
+	if len(data.PrevUrl) > 0 {
+		data.PrevUrl = calendarUrl(data.View, filter, data.PrevUrl)
+	}
+	if len(data.NextUrl) > 0 {
+		data.NextUrl = calendarUrl(data.View, filter, data.NextUrl)
 	}
-	data := CalendarData{service.GenerateTimeMarkers(), dayData, week, nextWeek, week - 1}
 	c.HTML(http.StatusOK, "calendar.html", data)
 }
+
+// Parses ids of query parameters. Invalid ids are ignored
+func parseIds(values []string) []int64 {
+	ids := []int64{}
+	for _, value := range values {
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func getCalendarFilterOptions(filter booking.BookingFilter) ([]CalendarFilterOption, []CalendarFilterOption, RoomFilterForm, error) {
+	rooms, err := roomRepo.GetAll()
+	if err != nil {
+		return nil, nil, RoomFilterForm{}, err
+	}
+	users, err := userRepo.GetAll()
+	if err != nil {
+		return nil, nil, RoomFilterForm{}, err
+	}
+	return roomOptions(rooms, filter.RoomIds), userOptions(users, filter.UserIds), newRoomFilterForm(rooms, filter.Rooms), nil
+}
+
+func roomOptions(rooms []*booking.Room, selected []int64) []CalendarFilterOption {
+	options := make([]CalendarFilterOption, len(rooms))
+	for idx, room := range rooms {
+		options[idx] = CalendarFilterOption{room.Id, room.Title, slices.Contains(selected, room.Id)}
+	}
+	return options
+}
+
+func userOptions(users []*booking.User, selected []int64) []CalendarFilterOption {
+	options := make([]CalendarFilterOption, len(users))
+	for idx, user := range users {
+		options[idx] = CalendarFilterOption{user.Id, user.Name, slices.Contains(selected, user.Id)}
+	}
+	return options
+}
+
+// Returns the URL of the view, with the given query parameters & the filter
+func calendarUrl(view string, filter booking.BookingFilter, params string) string {
+	query, _ := url.ParseQuery(params)
+	query.Set("view", view)
+	for _, roomId := range filter.RoomIds {
+		query.Add("room", strconv.FormatInt(roomId, 10))
+	}
+	for _, userId := range filter.UserIds {
+		query.Add("user", strconv.FormatInt(userId, 10))
+	}
+	addRoomFilterParams(query, filter.Rooms)
+	return "/calendar?" + query.Encode()
+}
+
+// Parses the "date" query parameter formatted as 2006-01-02 in the time zone of the current user.
+// Defaults to today
+func calendarDateParam(c *gin.Context) time.Time {
+	loc := displayLocation(c)
+	if date, err := time.ParseInLocation(time.DateOnly, c.Query("date"), loc); err == nil {
+		return date
+	}
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+}
+
+// Renders the ISO week given by ?year= & ?week=, or the week containing ?date=. Defaults to the
+// current week
+func getWeekCalendarData(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error) {
+	year, week := time.Now().In(displayLocation(c)).ISOWeek()
+	if date, err := time.Parse(time.DateOnly, c.Query("date")); err == nil {
+		year, week = date.ISOWeek()
+	} else {
+		// Fallback to current year & week if invalid or none provided
+		if numericYear, err := strconv.Atoi(c.Query("year")); err == nil && numericYear >= minCalendarYear && numericYear <= maxCalendarYear {
+			year = numericYear
+		}
+		if numericWeek, err := strconv.Atoi(c.Query("week")); err == nil && numericWeek >= 1 && numericWeek <= calendar.WeeksInYear(year) {
+			week = numericWeek
+		} else if week > calendar.WeeksInYear(year) {
+			week = calendar.WeeksInYear(year)
+		}
+	}
+	monday := calendar.WeekStart(year, week)
+	data := CalendarData{View: "week", Year: year, Cw: week, Date: monday.Format(time.DateOnly), Title: fmt.Sprintf("CW %d, %d", week, year)}
+	data.Url = fmt.Sprintf("year=%d&week=%d", year, week)
+	// Weeks continue across years, e.g. from week 53 of 2020 to week 1 of 2021
+	if prevYear, prevWeek := monday.AddDate(0, 0, -7).ISOWeek(); prevYear >= minCalendarYear {
+		data.PrevUrl = fmt.Sprintf("year=%d&week=%d", prevYear, prevWeek)
+	}
+	if nextYear, nextWeek := monday.AddDate(0, 0, 7).ISOWeek(); nextYear <= maxCalendarYear {
+		data.NextUrl = fmt.Sprintf("year=%d&week=%d", nextYear, nextWeek)
+	}
+	var err error
+	data.DayData, err = service.GetCalendarDayData(year, week, filter)
+	data.TimeMarkers = service.GenerateTimeMarkers(filter)
+	data.SlotMinutes = service.WorkingHours(filter).SlotMinutes
+	return data, err
+}
+
+func getDayCalendarData(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error) {
+	date := calendarDateParam(c)
+	data := CalendarData{View: "day", Date: date.Format(time.DateOnly), Title: date.Format("Mon, 2 Jan 2006")}
+	data.Url = "date=" + date.Format(time.DateOnly)
+	data.PrevUrl = "date=" + date.AddDate(0, 0, -1).Format(time.DateOnly)
+	data.NextUrl = "date=" + date.AddDate(0, 0, 1).Format(time.DateOnly)
+	day, err := service.GetDayData(date, filter)
+	data.DayData = []calendar.CalendarDayData{day}
+	data.TimeMarkers = service.GenerateTimeMarkers(filter)
+	data.SlotMinutes = service.WorkingHours(filter).SlotMinutes
+	return data, err
+}
+
+// Day view with one column per room of the filter
+func getResourceCalendarData(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error) {
+	date := calendarDateParam(c)
+	data := CalendarData{View: "resource", Date: date.Format(time.DateOnly), Title: date.Format("Mon, 2 Jan 2006")}
+	data.Url = "date=" + date.Format(time.DateOnly)
+	data.PrevUrl = "date=" + date.AddDate(0, 0, -1).Format(time.DateOnly)
+	data.NextUrl = "date=" + date.AddDate(0, 0, 1).Format(time.DateOnly)
+	var err error
+	if data.Resources, err = service.GetResourceData(date, filter); err != nil {
+		return data, err
+	}
+	// Share the working hours of all columns
+	columns := filter
+	columns.RoomIds = make([]int64, len(data.Resources))
+	for idx, resource := range data.Resources {
+		columns.RoomIds[idx] = resource.Room.Id
+	}
+	data.TimeMarkers = service.GenerateTimeMarkers(columns)
+	data.SlotMinutes = service.WorkingHours(columns).SlotMinutes
+	return data, nil
+}
+
+// Renders the month given by ?year= & ?month=, or the month containing ?date=. Defaults to the
+// current month
+func getMonthCalendarData(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error) {
+	now := time.Now().In(displayLocation(c))
+	year, month := now.Year(), int(now.Month())
+	if date, err := time.Parse(time.DateOnly, c.Query("date")); err == nil {
+		year, month = date.Year(), int(date.Month())
+	} else {
+		if numericYear, err := strconv.Atoi(c.Query("year")); err == nil && numericYear >= minCalendarYear && numericYear <= maxCalendarYear {
+			year = numericYear
+		}
+		if numericMonth, err := strconv.Atoi(c.Query("month")); err == nil && numericMonth >= 1 && numericMonth <= 12 {
+			month = numericMonth
+		}
+	}
+	// Normalized by time.Date across year boundaries
+	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	prev := firstOfMonth.AddDate(0, -1, 0)
+	next := firstOfMonth.AddDate(0, 1, 0)
+	data := CalendarData{View: "month", Year: year, Date: firstOfMonth.Format(time.DateOnly), Title: firstOfMonth.Format("January 2006")}
+	data.Url = fmt.Sprintf("year=%d&month=%d", year, month)
+	if prev.Year() >= minCalendarYear {
+		data.PrevUrl = fmt.Sprintf("year=%d&month=%d", prev.Year(), prev.Month())
+	}
+	if next.Year() <= maxCalendarYear {
+		data.NextUrl = fmt.Sprintf("year=%d&month=%d", next.Year(), next.Month())
+	}
+	monthData, err := service.GetMonthData(year, time.Month(month), filter)
+	data.Month = &monthData
+	return data, err
+}
+
+func getAgendaCalendarData(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error) {
+	from := calendarDateParam(c)
+	to := from.AddDate(0, 0, agendaDays-1)
+	data := CalendarData{View: "agenda", Date: from.Format(time.DateOnly), Title: from.Format("2 Jan 2006") + " - " + to.Format("2 Jan 2006")}
+	data.Url = "date=" + from.Format(time.DateOnly)
+	data.PrevUrl = "date=" + from.AddDate(0, 0, -agendaDays).Format(time.DateOnly)
+	data.NextUrl = "date=" + from.AddDate(0, 0, agendaDays).Format(time.DateOnly)
+	// Start with the current time, so today's past bookings are not listed as upcoming
+	start := from
+	if now := time.Now(); now.After(from) && now.Before(from.AddDate(0, 0, 1)) {
+		start = now
+	}
+	var err error
+	data.Agenda, err = service.GetAgendaData(start, agendaDays, filter)
+	return data, err
+}
+
+// Number of free slots listed by the availability page
+const availabilityCandidates = 20
+
+// Renders the search for free rooms within a day. The search runs once ?duration= is given,
+// listing free slots & the busy times of the rooms within ?from= & ?to= of ?date=
+func handleGetAvailabilityRequest(c *gin.Context) {
+	loc := displayLocation(c)
+	hours := calendarConfig.Default
+	data := AvailabilityData{
+		Date:     calendarDateParam(c).Format(time.DateOnly),
+		From:     c.DefaultQuery("from", formatTimeOfDay(hours.Start)),
+		To:       c.DefaultQuery("to", formatTimeOfDay(hours.End)),
+		Duration: 60,
+		TimeZone: loc.String(),
+	}
+	rooms := calendar.RoomSelection{RoomIds: parseIds(c.QueryArray("room")), Rooms: roomFilterFromQuery(c)}
+	var err error
+	if data.RoomOptions, _, data.RoomFilter, err = getCalendarFilterOptions(booking.BookingFilter{RoomIds: rooms.RoomIds, Rooms: rooms.Rooms}); err != nil {
+		data.Error = err.Error()
+		c.HTML(http.StatusUnprocessableEntity, "availability.html", data)
+		return
+	}
+	if len(c.Query("duration")) == 0 {
+		c.HTML(http.StatusOK, "availability.html", data)
+		return
+	}
+	data.Searched = true
+	if data.Duration, err = strconv.Atoi(c.Query("duration")); err != nil {
+		data.Error = "Invalid duration"
+		c.HTML(http.StatusUnprocessableEntity, "availability.html", data)
+		return
+	}
+	if err := findAvailability(&data, rooms, loc); err != nil {
+		data.Error = err.Error()
+		c.HTML(http.StatusUnprocessableEntity, "availability.html", data)
+		return
+	}
+	c.HTML(http.StatusOK, "availability.html", data)
+}
+
+// Fills the free slots & busy times of the rooms into the submitted form
+func findAvailability(data *AvailabilityData, rooms calendar.RoomSelection, loc *time.Location) error {
+	day, err := time.ParseInLocation(time.DateOnly, data.Date, loc)
+	if err != nil {
+		return err
+	}
+	from, errFrom := time.Parse("15:04", data.From)
+	to, errTo := time.Parse("15:04", data.To)
+	if errFrom != nil || errTo != nil {
+		return fmt.Errorf("Expected the window as times of day, e.g. 08:00")
+	}
+	query := calendar.AvailabilityQuery{
+		Duration:      time.Duration(data.Duration) * time.Minute,
+		From:          time.Date(day.Year(), day.Month(), day.Day(), from.Hour(), from.Minute(), 0, 0, loc),
+		To:            time.Date(day.Year(), day.Month(), day.Day(), to.Hour(), to.Minute(), 0, 0, loc),
+		RoomSelection: rooms,
+		Limit:         availabilityCandidates,
+	}
+	var service calendar.AvailabilityService = calendar.NewAvailabilityService(bookingRepo, roomRepo, calendarConfig)
+	if data.Candidates, err = service.FindRooms(query); err != nil {
+		return err
+	}
+	if data.Busy, err = service.FreeBusy(query.From, query.To, rooms); err != nil {
+		return err
+	}
+	for idx, candidate := range data.Candidates {
+		data.Candidates[idx].Start, data.Candidates[idx].End = candidate.Start.In(loc), candidate.End.In(loc)
+	}
+	for _, room := range data.Busy {
+		for idx, interval := range room.Busy {
+			room.Busy[idx] = calendar.Interval{Start: interval.Start.In(loc), End: interval.End.In(loc)}
+		}
+	}
+	return nil
+}
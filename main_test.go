@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lucb31/booking-go/booking"
+	"lucb31/booking-go/calendar"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCalendarNavigation_CrossesYearBoundaries(t *testing.T) {
+	useTestDatabase(t)
+	gin.SetMode(gin.TestMode)
+	service := calendar.NewService(bookingRepo, roomRepo, calendarConfig)
+	tests := []struct {
+		query   string
+		getData func(c *gin.Context, service calendar.CalendarService, filter booking.BookingFilter) (CalendarData, error)
+		url     string
+		prevUrl string
+		nextUrl string
+	}{
+		{"year=2020&week=53", getWeekCalendarData, "year=2020&week=53", "year=2020&week=52", "year=2021&week=1"},
+		{"year=2021&week=1", getWeekCalendarData, "year=2021&week=1", "year=2020&week=53", "year=2021&week=2"},
+		{"year=2025&week=1", getWeekCalendarData, "year=2025&week=1", "year=2024&week=52", "year=2025&week=2"},
+		{"year=2026&week=53", getWeekCalendarData, "year=2026&week=53", "year=2026&week=52", "year=2027&week=1"},
+		{"year=2020&week=53&date=2024-12-30", getWeekCalendarData, "year=2025&week=1", "year=2024&week=52", "year=2025&week=2"},
+		{"year=1&week=1", getWeekCalendarData, "year=1&week=1", "", "year=1&week=2"},
+		{"year=2025&month=1", getMonthCalendarData, "year=2025&month=1", "year=2024&month=12", "year=2025&month=2"},
+		{"date=2024-12-31", getMonthCalendarData, "year=2024&month=12", "year=2024&month=11", "year=2025&month=1"},
+		{"year=9999&month=12", getMonthCalendarData, "year=9999&month=12", "year=9999&month=11", ""},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/calendar?"+tt.query, nil)
+		data, err := tt.getData(c, service, booking.BookingFilter{})
+		if err != nil {
+			t.Fatalf("%s: %s", tt.query, err)
+		}
+		if data.Url != tt.url || data.PrevUrl != tt.prevUrl || data.NextUrl != tt.nextUrl {
+			t.Errorf("%s: Expected %s, prev %s, next %s, received %s, prev %s, next %s", tt.query, tt.url, tt.prevUrl, tt.nextUrl, data.Url, data.PrevUrl, data.NextUrl)
+		}
+	}
+}
+
+func TestGetBookingPageData_PagesThroughFilteredBookings(t *testing.T) {
+	useTestDatabase(t)
+	gin.SetMode(gin.TestMode)
+	user := createTestUser(t, "alice", "secret", booking.RoleMember)
+	first := newTestBooking(t, user)
+	newTestBooking(t, user)
+	third := newTestBooking(t, user)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/?room=1&room=3&from=2024-07-01&to=2024-07-01&sort=-startTime", nil)
+	data, err := getBookingPageData(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Bookings) != 2 || data.Bookings[0].Id != third.Id || data.Bookings[1].Id != first.Id || len(data.Query.NextUrl) > 0 {
+		t.Fatalf("Expected bookings of rooms 3 & 1 on a single page, received %v", data.Bookings)
+	}
+	if data.Query.From != "2024-07-01" || data.Query.Sort != booking.SortByStartTimeDesc || !data.Query.Rooms[0].Selected || data.Query.Rooms[1].Selected {
+		t.Fatalf("Expected the form to keep the filter, received %+v", data.Query)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/?from=2024-07-02", nil)
+	if data, err = getBookingPageData(c); err != nil || len(data.Bookings) != 0 {
+		t.Fatalf("Expected no bookings after the day, received %v, %v", data.Bookings, err)
+	}
+}
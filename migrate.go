@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"lucb31/booking-go/migrations"
+)
+
+const migrateUsage = `Usage: booking-go migrate [up | down <version> | status]
+  up              Apply all pending migrations (default)
+  down <version>  Revert the migrations newer than the version, down 0 reverts all
+  status          List the migrations & when they were applied`
+
+// booking-go migrate [up | down <version> | status]
+func runMigrateCommand(migrator *migrations.Migrator, args []string, out io.Writer) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch {
+	case action == "up" && len(args) <= 1:
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			fmt.Fprintf(out, "Applied %s\n", migration)
+		}
+		_, err = fmt.Fprintf(out, "Schema is at version %d\n", len(migrator.Migrations()))
+		return err
+	case action == "down" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("Invalid version '%s'", args[1])
+		}
+		reverted, err := migrator.Down(version)
+		if err != nil {
+			return err
+		}
+		for _, migration := range reverted {
+			fmt.Fprintf(out, "Reverted %s\n", migration)
+		}
+		return nil
+	case action == "status" && len(args) == 1:
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED")
+		for _, entry := range status {
+			applied := "pending"
+			if !entry.AppliedAt.IsZero() {
+				applied = entry.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", entry.Migration, applied)
+		}
+		return w.Flush()
+	}
+	fmt.Fprintln(out, migrateUsage)
+	return fmt.Errorf("Unknown migrate action '%s'", action)
+}
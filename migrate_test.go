@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRunMigrateCommand_RevertsAndAppliesMigrations(t *testing.T) {
+	useTestDatabase(t)
+	var out bytes.Buffer
+	if err := runMigrateCommand(migrator, []string{"down", "0"}, &out); err != nil {
+		t.Fatalf("Unable to revert: %s", err)
+	}
+	if !strings.Contains(out.String(), "Reverted 0001_initial") {
+		t.Fatalf("Expected reverted migration, received %s", out.String())
+	}
+	out.Reset()
+	if err := runMigrateCommand(migrator, []string{"status"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`0001_initial +pending`).MatchString(out.String()) {
+		t.Fatalf("Expected pending migration, received %s", out.String())
+	}
+	out.Reset()
+	if err := runMigrateCommand(migrator, []string{}, &out); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	if !strings.Contains(out.String(), "Applied 0001_initial") {
+		t.Fatalf("Expected applied migration, received %s", out.String())
+	}
+	if _, err := userRepo.GetAll(); err != nil {
+		t.Fatalf("Expected migrated schema, received '%s'", err)
+	}
+	if err := runMigrateCommand(migrator, []string{"sideways"}, &out); err == nil {
+		t.Fatal("Expected error for unknown action")
+	}
+}
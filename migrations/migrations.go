@@ -0,0 +1,373 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Scripts of every dialect, named <version>_<name>.up.sql & <version>_<name>.down.sql. Versions
+// start at 1 & have no gaps. Statements are never changed once released, changes go into a new
+// version. Comments may still be corrected
+//
+//go:embed sqlite/*.sql postgres/*.sql
+var scripts embed.FS
+
+var scriptName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Key of the Postgres advisory lock held while migrating
+const postgresLockKey = 4206901
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// SHA-256 of the statements of the up script. Applied migrations must keep their checksum
+	Checksum string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+type MigrationStatus struct {
+	Migration
+	// Zero, if the migration is pending
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Applies & reverts the migrations of the database dialect. Every run holds a lock, so concurrent
+// runners, e.g. several instances starting at once, apply each migration once
+type Migrator struct {
+	db         *sqlx.DB
+	dialect    string
+	migrations []Migration
+	// Steps run after the up script of the migration of the version
+	afterUp map[int]func(tx *sqlx.Tx) error
+}
+
+// Returns the migrator for the database, whose dialect is derived from its driver
+func New(db *sqlx.DB) (*Migrator, error) {
+	var dialect string
+	switch db.DriverName() {
+	case "sqlite3":
+		dialect = "sqlite"
+	case "postgres":
+		dialect = "postgres"
+	default:
+		return nil, fmt.Errorf("No migrations for database driver %s", db.DriverName())
+	}
+	migrations, err := load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db, dialect, migrations, map[int]func(tx *sqlx.Tx) error{}}, nil
+}
+
+// Runs fn in the transaction of the migration of the version, right after its up script. For
+// data changes that need application logic, e.g. values computed from the rows the script changed
+func (m *Migrator) AfterUp(version int, fn func(tx *sqlx.Tx) error) {
+	m.afterUp[version] = fn
+}
+
+// Reads the embedded scripts of the dialect, ordered by version
+func load(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(scripts, dialect)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := scriptName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("Unexpected migration script %s/%s", dialect, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(scripts, dialect+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("Migration %d of %s has the names %s & %s", version, dialect, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+	migrations := []Migration{}
+	for version := 1; version <= len(byVersion); version++ {
+		migration, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("Migration %d of %s is missing", version, dialect)
+		}
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("Migration %s of %s needs an up & a down script", migration, dialect)
+		}
+		migration.Checksum = checksum(migration.Up)
+		migrations = append(migrations, *migration)
+	}
+	return migrations, nil
+}
+
+// Returns the SHA-256 of the script without its comment & blank lines
+func checksum(script string) string {
+	statements := []string{}
+	for _, line := range strings.Split(script, "\n") {
+		if trimmed := strings.TrimSpace(line); len(trimmed) > 0 && !strings.HasPrefix(trimmed, "--") {
+			statements = append(statements, line)
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(statements, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns all migrations known to this build, ordered by version
+func (m *Migrator) Migrations() []Migration {
+	return slices.Clone(m.migrations)
+}
+
+// Applies all pending migrations & returns them. Fails without changes, if an applied migration
+// was changed or is unknown to this build
+func (m *Migrator) Up() ([]Migration, error) {
+	applied := []Migration{}
+	err := m.run(func(tx *sqlx.Tx, history []appliedMigration) error {
+		// Databases created before versioned migrations are adopted by the initial migration
+		if len(history) == 0 && m.dialect == "sqlite" {
+			if err := addUnversionedColumns(tx); err != nil {
+				return err
+			}
+		}
+		for _, migration := range m.migrations[len(history):] {
+			if _, err := tx.Exec(migration.Up); err != nil {
+				return fmt.Errorf("Migration %s failed: %w", migration, err)
+			}
+			if fn, ok := m.afterUp[migration.Version]; ok {
+				if err := fn(tx); err != nil {
+					return fmt.Errorf("Migration %s failed: %w", migration, err)
+				}
+			}
+			query := tx.Rebind(` INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?); `)
+			if _, err := tx.Exec(query, migration.Version, migration.Name, migration.Checksum, time.Now().UTC()); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Reverts the applied migrations newer than the version, newest first, & returns them. Down(0)
+// reverts all migrations
+func (m *Migrator) Down(version int) ([]Migration, error) {
+	if version < 0 || version > len(m.migrations) {
+		return nil, fmt.Errorf("Unknown schema version %d", version)
+	}
+	reverted := []Migration{}
+	err := m.run(func(tx *sqlx.Tx, history []appliedMigration) error {
+		for idx := len(history) - 1; idx >= version; idx-- {
+			migration := m.migrations[idx]
+			if _, err := tx.Exec(migration.Down); err != nil {
+				return fmt.Errorf("Reverting migration %s failed: %w", migration, err)
+			}
+			if _, err := tx.Exec(tx.Rebind(` DELETE FROM schema_migrations WHERE version = ?; `), migration.Version); err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+// Returns all migrations & when they were applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	status := []MigrationStatus{}
+	err := m.run(func(tx *sqlx.Tx, history []appliedMigration) error {
+		for idx, migration := range m.migrations {
+			entry := MigrationStatus{Migration: migration}
+			if idx < len(history) {
+				entry.AppliedAt = history[idx].AppliedAt
+			}
+			status = append(status, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Runs fn in a transaction holding the migration lock, with the verified history of applied
+// migrations. The transaction is committed, if fn succeeds
+func (m *Migrator) run(fn func(tx *sqlx.Tx, history []appliedMigration) error) error {
+	if m.dialect == "sqlite" {
+		// Outside of the transaction, so its first statement can take the lock
+		if _, err := m.db.Exec(sqliteHistoryTable); err != nil {
+			return err
+		}
+	}
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	lock := []string{
+		// The first write takes the lock of the whole database until the transaction ends
+		` DELETE FROM schema_migrations WHERE version < 0; `,
+	}
+	if m.dialect == "postgres" {
+		lock = []string{fmt.Sprintf(` SELECT pg_advisory_xact_lock(%d); `, postgresLockKey), postgresHistoryTable}
+	}
+	for _, query := range lock {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	history := []appliedMigration{}
+	if err := tx.Select(&history, ` SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version; `); err != nil {
+		return err
+	}
+	if err := m.verify(history); err != nil {
+		return err
+	}
+	if err := fn(tx, history); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Ensures the applied migrations are the first migrations of this build, unchanged
+func (m *Migrator) verify(history []appliedMigration) error {
+	for idx, applied := range history {
+		if idx >= len(m.migrations) || applied.Version != idx+1 {
+			return fmt.Errorf("Database has migration %04d_%s applied, which this build does not know", applied.Version, applied.Name)
+		}
+		if migration := m.migrations[idx]; applied.Checksum != migration.Checksum {
+			return fmt.Errorf("Migration %s was changed after it was applied", migration)
+		}
+	}
+	return nil
+}
+
+const sqliteHistoryTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+); `
+
+const postgresHistoryTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL
+); `
+
+// Columns of the tables of the initial migration, that SQLite databases created before versioned
+// migrations may lack. Bare names are columns of the first schema, which cannot be added later.
+// Constraints of added columns, e.g. unique user names, are not added to existing tables
+var unversionedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"user", []string{
+		"id",
+		"name",
+		"role TEXT NOT NULL DEFAULT 'member'",
+		"password_hash TEXT",
+		"failed_logins INTEGER NOT NULL DEFAULT 0",
+		"locked_until DATETIME",
+		"time_zone TEXT NOT NULL DEFAULT 'UTC'",
+	}},
+	{"room", []string{
+		"id",
+		"title",
+		"time_zone TEXT NOT NULL DEFAULT 'UTC'",
+		"capacity INTEGER NOT NULL DEFAULT 0",
+		"building TEXT NOT NULL DEFAULT ''",
+		"floor TEXT NOT NULL DEFAULT ''",
+		"description TEXT NOT NULL DEFAULT ''",
+		"equipment TEXT NOT NULL DEFAULT ''",
+		"accessibility TEXT NOT NULL DEFAULT ''",
+		"image_url TEXT NOT NULL DEFAULT ''",
+		"archived_at DATETIME",
+	}},
+	{"booking", []string{
+		"id",
+		"room_id",
+		"user_id",
+		"title TEXT",
+		"description TEXT",
+		"start_time",
+		"end_time",
+		"attendees INTEGER NOT NULL DEFAULT 0",
+		"recurrence TEXT",
+		"series_id INTEGER REFERENCES booking (id)",
+		"original_start DATETIME",
+	}},
+	{"booking_exdate", []string{"booking_id", "original_start"}},
+	{"session", []string{"id", "user_id", "token_hash", "expires_at", "revoked_at"}},
+	{"feed", []string{"id", "user_id", "room_id", "token_hash", "created_at", "revoked_at"}},
+	{"calendar_object", []string{"booking_id", "name", "uid"}},
+}
+
+// Adds the columns, that existing tables of an unversioned database lack. Fails, if a table lacks
+// a column that cannot be added. Missing tables are created by the initial migration
+func addUnversionedColumns(tx *sqlx.Tx) error {
+	for _, table := range unversionedColumns {
+		existing := []string{}
+		if err := tx.Select(&existing, ` SELECT name FROM pragma_table_info(?); `, table.table); err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			continue
+		}
+		for _, column := range table.columns {
+			fields := strings.Fields(column)
+			if slices.Contains(existing, fields[0]) {
+				continue
+			}
+			if len(fields) == 1 {
+				return fmt.Errorf("Unable to adopt unversioned database: table %s lacks column %s", table.table, column)
+			}
+			if _, err := tx.Exec(fmt.Sprintf(` ALTER TABLE %s ADD COLUMN %s; `, table.table, column)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestMigrator(t *testing.T, dsn string) *Migrator {
+	db, err := sqlx.Connect("sqlite3", dsn)
+	if err != nil {
+		t.Fatalf("Unable to open database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+func testDsn(t *testing.T) string {
+	return fmt.Sprintf("file:%s?_txlock=immediate&_busy_timeout=5000", filepath.Join(t.TempDir(), "test.db"))
+}
+
+func tableNames(t *testing.T, db *sqlx.DB) []string {
+	names := []string{}
+	if err := db.Select(&names, ` SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name; `); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestLoad_DialectsShareVersions(t *testing.T) {
+	sqlite, err := load("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgres, err := load("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) == 0 || len(sqlite) != len(postgres) {
+		t.Fatalf("Expected the same migrations for both dialects, received %d & %d", len(sqlite), len(postgres))
+	}
+	for idx := range sqlite {
+		if sqlite[idx].String() != postgres[idx].String() {
+			t.Errorf("Expected migration %s for both dialects, received %s", sqlite[idx], postgres[idx])
+		}
+	}
+}
+
+func TestChecksum_IgnoresComments(t *testing.T) {
+	script := "-- Rooms\nCREATE TABLE room ( id INTEGER );\n"
+	if checksum(script) != checksum("-- Rooms of a building\n\n"+script) {
+		t.Fatal("Expected changed comments to keep the checksum")
+	}
+	if checksum(script) == checksum("-- Rooms\nCREATE TABLE room ( id BIGINT );\n") {
+		t.Fatal("Expected changed statements to change the checksum")
+	}
+}
+
+func TestUp_AppliesPendingMigrationsOnce(t *testing.T) {
+	migrator := newTestMigrator(t, testDsn(t))
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	if len(applied) != len(migrator.Migrations()) {
+		t.Fatalf("Expected all %d migrations to be applied, received %v", len(migrator.Migrations()), applied)
+	}
+	if tables := tableNames(t, migrator.db); !strings.Contains(strings.Join(tables, ","), "booking,booking_exdate") {
+		t.Fatalf("Expected booking tables, received %v", tables)
+	}
+	if applied, err = migrator.Up(); err != nil || len(applied) != 0 {
+		t.Fatalf("Expected no migration to be pending, received %v, %v", applied, err)
+	}
+	status, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range status {
+		if entry.AppliedAt.IsZero() {
+			t.Errorf("Expected migration %s to be applied", entry.Migration)
+		}
+	}
+}
+
+func TestDown_RevertsMigrations(t *testing.T) {
+	migrator := newTestMigrator(t, testDsn(t))
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	reverted, err := migrator.Down(0)
+	if err != nil {
+		t.Fatalf("Unable to revert: %s", err)
+	}
+	if len(reverted) != len(migrator.Migrations()) {
+		t.Fatalf("Expected all migrations to be reverted, received %v", reverted)
+	}
+	if tables := tableNames(t, migrator.db); len(tables) != 1 || tables[0] != "schema_migrations" {
+		t.Fatalf("Expected only the migration history to be left, received %v", tables)
+	}
+	status, err := migrator.Status()
+	if err != nil || !status[0].AppliedAt.IsZero() {
+		t.Fatalf("Expected pending migrations, received %+v, %v", status, err)
+	}
+	if _, err := migrator.Down(len(migrator.Migrations()) + 1); err == nil {
+		t.Fatal("Expected error for unknown version")
+	}
+	// Reverted migrations can be applied again
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+}
+
+func TestUp_FailsForChangedOrUnknownMigrations(t *testing.T) {
+	migrator := newTestMigrator(t, testDsn(t))
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to migrate: %s", err)
+	}
+	if _, err := migrator.db.Exec(` UPDATE schema_migrations SET checksum = 'changed' WHERE version = 1; `); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("Expected error for changed migration, received '%v'", err)
+	}
+	if _, err := migrator.db.Exec(` UPDATE schema_migrations SET checksum = ? WHERE version = 1; `, migrator.migrations[0].Checksum); err != nil {
+		t.Fatal(err)
+	}
+	// Applied by a newer build
+	query := ` INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, 'newer', '', CURRENT_TIMESTAMP); `
+	if _, err := migrator.db.Exec(query, len(migrator.migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err == nil || !strings.Contains(err.Error(), "does not know") {
+		t.Fatalf("Expected error for unknown migration, received '%v'", err)
+	}
+}
+
+func TestUp_AdoptsDatabaseOfBaselineSchema(t *testing.T) {
+	migrator := newTestMigrator(t, testDsn(t))
+	// Schema of the first releases, before versioned migrations
+	if _, err := migrator.db.Exec(`
+CREATE TABLE user ( id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL );
+CREATE TABLE room ( id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL );
+CREATE TABLE booking ( id INTEGER PRIMARY KEY AUTOINCREMENT, room_id INTEGER NOT NULL, user_id INTEGER NOT NULL, start_time DATETIME NOT NULL, end_time DATETIME NOT NULL );
+INSERT INTO user ( name ) VALUES ("Old user");
+INSERT INTO room ( title ) VALUES ("Old room");
+INSERT INTO booking ( room_id, user_id, start_time, end_time ) VALUES (1, 1, '2024-07-08 08:00:00', '2024-07-08 09:00:00'); `); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("Unable to adopt database: %s", err)
+	}
+	for _, table := range unversionedColumns {
+		existing := []string{}
+		if err := migrator.db.Select(&existing, ` SELECT name FROM pragma_table_info(?); `, table.table); err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range table.columns {
+			if name := strings.Fields(column)[0]; !slices.Contains(existing, name) {
+				t.Errorf("Expected column %s.%s, received %v", table.table, name, existing)
+			}
+		}
+	}
+	var booking struct {
+		Attendees  int
+		Recurrence sql.NullString
+	}
+	if err := migrator.db.Get(&booking, ` SELECT attendees, recurrence FROM booking WHERE id = 1; `); err != nil || booking.Attendees != 0 || booking.Recurrence.Valid {
+		t.Fatalf("Expected booking with default values, received %+v, %v", booking, err)
+	}
+}
+
+func TestUp_FailsForUnversionedTableLackingColumns(t *testing.T) {
+	migrator := newTestMigrator(t, testDsn(t))
+	if _, err := migrator.db.Exec(` CREATE TABLE booking ( id INTEGER PRIMARY KEY AUTOINCREMENT, room_id INTEGER NOT NULL ); `); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(); err == nil || !strings.Contains(err.Error(), "booking lacks column user_id") {
+		t.Fatalf("Expected error for missing column, received '%v'", err)
+	}
+	if tables := tableNames(t, migrator.db); len(tables) != 2 {
+		t.Fatalf("Expected no changes, received tables %v", tables)
+	}
+}
+
+func TestUp_ConcurrentRunnersApplyEachMigrationOnce(t *testing.T) {
+	dsn := testDsn(t)
+	runners := []*Migrator{}
+	for range 4 {
+		runners = append(runners, newTestMigrator(t, dsn))
+	}
+	var wg sync.WaitGroup
+	applied := make([][]Migration, len(runners))
+	errs := make([]error, len(runners))
+	for idx, runner := range runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[idx], errs[idx] = runner.Up()
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for idx := range runners {
+		if errs[idx] != nil {
+			t.Fatalf("Runner %d failed: %s", idx, errs[idx])
+		}
+		total += len(applied[idx])
+	}
+	if total != len(runners[0].Migrations()) {
+		t.Fatalf("Expected every migration to be applied once, received %v", applied)
+	}
+}
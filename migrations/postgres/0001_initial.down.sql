@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS calendar_object;
+DROP TABLE IF EXISTS feed;
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS booking_exdate;
+DROP TABLE IF EXISTS booking;
+DROP TABLE IF EXISTS room;
+DROP TABLE IF EXISTS "user";
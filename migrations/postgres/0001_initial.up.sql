@@ -0,0 +1,77 @@
+-- Databases created before versioned migrations already hold some of these tables
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE TABLE IF NOT EXISTS "user" (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL DEFAULT 'member',
+	password_hash TEXT,
+	failed_logins INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ,
+	time_zone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+CREATE TABLE IF NOT EXISTS room (
+	id BIGSERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	time_zone TEXT NOT NULL DEFAULT 'UTC',
+	capacity INTEGER NOT NULL DEFAULT 0,
+	building TEXT NOT NULL DEFAULT '',
+	floor TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	equipment TEXT NOT NULL DEFAULT '',
+	accessibility TEXT NOT NULL DEFAULT '',
+	image_url TEXT NOT NULL DEFAULT '',
+	archived_at TIMESTAMPTZ
+);
+
+-- Single bookings & exceptions of a room cannot overlap, which the exclusion constraint enforces
+-- on top of the conflict checks. Series are only stored with their first occurrence, so their
+-- conflicts are left to the checks
+CREATE TABLE IF NOT EXISTS booking (
+	id BIGSERIAL PRIMARY KEY,
+	room_id BIGINT NOT NULL REFERENCES room (id),
+	user_id BIGINT NOT NULL REFERENCES "user" (id),
+	title TEXT,
+	description TEXT,
+	start_time TIMESTAMPTZ NOT NULL,
+	end_time TIMESTAMPTZ NOT NULL,
+	attendees INTEGER NOT NULL DEFAULT 0,
+	recurrence TEXT,
+	series_id BIGINT REFERENCES booking (id),
+	original_start TIMESTAMPTZ,
+	CONSTRAINT booking_room_overlap EXCLUDE USING gist (
+		room_id WITH =,
+		tstzrange(start_time, end_time) WITH &&
+	) WHERE (recurrence IS NULL)
+);
+
+CREATE TABLE IF NOT EXISTS booking_exdate (
+	booking_id BIGINT NOT NULL REFERENCES booking (id),
+	original_start TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (booking_id, original_start)
+);
+
+CREATE TABLE IF NOT EXISTS session (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES "user" (id),
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS feed (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES "user" (id),
+	room_id BIGINT REFERENCES room (id),
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+-- Objects are left behind by deleted bookings, so the booking is no foreign key here
+CREATE TABLE IF NOT EXISTS calendar_object (
+	booking_id BIGINT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	uid TEXT NOT NULL UNIQUE
+);
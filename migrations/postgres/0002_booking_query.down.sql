@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS booking_start_time;
+ALTER TABLE booking DROP COLUMN IF EXISTS last_end_time;
@@ -0,0 +1,7 @@
+-- End of the last occurrence, so bookings can be filtered by date without expanding series.
+-- NULL for series without end. Series stored before are filled in by 0003_series_last_end_time
+ALTER TABLE booking ADD COLUMN last_end_time TIMESTAMPTZ;
+UPDATE booking SET last_end_time = end_time WHERE recurrence IS NULL;
+
+-- Pages of bookings are ordered by start time & ID
+CREATE INDEX IF NOT EXISTS booking_start_time ON booking (start_time, id);
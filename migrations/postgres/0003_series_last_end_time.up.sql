@@ -0,0 +1,5 @@
+-- Placeholder for the Go step backfillLastEndTimes in booking/bookings.go, which
+-- booking.AddMigrationSteps registers for this version & the migrator runs in the same transaction.
+-- It stores the last end time of series saved before 0002_booking_query. SQL cannot compute it, as
+-- it depends on expanding the recurrence rules
+SELECT 1;
@@ -0,0 +1 @@
+ALTER TABLE calendar_object DROP CONSTRAINT IF EXISTS calendar_object_booking_id_fkey;
@@ -0,0 +1,5 @@
+-- Calendar objects are removed with their bookings. Objects left behind by bookings deleted before
+-- are dropped, so the key can be added
+DELETE FROM calendar_object WHERE booking_id NOT IN (SELECT id FROM booking);
+ALTER TABLE calendar_object
+	ADD CONSTRAINT calendar_object_booking_id_fkey FOREIGN KEY (booking_id) REFERENCES booking (id) ON DELETE CASCADE;
@@ -0,0 +1,75 @@
+-- Databases created before versioned migrations already hold some of these tables
+CREATE TABLE IF NOT EXISTS user (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL DEFAULT 'member',
+	password_hash TEXT,
+	failed_logins INTEGER NOT NULL DEFAULT 0,
+	locked_until DATETIME,
+	time_zone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+CREATE TABLE IF NOT EXISTS room (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	time_zone TEXT NOT NULL DEFAULT 'UTC',
+	capacity INTEGER NOT NULL DEFAULT 0,
+	building TEXT NOT NULL DEFAULT '',
+	floor TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	equipment TEXT NOT NULL DEFAULT '',
+	accessibility TEXT NOT NULL DEFAULT '',
+	image_url TEXT NOT NULL DEFAULT '',
+	archived_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS booking (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	title TEXT,
+	description TEXT,
+	start_time DATETIME NOT NULL,
+	end_time DATETIME NOT NULL,
+	attendees INTEGER NOT NULL DEFAULT 0,
+	recurrence TEXT,
+	series_id INTEGER,
+	original_start DATETIME,
+	FOREIGN KEY (room_id) REFERENCES room (id),
+	FOREIGN KEY (user_id) REFERENCES user (id),
+	FOREIGN KEY (series_id) REFERENCES booking (id)
+);
+
+CREATE TABLE IF NOT EXISTS booking_exdate (
+	booking_id INTEGER NOT NULL,
+	original_start DATETIME NOT NULL,
+	PRIMARY KEY (booking_id, original_start),
+	FOREIGN KEY (booking_id) REFERENCES booking (id)
+);
+
+CREATE TABLE IF NOT EXISTS session (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES user (id)
+);
+
+CREATE TABLE IF NOT EXISTS feed (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	room_id INTEGER,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES user (id),
+	FOREIGN KEY (room_id) REFERENCES room (id)
+);
+
+CREATE TABLE IF NOT EXISTS calendar_object (
+	booking_id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	uid TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (booking_id) REFERENCES booking (id)
+);
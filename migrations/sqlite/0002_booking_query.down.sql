@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS booking_start_time;
+ALTER TABLE booking DROP COLUMN last_end_time;
@@ -0,0 +1,3 @@
+-- Placeholder like the up script. Last end times of series are kept, as they are correct for
+-- every version
+SELECT 1;
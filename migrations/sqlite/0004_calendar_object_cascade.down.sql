@@ -0,0 +1,9 @@
+CREATE TABLE calendar_object_restrict (
+	booking_id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	uid TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (booking_id) REFERENCES booking (id)
+);
+INSERT INTO calendar_object_restrict ( booking_id, name, uid ) SELECT booking_id, name, uid FROM calendar_object;
+DROP TABLE calendar_object;
+ALTER TABLE calendar_object_restrict RENAME TO calendar_object;
@@ -0,0 +1,14 @@
+-- Calendar objects are removed with their bookings. SQLite only enforces the key with foreign keys
+-- turned on, so the booking repositories delete the objects themselves as well. Objects left
+-- behind by bookings deleted before are dropped
+DELETE FROM calendar_object WHERE booking_id NOT IN (SELECT id FROM booking);
+
+CREATE TABLE calendar_object_cascade (
+	booking_id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	uid TEXT NOT NULL UNIQUE,
+	FOREIGN KEY (booking_id) REFERENCES booking (id) ON DELETE CASCADE
+);
+INSERT INTO calendar_object_cascade ( booking_id, name, uid ) SELECT booking_id, name, uid FROM calendar_object;
+DROP TABLE calendar_object;
+ALTER TABLE calendar_object_cascade RENAME TO calendar_object;
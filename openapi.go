@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+// Contract of the /api/v1 routes
+//
+//go:embed openapi.yaml
+var openApiSpec []byte
+
+func loadOpenApiSpec() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openApiSpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func handleApiDocsRequest(c *gin.Context) {
+	c.HTML(http.StatusOK, "api-docs.html", nil)
+}
+
+func handleApiSpecRequest(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", openApiSpec)
+}
+
+// Buffers the response, so it can be validated before it is sent
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Middleware rejecting requests that do not match the OpenAPI document. Responses violating the
+// document are replaced by an error, so clients never receive payloads breaking the contract
+func OpenApiValidationMiddleware(doc *openapi3.T) (gin.HandlerFunc, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	options := &openapi3filter.Options{
+		// Authentication is enforced by ApiAuthMiddleware
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+		MultiError:            true,
+	}
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			// Unknown routes are answered by gin
+			if errors.Is(err, routers.ErrPathNotFound) {
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusMethodNotAllowed, ApiError{Code: "invalid_request", Message: err.Error()})
+			return
+		}
+		requestInput := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), requestInput); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, apiErrorFromValidationError(err))
+			return
+		}
+
+		writer := &bufferedResponseWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		responseInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: requestInput,
+			Status:                 writer.status,
+			Header:                 writer.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(writer.body.Bytes())),
+			Options:                options,
+		}
+		if err := openapi3filter.ValidateResponse(c.Request.Context(), responseInput); err != nil {
+			logger.Printf("Response of %s %s violates API spec: %s", c.Request.Method, c.Request.URL.Path, err)
+			c.JSON(http.StatusInternalServerError, ApiError{Code: "invalid_response", Message: "Response does not match the API specification"})
+			return
+		}
+		c.Writer.WriteHeader(writer.status)
+		c.Writer.Write(writer.body.Bytes())
+	}, nil
+}
+
+// Maps schema violations of the request to per-field errors
+func apiErrorFromValidationError(err error) ApiError {
+	res := ApiError{Code: "validation_failed", Message: "Request does not match the API specification", FieldErrors: map[string]string{}}
+	var multiErr openapi3.MultiError
+	errs := []error{err}
+	if errors.As(err, &multiErr) {
+		errs = multiErr
+	}
+	for _, e := range errs {
+		var schemaErr *openapi3.SchemaError
+		var requestErr *openapi3filter.RequestError
+		switch {
+		case errors.As(e, &requestErr) && requestErr.Parameter != nil:
+			reason := requestErr.Reason
+			if errors.As(e, &schemaErr) {
+				reason = schemaErr.Reason
+			}
+			if len(reason) == 0 && requestErr.Err != nil {
+				reason = requestErr.Err.Error()
+			}
+			res.FieldErrors[requestErr.Parameter.Name] = reason
+		case errors.As(e, &schemaErr):
+			field := strings.Join(schemaErr.JSONPointer(), ".")
+			if len(field) == 0 {
+				field = "body"
+			}
+			res.FieldErrors[field] = schemaErr.Reason
+		default:
+			res.Message = e.Error()
+		}
+	}
+	return res
+}
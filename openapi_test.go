@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"lucb31/booking-go/booking"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ginPathParam = regexp.MustCompile(`:(\w+)`)
+
+func newTestApiRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	if err := registerApiRoutes(r); err != nil {
+		t.Fatalf("Unable to register API routes: %s", err)
+	}
+	return r
+}
+
+func TestOpenApiSpec_DocumentsEveryApiRoute(t *testing.T) {
+	r := newTestApiRouter(t)
+	doc, err := loadOpenApiSpec()
+	if err != nil {
+		t.Fatalf("Invalid OpenAPI document: %s", err)
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" /api/v1"+path] = true
+		}
+	}
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1") {
+			continue
+		}
+		key := route.Method + " " + ginPathParam.ReplaceAllString(route.Path, "{$1}")
+		if !documented[key] {
+			t.Errorf("Route '%s' is missing in openapi.yaml", key)
+		}
+		delete(documented, key)
+	}
+	for key := range documented {
+		t.Errorf("Operation '%s' of openapi.yaml has no handler", key)
+	}
+}
+
+func TestOpenApiValidation_RejectsInvalidPayload(t *testing.T) {
+	r := newTestApiRouter(t)
+	token, err := GenerateJWT(&booking.User{Id: 1, Name: "root"}, 1)
+	if err != nil {
+		t.Fatalf("Unable to generate token: %s", err)
+	}
+
+	body := strings.NewReader(`{"roomId": "one", "userId": 1, "startTime": "2024-07-01T09:00:00Z", "endTime": "2024-07-01T10:00:00Z", "unknown": true}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/bookings", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+	var apiErr ApiError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("Expected error object, received '%s'", w.Body)
+	}
+	if apiErr.Code != "validation_failed" || len(apiErr.FieldErrors) == 0 {
+		t.Fatalf("Expected field errors, received '%s'", w.Body)
+	}
+}
+
+func TestApiGetBookings_FiltersByRepeatedRoomParameter(t *testing.T) {
+	useTestDatabase(t)
+	user := createTestUser(t, "alice", "secret", booking.RoleMember)
+	first := newTestBooking(t, user)
+	newTestBooking(t, user)
+	third := newTestBooking(t, user)
+
+	path := "/api/v1/bookings?from=2024-07-01T00:00:00Z&to=2024-07-02T00:00:00Z"
+	w := apiRequest(t, user, http.MethodGet, path+"&room=1&room=3", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var bookings []ApiBooking
+	if err := json.Unmarshal(w.Body.Bytes(), &bookings); err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 2 {
+		t.Fatalf("Expected bookings of rooms 1 & 3, received '%s'", w.Body)
+	}
+	for _, b := range bookings {
+		if b.Id != first.Id && b.Id != third.Id {
+			t.Fatalf("Expected bookings of rooms 1 & 3, received '%s'", w.Body)
+		}
+	}
+
+	if w := apiRequest(t, user, http.MethodGet, path+"&room=none", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for invalid room id, received %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestApiGetBookings_RejectsInvalidInterval(t *testing.T) {
+	useTestDatabase(t)
+	user := createTestUser(t, "alice", "secret", booking.RoleMember)
+
+	for _, query := range []string{
+		"from=2024-07-02T00:00:00Z&to=2024-07-01T00:00:00Z",
+		"from=2024-07-01T00:00:00Z&to=2024-07-01T00:00:00Z",
+		"from=2024-01-01T00:00:00Z&to=2025-01-02T00:00:01Z",
+	} {
+		w := apiRequest(t, user, http.MethodGet, "/api/v1/bookings?"+query, "")
+		var apiErr ApiError
+		if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || w.Code != http.StatusBadRequest || len(apiErr.FieldErrors["to"]) == 0 {
+			t.Fatalf("%s: Expected field error for to, received %d: %s", query, w.Code, w.Body)
+		}
+	}
+	if w := apiRequest(t, user, http.MethodGet, "/api/v1/bookings?from=2024-01-01T00:00:00Z&to=2025-01-01T00:00:00Z", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for a window of 366 days, received %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
+
+func TestApiGetBookings_PagesThroughBookingsWithLinkHeader(t *testing.T) {
+	useTestDatabase(t)
+	user := createTestUser(t, "alice", "secret", booking.RoleMember)
+	first := newTestBooking(t, user)
+	second := newTestBooking(t, user)
+	third := newTestBooking(t, user)
+
+	w := apiRequest(t, user, http.MethodGet, "/api/v1/bookings?sort=-startTime&limit=2", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var bookings []ApiBooking
+	if err := json.Unmarshal(w.Body.Bytes(), &bookings); err != nil {
+		t.Fatal(err)
+	}
+	// Bookings starting at the same time are ordered by ID
+	if len(bookings) != 2 || bookings[0].Id != third.Id || bookings[1].Id != second.Id {
+		t.Fatalf("Expected bookings %d & %d, received '%s'", third.Id, second.Id, w.Body)
+	}
+	next, found := strings.CutPrefix(w.Header().Get("Link"), "<")
+	next, _, _ = strings.Cut(next, ">")
+	if !found || !strings.Contains(next, "sort=-startTime") {
+		t.Fatalf("Expected link to the next page, received '%s'", w.Header().Get("Link"))
+	}
+
+	w = apiRequest(t, user, http.MethodGet, next, "")
+	if err := json.Unmarshal(w.Body.Bytes(), &bookings); err != nil {
+		t.Fatal(err)
+	}
+	if len(bookings) != 1 || bookings[0].Id != first.Id || len(w.Header().Get("Link")) > 0 {
+		t.Fatalf("Expected last page with booking %d, received '%s'", first.Id, w.Body)
+	}
+
+	invalid := []string{"limit=0", "status=someday", "after=yesterday", "cursor=garbage"}
+	for _, query := range invalid {
+		if w := apiRequest(t, user, http.MethodGet, "/api/v1/bookings?"+query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, received %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
+
+func TestApiAvailability_FindsFreeSlotsAndBusyTimes(t *testing.T) {
+	useTestDatabase(t)
+	user := createTestUser(t, "alice", "secret", booking.RoleMember)
+	first := newTestBooking(t, user)
+	newTestBooking(t, user)
+
+	w := apiRequest(t, user, http.MethodGet, "/api/v1/availability?duration=60&from=2024-07-01T08:00:00Z&to=2024-07-01T11:00:00Z&room=1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var slots []ApiAvailableSlot
+	if err := json.Unmarshal(w.Body.Bytes(), &slots); err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[0].Start.Hour() != 8 || slots[1].Start.Hour() != 10 || slots[1].RoomId != first.Room.Id {
+		t.Fatalf("Expected room 1 to be free at 8 & 10 AM, received '%s'", w.Body)
+	}
+
+	w = apiRequest(t, user, http.MethodGet, "/api/v1/freebusy?from=2024-07-01T00:00:00Z&to=2024-07-02T00:00:00Z", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var rooms []ApiRoomBusy
+	if err := json.Unmarshal(w.Body.Bytes(), &rooms); err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 || len(rooms[0].Busy) != 1 || !rooms[0].Busy[0].Start.Equal(first.StartTime) || !rooms[0].Busy[0].End.Equal(first.EndTime) {
+		t.Fatalf("Expected the booking as busy time, received '%s'", w.Body)
+	}
+
+	if w := apiRequest(t, user, http.MethodGet, "/api/v1/availability?duration=60&from=2024-07-01T08:00:00Z&to=2024-09-01T00:00:00Z", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d for a window exceeding 31 days, received %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestApiDeleteRoom_ArchivesRoomWithoutFutureBookings(t *testing.T) {
+	useTestDatabase(t)
+	admin := createTestUser(t, "root", "secret", booking.RoleAdmin)
+	past := newTestBooking(t, admin)
+	start := time.Now().UTC().Truncate(time.Hour).AddDate(0, 0, 1)
+	future, err := bookingRepo.Create(booking.Booking{Room: past.Room, User: *admin, StartTime: start, EndTime: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unable to create booking: %s", err)
+	}
+	path := fmt.Sprintf("/api/v1/rooms/%d", past.Room.Id)
+
+	w := apiRequest(t, admin, http.MethodDelete, path, "")
+	var apiErr ApiError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); w.Code != http.StatusConflict || err != nil || len(apiErr.ConflictingBookingIds) != 1 || apiErr.ConflictingBookingIds[0] != future.Id {
+		t.Fatalf("Expected conflict with booking %d, received %d: %s", future.Id, w.Code, w.Body)
+	}
+	if err := bookingRepo.Delete(future.Id); err != nil {
+		t.Fatal(err)
+	}
+	if w := apiRequest(t, admin, http.MethodDelete, path, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, received %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+	w = apiRequest(t, admin, http.MethodGet, path, "")
+	var room ApiRoom
+	if err := json.Unmarshal(w.Body.Bytes(), &room); w.Code != http.StatusOK || err != nil || room.ArchivedAt == nil {
+		t.Fatalf("Expected archived room, received %d: %s", w.Code, w.Body)
+	}
+	if w := apiRequest(t, admin, http.MethodGet, "/api/v1/rooms", ""); w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("Expected no rooms to be listed, received %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestOpenApiValidation_RejectsResponseViolatingSpec(t *testing.T) {
+	doc, err := loadOpenApiSpec()
+	if err != nil {
+		t.Fatalf("Invalid OpenAPI document: %s", err)
+	}
+	middleware, err := OpenApiValidationMiddleware(doc)
+	if err != nil {
+		t.Fatalf("Unable to create middleware: %s", err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// Room without the required title
+	r.GET("/api/v1/rooms/:id", middleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, map[string]int{"id": 1})
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/rooms/1", nil))
+
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "invalid_response") {
+		t.Fatalf("Expected invalid response to be replaced, received %d: %s", w.Code, w.Body)
+	}
+}
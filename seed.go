@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"lucb31/booking-go/fixtures"
+)
+
+// booking-go seed [-file fixtures.yaml]
+func runSeedCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.SetOutput(out)
+	file := flags.String("file", "", "YAML or JSON fixtures to seed instead of the development fixtures")
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: booking-go seed [-file fixtures.yaml]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return fmt.Errorf("Unexpected arguments %v", flags.Args())
+	}
+	seed := fixtures.Dev()
+	if len(*file) > 0 {
+		var err error
+		if seed, err = fixtures.Load(*file); err != nil {
+			return err
+		}
+	}
+	report, err := seed.Seed(fixtures.Repositories{Users: userRepo, Rooms: roomRepo, Bookings: bookingRepo}, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "Created %d users, %d rooms & %d bookings\n", report.Users, report.Rooms, report.Bookings)
+	return err
+}
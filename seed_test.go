@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSeedCommand_SeedsFixtureFile(t *testing.T) {
+	useTestDatabase(t)
+	file := filepath.Join(t.TempDir(), "fixtures.yaml")
+	fixtures := "users:\n  - {name: bob, password: secret}\nrooms:\n  - {title: Attic, capacity: 4}\n"
+	if err := os.WriteFile(file, []byte(fixtures), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	for range 2 {
+		if err := runSeedCommand([]string{"-file", file}, &out); err != nil {
+			t.Fatalf("Unable to seed: %s", err)
+		}
+	}
+	if !strings.Contains(out.String(), "Created 1 users, 1 rooms & 0 bookings\nCreated 0 users, 0 rooms & 0 bookings") {
+		t.Fatalf("Expected fixtures to be created once, received %s", out.String())
+	}
+	rooms, err := roomRepo.GetAll()
+	if err != nil || len(rooms) != 1 || rooms[0].Capacity != 4 {
+		t.Fatalf("Expected the attic, received %v, %v", rooms, err)
+	}
+	if err := runSeedCommand([]string{"-file", filepath.Join(t.TempDir(), "missing.yaml")}, &out); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+}